@@ -27,42 +27,81 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/functions"
 	"github.com/jtvaughan/freebean/pkg/parser"
 	"github.com/spf13/cobra"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var accountsCmd = &cobra.Command{
 	Use:   "accounts",
 	Short: "Print all accounts",
 	Long: `The accounts subcommand reads a ledger from standard input
-and prints all open accounts in CSV format.  The output includes a header.
+and prints all open accounts in CSV format, sorted by name.  The output
+includes a header.
 
 The -c flag makes Freebean also print closed accounts.
 The output will include a closing date column that specifies
 the closing date; if it is empty, the account is open.
 
 The -d flag specifies the date on which to stop parsing.
-The date should be formatted "YYYY-MM-DD".  Parsing stops
+See "freebean help" for the accepted date formats.  Parsing stops
 at the end of the day, so accounts opened on that day
 are included.  Freebean parses all input by default.
 
+The --depth flag omits accounts whose names have more than the specified
+number of colon-separated components.  For example, "--depth 2" prints
+Expenses:Travel but not Expenses:Travel:Lodging.
+
 The -o flag makes Freebean print an additional column
 that specifies the account's opening date.  If -c is also specified,
-the opening date column will appear before the closing date column.`,
+the opening date column will appear before the closing date column.
+
+The -s flag makes Freebean print these additional statistics columns
+after the others:
+
+  lots         the number of lots in the account, including the default
+               lot
+  commodities  the number of commodities with nonzero balances
+  tags         the account's tags, sorted and separated by semicolons
+  notes        the number of the account's notes
+
+The -b flag makes Freebean print an additional column after the
+others that specifies the book containing the account (see the book
+function).  The column is empty for accounts in the default book.
+
+The -H flag makes Freebean print an additional column after the others
+that lists the periods during which the account was open before it was
+last reopened, oldest first, separated by semicolons.  Each period is
+formatted "OPENING-DATE/CLOSING-DATE".  Reopening an account replaces it
+with a new one, but the old one keeps its lots, tags, and notes.
+
+The tags and notes columns include inherited tags and notes if the ledger
+sets the account-inheritance pragma.
+
+The -C flag specifies a comma-separated list of columns to print,
+in order, such as "name,opening date".  Column names are the header's
+names; hyphens or underscores may replace spaces.  All columns
+are printed by default.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runAccounts()
 	},
 }
 
 var accountsOptions = struct {
-	Date                Date
+	Date                EndDate
 	PrintClosedAccounts bool
 	PrintOpeningDates   bool
+	PrintStatistics     bool
+	PrintBooks          bool
+	PrintHistory        bool
+	Depth               int
+	Columns             []string
 }{}
 
 func init() {
@@ -70,10 +109,38 @@ func init() {
 	accountsCmd.Flags().VarP(&accountsOptions.Date, "date", "d", "date to stop parsing")
 	accountsCmd.Flags().BoolVarP(&accountsOptions.PrintClosedAccounts, "print-closed-accounts", "c", false, "also print closed accounts")
 	accountsCmd.Flags().BoolVarP(&accountsOptions.PrintOpeningDates, "print-opening-dates", "o", false, "also print opening dates")
+	accountsCmd.Flags().BoolVarP(&accountsOptions.PrintStatistics, "print-statistics", "s", false, "also print statistics columns")
+	accountsCmd.Flags().BoolVarP(&accountsOptions.PrintBooks, "print-books", "b", false, "print the accounts' books")
+	accountsCmd.Flags().BoolVarP(&accountsOptions.PrintHistory, "print-history", "H", false, "print the accounts' previous periods")
+	accountsCmd.Flags().IntVar(&accountsOptions.Depth, "depth", 0, "maximum number of name components")
+	accountsCmd.Flags().StringSliceVarP(&accountsOptions.Columns, "columns", "C", nil, "columns to print")
 }
 
 func runAccounts() {
-	done := &struct{}{}
+	row := []string{"name"}
+	if accountsOptions.PrintOpeningDates {
+		row = append(row, "opening date")
+	}
+	if accountsOptions.PrintClosedAccounts {
+		row = append(row, "closing date")
+	}
+	if accountsOptions.PrintStatistics {
+		row = append(row, "lots", "commodities", "tags", "notes")
+	}
+	if accountsOptions.PrintBooks {
+		row = append(row, "book")
+	}
+	if accountsOptions.PrintHistory {
+		row = append(row, "previous periods")
+	}
+	w, err := newTableWriter(os.Stdout, row, accountsOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
 	p := functions.NewParser(os.Stdin)
 	p.AddCoreFunctions()
 	date := core.Date(accountsOptions.Date)
@@ -82,45 +149,61 @@ func runAccounts() {
 			if err := functions.DateFunction(fn, op, ctx); err != nil {
 				return err
 			} else if ctx.Date.After(date) {
-				panic(done)
+				stop()
 			}
 			return nil
 		}
 	}
-	defer func() {
-		if r := recover(); r != nil && r != done {
-			panic(r)
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	var accounts []*core.Account
+	for _, a := range p.Context().Accounts {
+		if inBook(a) && (accountsOptions.PrintClosedAccounts || !a.IsClosed(p.Context().Date)) {
+			accounts = append(accounts, a)
+		}
+	}
+	core.NewAccountTree(accounts).Walk(accountsOptions.Depth, func(node *core.AccountTree) error {
+		a := node.Account
+		if a == nil {
+			return nil
 		}
-		w := csv.NewWriter(os.Stdout)
-		row := []string{"name"}
+		row = append(row[:0], a.Name)
 		if accountsOptions.PrintOpeningDates {
-			row = append(row, "opening date")
+			row = append(row, formatDate(a.CreationDate))
 		}
 		if accountsOptions.PrintClosedAccounts {
-			row = append(row, "closing date")
-		}
-		w.Write(row)
-		for an, a := range p.Context().Accounts {
-			if !accountsOptions.PrintClosedAccounts && a.IsClosed(p.Context().Date) {
-				continue
+			cd := ""
+			if !a.ClosingDate.IsZero() {
+				cd = formatDate(a.ClosingDate)
 			}
-			row = append(row[:0], an)
-			if accountsOptions.PrintOpeningDates {
-				row = append(row, a.CreationDate.String())
-			}
-			if accountsOptions.PrintClosedAccounts {
-				cd := ""
-				if !a.ClosingDate.IsZero() {
-					cd = a.ClosingDate.String()
+			row = append(row, cd)
+		}
+		if accountsOptions.PrintStatistics {
+			commodities := 0
+			for _, q := range a.Balances() {
+				if !q.Amount.IsZero() {
+					commodities++
 				}
-				row = append(row, cd)
 			}
-			w.Write(row)
+			tags := p.Context().AccountTags(a)
+			notes := p.Context().AccountNotes(a)
+			row = append(row, strconv.Itoa(len(a.Lots)), strconv.Itoa(commodities), strings.Join(tags, ";"), strconv.Itoa(len(notes)))
 		}
-		w.Flush()
-	}()
-	if err := p.Parse(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
-	}
+		if accountsOptions.PrintBooks {
+			row = append(row, a.Book)
+		}
+		if accountsOptions.PrintHistory {
+			periods := p.Context().AccountPeriods(a.Name)
+			previous := make([]string, len(periods)-1)
+			for n, ap := range periods[:len(periods)-1] {
+				previous[n] = formatDate(ap.OpeningDate) + "/" + formatDate(ap.ClosingDate)
+			}
+			row = append(row, strings.Join(previous, ";"))
+		}
+		w.Write(row)
+		return nil
+	})
+	w.Flush()
 }
@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+)
+
+// AccountingBasis is an accounting basis flag: "accrual" recognizes income
+// and expenses when they are recorded and "cash" recognizes those of
+// accrual entries when the entries are settled.
+type AccountingBasis string
+
+const (
+	AccrualBasis AccountingBasis = "accrual"
+	CashBasis    AccountingBasis = "cash"
+)
+
+func (b *AccountingBasis) String() string { return string(*b) }
+
+func (b *AccountingBasis) Set(v string) error {
+	switch AccountingBasis(v) {
+	case AccrualBasis, CashBasis:
+		*b = AccountingBasis(v)
+		return nil
+	}
+	return fmt.Errorf(`invalid accounting basis "%v": expected accrual or cash`, v)
+}
+
+func (b *AccountingBasis) Type() string { return "basis" }
+
+// Transactions with accrualNote notes are accrual entries, such as invoices
+// or bills, and transactions with settlesNote notes with the same values
+// settle them in cash.
+const (
+	accrualNote = "accrual"
+	settlesNote = "settles"
+)
+
+// cashBasis defers the transfers of accrual entries until the transactions
+// that settle them.
+type cashBasis struct {
+	pending map[string][]*functions.Transfer // link -> unsettled transfers
+	settled map[string]bool
+}
+
+func newCashBasis() *cashBasis {
+	return &cashBasis{pending: map[string][]*functions.Transfer{}, settled: map[string]bool{}}
+}
+
+// recognize returns the transfers that the cash basis recognizes when
+// the transaction occurs: the transfers of transactions that are not
+// accrual entries plus those of the accrual entries that the transaction
+// settles.  Accrual entries recorded after their settlements are
+// recognized immediately.  Only the first settlement of an accrual entry
+// recognizes its transfers.
+func (cb *cashBasis) recognize(xact *functions.Transaction) []*functions.Transfer {
+	transfers := xact.Transfers
+	if link, ok := xact.Notes[accrualNote]; ok && !cb.settled[link] {
+		cb.pending[link] = append(cb.pending[link], xact.Transfers...)
+		transfers = nil
+	}
+	if link, ok := xact.Notes[settlesNote]; ok && !cb.settled[link] {
+		transfers = append(append([]*functions.Transfer(nil), transfers...), cb.pending[link]...)
+		delete(cb.pending, link)
+		cb.settled[link] = true
+	}
+	return transfers
+}
@@ -0,0 +1,307 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var allReportsCmd = &cobra.Command{
+	Use:   "all-reports",
+	Short: "Write several reports from one parse",
+	Long: `The all-reports subcommand reads a ledger from standard input once
+and then writes the requested reports concurrently, one CSV file per
+report, into the directory specified by the -o flag, which is created
+if necessary.  This suits batch report generation, such as nightly
+reports of large ledgers, because the ledger is only parsed once.
+The reports only read the parsed ledger, so they cannot affect
+each other.
+
+The -b flag writes balances.csv, which has account name, commodity,
+and balance columns with one row per open account and commodity,
+like the balances printed by the repl subcommand.  The --depth flag
+makes it list accounts and their ancestors down to the specified number
+of colon-separated name components instead, with rolled-up balances that
+include the balances of the accounts below them.  For example, with
+"--depth 1", the Assets row holds the total of all open asset accounts.
+
+The -l flag writes lots.csv, which has the same columns as the lots
+subcommand's output with the --basis flag's basis, "cost" by default.
+Default lots are omitted.
+
+The -r flag writes the register of the default lot of an account in
+a commodity, specified as ACCOUNT=COMMODITY, to register-ACCOUNT-COMMODITY.csv,
+with colons in the account name replaced by underscores.  It has the same
+columns as the register subcommand's output without flags.  It may be
+repeated.
+
+At least one report must be requested.  Rows are sorted.
+
+The -d flag specifies the date on which to stop parsing.
+See "freebean help" for the accepted date formats.  Parsing stops
+at the end of the day.  Freebean parses all input by default.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAllReports()
+	},
+}
+
+var allReportsOptions = struct {
+	OutputDir string
+	Date      EndDate
+	Balances  bool
+	Lots      bool
+	Registers []string
+	Basis     Basis
+	Depth     int
+}{Basis: CostBasis}
+
+func init() {
+	rootCmd.AddCommand(allReportsCmd)
+	allReportsCmd.Flags().StringVarP(&allReportsOptions.OutputDir, "output-dir", "o", "", "directory to write reports into (required)")
+	allReportsCmd.MarkFlagRequired("output-dir")
+	allReportsCmd.Flags().VarP(&allReportsOptions.Date, "date", "d", "date to stop parsing")
+	allReportsCmd.Flags().BoolVarP(&allReportsOptions.Balances, "balances", "b", false, "write balances.csv")
+	allReportsCmd.Flags().BoolVarP(&allReportsOptions.Lots, "lots", "l", false, "write lots.csv")
+	allReportsCmd.Flags().StringArrayVarP(&allReportsOptions.Registers, "register", "r", nil, "write the register of ACCOUNT=COMMODITY")
+	allReportsCmd.Flags().Var(&allReportsOptions.Basis, "basis", "lots' valuation basis (cost or market)")
+	allReportsCmd.Flags().IntVar(&allReportsOptions.Depth, "depth", 0, "roll up balances to this many name components")
+}
+
+// report is a report that all-reports writes to a file.
+type report struct {
+	fileName string
+	write    func(io.Writer) error
+}
+
+// sortedOpenAccounts returns the names of ctx's open accounts in the
+// --book flag's book, sorted.
+func sortedOpenAccounts(ctx *core.Context) []string {
+	names := make([]string, len(ctx.Accounts))[:0]
+	for an, a := range ctx.Accounts {
+		if !a.IsClosed(ctx.Date) && inBook(a) {
+			names = append(names, an)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeBalancesReport writes the balances of ctx's open accounts or,
+// if depth is positive, the rolled-up balances of the accounts and their
+// ancestors down to depth.
+func writeBalancesReport(out io.Writer, ctx *core.Context, depth int) error {
+	w, err := newTableWriter(out, []string{"account name", "commodity", "balance"}, nil)
+	if err != nil {
+		return err
+	}
+	writeBalances := func(an string, balances map[string]core.Quantity) {
+		commodities := make([]string, len(balances))[:0]
+		for cn := range balances {
+			commodities = append(commodities, cn)
+		}
+		sort.Strings(commodities)
+		for _, cn := range commodities {
+			w.Write([]string{an, cn, balances[cn].String()})
+		}
+	}
+	if depth > 0 {
+		var accounts []*core.Account
+		for _, an := range sortedOpenAccounts(ctx) {
+			accounts = append(accounts, ctx.Accounts[an])
+		}
+		core.NewAccountTree(accounts).Walk(depth, func(node *core.AccountTree) error {
+			writeBalances(node.Name, node.Balances())
+			return nil
+		})
+	} else {
+		for _, an := range sortedOpenAccounts(ctx) {
+			writeBalances(an, ctx.Accounts[an].Balances())
+		}
+	}
+	w.Flush()
+	return w.w.Error()
+}
+
+func writeLotsReport(out io.Writer, ctx *core.Context, basis Basis) error {
+	w, err := newTableWriter(out, []string{"account name", "lot name", "commodity", "balance", "unit price", "total price"}, nil)
+	if err != nil {
+		return err
+	}
+	for _, an := range sortedOpenAccounts(ctx) {
+		a := ctx.Accounts[an]
+		lotNames := make([]string, len(a.Lots))[:0]
+		for ln := range a.Lots {
+			if len(ln) != 0 {
+				lotNames = append(lotNames, ln)
+			}
+		}
+		sort.Strings(lotNames)
+		for _, ln := range lotNames {
+			commodities := make([]string, len(a.Lots[ln]))[:0]
+			for cn := range a.Lots[ln] {
+				commodities = append(commodities, cn)
+			}
+			sort.Strings(commodities)
+			for _, cn := range commodities {
+				l := a.Lots[ln][cn]
+				row := []string{an, ln, cn, l.Balance.String(), "", ""}
+				if up, ok := lotPrice(ctx.Prices, l, basis); ok {
+					tp := core.Quantity{Commodity: up.Commodity, Amount: l.Balance.Amount.Mul(up.Amount)}
+					if basis == CostBasis {
+						tp = l.ExchangeRate.TotalPrice
+					}
+					row[4], row[5] = up.String(), tp.String()
+				}
+				w.Write(row)
+			}
+		}
+	}
+	w.Flush()
+	return w.w.Error()
+}
+
+func writeRegisterReport(out io.Writer, journal []core.JournalEntry, accountName, commodityName string) error {
+	w, err := newTableWriter(out, []string{"date", "entity", "amount", "balance"}, nil)
+	if err != nil {
+		return err
+	}
+	balance := core.Quantity{Commodity: &core.Commodity{Name: commodityName}}
+	for _, e := range journal {
+		for _, p := range e.Postings {
+			if p.Account == accountName && len(p.LotName) == 0 && p.Quantity.Commodity.Name == commodityName {
+				balance.Commodity = p.Quantity.Commodity
+				balance.Amount = balance.Amount.Add(p.Quantity.Amount)
+				w.Write([]string{formatDate(e.Date), e.Entity, p.Quantity.String(), balance.String()})
+			}
+		}
+	}
+	w.Flush()
+	return w.w.Error()
+}
+
+func runAllReports() {
+	var registers []accountBalance
+	for _, r := range allReportsOptions.Registers {
+		n := strings.LastIndex(r, "=")
+		if n <= 0 || n == len(r)-1 {
+			fmt.Fprintf(os.Stderr, "invalid register %#v: expected ACCOUNT=COMMODITY\n", r)
+			os.Exit(1)
+		}
+		registers = append(registers, accountBalance{r[:n], r[n+1:]})
+	}
+	if !allReportsOptions.Balances && !allReportsOptions.Lots && len(registers) == 0 {
+		fmt.Fprintln(os.Stderr, "no reports requested")
+		os.Exit(1)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	p.Context().Options.KeepJournal = len(registers) != 0
+	date := core.Date(allReportsOptions.Date)
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !date.IsZero() && ctx.Date.After(date) {
+			stop()
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+
+	// The Context is only read from here on.
+	ctx := p.Context()
+	var reports []report
+	if allReportsOptions.Balances {
+		reports = append(reports, report{"balances.csv", func(w io.Writer) error {
+			return writeBalancesReport(w, ctx, allReportsOptions.Depth)
+		}})
+	}
+	if allReportsOptions.Lots {
+		reports = append(reports, report{"lots.csv", func(w io.Writer) error {
+			return writeLotsReport(w, ctx, allReportsOptions.Basis)
+		}})
+	}
+	for _, r := range registers {
+		r := r
+		fileName := fmt.Sprintf("register-%v-%v.csv", strings.Replace(r.Account, ":", "_", -1), r.Commodity)
+		reports = append(reports, report{fileName, func(w io.Writer) error {
+			return writeRegisterReport(w, ctx.Journal, r.Account, r.Commodity)
+		}})
+	}
+
+	if err := os.MkdirAll(allReportsOptions.OutputDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	errs := make([]error, len(reports))
+	var wg sync.WaitGroup
+	for n, r := range reports {
+		wg.Add(1)
+		go func(n int, r report) {
+			defer wg.Done()
+			f, err := os.Create(filepath.Join(allReportsOptions.OutputDir, r.fileName))
+			if err != nil {
+				errs[n] = err
+				return
+			}
+			if err = r.write(f); err != nil {
+				f.Close()
+				errs[n] = fmt.Errorf("%v: %v", f.Name(), err)
+			} else if err = f.Close(); err != nil {
+				errs[n] = err
+			}
+		}(n, r)
+	}
+	wg.Wait()
+	failed := false
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var budgetCmd = &cobra.Command{
+	Use:   "budget",
+	Short: "Compare budgets with actual changes",
+	Long: `The budget subcommand reads a ledger from standard input and prints
+the budgets set by the budget function and the actual changes in their
+accounts' balances during each budget period in CSV format.  The output
+includes a header and has one row per budget and period, sorted by
+account name, commodity, and period, with these columns:
+
+  account name  the budget's account
+  commodity     the budget's commodity
+  period        monthly, quarterly, or yearly
+  first day     the first day of the period
+  last day      the last day of the period
+  budget        the budgeted change
+  actual        the change in the account's balance in the commodity
+  remaining     the budgeted change less the actual change
+  exceeded      "true" if the actual change exceeds the budgeted change
+                in the budgeted change's direction and "false" otherwise
+
+The actual change during each budget's first period excludes changes
+before the budget was set, and the actual change during its final period
+excludes changes after the ledger's final date (or the -e flag's date).  Periods respect the --month-start and
+--fiscal-year-start flags: quarterly periods are fiscal quarters and
+yearly periods are fiscal years.
+
+The -s flag omits periods that end before the specified date.
+
+The -e flag specifies the last day to include.  Freebean stops parsing
+at the end of that day.  Freebean parses all input by default.
+See "freebean help" for the accepted date formats.
+
+The -x flag prints only periods whose budgets were exceeded.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runBudget()
+	},
+}
+
+var budgetOptions = struct {
+	StartDate    Date
+	EndDate      EndDate
+	ExceededOnly bool
+	Columns      []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(budgetCmd)
+	budgetCmd.Flags().VarP(&budgetOptions.StartDate, "start-date", "s", "omit periods ending before this date")
+	budgetCmd.Flags().VarP(&budgetOptions.EndDate, "end-date", "e", "last day to include")
+	budgetCmd.Flags().BoolVarP(&budgetOptions.ExceededOnly, "exceeded", "x", false, "print only exceeded budgets")
+	budgetCmd.Flags().StringSliceVarP(&budgetOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+func runBudget() {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	p.Context().Options.Calendar = calendar
+	startDate := core.Date(budgetOptions.StartDate)
+	endDate := core.Date(budgetOptions.EndDate)
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	final := ctx.Date
+	if !endDate.IsZero() {
+		final = endDate
+	}
+	budgets := append([]core.Budget(nil), ctx.Budgets...)
+	sort.SliceStable(budgets, func(i, j int) bool {
+		if budgets[i].Account != budgets[j].Account {
+			return budgets[i].Account < budgets[j].Account
+		}
+		return budgets[i].Amount.Commodity.Name < budgets[j].Amount.Commodity.Name
+	})
+	header := []string{"account name", "commodity", "period", "first day", "last day", "budget", "actual", "remaining", "exceeded"}
+	w, err := newTableWriter(os.Stdout, header, budgetOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, b := range budgets {
+		// Stopping at the end date records the periods between it
+		// and the next date, which are omitted.
+		for _, r := range append(b.Results, b.Current(ctx)) {
+			if r.First.After(final) || r.Last.Before(startDate) || (budgetOptions.ExceededOnly && !r.Exceeded()) {
+				continue
+			}
+			w.Write([]string{
+				b.Account,
+				b.Amount.Commodity.Name,
+				b.Period,
+				formatDate(r.First),
+				formatDate(r.Last),
+				b.Amount.Commodity.FormatAmount(r.Amount.Amount),
+				b.Amount.Commodity.FormatAmount(r.Actual.Amount),
+				b.Amount.Commodity.FormatAmount(r.Amount.Amount.Sub(r.Actual.Amount)),
+				fmt.Sprint(r.Exceeded())})
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/lint"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check a ledger for questionable style",
+	Long: `The check subcommand reads a ledger from standard input, checks it
+for errors like the root command does, and then checks it against
+a set of named rules.  It prints each rule violation to standard output,
+prefixed by the date and the rule's name, and exits with exit code 1
+if it finds any.  Ledger errors cause exit code 2.  These are the rules:
+
+  account-naming-convention  account names match the account pattern
+  missing-receipt-note       transactions with transfers to receipt
+                             accounts have receipt notes
+  uncommented-transfers      every transfer has a comment
+  undeclared-payee           every transaction's entity is a declared
+                             payee
+
+All rules are enabled by default.  The -c flag specifies a configuration
+file, which is written in Freebean's language and may call these functions:
+
+  RULE+ enable                 enable rules
+  RULE+ disable                disable rules
+  ENTITY+ payee                declare payees
+  NOTE-NAME receipt-note       set the receipt note's name
+                               (default "receipt")
+  PATTERN receipt-accounts     set the regular expression matching
+                               receipt accounts (default "^Expenses(:|$)")
+  PATTERN account-pattern      set the regular expression that account
+                               names must match (default
+                               "^[A-Z][A-Za-z0-9]*(:[A-Z][A-Za-z0-9]*)*$")
+
+The -e and -x flags enable and disable rules, respectively, after
+the configuration file is read.  Both accept comma-separated lists
+and may be repeated.
+
+The -k flag reports all of the ledger's errors rather than only the first,
+the --trace flag logs each function call, and the --debug-stack-on-error
+flag prints the operand stack when parsing fails (see "freebean help").
+Rules are not checked if there are ledger errors.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCheck()
+	},
+}
+
+var checkOptions = struct {
+	Config  string
+	Enable  []string
+	Disable []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVarP(&checkOptions.Config, "config", "c", "", "configuration file")
+	checkCmd.Flags().StringSliceVarP(&checkOptions.Enable, "enable", "e", nil, "rules to enable")
+	checkCmd.Flags().StringSliceVarP(&checkOptions.Disable, "disable", "x", nil, "rules to disable")
+	addParserFlags(checkCmd)
+}
+
+func runCheck() {
+	config := lint.NewConfig()
+	if len(checkOptions.Config) != 0 {
+		f, err := os.Open(checkOptions.Config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		err = lint.ParseConfig(f, config)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", checkOptions.Config, err)
+			os.Exit(2)
+		}
+	}
+	for _, rule := range checkOptions.Enable {
+		if err := config.Enable(rule, true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	for _, rule := range checkOptions.Disable {
+		if err := config.Enable(rule, false); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	checker := lint.NewChecker(config)
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	p.Functions["xact"] = checker.XactFunction
+	configureParser(p)
+	if err := p.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	checker.Finish(p.Context())
+	for _, v := range checker.Violations {
+		fmt.Println(v)
+	}
+	if len(checker.Violations) != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/store"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+)
+
+// checkpointFile is the JSON form of a checkpoint.
+type checkpointFile struct {
+	*functions.Checkpoint
+	Context json.RawMessage
+}
+
+// checkpointPath is the --checkpoint flag of the subcommands that
+// support it.
+var checkpointPath string
+
+// addCheckpointFlag adds the --checkpoint flag to a subcommand.
+// The subcommand must parse standard input with parseStdin.
+func addCheckpointFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&checkpointPath, "checkpoint", "", "file holding a checkpoint of the ledger")
+}
+
+// parseStdin parses a ledger from standard input with the core functions.
+// If the --checkpoint flag was given and the file it names holds
+// a checkpoint of a prefix of the ledger, parseStdin parses only the rest
+// of the ledger.  It then saves a checkpoint of the whole ledger in
+// the file.  Unreadable checkpoints are ignored.
+func parseStdin() (*core.Context, error) {
+	if len(checkpointPath) == 0 {
+		p := functions.NewParser(os.Stdin)
+		p.AddCoreFunctions()
+		configureParser(p)
+		if err := p.Parse(); err != nil {
+			return nil, err
+		}
+		return p.Context(), nil
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	var cp *functions.Checkpoint
+	if data, err := ioutil.ReadFile(checkpointPath); err == nil {
+		cf := checkpointFile{Checkpoint: &functions.Checkpoint{}}
+		if json.Unmarshal(data, &cf) == nil {
+			if cf.Checkpoint.Context, err = store.UnmarshalJSON(cf.Context); err == nil {
+				cp = cf.Checkpoint
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	p, _ := functions.NewParserFromCheckpoint(input, cp)
+	p.AddCoreFunctions()
+	configureParser(p)
+	if err = p.Parse(); err != nil {
+		return nil, err
+	} else if cp, err = p.Checkpoint(); err != nil {
+		return nil, err
+	} else if cp != nil {
+		cf := checkpointFile{Checkpoint: cp}
+		if cf.Context, err = store.MarshalJSON(cp.Context); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(cf)
+		if err != nil {
+			return nil, err
+		}
+		tmp := checkpointPath + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+			return nil, err
+		} else if err = os.Rename(tmp, checkpointPath); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+	return p.Context(), nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var closeAccountCmd = &cobra.Command{
+	Use:   "close-account ACCOUNT TARGET",
+	Short: "Print the transactions that close an account",
+	Long: `The close-account subcommand reads a ledger from standard input and
+prints, in the ledger language, the transactions that empty ACCOUNT's
+nonempty named lots into TARGET's default lot followed by a call to
+the close function that closes ACCOUNT.  The close function refuses
+to close accounts with nonempty named lots, so appending the output to
+the ledger retires the account, such as a brokerage account, without
+writing the zeroing transfers by hand.
+
+The output begins with a date call for the ledger's final date (or
+the -d flag's date).  Each commodity gets its own transaction whose
+entity is "Closing" and whose description names ACCOUNT, with one
+transfer per lot, sorted by lot name.  The lots' exchange rates are
+not carried over to TARGET.  Default lots are left alone because
+the close function does not require them to be empty.
+
+The -d flag specifies the date on which to stop parsing and close
+the account.  See "freebean help" for the accepted date formats.
+Freebean parses all input by default.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCloseAccount(args[0], args[1])
+	},
+}
+
+var closeAccountOptions = struct {
+	Date EndDate
+}{}
+
+func init() {
+	rootCmd.AddCommand(closeAccountCmd)
+	closeAccountCmd.Flags().VarP(&closeAccountOptions.Date, "date", "d", "date to stop parsing and close the account")
+}
+
+func runCloseAccount(accountName, targetName string) {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	date := core.Date(closeAccountOptions.Date)
+	if !date.IsZero() {
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(date) {
+				stop()
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	if date.IsZero() || date.Before(ctx.Date) {
+		date = ctx.Date
+	}
+	a, ok := ctx.Account(accountName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "nonexistent account: %v\n", accountName)
+		os.Exit(1)
+	} else if a.IsClosed(date) {
+		fmt.Fprintf(os.Stderr, "closed account: %v\n", accountName)
+		os.Exit(1)
+	}
+	if target, ok := ctx.Account(targetName); !ok {
+		fmt.Fprintf(os.Stderr, "nonexistent account: %v\n", targetName)
+		os.Exit(1)
+	} else if target.IsClosed(date) {
+		fmt.Fprintf(os.Stderr, "closed account: %v\n", targetName)
+		os.Exit(1)
+	} else if target == a {
+		fmt.Fprintf(os.Stderr, "account %v cannot be closed into itself\n", accountName)
+		os.Exit(1)
+	}
+
+	// Group the nonempty named lots by commodity.
+	lotsByCommodity := map[string][]*core.Lot{}
+	for ln, ctol := range a.Lots {
+		if len(ln) == 0 {
+			continue
+		}
+		for cn, l := range ctol {
+			if !l.Balance.Amount.IsZero() {
+				lotsByCommodity[cn] = append(lotsByCommodity[cn], l)
+			}
+		}
+	}
+	commodities := make([]string, len(lotsByCommodity))[:0]
+	for cn := range lotsByCommodity {
+		commodities = append(commodities, cn)
+	}
+	sort.Strings(commodities)
+
+	fmt.Printf("%v %v %v date\n", date.Year, date.Month, date.Day)
+	for _, cn := range commodities {
+		lots := lotsByCommodity[cn]
+		sort.Slice(lots, func(i, j int) bool { return lots[i].Name < lots[j].Name })
+		fmt.Printf("(Closing %v\n", parser.Quote("Close "+a.Name))
+		total := decimal.Decimal{}
+		for _, l := range lots {
+			fmt.Printf("\t%v %v %v xfer %v lot\n", ledgerToken(a.Name), l.Balance.Amount.Neg(), ledgerToken(cn), ledgerToken(l.Name))
+			total = total.Add(l.Balance.Amount)
+		}
+		fmt.Printf("\t%v %v %v xfer\n", ledgerToken(targetName), total, ledgerToken(cn))
+		fmt.Println("\txact)")
+	}
+	fmt.Printf("%v close\n", ledgerToken(a.Name))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var closeBooksCmd = &cobra.Command{
+	Use:   "close-books",
+	Short: "Print transactions that close a fiscal year's books",
+	Long: `The close-books subcommand reads a ledger from standard input and prints
+a transaction in the ledger language that closes the books at the end
+of a fiscal year.  The transaction transfers the balances of open
+income and expense accounts ("Income:" and "Expenses:") as of the
+last day of the fiscal year to the equity account and is dated on
+that day, so insert it where the fiscal year ends.  Nothing is printed
+if there are no such balances.
+
+The -y flag specifies the fiscal year to close.  It defaults to the
+fiscal year containing the last date in the ledger.  Fiscal years
+respect the --fiscal-year-start flag, so with --fiscal-year-start 07-01,
+"-y 2021" closes the books on June 30, 2021.  Freebean stops parsing
+after the fiscal year's last day.
+
+The -a flag specifies the equity account.  It defaults to
+"Equity:RetainedEarnings".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCloseBooks()
+	},
+}
+
+var closeBooksOptions = struct {
+	FiscalYear    int
+	EquityAccount string
+}{}
+
+func init() {
+	rootCmd.AddCommand(closeBooksCmd)
+	closeBooksCmd.Flags().IntVarP(&closeBooksOptions.FiscalYear, "fiscal-year", "y", 0, "fiscal year to close")
+	closeBooksCmd.Flags().StringVarP(&closeBooksOptions.EquityAccount, "equity-account", "a", "Equity:RetainedEarnings", "account that receives income and expense balances")
+}
+
+func runCloseBooks() {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	if fy := closeBooksOptions.FiscalYear; fy != 0 {
+		last := calendar.FiscalYearStart.LastDay(fy)
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(last) {
+				stop()
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	fy := closeBooksOptions.FiscalYear
+	if fy == 0 {
+		fy = calendar.FiscalYearStart.FiscalYear(ctx.Date)
+	}
+	entry := importer.Entry{
+		Date:        calendar.FiscalYearStart.LastDay(fy),
+		Entity:      "close-books",
+		Description: fmt.Sprintf("Close the books for fiscal year %v", fy),
+	}
+	totals := map[string]decimal.Decimal{}
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) || !inBook(a) || (!strings.HasPrefix(an, "Income:") && !strings.HasPrefix(an, "Expenses:")) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				if l.Balance.Amount.IsZero() {
+					continue
+				}
+				entry.Postings = append(entry.Postings, importer.Posting{Account: an, Amount: l.Balance.Amount.Neg(), Commodity: cn, Lot: ln})
+				totals[cn] = totals[cn].Add(l.Balance.Amount)
+			}
+		}
+	}
+	if len(entry.Postings) == 0 {
+		return
+	}
+	sort.Slice(entry.Postings, func(i, j int) bool {
+		pi, pj := entry.Postings[i], entry.Postings[j]
+		if pi.Account != pj.Account {
+			return pi.Account < pj.Account
+		} else if pi.Lot != pj.Lot {
+			return pi.Lot < pj.Lot
+		}
+		return pi.Commodity < pj.Commodity
+	})
+	commodities := make([]string, len(totals))[:0]
+	for cn := range totals {
+		commodities = append(commodities, cn)
+	}
+	sort.Strings(commodities)
+	for _, cn := range commodities {
+		if !totals[cn].IsZero() {
+			entry.Postings = append(entry.Postings, importer.Posting{Account: closeBooksOptions.EquityAccount, Amount: totals[cn], Commodity: cn})
+		}
+	}
+	if err := importer.WriteEntries(os.Stdout, []importer.Entry{entry}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var commoditiesCmd = &cobra.Command{
+	Use:   "commodities",
+	Short: "Print all commodities",
+	Long: `The commodities subcommand reads a ledger from standard input
+and prints all commodities in CSV format.  The output includes a header
+and these columns:
+
+  name           the commodity's name
+  description    the commodity's description
+  creation date  the date on which the commodity was created
+  notes          the commodity's notes as NAME=VALUE pairs separated
+                 by semicolons, sorted by name
+
+The -d flag specifies the date on which to stop parsing.
+See "freebean help" for the accepted date formats.  Parsing stops
+at the end of the day, so commodities created on that day
+are included.  Freebean parses all input by default.
+
+The -C flag specifies a comma-separated list of columns to print,
+in order, such as "name,notes".  Column names are the header's
+names; hyphens or underscores may replace spaces.  All columns
+are printed by default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCommodities()
+	},
+}
+
+var commoditiesOptions = struct {
+	Date    EndDate
+	Columns []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(commoditiesCmd)
+	commoditiesCmd.Flags().VarP(&commoditiesOptions.Date, "date", "d", "date to stop parsing")
+	commoditiesCmd.Flags().StringSliceVarP(&commoditiesOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// formatNotes formats notes as NAME=VALUE pairs separated by semicolons,
+// sorted by name.
+func formatNotes(notes map[string]string) string {
+	pairs := make([]string, len(notes))[:0]
+	for nn, nv := range notes {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", nn, nv))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "; ")
+}
+
+func runCommodities() {
+	w, err := newTableWriter(os.Stdout, []string{"name", "description", "creation date", "notes"}, commoditiesOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	date := core.Date(commoditiesOptions.Date)
+	if !date.IsZero() {
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(date) {
+				stop()
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	names := make([]string, len(p.Context().Commodities))[:0]
+	for cn := range p.Context().Commodities {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+	for _, cn := range names {
+		c := p.Context().Commodities[cn]
+		w.Write([]string{cn, c.Description, formatDate(c.CreationDate), formatNotes(c.Notes)})
+	}
+	w.Flush()
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var documentsCmd = &cobra.Command{
+	Use:   "documents",
+	Short: "Print and verify transactions' documents",
+	Long: `The documents subcommand reads a ledger from standard input and prints
+the documents supporting its transactions, such as receipts and invoices,
+in CSV format.  Transfers' documents are set by the set-document function,
+and transactions' documents are the values of their document notes:
+
+  (Store "Printer paper"
+      Expenses:Office 20 USD xfer receipts/2021/paper.pdf set-document
+      Assets:Checking -20 USD xfer
+      document https://example.com/invoices/1234
+      xact)
+
+The output includes a header and has one row per document, in ledger
+order, with these columns:
+
+  date         the transaction's date
+  entity       the transaction's entity
+  description  the transaction's description
+  account      the transfer's account or empty for transactions'
+               documents
+  document     the document's path or URL
+  status       "ok" if the document is a file that exists, "missing"
+               if it does not exist, or "url" if the document is a URL,
+               which Freebean does not check
+
+Relative paths are relative to the directory specified by the -r flag,
+which is the current directory by default.  Freebean exits with exit
+code 3 if any documents are missing.
+
+The -m flag makes Freebean print only missing documents.
+
+The -s flag specifies the first day of the transactions to include.
+By default, the transactions start at the beginning of the ledger.
+
+The -e flag specifies the last day of the transactions to include.
+Freebean stops parsing at the end of that day.  Freebean parses all
+input by default.  See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDocuments()
+	},
+}
+
+var documentsOptions = struct {
+	Root        string
+	MissingOnly bool
+	StartDate   Date
+	EndDate     EndDate
+	Columns     []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(documentsCmd)
+	documentsCmd.Flags().StringVarP(&documentsOptions.Root, "root", "r", ".", "directory containing relative paths")
+	documentsCmd.Flags().BoolVarP(&documentsOptions.MissingOnly, "missing-only", "m", false, "print only missing documents")
+	documentsCmd.Flags().VarP(&documentsOptions.StartDate, "start-date", "s", "first day of transactions")
+	documentsCmd.Flags().VarP(&documentsOptions.EndDate, "end-date", "e", "last day of transactions")
+	documentsCmd.Flags().StringSliceVarP(&documentsOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// documentStatus returns the status column's value for a document.
+func documentStatus(document string) string {
+	if strings.Contains(document, "://") {
+		return "url"
+	}
+	path := document
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(documentsOptions.Root, path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "missing"
+	}
+	return "ok"
+}
+
+func runDocuments() {
+	w, err := newTableWriter(os.Stdout, []string{"date", "entity", "description", "account", "document", "status"}, documentsOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(documentsOptions.StartDate)
+	endDate := core.Date(documentsOptions.EndDate)
+	missing := false
+
+	write := func(ctx *core.Context, xact *functions.Transaction, account, document string) {
+		status := documentStatus(document)
+		if status == "missing" {
+			missing = true
+		} else if documentsOptions.MissingOnly {
+			return
+		}
+		w.Write([]string{formatDate(ctx.Date), xact.Entity, xact.Description, account, document, status})
+	}
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		} else if ctx.Date.Before(startDate) {
+			return nil
+		}
+		inBooks := false
+		for _, t := range xact.Transfers {
+			inBooks = inBooks || inBook(t.Account)
+		}
+		if document, ok := xact.Notes[functions.DocumentNote]; ok && inBooks {
+			write(ctx, &xact, "", document)
+		}
+		for _, t := range xact.Transfers {
+			if len(t.Document) != 0 && inBook(t.Account) {
+				write(ctx, &xact, t.Account.Name, t.Document)
+			}
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	w.Flush()
+	if missing {
+		os.Exit(3)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var cleanupDustCmd = &cobra.Command{
+	Use:   "cleanup-dust",
+	Short: "Print transactions that write off dust balances",
+	Long: `The cleanup-dust subcommand reads a ledger from standard input, finds
+asset and liability lots with dust balances, and prints a transaction
+in the ledger language that writes them off.  Dust balances are nonzero
+balances whose absolute values are less than their commodities'
+thresholds, such as residue left by rounding.
+
+The transaction transfers each dust balance out of its lot and
+the total of each commodity's dust balances to the rounding account.
+It is dated on the day parsing stopped.  Nothing is printed if there
+are no dust balances.  Amounts in the transaction may have more decimal
+places than decimal-places calls allow, so append the transaction
+before such calls for the affected commodities.
+
+The -t flag specifies a commodity's threshold as COMMODITY=AMOUNT,
+such as "USD=0.01".  It may be repeated or given comma-separated pairs.
+Commodities without thresholds are ignored.  At least one threshold
+is required.
+
+The -a flag specifies the rounding account.  It defaults to
+"Expenses:Rounding".
+
+The -d flag specifies the date on which to stop parsing.
+See "freebean help" for the accepted date formats.  Freebean parses
+all input by default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCleanupDust()
+	},
+}
+
+var cleanupDustOptions = struct {
+	Date            EndDate
+	Thresholds      map[string]string
+	RoundingAccount string
+}{}
+
+func init() {
+	rootCmd.AddCommand(cleanupDustCmd)
+	cleanupDustCmd.Flags().VarP(&cleanupDustOptions.Date, "date", "d", "date to stop parsing")
+	cleanupDustCmd.Flags().StringToStringVarP(&cleanupDustOptions.Thresholds, "threshold", "t", nil, "dust threshold of a commodity (COMMODITY=AMOUNT)")
+	cleanupDustCmd.Flags().StringVarP(&cleanupDustOptions.RoundingAccount, "rounding-account", "a", "Expenses:Rounding", "account that receives dust balances")
+}
+
+func runCleanupDust() {
+	if len(cleanupDustOptions.Thresholds) == 0 {
+		fmt.Fprintln(os.Stderr, "no thresholds specified")
+		os.Exit(1)
+	}
+	thresholds := map[string]decimal.Decimal{}
+	for cn, s := range cleanupDustOptions.Thresholds {
+		threshold, err := functions.ParseDecimal(s)
+		if err != nil || !threshold.IsPositive() {
+			fmt.Fprintf(os.Stderr, "illegal threshold for %v: %v\n", cn, s)
+			os.Exit(1)
+		}
+		thresholds[cn] = threshold
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	date := core.Date(cleanupDustOptions.Date)
+	if !date.IsZero() {
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(date) {
+				stop()
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	entry := importer.Entry{Date: ctx.Date, Entity: "cleanup-dust", Description: "Write off dust balances"}
+	if !date.IsZero() {
+		entry.Date = date
+	}
+	totals := map[string]decimal.Decimal{}
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) || !inBook(a) || (!strings.HasPrefix(an, "Assets:") && !strings.HasPrefix(an, "Liabilities:")) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				threshold, ok := thresholds[cn]
+				if !ok || l.Balance.Amount.IsZero() || l.Balance.Amount.Abs().GreaterThanOrEqual(threshold) {
+					continue
+				}
+				entry.Postings = append(entry.Postings, importer.Posting{Account: an, Amount: l.Balance.Amount.Neg(), Commodity: cn, Lot: ln})
+				totals[cn] = totals[cn].Add(l.Balance.Amount)
+			}
+		}
+	}
+	if len(entry.Postings) == 0 {
+		return
+	}
+	sort.Slice(entry.Postings, func(i, j int) bool {
+		pi, pj := entry.Postings[i], entry.Postings[j]
+		if pi.Account != pj.Account {
+			return pi.Account < pj.Account
+		} else if pi.Lot != pj.Lot {
+			return pi.Lot < pj.Lot
+		}
+		return pi.Commodity < pj.Commodity
+	})
+	commodities := make([]string, len(totals))[:0]
+	for cn := range totals {
+		commodities = append(commodities, cn)
+	}
+	sort.Strings(commodities)
+	for _, cn := range commodities {
+		if !totals[cn].IsZero() {
+			entry.Postings = append(entry.Postings, importer.Posting{Account: cleanupDustOptions.RoundingAccount, Amount: totals[cn], Commodity: cn})
+		}
+	}
+	if err := importer.WriteEntries(os.Stdout, []importer.Entry{entry}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var evalCmd = &cobra.Command{
+	Use:   "eval [LEDGER]",
+	Short: "Evaluate ledger code from the command line",
+	Long: `The eval subcommand evaluates the Freebean code given by the -e flag
+instead of reading standard input and prints the operand stack and
+the balances of all open accounts afterwards, one per line.  It's handy
+for quick experiments and shell one-liners, such as:
+
+  freebean eval -e '2 3 add'
+
+Unlike other subcommands, eval leaves unconsumed operands on the stack
+and permits unclosed parentheses, so that the stack can be inspected.
+
+If a LEDGER file is specified, Freebean evaluates it first.
+
+The -e flag may be repeated; Freebean evaluates the expressions in order
+as though they were separate lines.  At least one is required.
+
+The -B flag suppresses the balances.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runEval(args)
+	},
+}
+
+var evalOptions = struct {
+	Expressions  []string
+	SkipBalances bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(evalCmd)
+	evalCmd.Flags().StringArrayVarP(&evalOptions.Expressions, "expression", "e", nil, "code to evaluate")
+	evalCmd.Flags().BoolVarP(&evalOptions.SkipBalances, "skip-balances", "B", false, "do not print balances")
+}
+
+func runEval(args []string) {
+	if len(evalOptions.Expressions) == 0 {
+		fmt.Fprintln(os.Stderr, "no expressions specified")
+		os.Exit(1)
+	}
+	r := &repl{}
+	if len(args) != 0 {
+		ledger, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		r.ledger = string(ledger)
+	}
+	if err := r.reset(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	for _, expr := range evalOptions.Expressions {
+		if err := r.p.ParseMore(strings.NewReader(expr)); err != nil {
+			fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+			os.Exit(2)
+		}
+	}
+	r.printStack(os.Stdout)
+	if !evalOptions.SkipBalances {
+		r.printBalances(os.Stdout, "")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Print recorded events",
+	Long: `The events subcommand reads a ledger from standard input and prints
+the events recorded by the event function in CSV format.  The output
+includes a header and has one row per event in chronological order,
+with these columns:
+
+  date      the date of the event
+  name      the event's name, such as "location"
+  value     the event's value, such as "Tokyo"
+  last day  the day before the next event with the same name or
+            the ledger's final date (or the -e flag's date)
+  days      the number of days from the event's date through
+            its last day
+
+For example, this ledger records moves between tax jurisdictions:
+
+  2021 1 1 date location Tokyo event
+  2021 7 15 date location Osaka event
+
+An event's days count the days on which its value held, which helps with
+tracking residency.
+
+The -s flag omits events whose last days are before the specified date.
+
+The -e flag specifies the last day to include.  Freebean stops parsing
+at the end of that day.  Freebean parses all input by default.
+See "freebean help" for the accepted date formats.
+
+The -n flag prints only events with the specified names.  It accepts
+a comma-separated list and may be repeated.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runEvents()
+	},
+}
+
+var eventsOptions = struct {
+	StartDate Date
+	EndDate   EndDate
+	Names     []string
+	Columns   []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+	eventsCmd.Flags().VarP(&eventsOptions.StartDate, "start-date", "s", "omit events ending before this date")
+	eventsCmd.Flags().VarP(&eventsOptions.EndDate, "end-date", "e", "last day to include")
+	eventsCmd.Flags().StringSliceVarP(&eventsOptions.Names, "names", "n", nil, "names of the events to print")
+	eventsCmd.Flags().StringSliceVarP(&eventsOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+func runEvents() {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(eventsOptions.StartDate)
+	endDate := core.Date(eventsOptions.EndDate)
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	final := ctx.Date
+	if !endDate.IsZero() {
+		final = endDate
+	}
+	names := map[string]bool{}
+	for _, name := range eventsOptions.Names {
+		names[name] = true
+	}
+	w, err := newTableWriter(os.Stdout, []string{"date", "name", "value", "last day", "days"}, eventsOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for n, e := range ctx.Events {
+		if e.Date.After(final) || (len(names) != 0 && !names[e.Name]) {
+			continue
+		}
+		last := final
+		for _, next := range ctx.Events[n+1:] {
+			if next.Name == e.Name {
+				if next.Date.BeforeOrEqual(final) {
+					last = next.Date.AddDays(-1)
+				}
+				break
+			}
+		}
+		if last.Before(startDate) {
+			continue
+		}
+		days := core.DaysBetween(e.Date, last) + 1
+		w.Write([]string{formatDate(e.Date), e.Name, e.Value, formatDate(last), fmt.Sprint(days)})
+	}
+	w.Flush()
+}
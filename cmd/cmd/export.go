@@ -0,0 +1,329 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Convert ledger data into foreign documents",
+	Long: `The export subcommands read a ledger from standard input and print
+documents derived from it, such as reimbursement claims, to standard
+output.`,
+}
+
+var exportClaimCmd = &cobra.Command{
+	Use:   "claim CLAIM",
+	Short: "Print a reimbursement claim",
+	Long: `The claim export subcommand reads a ledger from standard input and
+prints a reimbursement claim, such as a mileage, per-diem, or expense
+claim, for the transactions linked to CLAIM.  A transaction is linked
+to a claim by a note whose value is the claim's name:
+
+  (Shell "Fuel for client visit"
+      Expenses:Travel:Fuel 45.20 USD xfer
+      Liabilities:Card -45.20 USD xfer
+      "claim"   "2021-03-trip"
+      "receipt" "receipts/2021-03-02-shell.pdf"
+      xact)
+
+The claim lists each of the linked transactions' transfers to accounts
+with the category prefix, sums them per category (the account name
+without the prefix), and lists the transactions' documents, including
+the documents of the claimed transfers (see the set-document function).
+
+Amounts may be converted to a single commodity with the -c flag using
+the most recent prices on or before the transactions' dates, which
+suits claims measured in units such as miles or days.  For example,
+if the ledger contains "MILE 0.585 USD price", -c USD converts a
+transfer of 100 MILE into 58.5 USD.  Freebean reports an error if it
+cannot convert an amount.
+
+The -r flag specifies where -c gets exchange rates that the ledger
+lacks: "ledger" (the default) uses the ledger's prices only, "ecb"
+also uses the European Central Bank's euro reference rates, and
+"exchangerate.host" also uses exchangerate.host's rates, which requires
+an access key in the FREEBEAN_EXCHANGERATE_HOST_KEY environment
+variable.  Requests for the web services' rates time out after
+30 seconds.
+
+The -f flag selects the output format: "csv" (the default) or
+"markdown".  CSV output includes a header and these columns:
+
+  section      item, subtotal, or total
+  date         the transaction's date (items only)
+  entity       the transaction's entity (items only)
+  description  the transaction's description (items only)
+  category     the transfer's category (items and subtotals)
+  amount       the amount claimed
+  documents    the transaction's documents, separated by semicolons
+               (items only)
+
+Markdown output contains a title, a table of items, a table of subtotals
+and totals, and a list of documents.
+
+The -n flag specifies the name of the notes linking transactions
+to claims (default "claim").
+
+The -D flag specifies the names of the notes holding document
+references (default "receipt").  It may be repeated.
+
+The -p flag specifies the category prefix (default "Expenses:").
+
+The -d flag specifies the date on which to stop parsing.
+See "freebean help" for the accepted date formats.  Parsing stops
+at the end of the day, so transactions on that day are included.
+Freebean parses all input by default.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runExportClaim(args[0])
+	},
+}
+
+var exportClaimOptions = struct {
+	Date      EndDate
+	Format    string
+	Note      string
+	Documents []string
+	Prefix    string
+	Commodity string
+	Rates     RateSource
+}{Rates: LedgerRates}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportClaimCmd)
+	exportClaimCmd.Flags().VarP(&exportClaimOptions.Date, "date", "d", "date to stop parsing")
+	exportClaimCmd.Flags().StringVarP(&exportClaimOptions.Format, "format", "f", "csv", "output format (csv or markdown)")
+	exportClaimCmd.Flags().StringVarP(&exportClaimOptions.Note, "note", "n", "claim", "name of the notes linking transactions to claims")
+	exportClaimCmd.Flags().StringSliceVarP(&exportClaimOptions.Documents, "documents", "D", []string{"receipt"}, "names of the notes holding document references")
+	exportClaimCmd.Flags().StringVarP(&exportClaimOptions.Prefix, "prefix", "p", "Expenses:", "category account prefix")
+	exportClaimCmd.Flags().StringVarP(&exportClaimOptions.Commodity, "commodity", "c", "", "commodity in which to claim amounts")
+	exportClaimCmd.Flags().VarP(&exportClaimOptions.Rates, "rates", "r", "exchange rate source (ledger, ecb, or exchangerate.host)")
+}
+
+// claimItem is a transfer included in a claim.
+type claimItem struct {
+	Date        core.Date
+	Entity      string
+	Description string
+	Category    string
+	Amount      core.Quantity
+	Documents   []string
+}
+
+// claim is a reimbursement claim.
+type claim struct {
+	Name  string
+	Items []claimItem
+}
+
+// sums returns the claim's amounts summed per category and commodity
+// and the sorted category names.  The empty category holds the totals.
+func (c *claim) sums() (map[string]map[string]decimal.Decimal, []string) {
+	sums := map[string]map[string]decimal.Decimal{"": {}}
+	var categories []string
+	for _, item := range c.Items {
+		if _, ok := sums[item.Category]; !ok {
+			sums[item.Category] = map[string]decimal.Decimal{}
+			categories = append(categories, item.Category)
+		}
+		cn := item.Amount.Commodity.Name
+		sums[item.Category][cn] = sums[item.Category][cn].Add(item.Amount.Amount)
+		sums[""][cn] = sums[""][cn].Add(item.Amount.Amount)
+	}
+	sort.Strings(categories)
+	return sums, categories
+}
+
+func sortedCommodityNames(ctoa map[string]decimal.Decimal) []string {
+	names := make([]string, len(ctoa))[:0]
+	for cn := range ctoa {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *claim) writeCSV(out io.Writer) error {
+	w, err := newTableWriter(out, []string{"section", "date", "entity", "description", "category", "amount", "documents"}, nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range c.Items {
+		w.Write([]string{"item", formatDate(item.Date), item.Entity, item.Description, item.Category, item.Amount.String(), strings.Join(item.Documents, ";")})
+	}
+	sums, categories := c.sums()
+	for _, category := range append(categories, "") {
+		section := "subtotal"
+		if len(category) == 0 {
+			section = "total"
+		}
+		for _, cn := range sortedCommodityNames(sums[category]) {
+			w.Write([]string{section, "", "", "", category, fmt.Sprintf("%v %v", sums[category][cn], cn), ""})
+		}
+	}
+	w.Flush()
+	return w.w.Error()
+}
+
+// markdownCell escapes a table cell's text.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+func (c *claim) writeMarkdown(out io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Claim %v\n\n", markdownCell(c.Name))
+	b.WriteString("| Date | Entity | Description | Category | Amount |\n")
+	b.WriteString("| --- | --- | --- | --- | ---: |\n")
+	for _, item := range c.Items {
+		fmt.Fprintf(&b, "| %v | %v | %v | %v | %v |\n", formatDate(item.Date), markdownCell(item.Entity), markdownCell(item.Description), markdownCell(item.Category), item.Amount)
+	}
+	b.WriteString("\n| Category | Amount |\n")
+	b.WriteString("| --- | ---: |\n")
+	sums, categories := c.sums()
+	for _, category := range append(categories, "") {
+		label := markdownCell(category)
+		if len(category) == 0 {
+			label = "**Total**"
+		}
+		for _, cn := range sortedCommodityNames(sums[category]) {
+			fmt.Fprintf(&b, "| %v | %v %v |\n", label, sums[category][cn], cn)
+		}
+	}
+	var documents []string
+	for _, item := range c.Items {
+		for _, d := range item.Documents {
+			if len(documents) == 0 || documents[len(documents)-1] != d {
+				documents = append(documents, d)
+			}
+		}
+	}
+	if len(documents) != 0 {
+		b.WriteString("\n## Documents\n\n")
+		for _, d := range documents {
+			fmt.Fprintf(&b, "- %v\n", d)
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func runExportClaim(claimName string) {
+	var write func(*claim, io.Writer) error
+	switch exportClaimOptions.Format {
+	case "csv":
+		write = (*claim).writeCSV
+	case "markdown":
+		write = (*claim).writeMarkdown
+	default:
+		fmt.Fprintf(os.Stderr, "invalid format %#v: expected csv or markdown\n", exportClaimOptions.Format)
+		os.Exit(1)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	rp := exportClaimOptions.Rates.provider(p.Context().Prices)
+	c := &claim{Name: claimName}
+	date := core.Date(exportClaimOptions.Date)
+	if !date.IsZero() {
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(date) {
+				stop()
+			}
+			return nil
+		}
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		} else if xact.Notes[exportClaimOptions.Note] != claimName {
+			return nil
+		}
+		var documents []string
+		for _, dn := range exportClaimOptions.Documents {
+			if d, ok := xact.Notes[dn]; ok {
+				documents = append(documents, d)
+			}
+		}
+		for _, t := range xact.Transfers {
+			if !strings.HasPrefix(t.Account.Name, exportClaimOptions.Prefix) {
+				continue
+			}
+			itemDocuments := documents
+			if len(t.Document) != 0 {
+				itemDocuments = append(documents[:len(documents):len(documents)], t.Document)
+			}
+			q := t.Quantity
+			if len(exportClaimOptions.Commodity) != 0 {
+				to, ok := ctx.Commodities[exportClaimOptions.Commodity]
+				if !ok {
+					return fmt.Errorf("%v: nonexistent commodity %v", fn, exportClaimOptions.Commodity)
+				} else if q, err = core.ConvertWith(rp, q, to, ctx.Date); err != nil {
+					return fmt.Errorf("%v: %v", fn, err)
+				}
+			}
+			c.Items = append(c.Items, claimItem{
+				Date:        ctx.Date,
+				Entity:      xact.Entity,
+				Description: xact.Description,
+				Category:    strings.TrimPrefix(t.Account.Name, exportClaimOptions.Prefix),
+				Amount:      q,
+				Documents:   itemDocuments})
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if err := write(c, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
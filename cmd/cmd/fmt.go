@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/format"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt",
+	Short: "Print a ledger in canonical style",
+	Long: `The fmt subcommand reads a ledger from standard input and prints it
+in a canonical style to standard output.  Each function call appears
+on its own line, except that calls that modify transfers (such as
+set-comment and lot) follow their transfers and arithmetic calls
+(such as add and div) stay with the calls that use their results.
+The contents of
+parentheses are indented one tab per level, opening parentheses
+are attached to the first line they contain, and closing parentheses
+are attached to the last.  Transaction notes appear one per line
+with aligned values.
+
+Formatting preserves every token exactly as written, including comments,
+so it never changes a ledger's meaning.  Single blank lines separating
+parts of the ledger are also preserved.  The fmt subcommand does not
+evaluate the ledger, so it does not report errors other than syntax
+errors.
+
+The --colon-definitions flag formats ":" and ";" word definitions,
+such as ": double 2 mul ;", as definitions rather than operands.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runFmt()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fmtCmd)
+	fmtCmd.Flags().BoolVar(&colonDefinitions, "colon-definitions", false, `format ":" and ";" word definitions`)
+}
+
+func runFmt() {
+	w := bufio.NewWriter(os.Stdout)
+	f := format.NewFormatter()
+	f.ColonDefinitions = colonDefinitions
+	if err := f.Format(parser.NewLexer(os.Stdin), w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	w.Flush()
+}
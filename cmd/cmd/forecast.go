@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/jtvaughan/freebean/pkg/schedule"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var forecastCmd = &cobra.Command{
+	Use:   "forecast SCHEDULE",
+	Short: "Project balances using scheduled recurring transactions",
+	Long: `The forecast subcommand reads a ledger from standard input, applies
+the recurring transactions in the specified schedule file (see
+"freebean help schedule") to it starting the day after the ledger's
+final date, and prints the projected balances of open accounts at the end
+of each period in CSV format.  The output includes a header and has
+one row per period, account, and commodity.  Scheduled transactions must
+satisfy the same rules as the ledger's transactions, so they may only
+use accounts and commodities that exist.
+
+The -n flag specifies how many periods to project.  The default is 12.
+
+The -p flag specifies the period: week, month (the default), or year.
+Years are fiscal years.  Periods respect the --week-start, --month-start,
+and --fiscal-year-start flags.  The first
+period is the one containing the ledger's final date.
+
+The -a flag limits the output to accounts whose names start with
+the specified prefix.  It may be repeated.
+
+The -H flag specifies additional holiday files for the schedule.
+It may be repeated.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runForecast(args[0])
+	},
+}
+
+var forecastOptions = struct {
+	Periods  int
+	Period   string
+	Prefixes []string
+	Holidays []string
+	Columns  []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(forecastCmd)
+	forecastCmd.Flags().IntVarP(&forecastOptions.Periods, "periods", "n", 12, "number of periods to project")
+	forecastCmd.Flags().StringVarP(&forecastOptions.Period, "period", "p", "month", "period length (week, month, or year)")
+	forecastCmd.Flags().StringArrayVarP(&forecastOptions.Prefixes, "account-prefix", "a", nil, "account name prefix")
+	forecastCmd.Flags().StringArrayVarP(&forecastOptions.Holidays, "holidays", "H", nil, "holiday file")
+	forecastCmd.Flags().StringSliceVarP(&forecastOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func runForecast(path string) {
+	var period func(core.Date) (core.Date, core.Date)
+	switch forecastOptions.Period {
+	case "week":
+		period = calendar.Week
+	case "month":
+		period = calendar.Month
+	case "year":
+		period = calendar.FiscalYear
+	default:
+		fmt.Fprintf(os.Stderr, "unknown period: %v\n", forecastOptions.Period)
+		os.Exit(1)
+	}
+	s := schedule.NewSchedule()
+	for _, p := range append(forecastOptions.Holidays, path) {
+		if err := parseScheduleFile(s, p); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+
+	ctx := p.Context()
+	header := []string{"date", "account name", "commodity", "balance"}
+	w, err := newTableWriter(os.Stdout, header, forecastOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	next := ctx.Date.AddDays(1)
+	for n := 0; n < forecastOptions.Periods; n++ {
+		_, last := period(next)
+		entries, err := s.Entries(next, last)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		var buf bytes.Buffer
+		importer.WriteEntries(&buf, entries)
+		fp := functions.NewParserWithContext(&buf, ctx)
+		fp.AddCoreFunctions()
+		if err := fp.Parse(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			os.Exit(2)
+		}
+
+		names := make([]string, len(ctx.Accounts))[:0]
+		for an, a := range ctx.Accounts {
+			if !a.IsClosed(last) && inBook(a) && hasAnyPrefix(an, forecastOptions.Prefixes) {
+				names = append(names, an)
+			}
+		}
+		sort.Strings(names)
+		for _, an := range names {
+			balances := ctx.Accounts[an].Balances()
+			commodities := make([]string, len(balances))[:0]
+			for cn := range balances {
+				commodities = append(commodities, cn)
+			}
+			sort.Strings(commodities)
+			for _, cn := range commodities {
+				w.Write([]string{formatDate(last), an, cn, balances[cn].String()})
+			}
+		}
+		next = last.AddDays(1)
+	}
+	w.Flush()
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var gainsCmd = &cobra.Command{
+	Use:   "gains",
+	Short: "Print realized capital gains",
+	Long: `The gains subcommand reads a ledger from standard input and prints
+the gains and losses realized by reducing lots with exchange rates,
+such as by selling shares, in CSV format.  The output includes a header
+and has one row per tax year, account, lot, and commodity, sorted
+in that order, with these columns:
+
+  tax year    the fiscal year in which the gains were realized
+              (see the --fiscal-year-start flag)
+  account     the account holding the lot
+  lot         the lot's name
+  commodity   the lot's commodity
+  quantity    the total amount by which the lot was reduced
+  proceeds    the total amount received for the reductions
+  cost basis  the lot's exchange rate applied to the quantity
+  gain        the proceeds minus the cost basis; losses are negative
+
+A reduction realizes a gain when a transfer with an exchange rate removes
+a commodity from a lot whose exchange rate is in the same commodity
+as the transfer's, such as:
+
+  Assets:Broker -5 ACME 130 USD -650 USD xfer-exch lot1 lot
+
+Transfers created by xfer-reduce also realize gains.
+
+The -y flag prints only the specified tax years.  It accepts
+a comma-separated list and may be repeated.
+
+The -C flag selects which columns to print and in what order.
+
+The --checkpoint flag speeds up parsing.  See "freebean help".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runGains()
+	},
+}
+
+var gainsOptions = struct {
+	TaxYears []int
+	Columns  []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(gainsCmd)
+	gainsCmd.Flags().IntSliceVarP(&gainsOptions.TaxYears, "tax-years", "y", nil, "tax years to print")
+	gainsCmd.Flags().StringSliceVarP(&gainsOptions.Columns, "columns", "C", nil, "columns to print")
+	addCheckpointFlag(gainsCmd)
+}
+
+// gainsKey identifies a row of the gains subcommand's output.
+type gainsKey struct {
+	taxYear                 int
+	account, lot, commodity string
+	proceedsCommodity       string
+}
+
+// gainsRow sums realized gains.
+type gainsRow struct {
+	quantity, proceeds, costBasis core.Quantity
+}
+
+func runGains() {
+	ctx, err := parseStdin()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	years := map[int]bool{}
+	for _, y := range gainsOptions.TaxYears {
+		years[y] = true
+	}
+	rows := map[gainsKey]*gainsRow{}
+	var keys []gainsKey
+	for _, g := range ctx.RealizedGains {
+		k := gainsKey{taxYear: calendar.FiscalYearStart.FiscalYear(g.Date), account: g.Account, lot: g.LotName, commodity: g.Quantity.Commodity.Name, proceedsCommodity: g.Proceeds.Commodity.Name}
+		if len(years) != 0 && !years[k.taxYear] {
+			continue
+		}
+		if r, ok := rows[k]; ok {
+			r.quantity.Amount = r.quantity.Amount.Add(g.Quantity.Amount)
+			r.proceeds.Amount = r.proceeds.Amount.Add(g.Proceeds.Amount)
+			r.costBasis.Amount = r.costBasis.Amount.Add(g.CostBasis.Amount)
+		} else {
+			rows[k] = &gainsRow{quantity: g.Quantity, proceeds: g.Proceeds, costBasis: g.CostBasis}
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.taxYear != b.taxYear {
+			return a.taxYear < b.taxYear
+		} else if a.account != b.account {
+			return a.account < b.account
+		} else if a.lot != b.lot {
+			return a.lot < b.lot
+		} else if a.commodity != b.commodity {
+			return a.commodity < b.commodity
+		}
+		return a.proceedsCommodity < b.proceedsCommodity
+	})
+	w, err := newTableWriter(os.Stdout, []string{"tax year", "account", "lot", "commodity", "quantity", "proceeds", "cost basis", "gain"}, gainsOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, k := range keys {
+		r := rows[k]
+		w.Write([]string{
+			fmt.Sprint(k.taxYear),
+			k.account,
+			k.lot,
+			k.commodity,
+			r.quantity.Commodity.FormatAmount(r.quantity.Amount),
+			r.proceeds.String(),
+			r.costBasis.String(),
+			core.RealizedGain{Proceeds: r.proceeds, CostBasis: r.costBasis}.Gain().String()})
+	}
+	w.Flush()
+}
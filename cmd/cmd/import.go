@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Convert foreign data into ledger syntax",
+	Long: `The import subcommands read foreign financial data, such as
+bank statements, from standard input and print the equivalent
+transactions in Freebean's ledger language to standard output.
+
+The --suggest-from flag specifies a ledger file whose past transactions
+categorize the imported ones: transfers to the csv subcommand's default
+account or the qif subcommand's uncategorized account are moved to
+the account that the suggest-account subcommand would suggest for
+the transaction's entity and the transfer's amount, if any.
+The --suggest-prefixes flag specifies the prefixes of the accounts
+that may be suggested as a comma-separated list.  The default is
+"Expenses,Income".`,
+}
+
+var importCSVCmd = &cobra.Command{
+	Use:   "csv [rules file]",
+	Short: "Convert a bank statement CSV file into ledger syntax",
+	Long: `The csv import subcommand reads a bank statement in CSV format
+from standard input and prints one transaction per row in Freebean's
+ledger language.  Each transaction transfers the row's amount between
+the statement's account and a counter account chosen by the rules file.
+Transactions are sorted by date.
+
+The rules file is a YAML document with these keys:
+
+  account          the statement's account
+  commodity        the statement's commodity
+  columns          a map from fields to 1-based column numbers or
+                   header names; fields are date, entity, description,
+                   amount, debit, credit, and note:NAME (which adds
+                   a transaction note named NAME)
+  date-format      the Go time layout of the date column (default
+                   "2006-01-02")
+  rules            a list of rules, each with a match key, a regular
+                   expression, and an account key; rows whose entity
+                   or description matches the expression transfer to
+                   the account, and the first matching rule wins
+  default-account  the counter account for rows that no rule matches
+  header           true if the first row is a header
+  skip-rows        the number of rows to skip before the header
+  separator        the field separator (default ",")
+  negate           true to negate all amounts, which is useful for
+                   credit card statements
+
+For example:
+
+  account: Assets:Checking
+  commodity: USD
+  date-format: 01/02/2006
+  header: true
+  columns:
+    date: Date
+    entity: Payee
+    amount: Amount
+  rules:
+    - match: (?i)coffee
+      account: Expenses:Dining
+  default-account: Expenses:Unknown
+
+The statement's account, commodity, date column, and at least one
+amount, debit, or credit column are required.  Debits are always
+treated as withdrawals and credits as deposits.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImportCSV(args[0])
+	},
+}
+
+var importQIFCmd = &cobra.Command{
+	Use:   "qif [account] [commodity]",
+	Short: "Convert a Quicken Interchange Format file into ledger syntax",
+	Long: `The qif import subcommand reads bank, cash, credit card, or
+asset/liability account data in Quicken Interchange Format from
+standard input and prints one transaction per record in Freebean's
+ledger language.  Each transaction has a transfer for the specified
+account (or the account named by the QIF data's account block, if any)
+and one transfer for each split, or one for the record's category if
+the record has no splits.  All transfers use the specified commodity.
+Memos become set-comment calls on their transfers, and check numbers
+become transaction notes named "number".  Transactions are sorted by date.
+
+Categories become accounts by prepending a prefix.  Categories of
+transfers that receive money become expense accounts; the others become
+income accounts.  Transfer categories ("[Account Name]") become
+accounts with the transfer prefix.  QIF classes are ignored.
+
+The -E, -I, and -T flags specify the expense, income, and transfer
+prefixes, respectively.  They default to "Expenses", "Income", and
+"Assets".
+
+The -u flag specifies the account that receives uncategorized transfers.
+It defaults to "Expenses:Uncategorized".
+
+The -D flag makes Freebean read dates as day/month/year instead of
+month/day/year.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImportQIF(args[0], args[1])
+	},
+}
+
+var importOptions = struct {
+	SuggestFrom     string
+	SuggestPrefixes []string
+}{}
+
+var importQIFOptions = struct {
+	ExpensePrefix        string
+	IncomePrefix         string
+	TransferPrefix       string
+	UncategorizedAccount string
+	DayFirst             bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.PersistentFlags().StringVar(&importOptions.SuggestFrom, "suggest-from", "", "ledger whose transactions categorize imported ones")
+	importCmd.PersistentFlags().StringSliceVar(&importOptions.SuggestPrefixes, "suggest-prefixes", []string{"Expenses", "Income"}, "prefixes of suggested accounts")
+	importCmd.AddCommand(importCSVCmd)
+	importCmd.AddCommand(importQIFCmd)
+	importQIFCmd.Flags().StringVarP(&importQIFOptions.ExpensePrefix, "expense-prefix", "E", "Expenses", "prefix for expense categories")
+	importQIFCmd.Flags().StringVarP(&importQIFOptions.IncomePrefix, "income-prefix", "I", "Income", "prefix for income categories")
+	importQIFCmd.Flags().StringVarP(&importQIFOptions.TransferPrefix, "transfer-prefix", "T", "Assets", "prefix for transfer categories")
+	importQIFCmd.Flags().StringVarP(&importQIFOptions.UncategorizedAccount, "uncategorized", "u", "Expenses:Uncategorized", "account for uncategorized transfers")
+	importQIFCmd.Flags().BoolVarP(&importQIFOptions.DayFirst, "day-first", "D", false, "read dates as day/month/year")
+}
+
+// categorize categorizes imported entries with the --suggest-from
+// ledger's transactions, if any.
+func categorize(entries []importer.Entry, fallback string) {
+	if len(importOptions.SuggestFrom) == 0 {
+		return
+	}
+	f, err := os.Open(importOptions.SuggestFrom)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	s, err := newSuggester(f, importOptions.SuggestPrefixes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", importOptions.SuggestFrom, err)
+		os.Exit(2)
+	}
+	s.Categorize(entries, fallback)
+}
+
+func runImportCSV(rulesFile string) {
+	f, err := os.Open(rulesFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rules, err := importer.ParseCSVRules(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", rulesFile, err)
+		os.Exit(2)
+	}
+	entries, err := rules.ConvertCSV(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	categorize(entries, rules.DefaultAccount)
+	if err = importer.WriteEntries(os.Stdout, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runImportQIF(account, commodity string) {
+	entries, err := importer.ConvertQIF(os.Stdin, importer.QIFOptions{
+		Account:              account,
+		Commodity:            commodity,
+		ExpensePrefix:        importQIFOptions.ExpensePrefix,
+		IncomePrefix:         importQIFOptions.IncomePrefix,
+		TransferPrefix:       importQIFOptions.TransferPrefix,
+		UncategorizedAccount: importQIFOptions.UncategorizedAccount,
+		DayFirst:             importQIFOptions.DayFirst})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	categorize(entries, importQIFOptions.UncategorizedAccount)
+	if err = importer.WriteEntries(os.Stdout, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var incomeCmd = &cobra.Command{
+	Use:   "income",
+	Short: "Print an income statement",
+	Long: `The income subcommand reads a ledger from standard input and prints
+an income statement in CSV format.  The output includes a header and
+these columns:
+
+  section   income, expenses, or unrealized
+  name      the account name or, for unrealized gains, the name
+            of the held commodity
+  amount    the net amount transferred to the account during
+            the period or the unrealized gain or loss
+
+Income and expense rows hold the realized flows recorded in the ledger,
+one per account and commodity.  Renamed accounts are reported under
+their final names.  Flows that net to zero are omitted.
+
+The -b flag selects the accounting basis: "accrual" (the default) or
+"cash".  Accrual entries, such as invoices and bills, are transactions
+with "accrual" notes; transactions with "settles" notes bearing the same
+values settle them in cash.  For example:
+
+  (Client "Invoice 42"
+      Assets:Receivable 1000 USD xfer
+      Income:Consulting -1000 USD xfer
+      "accrual" "INV-42"
+      xact)
+  (Client "Payment for invoice 42"
+      Assets:Checking 1000 USD xfer
+      Assets:Receivable -1000 USD xfer
+      "settles" "INV-42"
+      xact)
+
+The accrual basis reports income and expenses when they are recorded.
+The cash basis reports the income and expenses of accrual entries when
+the first transactions settling them occur instead and ignores unsettled
+entries.  Entries recorded after their settlements are reported when
+they are recorded.
+
+The -u flag adds unrealized gain and loss rows, measured in the specified
+commodity, after the income and expense rows.  For each commodity held
+in asset and liability lots whose unit prices are in that commodity,
+the unrealized gain is the change in the difference between the lots'
+market values and their cost during the period.  Market values use
+the most recent price of the commodity in the measuring commodity,
+recorded either by the price function or by a transfer that exchanged
+the two.  Holdings without known market prices are
+valued at cost.  Unrealized gains are not transferred to any account,
+so they are reported separately from realized flows.
+
+The -s flag specifies the first day of the period.  By default, the period
+starts at the beginning of the ledger.
+
+The -e flag specifies the last day of the period.  Freebean stops parsing
+at the end of that day.  Freebean parses all input by default.
+See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runIncome()
+	},
+}
+
+var incomeOptions = struct {
+	StartDate  Date
+	EndDate    EndDate
+	Basis      AccountingBasis
+	Unrealized string
+	Columns    []string
+}{Basis: AccrualBasis}
+
+func init() {
+	rootCmd.AddCommand(incomeCmd)
+	incomeCmd.Flags().VarP(&incomeOptions.StartDate, "start-date", "s", "first day of the period")
+	incomeCmd.Flags().VarP(&incomeOptions.EndDate, "end-date", "e", "last day of the period")
+	incomeCmd.Flags().VarP(&incomeOptions.Basis, "basis", "b", "accounting basis (accrual or cash)")
+	incomeCmd.Flags().StringVarP(&incomeOptions.Unrealized, "unrealized", "u", "", "include unrealized gains measured in this commodity")
+	incomeCmd.Flags().StringSliceVarP(&incomeOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// unrealizedGains returns the differences between the market values and
+// costs of asset and liability lots whose unit prices are in the named
+// commodity, keyed by the lots' commodity names.
+func unrealizedGains(ctx *core.Context, commodityName string) map[string]decimal.Decimal {
+	gains := map[string]decimal.Decimal{}
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) || !inBook(a) || (!strings.HasPrefix(an, "Assets:") && !strings.HasPrefix(an, "Liabilities:")) {
+			continue
+		}
+		for _, ctol := range a.Lots {
+			for cn, l := range ctol {
+				if l.ExchangeRate == nil || l.ExchangeRate.UnitPrice.Commodity.Name != commodityName {
+					continue
+				}
+				market, ok := ctx.Prices.LatestIn(cn, commodityName)
+				if !ok {
+					continue
+				}
+				gain := market.Price.Amount.Sub(l.ExchangeRate.UnitPrice.Amount).Mul(l.Balance.Amount)
+				gains[cn] = gains[cn].Add(gain)
+			}
+		}
+	}
+	return gains
+}
+
+// isFlowAccount returns true if the named account is an income or expense
+// account.
+func isFlowAccount(accountName string) bool {
+	return strings.HasPrefix(accountName, "Income:") || strings.HasPrefix(accountName, "Expenses:")
+}
+
+// addFlow adds q to the flow of the named account in q's commodity.
+func addFlow(flows map[string]map[string]core.Quantity, accountName string, q core.Quantity) {
+	if _, ok := flows[accountName]; !ok {
+		flows[accountName] = map[string]core.Quantity{}
+	}
+	cn := q.Commodity.Name
+	if flow, ok := flows[accountName][cn]; ok {
+		flow.Amount = flow.Amount.Add(q.Amount)
+		flows[accountName][cn] = flow
+	} else {
+		flows[accountName][cn] = q
+	}
+}
+
+// accrualFlows returns the net amounts transferred to income and expense
+// accounts since startDate, which are the changes in the accounts'
+// balances since the BalanceCheckpoint for the day before startDate.
+func accrualFlows(ctx *core.Context, startDate core.Date) map[string]map[string]core.Quantity {
+	flows := map[string]map[string]core.Quantity{}
+	for an, a := range ctx.Accounts {
+		if isFlowAccount(an) {
+			for _, q := range a.Balances() {
+				addFlow(flows, an, q)
+			}
+		}
+	}
+	if !startDate.IsZero() {
+		opening, _ := ctx.BalanceCheckpointOn(startDate.AddDays(-1))
+		for an, balances := range opening.Balances {
+			if isFlowAccount(an) {
+				for _, q := range balances {
+					q.Amount = q.Amount.Neg()
+					addFlow(flows, an, q)
+				}
+			}
+		}
+	}
+	return flows
+}
+
+func runIncome() {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(incomeOptions.StartDate)
+	endDate := core.Date(incomeOptions.EndDate)
+	started := startDate.IsZero()
+	startGains := map[string]decimal.Decimal{}
+	flows := map[string]map[string]core.Quantity{} // account name -> commodity name -> flow
+	var cash *cashBasis
+	var recognized []*functions.Transfer
+	if incomeOptions.Basis == CashBasis {
+		cash = newCashBasis()
+	}
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		} else if !started && !ctx.Date.Before(startDate) {
+			started = true
+			ctx.AddBalanceCheckpoint(startDate.AddDays(-1))
+			if len(incomeOptions.Unrealized) != 0 {
+				startGains = unrealizedGains(ctx, incomeOptions.Unrealized)
+			}
+		}
+		return nil
+	}
+	if cash != nil {
+		// Accrual entries' transfers are recognized when they are settled,
+		// so they cannot be read from balances.
+		p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			xact, err := functions.ParseTransaction(op, ctx)
+			if err == nil {
+				err = xact.Execute(ctx)
+			}
+			if err != nil {
+				return fmt.Errorf("%v: %v", fn, err)
+			}
+			transfers := cash.recognize(&xact)
+			if started {
+				recognized = append(recognized, transfers...)
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if cash == nil && started {
+		flows = accrualFlows(p.Context(), startDate)
+	}
+	for _, t := range recognized {
+		if an := t.Account.Name; isFlowAccount(an) {
+			addFlow(flows, an, t.Quantity) // renamed accounts have their final names
+		}
+	}
+	for an, ctoq := range flows {
+		for cn, q := range ctoq {
+			if q.Amount.IsZero() {
+				delete(ctoq, cn)
+			}
+		}
+		if len(ctoq) == 0 {
+			delete(flows, an)
+		}
+	}
+	header := []string{"section", "name", "amount"}
+	w, err := newTableWriter(os.Stdout, header, incomeOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	names := make([]string, len(flows))[:0]
+	for an := range flows {
+		names = append(names, an)
+	}
+	sort.Strings(names)
+	for _, section := range []string{"income", "expenses"} {
+		for _, an := range names {
+			if (section == "income") != strings.HasPrefix(an, "Income:") {
+				continue
+			}
+			commodities := make([]string, len(flows[an]))[:0]
+			for cn := range flows[an] {
+				commodities = append(commodities, cn)
+			}
+			sort.Strings(commodities)
+			for _, cn := range commodities {
+				w.Write([]string{section, an, flows[an][cn].String()})
+			}
+		}
+	}
+	if len(incomeOptions.Unrealized) != 0 && started {
+		endGains := unrealizedGains(p.Context(), incomeOptions.Unrealized)
+		commodities := make([]string, len(endGains))[:0]
+		for cn := range endGains {
+			commodities = append(commodities, cn)
+		}
+		for cn := range startGains {
+			if _, ok := endGains[cn]; !ok {
+				commodities = append(commodities, cn)
+			}
+		}
+		sort.Strings(commodities)
+		for _, cn := range commodities {
+			gain := endGains[cn].Sub(startGains[cn])
+			w.Write([]string{"unrealized", cn, formatQuantity(p.Context(), gain, incomeOptions.Unrealized)})
+		}
+	}
+	w.Flush()
+}
@@ -27,13 +27,17 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
-	"encoding/csv"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/functions"
 	"github.com/jtvaughan/freebean/pkg/parser"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
+	"sort"
+	"strings"
 )
 
 var lotsCmd = &cobra.Command{
@@ -44,36 +48,90 @@ and prints all lots in all open accounts in CSV format.  The output
 includes a header.  Lots without exchange rates have blank unit price
 and total price columns.
 
+The -b flag selects the basis of the unit price and total price
+columns.  With "cost" (the default), they are the lots' exchange rates,
+which give book values.  With "market", they use the most recent
+price of each lot's commodity, recorded either by the price function
+or by a transfer that exchanged the commodity, which gives market
+values; lots whose commodities were never priced fall back to their
+exchange rates.
+
 The -a flag makes Freebean print lot assertions in the ledger language
-instead of CSV.
+instead of CSV.  The assertions are sorted so that the output changes
+only where balances change.  They are preceded by a comment and a date
+call naming the date on which the balances hold (the -d date or the last
+date in the ledger) and followed by a comment containing the SHA-256
+checksum of the assertion lines, so periodically committed checkpoints
+produce small, reviewable diffs.  The output is itself a ledger.
 
 The -d flag specifies the date on which to stop parsing.
-The date should be formatted "YYYY-MM-DD".  Parsing stops
+See "freebean help" for the accepted date formats.  Parsing stops
 at the end of the day, so accounts opened and lots created
 on that day are included.  Freebean parses all input by default.
 
 The -D flag makes Freebean also print default (unnamed) lots.
-Default lots have blank lot names.`,
+Default lots have blank lot names.
+
+The -n flag adds a commodity notes column containing the notes of
+the lots' commodities as NAME=VALUE pairs separated by semicolons.
+
+The -N flag adds a column for each name of the printed lots' notes
+(see the add-lot-notes function), such as "lot note confirmation"
+for notes named "confirmation".  The columns are sorted by note name
+and are blank for lots without the notes.
+
+The -C flag specifies a comma-separated list of columns to print,
+in order, such as "account name,balance".  Column names are the header's
+names; hyphens or underscores may replace spaces.  All columns
+are printed by default.  This flag is ignored when -a is specified.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runLots()
 	},
 }
 
 var lotsOptions = struct {
-	Date             Date
+	Date             EndDate
 	PrintDefaultLots bool
 	PrintAssertions  bool
-}{}
+	PrintNotes       bool
+	PrintLotNotes    bool
+	Columns          []string
+	Basis            Basis
+}{Basis: CostBasis}
 
 func init() {
 	rootCmd.AddCommand(lotsCmd)
 	lotsCmd.Flags().BoolVarP(&lotsOptions.PrintDefaultLots, "print-default-lots", "D", false, "also print default lots")
 	lotsCmd.Flags().VarP(&lotsOptions.Date, "date", "d", "date to stop parsing")
 	lotsCmd.Flags().BoolVarP(&lotsOptions.PrintAssertions, "print-assertions", "a", false, "print assertions instead of CSV")
+	lotsCmd.Flags().BoolVarP(&lotsOptions.PrintNotes, "print-commodity-notes", "n", false, "also print commodities' notes")
+	lotsCmd.Flags().BoolVarP(&lotsOptions.PrintLotNotes, "print-lot-notes", "N", false, "also print lots' notes")
+	lotsCmd.Flags().StringSliceVarP(&lotsOptions.Columns, "columns", "C", nil, "columns to print")
+	lotsCmd.Flags().VarP(&lotsOptions.Basis, "basis", "b", "valuation basis (cost or market)")
 }
 
 func runLots() {
-	done := &struct{}{}
+	var w *tableWriter
+	row := []string{"account name", "lot name", "commodity", "balance", "unit price", "total price"}
+	if lotsOptions.PrintNotes {
+		row = append(row, "commodity notes")
+	}
+	newWriter := func() {
+		var err error
+		if w, err = newTableWriter(os.Stdout, row, lotsOptions.Columns); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// The lot note columns depend on the lots, so they are added
+	// after parsing.
+	if !lotsOptions.PrintAssertions && !lotsOptions.PrintLotNotes {
+		newWriter()
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
 	p := functions.NewParser(os.Stdin)
 	p.AddCoreFunctions()
 	date := core.Date(lotsOptions.Date)
@@ -82,53 +140,126 @@ func runLots() {
 			if err := functions.DateFunction(fn, op, ctx); err != nil {
 				return err
 			} else if ctx.Date.After(date) {
-				panic(done)
+				stop()
 			}
 			return nil
 		}
 	}
-	defer func() {
-		if r := recover(); r != nil && r != done {
-			panic(r)
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	var noteNames []string
+	if !lotsOptions.PrintAssertions && lotsOptions.PrintLotNotes {
+		noteNames = lotNoteNames(p.Context(), lotsOptions.PrintDefaultLots)
+		for _, nn := range noteNames {
+			row = append(row, "lot note "+nn)
 		}
-		w := csv.NewWriter(os.Stdout)
-		row := []string{"account name", "lot name", "commodity", "balance", "unit price", "total price"}
-		printRow := func(vals []string) { w.Write(row) }
-		if lotsOptions.PrintAssertions {
-			printRow = func(vals []string) {
-				if len(vals[1]) == 0 {
-					fmt.Printf("%v %v assert\n", vals[0], vals[3])
-				} else {
-					fmt.Printf("%v %v %v assert-lot\n", vals[0], vals[1], vals[3])
-				}
-			}
+		newWriter()
+	}
+	var assertions []string
+	printRow := func(vals []string) {
+		if len(vals[1]) == 0 {
+			assertions = append(assertions, fmt.Sprintf("%v %v assert", ledgerToken(vals[0]), vals[3]))
 		} else {
-			w.Write(row)
+			assertions = append(assertions, fmt.Sprintf("%v %v %v assert-lot", ledgerToken(vals[0]), ledgerToken(vals[1]), vals[3]))
 		}
-		for an, a := range p.Context().Accounts {
-			if !a.IsClosed(p.Context().Date) {
-				row = append(row[:0], an)
-				for ln, ctol := range a.Lots {
-					if !lotsOptions.PrintDefaultLots && len(ln) == 0 {
-						continue
-					}
-					row = append(row[:1], ln)
-					for cn, l := range ctol {
+	}
+	if w != nil {
+		printRow = w.Write
+	}
+	for an, a := range p.Context().Accounts {
+		if !a.IsClosed(p.Context().Date) && inBook(a) {
+			row = append(row[:0], an)
+			for ln, ctol := range a.Lots {
+				if !lotsOptions.PrintDefaultLots && len(ln) == 0 {
+					continue
+				}
+				row = append(row[:1], ln)
+				for cn, l := range ctol {
+					if w == nil {
+						row = append(row[:2], cn, l.Balance.Amount.String()+" "+ledgerToken(cn))
+					} else {
 						row = append(row[:2], cn, l.Balance.String())
-						if l.ExchangeRate != nil {
-							row = append(row, l.ExchangeRate.UnitPrice.String(), l.ExchangeRate.TotalPrice.String())
-						} else {
-							row = append(row, "", "")
+					}
+					if up, ok := lotPrice(p.Context().Prices, l, lotsOptions.Basis); ok {
+						tp := core.Quantity{Commodity: up.Commodity, Amount: l.Balance.Amount.Mul(up.Amount)}
+						if lotsOptions.Basis == CostBasis {
+							tp = l.ExchangeRate.TotalPrice
 						}
-						printRow(row)
+						row = append(row, up.String(), tp.String())
+					} else {
+						row = append(row, "", "")
+					}
+					if lotsOptions.PrintNotes {
+						row = append(row, formatNotes(l.Balance.Commodity.Notes))
 					}
+					for _, nn := range noteNames {
+						row = append(row, l.Notes[nn])
+					}
+					printRow(row)
 				}
 			}
 		}
+	}
+	if w != nil {
 		w.Flush()
-	}()
-	if err := p.Parse(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
+	} else {
+		if date.IsZero() {
+			date = p.Context().Date
+		}
+		printAssertions(os.Stdout, date, assertions)
+	}
+}
+
+// lotNoteNames returns the sorted names of the notes of the lots in ctx's
+// open accounts.  Default lots are included if defaultLots is true.
+func lotNoteNames(ctx *core.Context, defaultLots bool) []string {
+	set := map[string]bool{}
+	for _, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) || !inBook(a) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			if !defaultLots && len(ln) == 0 {
+				continue
+			}
+			for _, l := range ctol {
+				for nn := range l.Notes {
+					set[nn] = true
+				}
+			}
+		}
+	}
+	names := make([]string, len(set))[:0]
+	for nn := range set {
+		names = append(names, nn)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ledgerToken quotes s if the Lexer would not lex it as a single unquoted
+// string.
+func ledgerToken(s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\r\n\"()\\") {
+		return parser.Quote(s)
+	}
+	return s
+}
+
+// printAssertions writes sorted assertions to w between a header naming
+// the date on which they hold and a comment containing their checksum.
+func printAssertions(w io.Writer, date core.Date, assertions []string) {
+	sort.Strings(assertions)
+	sum := sha256.New()
+	for _, a := range assertions {
+		fmt.Fprintln(sum, a)
+	}
+	fmt.Fprintf(w, "%v comment\n", parser.Quote("lot assertions as of "+date.String()))
+	fmt.Fprintf(w, "%v %v %v date\n", date.Year, date.Month, date.Day)
+	for _, a := range assertions {
+		fmt.Fprintln(w, a)
 	}
+	fmt.Fprintf(w, "%v comment\n", parser.Quote(fmt.Sprintf("sha256 %x", sum.Sum(nil))))
 }
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tableWriter writes CSV tables, optionally limiting output to a selection
+// of columns in a user-specified order.
+type tableWriter struct {
+	w       *csv.Writer
+	indices []int // selected column indices; nil selects all columns
+	row     []string
+}
+
+// normalizeColumnName makes column selection insensitive to case and
+// to the use of hyphens or underscores in place of spaces.
+func normalizeColumnName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", " ", "_", " ").Replace(strings.TrimSpace(name)))
+}
+
+// newTableWriter creates a tableWriter that writes to out and writes
+// the header.  columns lists the names of the header's columns to print,
+// in order; if it is empty, all columns are printed.
+func newTableWriter(out io.Writer, header []string, columns []string) (*tableWriter, error) {
+	tw := &tableWriter{w: csv.NewWriter(out)}
+	if len(columns) != 0 {
+		tw.indices = make([]int, len(columns))
+		for n, c := range columns {
+			found := false
+			for m, h := range header {
+				if normalizeColumnName(c) == normalizeColumnName(h) {
+					tw.indices[n] = m
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown column %#v; available columns: %v", c, strings.Join(header, ", "))
+			}
+		}
+	}
+	tw.Write(header)
+	return tw, nil
+}
+
+// Write writes a row containing the selected columns.
+func (tw *tableWriter) Write(row []string) {
+	if tw.indices == nil {
+		tw.w.Write(row)
+		return
+	}
+	tw.row = tw.row[:0]
+	for _, n := range tw.indices {
+		if n < len(row) {
+			tw.row = append(tw.row, row[n])
+		} else {
+			tw.row = append(tw.row, "")
+		}
+	}
+	tw.w.Write(tw.row)
+}
+
+// Flush flushes buffered rows.
+func (tw *tableWriter) Flush() {
+	tw.w.Flush()
+}
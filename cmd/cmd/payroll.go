@@ -0,0 +1,238 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var payrollCmd = &cobra.Command{
+	Use:   "payroll",
+	Short: "Print payroll withholdings per employee",
+	Long: `The payroll subcommand reads a ledger from standard input and prints
+the amounts withheld from each employee's pay during each period
+in CSV format, such as for filing a household employer's quarterly
+returns.  Withholding accounts are accounts with the withholding tag
+and their subaccounts, such as:
+
+  Liabilities:Withholding:FederalTax open
+  Liabilities:Withholding:FederalTax withholding tag
+  Liabilities:Withholding:FederalTax category tax add-notes
+
+Employees are the entities of the transactions that transfer to
+withholding accounts:
+
+  (Alice "Paycheck"
+      Expenses:Wages 1000 USD xfer
+      Liabilities:Withholding:FederalTax -100 USD xfer
+      Liabilities:Withholding:Retirement -50 USD xfer
+      Assets:Checking -850 USD xfer
+      xact)
+
+The output includes a header and has one row per period, employee,
+account, and commodity, sorted in that order, with these columns:
+
+  first day   the period's first day
+  last day    the period's last day
+  employee    the employee's name
+  account     the withholding account's name
+  category    the account's category note, such as "tax",
+              "retirement", or "insurance"
+  amount      the total withheld, which is the negation of the sum
+              of the transfers to the account, so amounts withheld
+              into liability accounts are positive
+
+The -t flag specifies the tag that marks withholding accounts instead
+of withholding.  Tags and notes are inherited from parent accounts
+(for example, Liabilities:Withholding:FederalTax inherits the tags and
+notes of Liabilities:Withholding) whether or not the account-inheritance
+pragma is set.
+
+The -p flag specifies the period length: week, month, quarter (the
+default), or year.  Quarters are fiscal quarters and years are fiscal
+years; see the --fiscal-year-start flag.  Periods respect the --week-start and
+--month-start flags.
+
+The -s flag specifies the first day of the transactions to include.
+By default, the transactions start at the beginning of the ledger.
+
+The -e flag specifies the last day of the transactions to include.
+Freebean stops parsing at the end of that day.  Freebean parses all
+input by default.  See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runPayroll()
+	},
+}
+
+var payrollOptions = struct {
+	Tag       string
+	Period    string
+	StartDate Date
+	EndDate   EndDate
+	Columns   []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(payrollCmd)
+	payrollCmd.Flags().StringVarP(&payrollOptions.Tag, "tag", "t", "withholding", "tag of withholding accounts")
+	payrollCmd.Flags().StringVarP(&payrollOptions.Period, "period", "p", "quarter", "period length (week, month, quarter, or year)")
+	payrollCmd.Flags().VarP(&payrollOptions.StartDate, "start-date", "s", "first day of transactions")
+	payrollCmd.Flags().VarP(&payrollOptions.EndDate, "end-date", "e", "last day of transactions")
+	payrollCmd.Flags().StringSliceVarP(&payrollOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// payrollKey identifies a row of the payroll subcommand's output.
+type payrollKey struct {
+	first                        core.Date
+	employee, account, commodity string
+}
+
+// payrollRow sums withholdings.
+type payrollRow struct {
+	last     core.Date
+	category string
+	amount   core.Quantity
+}
+
+// isWithholdingAccount returns true if a or one of its ancestors has tag.
+func isWithholdingAccount(ctx *core.Context, a *core.Account, tag string) bool {
+	for _, b := range append(ctx.Ancestors(a), a) {
+		if b.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// withholdingCategory returns the category note of a or its nearest
+// ancestor with one.
+func withholdingCategory(ctx *core.Context, a *core.Account) string {
+	if c, ok := a.Notes["category"]; ok {
+		return c
+	}
+	for _, b := range ctx.Ancestors(a) {
+		if c, ok := b.Notes["category"]; ok {
+			return c
+		}
+	}
+	return ""
+}
+
+func runPayroll() {
+	var period func(core.Date) (core.Date, core.Date)
+	switch payrollOptions.Period {
+	case "week":
+		period = calendar.Week
+	case "month":
+		period = calendar.Month
+	case "quarter":
+		period = calendar.Quarter
+	case "year":
+		period = calendar.FiscalYear
+	default:
+		fmt.Fprintf(os.Stderr, "unknown period: %v\n", payrollOptions.Period)
+		os.Exit(1)
+	}
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(payrollOptions.StartDate)
+	endDate := core.Date(payrollOptions.EndDate)
+	rows := map[payrollKey]*payrollRow{}
+	var keys []payrollKey
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		} else if ctx.Date.Before(startDate) {
+			return nil
+		}
+		for _, t := range xact.Transfers {
+			if !isWithholdingAccount(ctx, t.Account, payrollOptions.Tag) {
+				continue
+			}
+			first, last := period(ctx.Date)
+			k := payrollKey{first: first, employee: xact.Entity, account: t.Account.Name, commodity: t.Quantity.Commodity.Name}
+			r, ok := rows[k]
+			if !ok {
+				r = &payrollRow{last: last, category: withholdingCategory(ctx, t.Account), amount: core.Quantity{Commodity: t.Quantity.Commodity}}
+				rows[k] = r
+				keys = append(keys, k)
+			}
+			r.amount.Amount = r.amount.Amount.Sub(t.Quantity.Amount)
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if !a.first.Equal(b.first) {
+			return a.first.Before(b.first)
+		} else if a.employee != b.employee {
+			return a.employee < b.employee
+		} else if a.account != b.account {
+			return a.account < b.account
+		}
+		return a.commodity < b.commodity
+	})
+	w, err := newTableWriter(os.Stdout, []string{"first day", "last day", "employee", "account", "category", "amount"}, payrollOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, k := range keys {
+		r := rows[k]
+		w.Write([]string{formatDate(k.first), formatDate(r.last), k.employee, k.account, r.category, r.amount.String()})
+	}
+	w.Flush()
+}
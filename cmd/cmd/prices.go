@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/rates"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Basis is a valuation basis flag: "cost" values lots using their
+// exchange rates and "market" values them using the latest prices.
+type Basis string
+
+const (
+	CostBasis   Basis = "cost"
+	MarketBasis Basis = "market"
+)
+
+func (b *Basis) String() string { return string(*b) }
+
+func (b *Basis) Set(v string) error {
+	switch Basis(v) {
+	case CostBasis, MarketBasis:
+		*b = Basis(v)
+		return nil
+	}
+	return fmt.Errorf(`invalid basis "%v": expected cost or market`, v)
+}
+
+func (b *Basis) Type() string { return "basis" }
+
+// lotPrice returns the unit price of a lot's commodity according to
+// the basis.  Market prices come from the price database.  It returns
+// false if the price is unknown.
+func lotPrice(prices *core.PriceDB, l *core.Lot, basis Basis) (core.Quantity, bool) {
+	if basis == MarketBasis {
+		if p, ok := prices.Latest(l.Balance.Commodity.Name); ok {
+			return p.Price, true
+		}
+	}
+	if l.ExchangeRate != nil {
+		return l.ExchangeRate.UnitPrice, true
+	}
+	return core.Quantity{}, false
+}
+
+// RateSource is an exchange rate source flag: "ledger" uses only
+// the ledger's prices, and "ecb" and "exchangerate.host" also use
+// the web services' rates when the ledger has no price.
+type RateSource string
+
+const (
+	LedgerRates           RateSource = "ledger"
+	ECBRates              RateSource = "ecb"
+	ExchangeRateHostRates RateSource = "exchangerate.host"
+)
+
+func (s *RateSource) String() string { return string(*s) }
+
+func (s *RateSource) Set(v string) error {
+	switch RateSource(v) {
+	case LedgerRates, ECBRates, ExchangeRateHostRates:
+		*s = RateSource(v)
+		return nil
+	}
+	return fmt.Errorf(`invalid rate source "%v": expected ledger, ecb, or exchangerate.host`, v)
+}
+
+func (s *RateSource) Type() string { return "source" }
+
+// rateTimeout limits the time that requests for the web services' rates
+// can take so that unresponsive services cannot hang subcommands.
+const rateTimeout = 30 * time.Second
+
+// provider returns a RateProvider that uses prices and then the source's
+// rates, if any.
+func (s RateSource) provider(prices *core.PriceDB) core.RateProvider {
+	client := &http.Client{Timeout: rateTimeout}
+	switch s {
+	case ECBRates:
+		return core.RateProviders{prices, &rates.ECB{Client: client}}
+	case ExchangeRateHostRates:
+		return core.RateProviders{prices, &rates.ExchangeRateHost{AccessKey: os.Getenv("FREEBEAN_EXCHANGERATE_HOST_KEY"), Client: client}}
+	}
+	return prices
+}
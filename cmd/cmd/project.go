@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/jtvaughan/freebean/pkg/projection"
+	"github.com/spf13/cobra"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var projectCmd = &cobra.Command{
+	Use:   "project [assumptions file] [commodity]",
+	Short: "Simulate future net worth",
+	Long: `The project subcommand reads a ledger from standard input,
+values the lots in all open Assets and Liabilities accounts in
+the specified commodity, and runs a Monte Carlo simulation of
+the resulting portfolio's value over future years.  It prints
+the simulated net worth percentiles at the end of each year
+in CSV format.  The output includes a header with the year,
+the 10th, 50th, and 90th percentile net worth, and the fraction
+of trials in which the portfolio was depleted.
+
+Lots in the specified commodity are valued at face value.
+Lots in other commodities are valued using their exchange rates'
+unit prices, which must be in the specified commodity.  Freebean
+warns about and ignores lots that it cannot value.
+
+The assumptions file is written in the ledger language and may call
+the following functions:
+
+  CLASS MEAN VOLATILITY returns ->
+                          set the mean annual return and volatility
+                          (standard deviation), both in percent,
+                          of an asset class
+  AMOUNT FIRST-YEAR LAST-YEAR contribute ->
+                          add AMOUNT to the portfolio at the end of
+                          each year from FIRST-YEAR to LAST-YEAR
+  AMOUNT FIRST-YEAR LAST-YEAR withdraw ->
+                          remove AMOUNT from the portfolio at the end
+                          of each year from FIRST-YEAR to LAST-YEAR
+
+Years are numbered from 1.  A lot's asset class is its commodity's name
+if the assumptions file sets returns for it or otherwise the first
+(alphabetically) of its commodity's tags that has returns.  Lots without
+asset classes have zero returns.  Contributions and withdrawals are
+allocated among asset classes in proportion to their values.
+
+The -y flag specifies the number of years to simulate (default 30).
+
+The -n flag specifies the number of trials (default 1000).
+
+The -r flag specifies the random number generator's seed (default 1).
+Runs with the same seed and inputs produce the same results.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProject(args[0], args[1])
+	},
+}
+
+var projectOptions = struct {
+	Years  int
+	Trials int
+	Seed   int64
+}{}
+
+func init() {
+	rootCmd.AddCommand(projectCmd)
+	projectCmd.Flags().IntVarP(&projectOptions.Years, "years", "y", 30, "number of years to simulate")
+	projectCmd.Flags().IntVarP(&projectOptions.Trials, "trials", "n", 1000, "number of trials")
+	projectCmd.Flags().Int64VarP(&projectOptions.Seed, "seed", "r", 1, "random number generator seed")
+}
+
+// getAssetClass returns the asset class of the specified commodity.
+func getAssetClass(c *core.Commodity, a *projection.Assumptions) string {
+	if _, ok := a.Returns[c.Name]; ok {
+		return c.Name
+	}
+	tags := c.GetTags()
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if _, ok := a.Returns[tag]; ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+func runProject(assumptionsFile, commodityName string) {
+	f, err := os.Open(assumptionsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	assumptions, err := projection.ParseAssumptions(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", assumptionsFile, err)
+		os.Exit(2)
+	}
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+
+	ctx := p.Context()
+	holdings := map[string]float64{}
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) || !inBook(a) || (!strings.HasPrefix(an, "Assets:") && !strings.HasPrefix(an, "Liabilities:")) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				value := l.Balance.Amount
+				if cn != commodityName {
+					if l.ExchangeRate == nil || l.ExchangeRate.UnitPrice.Commodity.Name != commodityName {
+						fmt.Fprintf(os.Stderr, "warning: cannot value %v in lot %#v of account %v in %v\n", l.Balance, ln, an, commodityName)
+						continue
+					}
+					value = value.Mul(l.ExchangeRate.UnitPrice.Amount)
+				}
+				v, _ := value.Float64()
+				holdings[getAssetClass(l.Balance.Commodity, assumptions)] += v
+			}
+		}
+	}
+
+	percentiles := []int{10, 50, 90}
+	results := projection.Simulate(holdings, assumptions, projectOptions.Years, projectOptions.Trials, percentiles, rand.New(rand.NewSource(projectOptions.Seed)))
+	w := csv.NewWriter(os.Stdout)
+	row := []string{"year"}
+	for _, pc := range percentiles {
+		row = append(row, fmt.Sprintf("p%v", pc))
+	}
+	row = append(row, "depleted")
+	w.Write(row)
+	for _, r := range results {
+		row = append(row[:0], strconv.Itoa(ctx.Date.Year+r.Year))
+		for _, pc := range percentiles {
+			row = append(row, strconv.FormatFloat(r.Percentiles[pc], 'f', 2, 64))
+		}
+		row = append(row, strconv.FormatFloat(r.Depleted, 'f', 4, 64))
+		w.Write(row)
+	}
+	w.Flush()
+}
@@ -0,0 +1,372 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/format"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune CUTOFF",
+	Short: "Replace a ledger's old activity with opening balances",
+	Long: `The prune subcommand reads a ledger from standard input and prints
+an equivalent ledger in which all activity before the CUTOFF date has
+been replaced by generated opening balances and assertions.  Decades-old
+ledgers shrink to the declarations they still need, while the original
+ledger can be archived elsewhere with its full history.
+
+Pruning divides the input into statements: runs of tokens that start
+and end with an empty operand stack outside parentheses.  Statements
+before the first date call on or after CUTOFF are kept, except that
+those that call xact, pad, split-lot, close-lot, add-lot-notes, price,
+checksum, or any of the assert functions are dropped, as are consecutive
+date calls with nothing kept between them.  Kept declarations such as
+open, commodity, pragma, tag, and word definitions are printed one call
+per line in the style of the fmt subcommand.  The contents of files
+included before CUTOFF are inlined the same way.
+
+The kept statements are followed by a date call for the last date before
+CUTOFF and, for each commodity in which transfers are measured, an
+"Opening" transaction that recreates the nonempty lots of all open
+accounts, including their names and exchange rates, and balances them
+against the equity account, followed by calls to add-lot-notes that
+restore the lots' notes.  The latest price of each commodity in each
+quote commodity follows, and then lot assertions like those printed by
+"freebean lots -a" that check the recreated balances.  The rest of
+the input, starting with the line containing the first date call on or
+after CUTOFF, is printed unchanged.
+
+The equity account's own balances are not carried over: it receives
+the opposites of the recreated balances instead, and it is not asserted.
+Lots' creation dates become the last date before CUTOFF, and realized
+gains, older prices, and journal entries before CUTOFF are lost,
+so reports covering dates before CUTOFF need the original ledger.
+Calls to checksum after CUTOFF fail because they cover the pruned
+tokens; update them after pruning.
+
+The first date call on or after CUTOFF must begin its own line outside
+parentheses and outside included files.  Freebean prints the ledger
+unchanged if no date call precedes CUTOFF.  The ledger is pruned through
+its end if no date call is on or after CUTOFF.  See "freebean help" for
+the accepted date formats.
+
+The -a flag specifies the equity account.  It defaults to
+"Equity:Opening-Balances".  It is opened on the last date before CUTOFF
+if it does not exist.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPrune(args[0])
+	},
+}
+
+var pruneOptions = struct {
+	EquityAccount string
+}{}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVarP(&pruneOptions.EquityAccount, "equity-account", "a", "Equity:Opening-Balances", "account that balances the opening balances")
+}
+
+// pruneActivityFunctions are the functions whose statements prune drops.
+// They record activity, check balances, or describe lots that pruning
+// replaces.
+var pruneActivityFunctions = []string{"add-lot-notes", "assert", "assert-budget", "assert-equation", "assert-lot", "assert-lots-sum", "checksum", "close-lot", "pad", "price", "split-lot", "xact"}
+
+// pruneStatement is a statement lexed by prune.  Statements are runs of
+// tokens that start and end with empty operand stacks outside parentheses.
+type pruneStatement struct {
+	tokens    []string
+	firstLine uint64 // line numbers in the top-level input
+	lastLine  uint64
+	drop      bool // the statement recorded activity
+	date      bool // the statement is a date call
+}
+
+func runPrune(arg string) {
+	var cutoff core.Date
+	if err := setDateFlag(&cutoff, arg, false); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var kept []string
+	var keptDate bool // the last kept statement is a date call
+	var lastLine uint64
+	keep := func(s string, date bool) {
+		if date && keptDate {
+			kept = kept[:len(kept)-1]
+		}
+		kept = append(kept, s)
+		keptDate = date
+	}
+	cur := &pruneStatement{}
+	flush := func() {
+		if len(cur.tokens) != 0 {
+			if !cur.drop {
+				keep(strings.Join(cur.tokens, " "), cur.date)
+			}
+			lastLine = cur.lastLine
+		}
+		cur = &pruneStatement{}
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	var last core.Date // the last date before cutoff
+	cut := false
+	including := 0
+	p := functions.NewParser(bytes.NewReader(input))
+	p.AddCoreFunctions()
+	p.Preprocessors = append(p.Preprocessors, func(t parser.Token) ([]parser.Token, error) {
+		if p.Depth() == 0 && len(p.Stack()) == 0 {
+			flush()
+		}
+		if len(cur.tokens) == 0 {
+			cur.firstLine = p.LineNumber()
+		}
+		cur.lastLine = p.LineNumber()
+		switch t.Type {
+		case parser.QuotedString:
+			cur.tokens = append(cur.tokens, parser.Quote(t.Text))
+		case parser.OpenParen:
+			cur.tokens = append(cur.tokens, "(")
+		case parser.CloseParen:
+			cur.tokens = append(cur.tokens, ")")
+		default:
+			cur.tokens = append(cur.tokens, t.Text)
+		}
+		return []parser.Token{t}, nil
+	})
+	for _, fn := range pruneActivityFunctions {
+		f := p.Functions[fn]
+		p.Functions[fn] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			cur.drop = true
+			return f(fn, op, ctx)
+		}
+	}
+	include := p.Functions["include"]
+	p.Functions["include"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		cur.drop = true
+		including++
+		defer func() { including-- }()
+		return include(fn, op, ctx)
+	}
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		prev := ctx.Date
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if ctx.Date.Before(cutoff) {
+			cur.date = p.Depth() == 0
+			return nil
+		} else if including != 0 {
+			return fmt.Errorf("%v: the first date on or after %v is in an included file", fn, cutoff)
+		} else if p.Depth() != 0 {
+			return fmt.Errorf("%v: the first date on or after %v is inside parentheses", fn, cutoff)
+		}
+		last = prev
+		cut = true
+		stop()
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	var tail []byte
+	if cut {
+		if cur.firstLine == lastLine {
+			fmt.Fprintf(os.Stderr, "the first date on or after %v shares line %v with earlier statements\n", cutoff, lastLine)
+			os.Exit(1)
+		}
+		tail = input
+		for n := uint64(1); n < cur.firstLine; n++ {
+			tail = tail[bytes.IndexByte(tail, '\n')+1:]
+		}
+	} else {
+		flush()
+		last = ctx.Date
+	}
+	if last.IsZero() {
+		os.Stdout.Write(input)
+		return
+	}
+
+	// writePruneBalances begins with a date call for last.
+	if keptDate {
+		kept = kept[:len(kept)-1]
+	}
+	var b bytes.Buffer
+	for _, s := range kept {
+		fmt.Fprintln(&b, s)
+	}
+	fmt.Fprintln(&b)
+	writePruneBalances(&b, ctx, last, pruneOptions.EquityAccount)
+	w := bufio.NewWriter(os.Stdout)
+	if err := format.NewFormatter().Format(parser.NewLexer(&b), w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(tail) != 0 {
+		fmt.Fprintln(w)
+		w.Write(tail)
+	}
+	w.Flush()
+}
+
+// writePruneBalances writes to w the ledger statements that recreate
+// ctx's nonempty lots on date and assert their balances, followed by
+// the latest prices.  The lots are balanced against equityName.
+func writePruneBalances(w io.Writer, ctx *core.Context, date core.Date, equityName string) {
+	fmt.Fprintf(w, "%v %v %v date\n", date.Year, date.Month, date.Day)
+	if a, ok := ctx.Account(equityName); !ok {
+		fmt.Fprintf(w, "%v open\n", ledgerToken(equityName))
+	} else if a.IsClosed(date) {
+		fmt.Fprintf(os.Stderr, "closed account: %v\n", equityName)
+		os.Exit(1)
+	} else {
+		equityName = a.Name
+	}
+
+	// Group the lots by the commodities in which their transfers
+	// are measured, which are their exchange rates' commodities
+	// if they have exchange rates.
+	lotsByCommodity := map[string][]*core.Lot{}
+	accounts := map[*core.Lot]string{}
+	var assertions, notes []string
+	for an, a := range ctx.Accounts {
+		if an == equityName || a.IsClosed(date) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				if l.Balance.Amount.IsZero() {
+					continue
+				}
+				tcn := cn
+				if l.ExchangeRate != nil {
+					tcn = l.ExchangeRate.TotalPrice.Commodity.Name
+				}
+				lotsByCommodity[tcn] = append(lotsByCommodity[tcn], l)
+				accounts[l] = an
+				if len(l.Notes) != 0 {
+					names := make([]string, len(l.Notes))[:0]
+					for nn := range l.Notes {
+						names = append(names, nn)
+					}
+					sort.Strings(names)
+					call := []string{ledgerToken(an), ledgerToken(ln)}
+					for _, nn := range names {
+						call = append(call, ledgerToken(nn), ledgerToken(l.Notes[nn]))
+					}
+					notes = append(notes, strings.Join(append(call, "add-lot-notes"), " "))
+				}
+				if len(ln) == 0 {
+					assertions = append(assertions, fmt.Sprintf("%v %v %v assert", ledgerToken(an), l.Balance.Amount, ledgerToken(cn)))
+				} else {
+					assertions = append(assertions, fmt.Sprintf("%v %v %v %v assert-lot", ledgerToken(an), ledgerToken(ln), l.Balance.Amount, ledgerToken(cn)))
+				}
+			}
+		}
+	}
+	commodities := make([]string, len(lotsByCommodity))[:0]
+	for cn := range lotsByCommodity {
+		commodities = append(commodities, cn)
+	}
+	sort.Strings(commodities)
+	for _, tcn := range commodities {
+		lots := lotsByCommodity[tcn]
+		sort.Slice(lots, func(i, j int) bool {
+			if accounts[lots[i]] != accounts[lots[j]] {
+				return accounts[lots[i]] < accounts[lots[j]]
+			} else if lots[i].Name != lots[j].Name {
+				return lots[i].Name < lots[j].Name
+			}
+			return lots[i].Balance.Commodity.Name < lots[j].Balance.Commodity.Name
+		})
+		fmt.Fprintf(w, "(Opening %v\n", parser.Quote("Balances as of "+date.String()))
+		total := decimal.Decimal{}
+		for _, l := range lots {
+			fmt.Fprintf(w, "%v %v %v", ledgerToken(accounts[l]), l.Balance.Amount, ledgerToken(l.Balance.Commodity.Name))
+			if er := l.ExchangeRate; er != nil {
+				fmt.Fprintf(w, " %v %v %v %v xfer-exch", er.UnitPrice.Amount, ledgerToken(er.UnitPrice.Commodity.Name), er.TotalPrice.Amount, ledgerToken(tcn))
+				total = total.Add(er.TotalPrice.Amount)
+			} else {
+				fmt.Fprint(w, " xfer")
+				total = total.Add(l.Balance.Amount)
+			}
+			if len(l.Name) != 0 {
+				fmt.Fprintf(w, " %v create-lot", ledgerToken(l.Name))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%v %v %v xfer\nxact)\n", ledgerToken(equityName), total.Neg(), ledgerToken(tcn))
+	}
+
+	sort.Strings(notes)
+	for _, n := range notes {
+		fmt.Fprintln(w, n)
+	}
+
+	// Print the latest price of each commodity in each quote commodity.
+	for _, cn := range ctx.Prices.Commodities() {
+		latest := map[string]core.Price{}
+		var quotes []string
+		for _, pr := range ctx.Prices.History(cn) {
+			if _, ok := latest[pr.Price.Commodity.Name]; !ok {
+				quotes = append(quotes, pr.Price.Commodity.Name)
+			}
+			latest[pr.Price.Commodity.Name] = pr
+		}
+		sort.Strings(quotes)
+		for _, qn := range quotes {
+			fmt.Fprintf(w, "%v %v %v price\n", ledgerToken(cn), latest[qn].Price.Amount, ledgerToken(qn))
+		}
+	}
+	fmt.Fprintln(w)
+	printAssertions(w, date, assertions)
+}
@@ -27,7 +27,7 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
-	"encoding/csv"
+	"context"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/functions"
@@ -46,11 +46,11 @@ with each transfer's date, its transaction's entity name,
 the amount transferred, and the current balance.
 
 The -s flag specifies the date on which to start printing transfers.
-The date should be formatted "YYYY-MM-DD".  Freebean parses all input
+See "freebean help" for the accepted date formats.  Freebean parses all input
 by default.
 
 The -e flag specifies the date on which to stop parsing.
-The date should be formatted "YYYY-MM-DD".  Parsing stops
+See "freebean help" for the accepted date formats.  Parsing stops
 at the end of the day, so transfers affecting the account
 on that day are included.  Freebean parses all input by default.
 
@@ -64,6 +64,17 @@ with the note's name and value to the output.  If the note
 is absent from a transfer's transaction, the column value
 will be blank.  The -n flag may be repeated any number of times.
 
+The -w flag makes Freebean print only the transfers whose transactions'
+notes satisfy the specified condition, such as "miles > 100" or
+'note("miles") > 100'.  The comparison may be <, <=, >, >=, =, or !=.
+Notes' values are compared as the types declared by the note-type
+function or, if their types are not declared, as decimal numbers,
+dates formatted "YYYY-MM-DD", booleans, or strings, whichever they
+look like.  Transactions without the note never match.  The -w flag
+may be repeated, in which case transfers must satisfy every condition.
+Transfers that are not printed do not count toward the totals row
+or, with -z, the balance.
+
 The -x flag makes Freebean also print exchange rates.
 This adds unit price and total price columns to the output.
 Transfers without exchange rates will have blank values
@@ -72,7 +83,25 @@ in these columns.
 The -z flag makes Freebean start the account with a zero balance
 on the start date specified by the -s flag.  Freebean uses the
 account's real balance by default regardless of the start date.
-This flag only makes sense when combined with -s.`,
+This flag only makes sense when combined with -s.
+
+The -o flag makes Freebean print an opening balance row before
+the transfers.  Its date is the start date specified by the -s flag
+(or the ledger's first date), its entity is "Opening balance", its amount
+is blank, and its balance is the balance before the transfers.
+
+The -t flag makes Freebean print a totals row after the transfers.
+Its date is the end date specified by the -e flag (or the ledger's final
+date), its entity is "Total of N transfers", where N is the number of
+printed transfers, its amount is the net change of the printed transfers,
+and its balance is the closing balance.
+
+Both rows have blank exchange rate and note columns.
+
+The -C flag specifies a comma-separated list of columns to print,
+in order, such as "date,amount".  Column names are the header's names;
+note columns are named after their notes.  Hyphens or underscores
+may replace spaces.  All columns are printed by default.`,
 	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		runRegister(args[0], args[1])
@@ -81,11 +110,15 @@ This flag only makes sense when combined with -s.`,
 
 var registerOptions = struct {
 	StartDate            Date
-	EndDate              Date
+	EndDate              EndDate
 	LotName              string
 	PrintExchangeRates   bool
 	StartWithZeroBalance bool
+	PrintOpeningBalance  bool
+	PrintTotals          bool
 	Notes                []string
+	Conditions           []string
+	Columns              []string
 }{}
 
 func init() {
@@ -95,21 +128,48 @@ func init() {
 	registerCmd.Flags().StringVarP(&registerOptions.LotName, "lot", "l", "", "limit results to this lot")
 	registerCmd.Flags().BoolVarP(&registerOptions.PrintExchangeRates, "print-exchange-rates", "x", false, "also print exchange rates")
 	registerCmd.Flags().BoolVarP(&registerOptions.StartWithZeroBalance, "zero-balance", "z", false, "start with a zero balance")
+	registerCmd.Flags().BoolVarP(&registerOptions.PrintOpeningBalance, "opening-balance", "o", false, "print an opening balance row")
+	registerCmd.Flags().BoolVarP(&registerOptions.PrintTotals, "totals", "t", false, "print a totals row")
 	registerCmd.Flags().StringSliceVarP(&registerOptions.Notes, "note", "n", nil, "also print these transaction notes")
+	registerCmd.Flags().StringArrayVarP(&registerOptions.Conditions, "where", "w", nil, "print only transfers whose transactions' notes satisfy this condition")
+	registerCmd.Flags().StringSliceVarP(&registerOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// matchesNoteConditions returns true if notes satisfy all of conditions.
+func matchesNoteConditions(conditions []core.NoteCondition, notes map[string]string, ctx *core.Context) bool {
+	for _, c := range conditions {
+		if !c.Matches(notes, ctx.NoteTypes) {
+			return false
+		}
+	}
+	return true
 }
 
 func runRegister(accountName, commodityName string) {
-	done := &struct{}{}
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	var conditions []core.NoteCondition
+	for _, s := range registerOptions.Conditions {
+		c, err := core.ParseNoteCondition(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		conditions = append(conditions, c)
+	}
 	p := functions.NewParser(os.Stdin)
 	p.AddCoreFunctions()
 
-	w := csv.NewWriter(os.Stdout)
 	row := []string{"date", "entity", "amount", "balance"}
 	if registerOptions.PrintExchangeRates {
 		row = append(row, "unit price", "total price")
 	}
 	row = append(row, registerOptions.Notes...)
-	w.Write(row)
+	w, err := newTableWriter(os.Stdout, row, registerOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	var balance *core.Quantity
 	if registerOptions.StartWithZeroBalance {
@@ -117,15 +177,53 @@ func runRegister(accountName, commodityName string) {
 	}
 	startDate := core.Date(registerOptions.StartDate)
 	endDate := core.Date(registerOptions.EndDate)
-	if !endDate.IsZero() {
-		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
-			if err := functions.DateFunction(fn, op, ctx); err != nil {
-				return err
-			} else if ctx.Date.After(endDate) {
-				panic(done)
+
+	// currentBalance returns the balance that the balance column shows.
+	currentBalance := func(ctx *core.Context) core.Quantity {
+		if balance != nil {
+			return *balance
+		} else if a, ok := ctx.Accounts[accountName]; ok {
+			if l, ok := a.Lots[registerOptions.LotName][commodityName]; ok {
+				return l.Balance
 			}
-			return nil
 		}
+		if c, ok := ctx.Commodities[commodityName]; ok {
+			return core.Quantity{Commodity: c}
+		}
+		return core.Quantity{Commodity: &core.Commodity{Name: commodityName}}
+	}
+	// writeSummary writes an opening balance or totals row.
+	writeSummary := func(date core.Date, entity, amount string, b core.Quantity) {
+		row = append(row[:0], formatDate(date), entity, amount, b.String())
+		if registerOptions.PrintExchangeRates {
+			row = append(row, "", "")
+		}
+		for range registerOptions.Notes {
+			row = append(row, "")
+		}
+		w.Write(row)
+	}
+	opened := !registerOptions.PrintOpeningBalance
+	open := func(ctx *core.Context) {
+		opened = true
+		date := startDate
+		if date.IsZero() {
+			date = ctx.Date
+		}
+		writeSummary(date, "Opening balance", "", currentBalance(ctx))
+	}
+	transfers := 0
+	netChange := core.Quantity{Commodity: &core.Commodity{Name: commodityName}}
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		} else if !opened && ctx.Date.EqualOrAfter(startDate) {
+			open(ctx)
+		}
+		return nil
 	}
 	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
 		var xact functions.Transaction
@@ -135,11 +233,15 @@ func runRegister(accountName, commodityName string) {
 		} else if err = xact.Execute(ctx); err != nil {
 			return err
 		}
-		if ctx.Date.EqualOrAfter(startDate) {
+		if ctx.Date.EqualOrAfter(startDate) && matchesNoteConditions(conditions, xact.Notes, ctx) {
 			for _, t := range xact.Transfers {
 				if t.Account.Name == accountName && t.LotName == registerOptions.LotName && t.Quantity.Commodity.Name == commodityName {
-					row = append(row[:0], ctx.Date.String(), xact.Entity, t.Quantity.String())
+					transfers++
+					netChange.Commodity = t.Quantity.Commodity
+					netChange.Amount = netChange.Amount.Add(t.Quantity.Amount)
+					row = append(row[:0], formatDate(ctx.Date), xact.Entity, t.Quantity.String())
 					if balance != nil {
+						balance.Commodity = t.Quantity.Commodity
 						balance.Amount = balance.Amount.Add(t.Quantity.Amount)
 						row = append(row, balance.String())
 					} else {
@@ -161,14 +263,20 @@ func runRegister(accountName, commodityName string) {
 		}
 		return nil
 	}
-	defer func() {
-		if r := recover(); r != nil && r != done {
-			panic(r)
-		}
-		w.Flush()
-	}()
-	if err := p.Parse(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
 		os.Exit(2)
 	}
+	ctx := p.Context()
+	if !opened {
+		open(ctx)
+	}
+	if registerOptions.PrintTotals {
+		date := endDate
+		if date.IsZero() {
+			date = ctx.Date
+		}
+		writeSummary(date, fmt.Sprintf("Total of %v transfers", transfers), netChange.String(), currentBalance(ctx))
+	}
+	w.Flush()
 }
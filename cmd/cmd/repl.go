@@ -0,0 +1,218 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var replCmd = &cobra.Command{
+	Use:   "repl [LEDGER]",
+	Short: "Evaluate ledger code interactively",
+	Long: `The repl subcommand reads Freebean code from standard input one line
+at a time, evaluates each line, and prints the operand stack afterwards.
+The ledger's state persists across lines, so a parenthesized transaction
+may span several lines.  The prompt shows the number of unclosed
+parentheses, if any.  If a line has an error, Freebean prints it
+and discards the line's effects.
+
+If a LEDGER file is specified, Freebean evaluates it before reading
+from standard input.  Lines starting with "." are commands:
+
+  .balances [PREFIX]  print the balances of open accounts whose names
+                      start with PREFIX (all open accounts by default)
+  .undo               discard the effects of the last line
+  .help               print this list of commands
+  .quit               exit (as does end of input)`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runRepl(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replCmd)
+}
+
+const replHelp = `.balances [PREFIX]  print balances of open accounts
+.undo               discard the effects of the last line
+.help               print this list of commands
+.quit               exit`
+
+// repl holds an interactive session's state.  Undoing a line replays
+// all lines before it into a new Parser, which keeps undo simple
+// and exact at the cost of speed.
+type repl struct {
+	ledger  string
+	history []string
+	p       *functions.Parser
+}
+
+// reset replays the ledger and history into a new Parser.
+func (r *repl) reset() error {
+	r.p = functions.NewParser(strings.NewReader(""))
+	r.p.AddCoreFunctions()
+	if err := r.p.ParseMore(strings.NewReader(r.ledger)); err != nil {
+		return err
+	}
+	for _, line := range r.history {
+		if err := r.p.ParseMore(strings.NewReader(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eval evaluates a line.  If the line has an error, eval restores
+// the state before the line.
+func (r *repl) eval(line string) error {
+	if err := r.p.ParseMore(strings.NewReader(line)); err != nil {
+		if rerr := r.reset(); rerr != nil {
+			panic(rerr)
+		}
+		return err
+	}
+	r.history = append(r.history, line)
+	return nil
+}
+
+func (r *repl) undo() error {
+	if len(r.history) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	r.history = r.history[:len(r.history)-1]
+	return r.reset()
+}
+
+func (r *repl) prompt() string {
+	if depth := r.p.Depth(); depth != 0 {
+		return fmt.Sprintf("freebean(%v)> ", depth)
+	}
+	return "freebean> "
+}
+
+func formatOperand(v interface{}) string {
+	switch o := v.(type) {
+	case string:
+		if len(o) == 0 || strings.ContainsAny(o, " \t\r\n\v\f\"()\\") {
+			return parser.Quote(o)
+		}
+		return o
+	case *functions.Transfer:
+		return fmt.Sprintf("<transfer %v>", o)
+	}
+	return fmt.Sprintf("<%v>", v)
+}
+
+func (r *repl) printStack(w io.Writer) {
+	stack := r.p.Stack()
+	strs := make([]string, len(stack))
+	for n, v := range stack {
+		strs[n] = formatOperand(v)
+	}
+	fmt.Fprintf(w, "[%v]\n", strings.Join(strs, " "))
+}
+
+func (r *repl) printBalances(w io.Writer, prefix string) {
+	ctx := r.p.Context()
+	names := make([]string, len(ctx.Accounts))[:0]
+	for an, a := range ctx.Accounts {
+		if strings.HasPrefix(an, prefix) && !a.IsClosed(ctx.Date) {
+			names = append(names, an)
+		}
+	}
+	sort.Strings(names)
+	for _, an := range names {
+		balances := ctx.Accounts[an].Balances()
+		commodities := make([]string, len(balances))[:0]
+		for cn := range balances {
+			commodities = append(commodities, cn)
+		}
+		sort.Strings(commodities)
+		for _, cn := range commodities {
+			fmt.Fprintf(w, "%v\t%v\n", an, balances[cn])
+		}
+	}
+}
+
+func runRepl(args []string) {
+	r := &repl{}
+	if len(args) != 0 {
+		ledger, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		r.ledger = string(ledger)
+	}
+	if err := r.reset(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print(r.prompt()); scanner.Scan(); fmt.Print(r.prompt()) {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case ".balances":
+			r.printBalances(os.Stdout, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ".balances")))
+			continue
+		case ".undo":
+			err = r.undo()
+		case ".help":
+			fmt.Println(replHelp)
+			continue
+		case ".quit":
+			return
+		default:
+			if strings.HasPrefix(fields[0], ".") {
+				err = fmt.Errorf("unknown command: %v", fields[0])
+			} else {
+				err = r.eval(line)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		r.printStack(os.Stdout)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,332 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Print a custom report using a template",
+	Long: `The report subcommand reads a ledger from standard input
+and executes the Go text/template file specified by the -t flag,
+printing the result to standard output.  The template's data
+provides these methods:
+
+  .Date                      the ledger's final date
+  .Accounts                  all open accounts, sorted by name
+  .ClosedAccounts            all closed accounts, sorted by name
+  .Account NAME              the named account
+  .AccountHistory NAME       the named account and the closed accounts
+                             that reopening it replaced, oldest first
+  .Commodities               all commodities, sorted by name
+  .Commodity NAME            the named commodity
+  .Tagged TAG                the names of accounts and commodities
+                             tagged TAG, sorted; TAG may be a key
+                             or a key=value pair
+  .Balance ACCOUNT COMMODITY the sum of the account's lots in
+                             the commodity
+  .Register ACCOUNT COMMODITY
+                             the transfers affecting the account
+                             in the commodity, each with Date,
+                             Entity, Description, Lot, Comment,
+                             Amount, Balance, and Notes fields
+  .BalanceCheckpoints        the open accounts' balances at the end of
+                             each month, oldest first, each with Date
+                             and Balances fields; Balances maps account
+                             names to commodity names to balances
+  .BalanceOn DATE ACCOUNT COMMODITY
+                             the account's balance in the commodity
+                             at the end of the latest month ending on
+                             or before DATE
+
+Accounts have Name, CreationDate, ClosingDate, Tags, Notes, and Lots
+fields; commodities have Name, Description, CreationDate, and Tags fields.
+Templates may also call these functions:
+
+  date DATE             format a date using the --date-format flag
+  fiscalYear DATE       the fiscal year containing a date
+  join LIST SEPARATOR   join a list of strings
+  lower STRING          convert a string to lower case
+  month DATE            the first day of the month containing a date
+  upper STRING          convert a string to upper case
+  week DATE             the first day of the week containing a date
+
+The fiscalYear, month, and week functions respect the --fiscal-year-start,
+--month-start, and --week-start flags, so templates can group
+transactions into periods with them.
+
+The -t flag specifies the template file.  It is required.
+
+The -d flag specifies the date on which to stop parsing.
+See "freebean help" for the accepted date formats.  Parsing stops
+at the end of the day, so transactions on that day are included.
+Freebean parses all input by default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runReport()
+	},
+}
+
+var reportOptions = struct {
+	Date     EndDate
+	Template string
+}{}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().VarP(&reportOptions.Date, "date", "d", "date to stop parsing")
+	reportCmd.Flags().StringVarP(&reportOptions.Template, "template", "t", "", "template file")
+}
+
+// RegisterEntry is a transfer affecting an account, as seen by
+// report templates.
+type RegisterEntry struct {
+	Date        core.Date
+	Entity      string
+	Description string
+	Lot         string
+	Comment     string
+	Amount      core.Quantity
+	Balance     core.Quantity
+	Notes       map[string]string
+}
+
+type datedTransaction struct {
+	Date core.Date
+	functions.Transaction
+}
+
+// reportData is the data given to report templates.
+type reportData struct {
+	ctx          *core.Context
+	transactions []datedTransaction
+}
+
+func (d *reportData) Date() core.Date { return d.ctx.Date }
+
+func (d *reportData) getAccounts(closed bool) []*core.Account {
+	accounts := make([]*core.Account, len(d.ctx.Accounts))[:0]
+	for _, a := range d.ctx.Accounts {
+		if a.IsClosed(d.ctx.Date) == closed && inBook(a) {
+			accounts = append(accounts, a)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+func (d *reportData) Accounts() []*core.Account { return d.getAccounts(false) }
+
+func (d *reportData) ClosedAccounts() []*core.Account { return d.getAccounts(true) }
+
+func (d *reportData) Account(name string) (*core.Account, error) {
+	if a, ok := d.ctx.Accounts[name]; ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("nonexistent account: %v", name)
+}
+
+func (d *reportData) AccountHistory(name string) ([]*core.Account, error) {
+	if history := d.ctx.AccountHistory(name); len(history) != 0 {
+		return history, nil
+	}
+	return nil, fmt.Errorf("nonexistent account: %v", name)
+}
+
+func (d *reportData) Commodities() []*core.Commodity {
+	commodities := make([]*core.Commodity, len(d.ctx.Commodities))[:0]
+	for _, c := range d.ctx.Commodities {
+		commodities = append(commodities, c)
+	}
+	sort.Slice(commodities, func(i, j int) bool { return commodities[i].Name < commodities[j].Name })
+	return commodities
+}
+
+func (d *reportData) Commodity(name string) (*core.Commodity, error) {
+	if c, ok := d.ctx.Commodities[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("nonexistent commodity: %v", name)
+}
+
+func (d *reportData) Tagged(tag string) []string {
+	tagged := d.ctx.Tagged(tag)
+	names := make([]string, len(tagged))[:0]
+	for _, tt := range tagged {
+		switch v := tt.(type) {
+		case *core.Account:
+			names = append(names, v.Name)
+		case *core.Commodity:
+			names = append(names, v.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *reportData) Balance(accountName, commodityName string) (core.Quantity, error) {
+	a, err := d.Account(accountName)
+	if err != nil {
+		return core.Quantity{}, err
+	}
+	c, err := d.Commodity(commodityName)
+	if err != nil {
+		return core.Quantity{}, err
+	}
+	if q, ok := a.Balances()[commodityName]; ok {
+		return q, nil
+	}
+	return core.Quantity{Commodity: c}, nil
+}
+
+func (d *reportData) BalanceCheckpoints() []core.BalanceCheckpoint {
+	return d.ctx.BalanceCheckpoints
+}
+
+func (d *reportData) BalanceOn(date core.Date, accountName, commodityName string) (core.Quantity, error) {
+	c, err := d.Commodity(commodityName)
+	if err != nil {
+		return core.Quantity{}, err
+	}
+	bc, ok := d.ctx.BalanceCheckpointOn(date)
+	if !ok {
+		return core.Quantity{}, fmt.Errorf("no months end on or before %v", date)
+	} else if q, ok := bc.Balance(accountName, commodityName); ok {
+		return q, nil
+	}
+	return core.Quantity{Commodity: c}, nil
+}
+
+func (d *reportData) Register(accountName, commodityName string) ([]RegisterEntry, error) {
+	if _, err := d.Account(accountName); err != nil {
+		return nil, err
+	}
+	c, err := d.Commodity(commodityName)
+	if err != nil {
+		return nil, err
+	}
+	var entries []RegisterEntry
+	balance := core.Quantity{Commodity: c}
+	for _, xact := range d.transactions {
+		for _, t := range xact.Transfers {
+			if t.Account.Name == accountName && t.Quantity.Commodity.Name == commodityName {
+				balance.Amount = balance.Amount.Add(t.Quantity.Amount)
+				entries = append(entries, RegisterEntry{
+					Date:        xact.Date,
+					Entity:      xact.Entity,
+					Description: xact.Description,
+					Lot:         t.LotName,
+					Comment:     t.Comment,
+					Amount:      t.Quantity,
+					Balance:     balance,
+					Notes:       xact.Notes})
+			}
+		}
+	}
+	return entries, nil
+}
+
+func runReport() {
+	if len(reportOptions.Template) == 0 {
+		fmt.Fprintln(os.Stderr, "no template specified")
+		os.Exit(1)
+	}
+	text, err := ioutil.ReadFile(reportOptions.Template)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	tmpl, err := template.New(reportOptions.Template).Funcs(template.FuncMap{
+		"date": formatDate,
+		"fiscalYear": func(d core.Date) int {
+			return calendar.FiscalYearStart.FiscalYear(d)
+		},
+		"week": func(d core.Date) core.Date {
+			first, _ := calendar.Week(d)
+			return first
+		},
+		"month": func(d core.Date) core.Date {
+			first, _ := calendar.Month(d)
+			return first
+		},
+		"join":  strings.Join,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Parse(string(text))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	p.Context().Options.Calendar = calendar
+	p.Context().Options.BalanceCheckpoints = true
+	data := &reportData{ctx: p.Context()}
+	date := core.Date(reportOptions.Date)
+	if !date.IsZero() {
+		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			if err := functions.DateFunction(fn, op, ctx); err != nil {
+				return err
+			} else if ctx.Date.After(date) {
+				stop()
+			}
+			return nil
+		}
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err != nil {
+			return err
+		} else if err = xact.Execute(ctx); err != nil {
+			return err
+		}
+		data.transactions = append(data.transactions, datedTransaction{Date: ctx.Date, Transaction: xact})
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if err := tmpl.Execute(os.Stdout, data); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
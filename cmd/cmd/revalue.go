@@ -0,0 +1,279 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var revalueCmd = &cobra.Command{
+	Use:   "revalue COMMODITY",
+	Short: "Print foreign currency revaluation entries",
+	Long: `The revalue subcommand reads a ledger from standard input, computes
+the unrealized gains and losses of asset and liability balances held
+in foreign commodities during a period, measured in the specified
+commodity, and prints the adjusting transaction in the ledger language.
+
+Each balance's book value is its value at the start of the period plus
+the values of the amounts transferred to and from the account during
+the period on the days of the transfers.  The unrealized gain is the
+difference between the balance's value at the end of the period and its
+book value.  Values use the most recent price of the foreign commodity
+in the measuring commodity (or vice versa) on or before the day,
+recorded either by the price function or by a transfer that exchanged
+the two.  Amounts transferred before the foreign commodity was first
+priced are valued at the first price that follows them, which may
+be recorded later on the same day.  Balances
+that are never priced are ignored.  Gains are rounded to two decimal
+places.
+
+The adjusting transaction is dated on the last day of the period.
+It transfers each balance's gain to the adjustment account, with
+a comment naming the balance, and the total gain from the gain account.
+Appending the transaction to the ledger records the gains; the next
+period's revaluation should start after it.  Nothing is printed if there
+are no gains or losses.
+
+The -a flag specifies the adjustment account.  It defaults to
+"Equity:Revaluation".
+
+The -g flag specifies the gain account.  It defaults to
+"Income:Revaluation".
+
+The -t flag restricts revaluation to commodities with the specified tag,
+such as "currency".  All commodities are revalued by default.
+
+The -r flag specifies the source of exchange rates: "ledger" (the
+default) uses the ledger's prices only, "ecb" also uses the European
+Central Bank's euro reference rates, and "exchangerate.host" also uses
+exchangerate.host's rates, which requires an access key in the
+FREEBEAN_EXCHANGERATE_HOST_KEY environment variable.  The ledger's
+prices take precedence over the web services' rates.  Requests for
+the web services' rates time out after 30 seconds.
+
+The -s flag specifies the first day of the period.  By default, the period
+starts at the beginning of the ledger.
+
+The -e flag specifies the last day of the period.  Freebean stops parsing
+at the end of that day.  Freebean parses all input by default.
+See "freebean help" for the accepted date formats.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runRevalue(args[0])
+	},
+}
+
+var revalueOptions = struct {
+	StartDate         Date
+	EndDate           EndDate
+	AdjustmentAccount string
+	GainAccount       string
+	Tag               string
+	Rates             RateSource
+}{Rates: LedgerRates}
+
+func init() {
+	rootCmd.AddCommand(revalueCmd)
+	revalueCmd.Flags().VarP(&revalueOptions.StartDate, "start-date", "s", "first day of the period")
+	revalueCmd.Flags().VarP(&revalueOptions.EndDate, "end-date", "e", "last day of the period")
+	revalueCmd.Flags().StringVarP(&revalueOptions.AdjustmentAccount, "adjustment-account", "a", "Equity:Revaluation", "account that receives the gains")
+	revalueCmd.Flags().StringVarP(&revalueOptions.GainAccount, "gain-account", "g", "Income:Revaluation", "account that records the gains")
+	revalueCmd.Flags().StringVarP(&revalueOptions.Tag, "tag", "t", "", "revalue only commodities with this tag")
+	revalueCmd.Flags().VarP(&revalueOptions.Rates, "rates", "r", "exchange rate source (ledger, ecb, or exchangerate.host)")
+}
+
+// bookValue tracks the book value of a foreign commodity balance.
+type bookValue struct {
+	value    decimal.Decimal // in the measuring commodity
+	unvalued decimal.Decimal // foreign amount awaiting its first price
+}
+
+// add adds amount of the commodity com to b, valuing it and any
+// unvalued amounts in the commodity to on the specified date if possible.
+func (b *bookValue) add(rp core.RateProvider, amount decimal.Decimal, com, to *core.Commodity, date core.Date) {
+	b.unvalued = b.unvalued.Add(amount)
+	if b.unvalued.IsZero() {
+		return
+	} else if v, err := core.ConvertWith(rp, core.Quantity{Commodity: com, Amount: b.unvalued}, to, date); err == nil {
+		b.value = b.value.Add(v.Amount)
+		b.unvalued = decimal.Zero
+	}
+}
+
+func runRevalue(commodityName string) {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	rp := revalueOptions.Rates.provider(p.Context().Prices)
+	startDate := core.Date(revalueOptions.StartDate)
+	endDate := core.Date(revalueOptions.EndDate)
+	started := false
+	books := map[accountBalance]*bookValue{}
+
+	revalued := func(an string, com *core.Commodity) bool {
+		return com.Name != commodityName &&
+			(strings.HasPrefix(an, "Assets:") || strings.HasPrefix(an, "Liabilities:")) &&
+			(len(revalueOptions.Tag) == 0 || com.HasTag(revalueOptions.Tag))
+	}
+	start := func(ctx *core.Context) {
+		started = true
+		to, ok := ctx.Commodities[commodityName]
+		if !ok {
+			return
+		}
+		for an, a := range ctx.Accounts {
+			if !inBook(a) {
+				continue
+			}
+			for cn, q := range a.Balances() {
+				if revalued(an, q.Commodity) {
+					b := &bookValue{}
+					b.add(rp, q.Amount, q.Commodity, to, ctx.Date)
+					books[accountBalance{an, cn}] = b
+				}
+			}
+		}
+	}
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		// Value amounts that were transferred before prices recorded
+		// later on the same day.
+		if to, ok := ctx.Commodities[commodityName]; ok {
+			for key, b := range books {
+				b.add(rp, decimal.Zero, ctx.Commodities[key.Commodity], to, ctx.Date)
+			}
+		}
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		} else if !started && !ctx.Date.Before(startDate) {
+			start(ctx)
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		to, ok := ctx.Commodities[commodityName]
+		if !started || !ok {
+			return nil
+		}
+		for _, t := range xact.Transfers {
+			if !revalued(t.Account.Name, t.Quantity.Commodity) {
+				continue
+			}
+			key := accountBalance{t.Account.Name, t.Quantity.Commodity.Name}
+			b, ok := books[key]
+			if !ok {
+				b = &bookValue{}
+				books[key] = b
+			}
+			b.add(rp, t.Quantity.Amount, t.Quantity.Commodity, to, ctx.Date)
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	date := ctx.Date
+	if !endDate.IsZero() {
+		date = endDate
+	}
+	to, ok := ctx.Commodities[commodityName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown commodity: %v\n", commodityName)
+		os.Exit(1)
+	}
+	keys := make([]accountBalance, len(books))[:0]
+	for key := range books {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Account != keys[j].Account {
+			return keys[i].Account < keys[j].Account
+		}
+		return keys[i].Commodity < keys[j].Commodity
+	})
+	entry := importer.Entry{
+		Date:        date,
+		Entity:      "revalue",
+		Description: fmt.Sprintf("Revalue foreign balances in %v", commodityName)}
+	total := decimal.Zero
+	for _, key := range keys {
+		b := books[key]
+		balance, ok := ctx.Accounts[key.Account].Balances()[key.Commodity]
+		if !ok {
+			balance = core.Quantity{Commodity: ctx.Commodities[key.Commodity]}
+		}
+		// Unvalued amounts are valued at the closing price, so they
+		// have no gains.
+		valued := core.Quantity{Commodity: balance.Commodity, Amount: balance.Amount.Sub(b.unvalued)}
+		value, err := core.ConvertWith(rp, valued, to, date)
+		if err != nil {
+			continue
+		}
+		gain := value.Amount.Sub(b.value).Round(2)
+		if gain.IsZero() {
+			continue
+		}
+		entry.Postings = append(entry.Postings, importer.Posting{
+			Account:   revalueOptions.AdjustmentAccount,
+			Amount:    gain,
+			Commodity: commodityName,
+			Comment:   fmt.Sprintf("%v %v", key.Account, balance)})
+		total = total.Add(gain)
+	}
+	if len(entry.Postings) != 0 {
+		entry.Postings = append(entry.Postings, importer.Posting{
+			Account:   revalueOptions.GainAccount,
+			Amount:    total.Neg(),
+			Commodity: commodityName})
+		if err := importer.WriteEntries(os.Stdout, []importer.Entry{entry}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
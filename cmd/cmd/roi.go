@@ -0,0 +1,309 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/jtvaughan/freebean/pkg/returns"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var roiCmd = &cobra.Command{
+	Use:   "roi COMMODITY",
+	Short: "Print investment returns",
+	Long: `The roi subcommand reads a ledger from standard input and prints
+the returns of the accounts tagged "investment" that are open at any
+point during the measured period, including accounts closed during it,
+in CSV format.  Values are measured in the specified commodity.  The output includes
+a header and these columns:
+
+  start date       the first day of the measured period
+  end date         the last day of the measured period
+  start value      the accounts' value at the start of the period
+  end value        the accounts' value at the end of the period
+  net flows        the net value transferred into the accounts
+  time-weighted    the time-weighted return for the whole period
+  money-weighted   the annualized money-weighted return (internal
+                   rate of return)
+
+Returns are fractions, so 0.05 means 5%.  Cash flows are transfers
+between the investment accounts and asset, liability, or equity accounts
+that are not tagged; transfers involving income and expense accounts,
+such as dividends and fees, are part of the returns.
+
+Freebean values holdings in other commodities using their most recent
+prices in COMMODITY, either directly or through one intermediate
+commodity.  Prices are recorded by the price function and by transfers
+that exchange commodities.  Failing that,
+it uses the unit prices of the lots holding the commodities.
+Freebean reports an error if it cannot value a holding.
+
+The -t flag specifies the tag marking investment accounts.
+
+The -s flag specifies the first day of the measured period.
+By default, the period starts at the beginning of the ledger.
+
+The -e flag specifies the last day of the measured period.  Freebean
+stops parsing at the end of that day.  Freebean parses all input
+by default.  See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runROI(args[0])
+	},
+}
+
+var roiOptions = struct {
+	Tag       string
+	StartDate Date
+	EndDate   EndDate
+	Columns   []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(roiCmd)
+	roiCmd.Flags().StringVarP(&roiOptions.Tag, "tag", "t", "investment", "tag marking investment accounts")
+	roiCmd.Flags().VarP(&roiOptions.StartDate, "start-date", "s", "first day of the measured period")
+	roiCmd.Flags().VarP(&roiOptions.EndDate, "end-date", "e", "last day of the measured period")
+	roiCmd.Flags().StringSliceVarP(&roiOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// roiState tracks the values of investment accounts during parsing.
+type roiState struct {
+	ctx       *core.Context
+	commodity string
+	tag       string
+	start     core.Date // the first day of the measured period
+}
+
+// unitPrice returns the price of one unit of the named commodity
+// in s.commodity, if known.
+func (s *roiState) unitPrice(commodityName string) (float64, bool) {
+	p, ok := s.ctx.Prices.Latest(commodityName)
+	if !ok {
+		return 0, false
+	}
+	price, _ := p.Price.Amount.Float64()
+	if p.Price.Commodity.Name == s.commodity {
+		return price, true
+	} else if pp, ok := s.ctx.Prices.LatestIn(p.Price.Commodity.Name, s.commodity); ok {
+		pprice, _ := pp.Price.Amount.Float64()
+		return price * pprice, true
+	}
+	return 0, false
+}
+
+// value values a quantity held in the specified lot (which may be nil).
+func (s *roiState) value(q core.Quantity, lot *core.Lot) (float64, error) {
+	amount, _ := q.Amount.Float64()
+	if q.Commodity.Name == s.commodity {
+		return amount, nil
+	} else if price, ok := s.unitPrice(q.Commodity.Name); ok {
+		return amount * price, nil
+	} else if lot != nil && lot.ExchangeRate != nil && lot.ExchangeRate.UnitPrice.Commodity.Name == s.commodity {
+		price, _ := lot.ExchangeRate.UnitPrice.Amount.Float64()
+		return amount * price, nil
+	}
+	return 0, fmt.Errorf("cannot value %v in %v", q, s.commodity)
+}
+
+// isInvestment returns true if a is an investment account that is open
+// at some point during the measured period.  Accounts closed during
+// the period have zero balances afterwards, so they only contribute
+// the flows and values from before they closed.
+func (s *roiState) isInvestment(a *core.Account) bool {
+	return a.HasTag(s.tag) && !a.IsClosed(s.start)
+}
+
+// portfolioValue returns the total value of the investment accounts.
+func (s *roiState) portfolioValue() (float64, error) {
+	total := 0.0
+	for _, a := range s.ctx.Accounts {
+		if !inBook(a) || !s.isInvestment(a) {
+			continue
+		}
+		for _, ctol := range a.Lots {
+			for _, l := range ctol {
+				v, err := s.value(l.Balance, l)
+				if err != nil {
+					return 0, fmt.Errorf("account %v: %v", a.Name, err)
+				}
+				total += v
+			}
+		}
+	}
+	return total, nil
+}
+
+// flow returns the value transferred into the investment accounts
+// from outside by a transaction.
+func (s *roiState) flow(xact *functions.Transaction) (float64, error) {
+	flow := 0.0
+	investment := false
+	for _, t := range xact.Transfers {
+		if s.isInvestment(t.Account) {
+			investment = true
+			continue
+		} else if !strings.HasPrefix(t.Account.Name, "Assets:") && !strings.HasPrefix(t.Account.Name, "Liabilities:") && !strings.HasPrefix(t.Account.Name, "Equity") {
+			continue
+		}
+		v, err := s.value(t.GetTransferQuantity(), nil)
+		if err != nil {
+			return 0, err
+		}
+		flow -= v
+	}
+	if !investment {
+		return 0, nil
+	}
+	return flow, nil
+}
+
+func formatReturn(r float64) string {
+	return strconv.FormatFloat(r, 'f', 4, 64)
+}
+
+func runROI(commodityName string) {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	s := &roiState{ctx: p.Context(), commodity: commodityName, tag: roiOptions.Tag}
+	startDate := core.Date(roiOptions.StartDate)
+	endDate := core.Date(roiOptions.EndDate)
+
+	var started bool
+	var startValue, lastValue float64
+	var periods []returns.Period
+	var flows []returns.Flow
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		wasZero := ctx.Date.IsZero()
+		prev := ctx.Date
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			ctx.Date = prev
+			stop()
+		} else if !started && (startDate.IsZero() || !ctx.Date.Before(startDate)) {
+			started = true
+			if startDate.IsZero() || wasZero {
+				startDate = ctx.Date
+			}
+			s.start = startDate
+			v, err := s.portfolioValue()
+			if err != nil {
+				return err
+			}
+			startValue, lastValue = v, v
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		var flow float64
+		if started {
+			if flow, err = s.flow(&xact); err != nil {
+				return fmt.Errorf("%v: %v", fn, err)
+			}
+		}
+		if flow != 0 {
+			v, err := s.portfolioValue()
+			if err != nil {
+				return fmt.Errorf("%v: %v", fn, err)
+			}
+			periods = append(periods, returns.Period{Start: lastValue, End: v})
+			flows = append(flows, returns.Flow{Date: ctx.Date, Amount: flow})
+		}
+		if err = xact.Execute(ctx); err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		if flow != 0 {
+			if lastValue, err = s.portfolioValue(); err != nil {
+				return fmt.Errorf("%v: %v", fn, err)
+			}
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if !started {
+		fmt.Fprintln(os.Stderr, "the ledger ends before the start date")
+		os.Exit(1)
+	}
+	if endDate.IsZero() {
+		endDate = s.ctx.Date
+	}
+	endValue, err := s.portfolioValue()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	periods = append(periods, returns.Period{Start: lastValue, End: endValue})
+	twr, err := returns.TimeWeighted(periods)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	mwr := ""
+	if endDate.After(startDate) {
+		if r, err := returns.MoneyWeighted(startDate, startValue, flows, endDate, endValue); err == nil {
+			mwr = formatReturn(r)
+		}
+	}
+	netFlows := 0.0
+	for _, f := range flows {
+		netFlows += f.Amount
+	}
+	header := []string{"start date", "end date", "start value", "end value", "net flows", "time-weighted", "money-weighted"}
+	w, err := newTableWriter(os.Stdout, header, roiOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	w.Write([]string{
+		formatDate(startDate),
+		formatDate(endDate),
+		strconv.FormatFloat(startValue, 'f', 2, 64),
+		strconv.FormatFloat(endValue, 'f', 2, 64),
+		strconv.FormatFloat(netFlows, 'f', 2, 64),
+		formatReturn(twr),
+		mwr})
+	w.Flush()
+}
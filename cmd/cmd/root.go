@@ -28,9 +28,14 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/cobra"
 	"os"
+	"strings"
+	"time"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -44,17 +49,247 @@ notation language.
 Freebean has numerous subcommands, which are described briefly below.
 Invoked without any subcommands, Freebean reads a ledger from standard
 input and checks it for any errors.  If it finds one, it prints it
-to standard error and exits with a nonzero exit code.`,
+to standard error and exits with a nonzero exit code.  It then checks
+the ledger's balance alerts, which the alert function adds:
+
+  ACCOUNT COMPARISON AMOUNT COMMODITY alert ->
+                        warn when the account's balance in COMMODITY
+                        compares to AMOUNT as specified, where
+                        COMPARISON is <, <=, >, >=, =, or !=
+
+For example, "Assets:Checking < 500 USD alert" warns when the checking
+account's balance drops below 500 USD.  Alerts are checked against
+the final balances, so they may precede the transactions they watch.
+Freebean prints each triggered alert to standard error and exits
+with exit code 3 if any were triggered.
+
+Freebean also prints the ledger's warnings, such as transactions that
+rounding transfers balanced (see the rounding-tolerance function and
+the rounding-account pragma), to standard error.  Warnings do not
+change the exit code.
+
+Flags that take dates accept dates formatted "YYYY-MM-DD", "YYYY/MM/DD",
+"DD.MM.YYYY", or "YYYYMMDD".  They also accept these relative dates,
+which are resolved against the current date:
+
+  today, yesterday      the current or previous day
+  -N(d|w|m|y)           N days, weeks, months, or years ago
+  +N(d|w|m|y)           N days, weeks, months, or years from now
+  (this|last)-(week|month|year|fiscal-year)
+                        the current or previous period
+
+Periods resolve to their first days for flags that specify when
+to start printing and to their last days for flags that specify
+when to stop.  For example, "freebean register -s last-month
+-e last-month" prints last month's transfers.
+
+The --week-start flag specifies the day on which weeks begin, such as
+"sunday" or "mon".  The default is Monday.
+
+The --month-start flag specifies the day of the month, from 1 to 28,
+on which months begin.  For example, 15 makes months run from the 15th
+through the 14th of the following month, such as a credit card's billing
+cycle.  Months are named for the calendar months in which they begin.
+The default is 1.
+
+The --fiscal-year-start flag specifies the month and day on which
+fiscal years begin, formatted "MM-DD".  Fiscal years are named for
+the calendar years in which they end.  The default is the value of
+the FREEBEAN_FISCAL_YEAR_START environment variable or, if it is not
+set, "01-01".
+
+The --checkpoint flag, which Freebean accepts when invoked without
+subcommands and for the gains, snapshot, and state-diff subcommands,
+names a file in which Freebean keeps a checkpoint of the ledger read
+from standard input.  If the ledger starts with the checkpointed ledger
+and the files that the latter included are unchanged, Freebean parses
+only the rest of the ledger, which saves time on long ledgers that only
+grow at their ends.  Freebean then replaces the checkpoint with one of
+the whole ledger.  Ledgers must end with newlines to be checkpointed.
+
+The -k (--keep-going) flag, which Freebean accepts when invoked without
+subcommands and for the check subcommand, makes Freebean report all
+of the ledger's errors rather than stop at the first one.  After each
+error, Freebean discards the operands within the innermost parentheses
+and skips the rest of them, such as the rest of a transaction, and
+continues with the next token.  Later errors might follow from earlier
+ones, such as transactions that use accounts that failed to open.
+
+The --trace flag, which Freebean accepts wherever it accepts -k, logs
+each function call to standard error with the current date, the line
+and column of the call, the operands within the innermost parentheses,
+and how long the call took, such as:
+
+  trace: 2021-03-01: 12:30: Assets:Checking -50 USD xfer (3.1µs)
+
+Failed calls are marked "failed".  This helps debug ledgers and find
+slow functions.
+
+The --debug-stack-on-error flag, which Freebean also accepts wherever
+it accepts -k, prints the operand stack to standard error when parsing
+fails, such as on "unconsumed operands" errors.  Each operand is listed
+with the line and column of the token that pushed it and its type, and
+"(" marks each open parenthesis:
+
+  operand stack (2 operands, 1 open parentheses):
+  	(
+  	3:2	string	"Grocer"
+  	3:9	*functions.Transfer	Assets:Cash -50 USD
+
+With the flag, the dump-stack function also prints the stack the same
+way at any point without changing it.  Without it, dump-stack does
+nothing.
+
+The --colon-definitions flag, which Freebean also accepts wherever
+it accepts -k and for the fmt subcommand, enables Forth-style word
+definitions, such as ": double 2 mul ;".  Without it, ":" and ";" are
+ordinary operands.
+
+The --book flag restricts the accounts that subcommands report on,
+such as the accounts whose balances they print, to those in the named
+book (see the book function).  Books partition a ledger's accounts,
+such as into personal and business accounts.  All books are reported
+by default.
+
+The --date-format flag sets the format
+of dates in subcommands' output.  Its value is a Go time layout
+describing how the date January 2, 2006 should appear, such as
+"02.01.2006" or "Jan 2, 2006".  The default is "2006-01-02".`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := core.CheckMonthStartDay(calendar.MonthStartDay); err != nil {
+			return err
+		}
+		resolveRelativeDateFlags()
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		p := functions.NewParser(os.Stdin)
-		p.AddCoreFunctions()
-		if err := p.Parse(); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+		ctx, err := parseStdin()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, parser.PrettyError(err))
 			os.Exit(2)
 		}
+		for _, w := range ctx.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", w)
+		}
+		alerts := ctx.TriggeredAlerts()
+		for _, a := range alerts {
+			fmt.Fprintf(os.Stderr, "alert: %v\n", a)
+		}
+		if len(alerts) != 0 {
+			os.Exit(3)
+		}
 	},
 }
 
+// addParserFlags adds the -k (--keep-going), --trace,
+// --debug-stack-on-error, and --colon-definitions flags to cmd.
+func addParserFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&keepGoing, "keep-going", "k", false, "report all errors rather than only the first")
+	cmd.Flags().BoolVar(&trace, "trace", false, "log each function call to standard error")
+	cmd.Flags().BoolVar(&debugStackOnError, "debug-stack-on-error", false, "print the operand stack to standard error when parsing fails")
+	cmd.Flags().BoolVar(&colonDefinitions, "colon-definitions", false, `enable ":" and ";" word definitions`)
+}
+
+// configureParser applies the -k (--keep-going), --trace,
+// --debug-stack-on-error, and --colon-definitions flags to p.
+func configureParser(p *functions.Parser) {
+	p.KeepGoing = keepGoing
+	p.ColonDefinitions = colonDefinitions
+	if debugStackOnError {
+		p.DebugOutput, p.DumpStackOnError = os.Stderr, true
+	}
+	if !trace {
+		return
+	}
+	type call struct {
+		date     core.Date
+		start    time.Time
+		operands []string
+	}
+	var calls []call // a stack, because include calls nest
+	p.BeforeCall = func(fn string, op parser.Operands, pos parser.Position) {
+		c := call{date: p.Context().Date, operands: []string{}}
+		for _, v := range op.GetValues() {
+			c.operands = append(c.operands, formatOperand(v))
+		}
+		c.start = time.Now()
+		calls = append(calls, c)
+	}
+	p.AfterCall = func(fn string, op parser.Operands, pos parser.Position, err error) {
+		c := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		elapsed := time.Since(c.start)
+		result := ""
+		if err != nil {
+			result = " failed"
+		}
+		fmt.Fprintf(os.Stderr, "trace: %v: %v: %v %v%v (%v)\n", c.date, pos, strings.Join(c.operands, " "), fn, result, elapsed)
+	}
+}
+
+// dateFormat is the Go time layout for dates in subcommands' output.
+var dateFormat string
+
+// book is the name of the book whose accounts subcommands report on,
+// or empty if they report on all accounts.
+var book string
+
+// keepGoing is true if parsers should report all of a ledger's errors
+// rather than stop at the first one.
+var keepGoing bool
+
+// trace is true if parsers should log each function call.
+var trace bool
+
+// debugStackOnError is true if parsers should print the operand stack
+// when parsing fails.
+var debugStackOnError bool
+
+// colonDefinitions is true if parsers should accept ":" and ";" word
+// definitions.
+var colonDefinitions bool
+
+// calendar determines the boundaries of weeks, months, and fiscal years.
+var calendar = core.DefaultCalendar
+
+func init() {
+	addCheckpointFlag(rootCmd)
+	addParserFlags(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&dateFormat, "date-format", "2006-01-02", "output date layout")
+	rootCmd.PersistentFlags().StringVar(&book, "book", "", "report only on accounts in this book")
+	if v, ok := os.LookupEnv("FREEBEAN_FISCAL_YEAR_START"); ok {
+		if err := (*FiscalYearStart)(&calendar.FiscalYearStart).Set(v); err != nil {
+			fmt.Fprintf(os.Stderr, "FREEBEAN_FISCAL_YEAR_START: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	rootCmd.PersistentFlags().Var((*FiscalYearStart)(&calendar.FiscalYearStart), "fiscal-year-start", "first month and day of fiscal years")
+	rootCmd.PersistentFlags().Var((*Weekday)(&calendar.WeekStart), "week-start", "first day of weeks")
+	rootCmd.PersistentFlags().IntVar(&calendar.MonthStartDay, "month-start", 1, "first day of months")
+}
+
+// inBook returns true if subcommands should report on the account,
+// which is the case if it is in the book that the --book flag names
+// or if the flag was not specified.
+func inBook(a *core.Account) bool {
+	return len(book) == 0 || a.Book == book
+}
+
+// formatDate formats a date for subcommands' output.
+func formatDate(d core.Date) string {
+	return d.Format(dateFormat)
+}
+
+// formatQuantity formats an amount of the named commodity for subcommands'
+// output according to the commodity's display format in ctx, if any.
+func formatQuantity(ctx *core.Context, amount decimal.Decimal, commodityName string) string {
+	c, ok := ctx.Commodities[commodityName]
+	if !ok {
+		c = &core.Commodity{Name: commodityName}
+	}
+	return core.Quantity{Commodity: c, Amount: amount}.String()
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
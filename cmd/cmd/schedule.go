@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/schedule"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule SCHEDULE",
+	Short: "Print scheduled recurring transactions",
+	Long: `The schedule subcommand reads the recurring transactions described
+by the specified schedule file and prints their occurrences within
+a date range in Freebean's ledger language, sorted by date.
+
+The schedule file is written in the ledger language.  Recurring
+transactions look like this:
+
+  (ENTITY DESCRIPTION
+      ACCOUNT AMOUNT COMMODITY posting
+      ACCOUNT AMOUNT COMMODITY posting "COMMENT" set-comment
+      YEAR MONTH DAY starting
+      YEAR MONTH DAY until
+      N UNIT every
+      RULE shift
+      NOTE-NAME NOTE-VALUE
+      recur)
+
+At least two postings and the starting date are required.  UNIT is
+day, week, month, or year (or their plurals); the default interval is
+one month.  Monthly and yearly occurrences that would fall beyond the ends
+of their months fall on the last days of their months instead.
+
+RULE determines what happens to occurrences that fall on weekends
+or holidays: next-business-day moves them forward to the next business
+day, previous-business-day moves them back to the previous business day,
+and none (the default) leaves them alone.  Schedule files may define
+non-business days with these functions:
+
+  YEAR MONTH DAY holiday    a holiday
+  MONTH DAY annual-holiday  a holiday that occurs every year
+  WEEKDAY* weekend          the days of the week that are not business
+                            days (default: saturday sunday)
+
+The -H flag specifies additional files of holidays, which use the same
+syntax.  It may be repeated.
+
+The -s flag specifies the first date to print.  The default is today.
+
+The -e flag specifies the last date to print.  It is required.
+See "freebean help" for the accepted date formats.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSchedule(args[0])
+	},
+}
+
+var scheduleOptions = struct {
+	StartDate Date
+	EndDate   EndDate
+	Holidays  []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.Flags().VarP(&scheduleOptions.StartDate, "start-date", "s", "first date to print")
+	scheduleCmd.Flags().VarP(&scheduleOptions.EndDate, "end-date", "e", "last date to print")
+	scheduleCmd.Flags().StringArrayVarP(&scheduleOptions.Holidays, "holidays", "H", nil, "holiday file")
+}
+
+func parseScheduleFile(s *schedule.Schedule, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = s.Parse(f); err != nil {
+		return fmt.Errorf("%v: %v", path, err)
+	}
+	return nil
+}
+
+func runSchedule(path string) {
+	endDate := core.Date(scheduleOptions.EndDate)
+	if endDate.IsZero() {
+		fmt.Fprintln(os.Stderr, "no end date specified")
+		os.Exit(1)
+	}
+	startDate := core.Date(scheduleOptions.StartDate)
+	if startDate.IsZero() {
+		startDate = core.FromTime(today())
+	}
+	s := schedule.NewSchedule()
+	for _, p := range append(scheduleOptions.Holidays, path) {
+		if err := parseScheduleFile(s, p); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	entries, err := s.Entries(startDate, endDate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	} else if err = importer.WriteEntries(os.Stdout, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
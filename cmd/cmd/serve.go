@@ -0,0 +1,443 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/search"
+	"github.com/spf13/cobra"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve LEDGER",
+	Short: "Serve a ledger's data as JSON over HTTP",
+	Long: `The serve subcommand parses the specified ledger file and serves
+read-only JSON descriptions of it over HTTP.  These are the endpoints:
+
+  /accounts            all accounts with their opening and closing dates,
+                       tags, and notes
+  /balances            the balances of all open accounts, one per
+                       account and commodity
+  /register/ACCOUNT    the transfers affecting the account; the optional
+                       commodity query parameter limits them to
+                       one commodity
+  /lots                all nonempty lots in open accounts
+  /tags                all tags with the accounts and commodities
+                       bearing them
+  /prices              the most recent price of each commodity
+  /search?q=TERMS      the transactions whose entities, descriptions,
+                       notes, or transfer comments contain all of
+                       the terms (case-insensitive runs of letters
+                       and digits)
+  /eval?e=CODE         the operand stack after evaluating the Freebean
+                       code against the ledger, formatted as in
+                       the repl subcommand
+
+The code given to /eval runs in read-only mode: only functions that
+query the ledger, check assertions, or manipulate the operand stack
+are available, so requests cannot change the served ledger.  Code
+that runs for more than five seconds, or whose request is cancelled,
+stops with an error.
+
+Amounts are decimal strings and dates are formatted "YYYY-MM-DD".
+
+The -a flag specifies the address on which to listen.  The default
+is "localhost:8080".
+
+The -r flag makes Freebean check the ledger file and the files that it
+includes for changes every second and parse the ledger again when any
+of them changes.  If the new ledger has errors,
+Freebean logs them and continues to serve the old ledger.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runServe(args[0])
+	},
+}
+
+var serveOptions = struct {
+	Address string
+	Reload  bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVarP(&serveOptions.Address, "address", "a", "localhost:8080", "address on which to listen")
+	serveCmd.Flags().BoolVarP(&serveOptions.Reload, "reload", "r", false, "parse the ledger again when it changes")
+}
+
+// servedLedger is a parsed ledger as seen by serve's endpoints.
+type servedLedger struct {
+	ctx   *core.Context
+	index *search.Index
+}
+
+// loadServedLedger parses the ledger at path.  It also returns the files
+// that the ledger included before it finished or failed.
+func loadServedLedger(path string) (*servedLedger, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	p := functions.NewParser(f)
+	p.SetFileName(path)
+	p.AddCoreFunctions()
+	p.Context().Options.KeepJournal = true
+	if err := p.Parse(); err != nil {
+		return nil, p.IncludedFiles(), fmt.Errorf("%v: %v", path, err)
+	}
+	return &servedLedger{ctx: p.Context(), index: search.NewIndex(p.Context().Journal)}, p.IncludedFiles(), nil
+}
+
+type jsonQuantity struct {
+	Amount    string `json:"amount"`
+	Commodity string `json:"commodity"`
+}
+
+func toJSONQuantity(q core.Quantity) jsonQuantity {
+	return jsonQuantity{Amount: q.Amount.String(), Commodity: q.Commodity.Name}
+}
+
+func jsonDate(d core.Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.String()
+}
+
+func sortedStrings(strs []string) []string {
+	sort.Strings(strs)
+	return strs
+}
+
+func (l *servedLedger) sortedAccounts() []*core.Account {
+	accounts := make([]*core.Account, len(l.ctx.Accounts))[:0]
+	for _, a := range l.ctx.Accounts {
+		if inBook(a) {
+			accounts = append(accounts, a)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+func (l *servedLedger) accounts(r *http.Request) (interface{}, error) {
+	type jsonAccount struct {
+		Name   string            `json:"name"`
+		Opened string            `json:"opened"`
+		Closed string            `json:"closed,omitempty"`
+		Tags   []string          `json:"tags"`
+		Notes  map[string]string `json:"notes"`
+	}
+	accounts := []jsonAccount{}
+	for _, a := range l.sortedAccounts() {
+		accounts = append(accounts, jsonAccount{
+			Name:   a.Name,
+			Opened: jsonDate(a.CreationDate),
+			Closed: jsonDate(a.ClosingDate),
+			Tags:   sortedStrings(a.GetTags()),
+			Notes:  a.Notes})
+	}
+	return accounts, nil
+}
+
+func (l *servedLedger) balances(r *http.Request) (interface{}, error) {
+	type jsonBalance struct {
+		Account string `json:"account"`
+		jsonQuantity
+	}
+	balances := []jsonBalance{}
+	for _, a := range l.sortedAccounts() {
+		if a.IsClosed(l.ctx.Date) {
+			continue
+		}
+		byCommodity := a.Balances()
+		commodities := make([]string, len(byCommodity))[:0]
+		for cn := range byCommodity {
+			commodities = append(commodities, cn)
+		}
+		for _, cn := range sortedStrings(commodities) {
+			balances = append(balances, jsonBalance{Account: a.Name, jsonQuantity: toJSONQuantity(byCommodity[cn])})
+		}
+	}
+	return balances, nil
+}
+
+func (l *servedLedger) register(r *http.Request) (interface{}, error) {
+	type jsonEntry struct {
+		Date        string            `json:"date"`
+		Entity      string            `json:"entity"`
+		Description string            `json:"description"`
+		Lot         string            `json:"lot"`
+		Comment     string            `json:"comment"`
+		Amount      jsonQuantity      `json:"amount"`
+		Balance     jsonQuantity      `json:"balance"`
+		Notes       map[string]string `json:"notes"`
+	}
+	accountName := strings.TrimPrefix(r.URL.Path, "/register/")
+	if _, ok := l.ctx.Accounts[accountName]; !ok {
+		return nil, fmt.Errorf("no such account: %v", accountName)
+	}
+	commodityName := r.URL.Query().Get("commodity")
+	balances := map[string]core.Quantity{}
+	entries := []jsonEntry{}
+	for _, e := range l.ctx.Journal {
+		for _, t := range e.Postings {
+			cn := t.Quantity.Commodity.Name
+			if t.Account != accountName || (len(commodityName) != 0 && cn != commodityName) {
+				continue
+			}
+			balance, ok := balances[cn]
+			if ok {
+				balance.Amount = balance.Amount.Add(t.Quantity.Amount)
+			} else {
+				balance = t.Quantity
+			}
+			balances[cn] = balance
+			entries = append(entries, jsonEntry{
+				Date:        jsonDate(e.Date),
+				Entity:      e.Entity,
+				Description: e.Description,
+				Lot:         t.LotName,
+				Comment:     t.Comment,
+				Amount:      toJSONQuantity(t.Quantity),
+				Balance:     toJSONQuantity(balance),
+				Notes:       e.Notes})
+		}
+	}
+	return entries, nil
+}
+
+func (l *servedLedger) search(r *http.Request) (interface{}, error) {
+	type jsonTransfer struct {
+		Account string       `json:"account"`
+		Lot     string       `json:"lot"`
+		Comment string       `json:"comment"`
+		Amount  jsonQuantity `json:"amount"`
+	}
+	type jsonTransaction struct {
+		Date        string            `json:"date"`
+		Entity      string            `json:"entity"`
+		Description string            `json:"description"`
+		Transfers   []jsonTransfer    `json:"transfers"`
+		Notes       map[string]string `json:"notes"`
+	}
+	transactions := []jsonTransaction{}
+	for _, e := range l.index.Search(r.URL.Query().Get("q")) {
+		jt := jsonTransaction{Date: jsonDate(e.Date), Entity: e.Entity, Description: e.Description, Transfers: []jsonTransfer{}, Notes: e.Notes}
+		for _, p := range e.Postings {
+			jt.Transfers = append(jt.Transfers, jsonTransfer{Account: p.Account, Lot: p.LotName, Comment: p.Comment, Amount: toJSONQuantity(p.Quantity)})
+		}
+		transactions = append(transactions, jt)
+	}
+	return transactions, nil
+}
+
+// evalTimeout limits the time that /eval requests can take so that
+// recursive words cannot tie up the server.
+const evalTimeout = 5 * time.Second
+
+func (l *servedLedger) eval(r *http.Request) (interface{}, error) {
+	p := functions.NewParserWithContext(nil, l.ctx)
+	p.AddCoreFunctions()
+	p.ReadOnly = true
+	evaluating, stop := context.WithTimeout(r.Context(), evalTimeout)
+	defer stop()
+	if err := p.ParseMoreContext(evaluating, strings.NewReader(r.URL.Query().Get("e"))); err != nil {
+		return nil, err
+	}
+	stack := []string{}
+	for _, v := range p.Stack() {
+		stack = append(stack, formatOperand(v))
+	}
+	return stack, nil
+}
+
+func (l *servedLedger) lots(r *http.Request) (interface{}, error) {
+	type jsonLot struct {
+		Account   string        `json:"account"`
+		Name      string        `json:"name"`
+		Created   string        `json:"created"`
+		Balance   jsonQuantity  `json:"balance"`
+		UnitPrice *jsonQuantity `json:"unitPrice,omitempty"`
+	}
+	lots := []jsonLot{}
+	for _, a := range l.sortedAccounts() {
+		if a.IsClosed(l.ctx.Date) {
+			continue
+		}
+		lotNames := make([]string, len(a.Lots))[:0]
+		for ln := range a.Lots {
+			lotNames = append(lotNames, ln)
+		}
+		for _, ln := range sortedStrings(lotNames) {
+			ctol := a.Lots[ln]
+			commodities := make([]string, len(ctol))[:0]
+			for cn := range ctol {
+				commodities = append(commodities, cn)
+			}
+			for _, cn := range sortedStrings(commodities) {
+				lot := ctol[cn]
+				if lot.Balance.Amount.IsZero() {
+					continue
+				}
+				jl := jsonLot{Account: a.Name, Name: ln, Created: jsonDate(lot.CreationDate), Balance: toJSONQuantity(lot.Balance)}
+				if lot.ExchangeRate != nil {
+					up := toJSONQuantity(lot.ExchangeRate.UnitPrice)
+					jl.UnitPrice = &up
+				}
+				lots = append(lots, jl)
+			}
+		}
+	}
+	return lots, nil
+}
+
+func (l *servedLedger) tags(r *http.Request) (interface{}, error) {
+	type jsonTag struct {
+		Accounts    []string `json:"accounts"`
+		Commodities []string `json:"commodities"`
+	}
+	tags := map[string]*jsonTag{}
+	for tag, targets := range l.ctx.Tags {
+		jt := &jsonTag{Accounts: []string{}, Commodities: []string{}}
+		for _, target := range targets {
+			switch v := target.(type) {
+			case *core.Account:
+				jt.Accounts = append(jt.Accounts, v.Name)
+			case *core.Commodity:
+				jt.Commodities = append(jt.Commodities, v.Name)
+			}
+		}
+		sort.Strings(jt.Accounts)
+		sort.Strings(jt.Commodities)
+		tags[tag] = jt
+	}
+	return tags, nil
+}
+
+func (l *servedLedger) priceList(r *http.Request) (interface{}, error) {
+	type jsonPrice struct {
+		Commodity string       `json:"commodity"`
+		Date      string       `json:"date"`
+		Price     jsonQuantity `json:"price"`
+	}
+	prices := []jsonPrice{}
+	for _, cn := range l.ctx.Prices.Commodities() {
+		p, _ := l.ctx.Prices.Latest(cn)
+		prices = append(prices, jsonPrice{Commodity: cn, Date: jsonDate(p.Date), Price: toJSONQuantity(p.Price)})
+	}
+	return prices, nil
+}
+
+// ledgerServer serves the most recently loaded ledger.
+type ledgerServer struct {
+	mu     sync.RWMutex
+	ledger *servedLedger
+}
+
+func (s *ledgerServer) current() *servedLedger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ledger
+}
+
+func (s *ledgerServer) set(l *servedLedger) {
+	s.mu.Lock()
+	s.ledger = l
+	s.mu.Unlock()
+}
+
+// handle registers an endpoint that writes the result of f as JSON.
+// Errors returned by f become 404 responses.
+func (s *ledgerServer) handle(mux *http.ServeMux, pattern string, f func(*servedLedger, *http.Request) (interface{}, error)) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := f(s.current(), r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// reload parses the ledger again and serves it if it has no errors.
+// It returns the files that the ledger included.
+func (s *ledgerServer) reload(path string) []string {
+	l, included, err := loadServedLedger(path)
+	if err != nil {
+		log.Println(err)
+	} else {
+		s.set(l)
+		log.Printf("reloaded %v", path)
+	}
+	return included
+}
+
+func runServe(path string) {
+	l, included, err := loadServedLedger(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	s := &ledgerServer{ledger: l}
+	mux := http.NewServeMux()
+	s.handle(mux, "/accounts", (*servedLedger).accounts)
+	s.handle(mux, "/balances", (*servedLedger).balances)
+	s.handle(mux, "/register/", (*servedLedger).register)
+	s.handle(mux, "/lots", (*servedLedger).lots)
+	s.handle(mux, "/tags", (*servedLedger).tags)
+	s.handle(mux, "/prices", (*servedLedger).priceList)
+	s.handle(mux, "/search", (*servedLedger).search)
+	s.handle(mux, "/eval", (*servedLedger).eval)
+	if serveOptions.Reload {
+		files := func() []string { return append([]string{path}, included...) }
+		go watchFiles(files, time.Second, func() { included = s.reload(path) })
+	}
+	if err := http.ListenAndServe(serveOptions.Address, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/jtvaughan/freebean/pkg/settle"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var settleCmd = &cobra.Command{
+	Use:   "settle",
+	Short: "Print who owes whom for shared expenses",
+	Long: `The settle subcommand reads a ledger from standard input and prints
+the net balances between the people sharing the ledger's transfers
+in CSV format.  Transfers are shared with the share and paid-by
+transfer modifiers:
+
+  Transfer (PERSON WEIGHT)+ share -> Transfer
+                          split the transfer's quantity among people
+                          in proportion to their weights
+  Transfer PERSON paid-by -> Transfer
+                          name the person who paid the transfer
+
+For example, this transaction records rent that Alice paid and that
+Alice, Bob, and Carol share equally:
+
+  (Landlord "March rent"
+      Expenses:Rent 900 USD xfer alice paid-by alice 1 bob 1 carol 1 share
+      Assets:Checking -900 USD xfer
+      xact)
+
+The payer is owed each shared transfer's quantity and each person
+sharing it owes their share, so Bob and Carol each owe Alice 300 USD.
+A repayment is a transfer that the debtor pays and the creditor
+shares entirely.  Shared transfers must have payers.
+
+The output includes a header and these columns:
+
+  person    the person's name
+  balance   the amount owed to the person (positive) or by the person
+            (negative), one row per commodity
+
+The -p flag prints payments that settle all debts instead of balances.
+The output's columns are then:
+
+  from      the paying person's name
+  to        the paid person's name
+  amount    the payment's amount
+
+The -s flag specifies the first day of the period whose transfers
+are settled.  By default, the period starts at the beginning
+of the ledger.
+
+The -e flag specifies the last day of the period.  Freebean stops parsing
+at the end of that day.  Freebean parses all input by default.
+See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSettle()
+	},
+}
+
+var settleOptions = struct {
+	StartDate Date
+	EndDate   EndDate
+	Payments  bool
+	Columns   []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(settleCmd)
+	settleCmd.Flags().VarP(&settleOptions.StartDate, "start-date", "s", "first day of the period")
+	settleCmd.Flags().VarP(&settleOptions.EndDate, "end-date", "e", "last day of the period")
+	settleCmd.Flags().BoolVarP(&settleOptions.Payments, "payments", "p", false, "print settling payments instead of balances")
+	settleCmd.Flags().StringSliceVarP(&settleOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+func runSettle() {
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(settleOptions.StartDate)
+	endDate := core.Date(settleOptions.EndDate)
+	balances := settle.Balances{}
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err == nil && !ctx.Date.Before(startDate) {
+			err = balances.AddTransaction(&xact)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	header := []string{"person", "balance"}
+	if settleOptions.Payments {
+		header = []string{"from", "to", "amount"}
+	}
+	w, err := newTableWriter(os.Stdout, header, settleOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if settleOptions.Payments {
+		for _, pmt := range balances.Payments() {
+			w.Write([]string{pmt.From, pmt.To, formatQuantity(p.Context(), pmt.Amount, pmt.Commodity)})
+		}
+	} else {
+		for _, person := range balances.People() {
+			commodities := make([]string, len(balances[person]))[:0]
+			for cn := range balances[person] {
+				commodities = append(commodities, cn)
+			}
+			sort.Strings(commodities)
+			for _, cn := range commodities {
+				w.Write([]string{person, formatQuantity(p.Context(), balances[person][cn], cn)})
+			}
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/jtvaughan/freebean/pkg/store"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var stateDiffCmd = &cobra.Command{
+	Use:   "state-diff OLD [NEW]",
+	Short: "Print the differences between two ledger states",
+	Long: `The state-diff subcommand compares the states of two ledgers and
+prints their differences in CSV format.  It's handy for verifying that
+rewriting a ledger, such as by splitting it into included files or
+replacing transactions with words, did not change its results.
+
+OLD and NEW are ledger files, which Freebean parses, or snapshots
+written by the snapshot subcommand: directories or files whose names
+end in ".json".  If NEW is not specified,
+Freebean parses a ledger from standard input instead.
+
+The output includes a header and has these columns:
+
+  change       opened account, closed account, new commodity,
+               or balance
+  account      the account (blank for new commodities)
+  lot          the lot whose balance changed (balances only)
+  commodity    the new commodity or the lot's commodity
+  old balance  the lot's balance in OLD (balances only)
+  new balance  the lot's balance in NEW (balances only)
+
+Opened accounts are open in NEW but not in OLD, and closed accounts are
+open in OLD but not in NEW.  Balance rows compare the lots of open
+accounts; missing lots have zero balances.
+
+Freebean exits with status 3 if there are differences.
+
+The --checkpoint flag speeds up parsing standard input.  See
+"freebean help".
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runStateDiff(args)
+	},
+}
+
+var stateDiffOptions = struct {
+	Columns []string
+}{}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot PATH",
+	Short: "Save a ledger's state",
+	Long: `The snapshot subcommand reads a ledger from standard input and saves
+the resulting state at the specified path, replacing any state saved
+there.  If the path ends in ".json", Freebean writes a JSON file, which
+other programs can read instead of parsing the ledger.  Otherwise,
+the path is a directory, which Freebean creates if it does not exist.
+The state-diff subcommand can compare snapshots with each other and
+with ledgers.
+
+The --checkpoint flag speeds up parsing standard input.  See
+"freebean help".
+
+The JSON file is an object mapping keys to the JSON descriptions
+of the state's parts, such as:
+
+  context                   the date, variables, options, events,
+                            alerts, and realized gains
+  commodities/NAME          a commodity
+  accounts/NAME             an open account and its lots
+  closed-accounts/NUMBER    a closed account that was reopened
+  prices/COMMODITY          a commodity's price history
+  budgets/NUMBER            a budget
+  journal/NUMBER            a transaction, if the journal pragma is set
+
+A directory holds the same values in files named by their keys.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSnapshot(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stateDiffCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	stateDiffCmd.Flags().StringSliceVarP(&stateDiffOptions.Columns, "columns", "C", nil, "columns to print")
+	addCheckpointFlag(stateDiffCmd)
+	addCheckpointFlag(snapshotCmd)
+}
+
+// loadState parses the ledger file at path or, if path is a directory
+// or a JSON file, loads the snapshot that it holds.
+func loadState(path string) (*core.Context, error) {
+	if strings.HasSuffix(path, ".json") {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ctx, err := store.UnmarshalJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return ctx, nil
+	} else if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		s, err := store.NewDirStore(path)
+		if err != nil {
+			return nil, err
+		}
+		ctx, err := store.Load(s)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return ctx, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := functions.NewParser(f)
+	p.SetFileName(path)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return p.Context(), nil
+}
+
+func runStateDiff(args []string) {
+	old, err := loadState(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var new *core.Context
+	if len(args) == 2 {
+		new, err = loadState(args[1])
+	} else {
+		new, err = parseStdin()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	w, err := newTableWriter(os.Stdout, []string{"change", "account", "lot", "commodity", "old balance", "new balance"}, stateDiffOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	d := core.Diff(old, new)
+	for _, an := range d.OpenedAccounts {
+		w.Write([]string{"opened account", an, "", "", "", ""})
+	}
+	for _, an := range d.ClosedAccounts {
+		w.Write([]string{"closed account", an, "", "", "", ""})
+	}
+	for _, cn := range d.NewCommodities {
+		w.Write([]string{"new commodity", "", "", cn, "", ""})
+	}
+	for _, l := range d.Lots {
+		w.Write([]string{"balance", l.Account, l.Lot, l.Commodity, l.Old.String(), l.New.String()})
+	}
+	w.Flush()
+	if !d.IsEmpty() {
+		os.Exit(3)
+	}
+}
+
+func runSnapshot(path string) {
+	ctx, err := parseStdin()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if strings.HasSuffix(path, ".json") {
+		data, err := store.MarshalJSON(ctx)
+		if err == nil {
+			err = ioutil.WriteFile(path, data, 0666)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	s, err := store.NewDirStore(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	} else if err = store.Save(s, ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
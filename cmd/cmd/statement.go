@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+)
+
+var statementCmd = &cobra.Command{
+	Use:   "statement ACCOUNT COMMODITY",
+	Short: "Print an account's transfers grouped into statement cycles",
+	Long: `The statement subcommand reads a ledger from standard input, groups
+the transfers affecting the specified account in the specified commodity
+into monthly statement cycles, such as a credit card's, and prints
+one row per cycle in CSV format.  The output includes a header and
+these columns:
+
+  start            the cycle's first day
+  end              the cycle's closing date
+  opening balance  the account's balance before the cycle
+  debits           the sum of the cycle's positive transfers
+  credits          the sum of the cycle's negative transfers
+  closing balance  the account's balance at the end of the cycle
+  transfers        the number of transfers in the cycle
+  status           the cycle's reconciliation status
+
+Balances include all of the account's lots.  A cycle's status is
+"reconciled" if the ledger asserts the account's balance in the commodity
+(with assert or assert-lots-sum) on the cycle's closing date, "open" if
+the cycle has not closed by the end of the ledger, and "unreconciled"
+otherwise.  Record each statement's closing balance with an assertion
+dated on its closing date to reconcile it.
+
+The -c flag specifies the day of the month on which cycles close,
+from 1 to 31.  It is required.  Cycles closing on days beyond the ends
+of shorter months close on the months' last days.
+
+The -s flag specifies a date within the first cycle to print.  By default,
+Freebean starts with the cycle containing the account's first transfer.
+
+The -e flag specifies the date on which to stop parsing.  The last cycle
+printed is the one containing the ledger's last date.  Freebean parses
+all input by default.  See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runStatement(args[0], args[1])
+	},
+}
+
+var statementOptions = struct {
+	ClosingDay int
+	StartDate  Date
+	EndDate    EndDate
+	Columns    []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(statementCmd)
+	statementCmd.Flags().IntVarP(&statementOptions.ClosingDay, "closing-day", "c", 0, "day of the month on which cycles close")
+	statementCmd.Flags().VarP(&statementOptions.StartDate, "start-date", "s", "date within the first cycle to print")
+	statementCmd.Flags().VarP(&statementOptions.EndDate, "end-date", "e", "date to stop parsing")
+	statementCmd.Flags().StringSliceVarP(&statementOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// statementCycle summarizes an account's transfers during a statement cycle.
+type statementCycle struct {
+	First, Last     core.Date
+	Opening         decimal.Decimal
+	Debits, Credits decimal.Decimal
+	Transfers       int
+	Reconciled      bool
+}
+
+func (c *statementCycle) closing() decimal.Decimal {
+	return c.Opening.Add(c.Debits).Add(c.Credits)
+}
+
+func runStatement(accountName, commodityName string) {
+	closingDay := statementOptions.ClosingDay
+	if closingDay == 0 {
+		fmt.Fprintln(os.Stderr, "no closing day specified")
+		os.Exit(1)
+	} else if err := core.CheckClosingDay(closingDay); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	header := []string{"start", "end", "opening balance", "debits", "credits", "closing balance", "transfers", "status"}
+	w, err := newTableWriter(os.Stdout, header, statementOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	endDate := core.Date(statementOptions.EndDate)
+	var cycles []*statementCycle
+	var balance decimal.Decimal
+
+	// cycle returns the cycle containing d, adding it and any empty cycles
+	// preceding it if necessary.
+	cycle := func(d core.Date) *statementCycle {
+		if len(cycles) == 0 {
+			first, last := core.StatementCycle(d, closingDay)
+			cycles = append(cycles, &statementCycle{First: first, Last: last})
+		}
+		for c := cycles[len(cycles)-1]; c.Last.Before(d); c = cycles[len(cycles)-1] {
+			first, last := core.StatementCycle(c.Last.AddDays(1), closingDay)
+			cycles = append(cycles, &statementCycle{First: first, Last: last, Opening: balance})
+		}
+		return cycles[len(cycles)-1]
+	}
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		prev := ctx.Date
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			ctx.Date = prev
+			stop()
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		for _, t := range xact.Transfers {
+			if t.Account.Name != accountName || t.Quantity.Commodity.Name != commodityName {
+				continue
+			}
+			c := cycle(ctx.Date)
+			if t.Quantity.Amount.IsNegative() {
+				c.Credits = c.Credits.Add(t.Quantity.Amount)
+			} else {
+				c.Debits = c.Debits.Add(t.Quantity.Amount)
+			}
+			c.Transfers++
+			balance = balance.Add(t.Quantity.Amount)
+		}
+		return nil
+	}
+	for _, fn := range []string{"assert", "assert-lots-sum"} {
+		assert := p.Functions[fn]
+		p.Functions[fn] = func(fn string, op parser.Operands, ctx *core.Context) error {
+			values := op.GetValues()
+			matches := len(values) >= 3 && values[len(values)-3] == accountName && values[len(values)-1] == commodityName
+			if err := assert(fn, op, ctx); err != nil {
+				return err
+			} else if matches && len(cycles) != 0 {
+				if c := cycle(ctx.Date); c.Last.Equal(ctx.Date) {
+					c.Reconciled = true
+				}
+			}
+			return nil
+		}
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	if len(cycles) != 0 {
+		cycle(p.Context().Date)
+	}
+	startDate := core.Date(statementOptions.StartDate)
+	for _, c := range cycles {
+		if c.Last.Before(startDate) {
+			continue
+		}
+		status := "unreconciled"
+		if c.Reconciled {
+			status = "reconciled"
+		} else if c.Last.After(p.Context().Date) {
+			status = "open"
+		}
+		w.Write([]string{
+			formatDate(c.First),
+			formatDate(c.Last),
+			formatQuantity(p.Context(), c.Opening, commodityName),
+			formatQuantity(p.Context(), c.Debits, commodityName),
+			formatQuantity(p.Context(), c.Credits, commodityName),
+			formatQuantity(p.Context(), c.closing(), commodityName),
+			strconv.Itoa(c.Transfers),
+			status})
+	}
+	w.Flush()
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strconv"
+)
+
+var suggestAccountCmd = &cobra.Command{
+	Use:   "suggest-account ENTITY AMOUNT",
+	Short: "Suggest counter accounts based on past transactions",
+	Long: `The suggest-account subcommand reads a ledger from standard input
+and suggests counter accounts for a transaction with the specified entity
+that transfers AMOUNT to its counter account, such as 12.50 for a purchase
+recorded as an expense.  It prints the suggestions in CSV format, most
+likely first, with a header and these columns:
+
+  account  the suggested account
+  count    the number of the entity's past transactions that transferred
+           amounts with AMOUNT's sign to the account
+
+Suggestions are ranked by count, then by how close the account's most
+recent transfer from the entity was to AMOUNT.  Entities are compared
+without regard to case or whitespace.  Freebean prints only the header
+if the entity has no past transfers to candidate accounts.
+
+The -p flag specifies the prefixes of candidate accounts' names as
+a comma-separated list.  The default is "Expenses".
+
+The -n flag specifies the maximum number of suggestions.  The default
+is 1; 0 prints all suggestions.
+
+The import subcommands' --suggest-from flag uses the same suggestions
+to categorize imported transactions.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSuggestAccount(args[0], args[1])
+	},
+}
+
+var suggestAccountOptions = struct {
+	Prefixes []string
+	Limit    int
+}{}
+
+func init() {
+	rootCmd.AddCommand(suggestAccountCmd)
+	suggestAccountCmd.Flags().StringSliceVarP(&suggestAccountOptions.Prefixes, "prefixes", "p", []string{"Expenses"}, "prefixes of candidate accounts")
+	suggestAccountCmd.Flags().IntVarP(&suggestAccountOptions.Limit, "limit", "n", 1, "maximum number of suggestions")
+}
+
+// newSuggester parses a ledger and returns a Suggester based on its
+// transactions.
+func newSuggester(r io.Reader, prefixes []string) (*importer.Suggester, error) {
+	p := functions.NewParser(r)
+	p.AddCoreFunctions()
+	p.Context().Options.KeepJournal = true
+	if err := p.Parse(); err != nil {
+		return nil, err
+	}
+	return importer.NewSuggester(p.Context().Journal, prefixes), nil
+}
+
+func runSuggestAccount(entity, amount string) {
+	a, err := functions.ParseDecimal(amount)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "illegal amount %v: %v\n", amount, err)
+		os.Exit(1)
+	}
+	s, err := newSuggester(os.Stdin, suggestAccountOptions.Prefixes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	w, err := newTableWriter(os.Stdout, []string{"account", "count"}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for n, suggestion := range s.Suggest(entity, a) {
+		if suggestAccountOptions.Limit > 0 && n == suggestAccountOptions.Limit {
+			break
+		}
+		w.Write([]string{suggestion.Account, strconv.Itoa(suggestion.Count)})
+	}
+	w.Flush()
+}
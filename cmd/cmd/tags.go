@@ -27,6 +27,7 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
@@ -34,13 +35,24 @@ import (
 	"github.com/jtvaughan/freebean/pkg/parser"
 	"github.com/spf13/cobra"
 	"os"
+	"sort"
+	"strconv"
 )
 
 var tagsCmd = &cobra.Command{
 	Use:   "tags",
 	Short: "Print all tags",
 	Long: `The tags subcommand reads a ledger from standard input
-and prints all tags in CSV format.  The output includes a header.
+and prints all tags in CSV format, sorted by name and value.  The output
+includes a header, a name column, a value column, and a count column
+with the number of accounts, commodities, and transactions that have
+the tag.  Tags may be plain names, such as "bank", which have empty
+values, or key=value pairs, such as "trip=japan2024", whose names are
+their keys; each value of a key gets its own row.  Closed accounts are
+not counted, and tags that only closed accounts have are not printed.
+If the ledger sets the account-inheritance pragma, accounts inherit
+their ancestors' tags, so a tag on Expenses:Travel also counts
+Expenses:Travel:Lodging.
 
 The -a flag makes Freebean print tagged accounts.  The output will include
 a type column with the value "account" and a name column.  Note that this
@@ -50,10 +62,21 @@ The -c flag makes Freebean print tagged commodities.  The output will include
 a type column with the value "commodity" and a name column.  Note that this
 flag makes the output repeat tags, once per tagged commodity.
 
-Specifying both -a and -c with interleave their results.
+The -x flag makes Freebean print tagged transactions (see the tag-xact
+function).  The output will include a type column with the value
+"transaction" and a name column containing the transactions'
+descriptions.  Note that this flag makes the output repeat tags,
+once per tagged transaction.
+
+Specifying more than one of -a, -c, and -x will interleave their results.
+
+The --include-closed flag makes Freebean count and print closed accounts,
+including accounts that were closed and later reopened, as they were
+when they were closed.  If -a is also specified, the output will include
+a closing date column, which is empty for open accounts and commodities.
 
 The -d flag specifies the date on which to stop parsing.
-The date should be formatted "YYYY-MM-DD".  Parsing stops
+See "freebean help" for the accepted date formats.  Parsing stops
 at the end of the day, so accounts opened and commodities created
 on that day are included.  Freebean parses all input by default.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -62,9 +85,11 @@ on that day are included.  Freebean parses all input by default.`,
 }
 
 var tagsOptions = struct {
-	Date             Date
-	PrintAccounts    bool
-	PrintCommodities bool
+	Date              EndDate
+	PrintAccounts     bool
+	PrintCommodities  bool
+	PrintTransactions bool
+	IncludeClosed     bool
 }{}
 
 func init() {
@@ -72,59 +97,131 @@ func init() {
 	tagsCmd.Flags().VarP(&tagsOptions.Date, "date", "d", "date to stop parsing")
 	tagsCmd.Flags().BoolVarP(&tagsOptions.PrintAccounts, "print-accounts", "a", false, "print tagged accounts")
 	tagsCmd.Flags().BoolVarP(&tagsOptions.PrintCommodities, "print-commodities", "c", false, "print tagged commodities")
+	tagsCmd.Flags().BoolVarP(&tagsOptions.PrintTransactions, "print-transactions", "x", false, "print tagged transactions")
+	tagsCmd.Flags().BoolVar(&tagsOptions.IncludeClosed, "include-closed", false, "include closed accounts")
 }
 
 func runTags() {
-	done := &struct{}{}
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
 	p := functions.NewParser(os.Stdin)
 	p.AddCoreFunctions()
+	p.Context().Options.KeepJournal = true
 	date := core.Date(tagsOptions.Date)
 	if !date.IsZero() {
 		p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
 			if err := functions.DateFunction(fn, op, ctx); err != nil {
 				return err
 			} else if ctx.Date.After(date) {
-				panic(done)
+				stop()
 			}
 			return nil
 		}
 	}
-	defer func() {
-		if r := recover(); r != nil && r != done {
-			panic(r)
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	ctx := p.Context()
+	w := csv.NewWriter(os.Stdout)
+	row := []string{"name", "value", "count"}
+	addlColumns := tagsOptions.PrintAccounts || tagsOptions.PrintCommodities || tagsOptions.PrintTransactions
+	closingDates := tagsOptions.PrintAccounts && tagsOptions.IncludeClosed
+	if addlColumns {
+		row = append(row, "type", "name")
+	}
+	if closingDates {
+		row = append(row, "closing date")
+	}
+	w.Write(row)
+
+	// Group the tagged accounts, commodities, and transactions
+	// by tag key and value.
+	type taggedItem struct {
+		kind, name, closingDate string
+	}
+	items := map[string]map[string][]taggedItem{}
+	add := func(key, value string, item taggedItem) {
+		if items[key] == nil {
+			items[key] = map[string][]taggedItem{}
 		}
-		w := csv.NewWriter(os.Stdout)
-		row := []string{"name"}
-		addlColumns := tagsOptions.PrintAccounts || tagsOptions.PrintCommodities
-		if addlColumns {
-			row = append(row, "type", "name")
+		items[key][value] = append(items[key][value], item)
+	}
+	addAccount := func(key, value string, a *core.Account) {
+		if !inBook(a) || (!tagsOptions.IncludeClosed && a.IsClosed(ctx.Date)) {
+			return
 		}
-		w.Write(row)
-		for tn, tagged := range p.Context().Tags {
-			row = append(row[:0], tn)
-			if addlColumns {
-				for _, to := range tagged {
-					switch v := to.(type) {
-					case *core.Account:
-						if tagsOptions.PrintAccounts && !v.IsClosed(p.Context().Date) {
-							row = append(row[:1], "account", v.Name)
-							w.Write(row)
-						}
-					case *core.Commodity:
-						if tagsOptions.PrintCommodities {
-							row = append(row[:1], "commodity", v.Name)
-							w.Write(row)
-						}
-					}
+		cd := ""
+		if a.IsClosed(ctx.Date) {
+			cd = formatDate(a.ClosingDate)
+		}
+		add(key, value, taggedItem{"account", a.Name, cd})
+	}
+	keys := make([]string, len(ctx.Tags))[:0]
+	for key := range ctx.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, to := range ctx.Tags[key] {
+			value, _ := to.TagValue(key)
+			switch v := to.(type) {
+			case *core.Account:
+				addAccount(key, value, v)
+			case *core.Commodity:
+				add(key, value, taggedItem{"commodity", v.Name, ""})
+			}
+		}
+	}
+	if ctx.Options.AccountInheritance {
+		var accounts []*core.Account
+		for _, a := range ctx.Accounts {
+			accounts = append(accounts, a)
+		}
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+		for _, a := range accounts {
+			for _, tag := range ctx.AccountTags(a) {
+				key, value, _ := core.SplitTag(tag)
+				if _, ok := a.Tags[key]; !ok {
+					addAccount(key, value, a)
 				}
-			} else {
+			}
+		}
+	}
+	for _, e := range ctx.Journal {
+		for key, value := range e.Tags {
+			add(key, value, taggedItem{"transaction", e.Description, ""})
+		}
+	}
+
+	keys = keys[:0]
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := make([]string, len(items[key]))[:0]
+		for value := range items[key] {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			tagged := items[key][value]
+			row = append(row[:0], key, value, strconv.Itoa(len(tagged)))
+			if !addlColumns {
 				w.Write(row)
+				continue
+			}
+			for _, item := range tagged {
+				if (item.kind == "account" && tagsOptions.PrintAccounts) || (item.kind == "commodity" && tagsOptions.PrintCommodities) || (item.kind == "transaction" && tagsOptions.PrintTransactions) {
+					row = append(row[:3], item.kind, item.name)
+					if closingDates {
+						row = append(row, item.closingDate)
+					}
+					w.Write(row)
+				}
 			}
 		}
-		w.Flush()
-	}()
-	if err := p.Parse(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
 	}
+	w.Flush()
 }
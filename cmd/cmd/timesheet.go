@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var timesheetCmd = &cobra.Command{
+	Use:   "timesheet",
+	Short: "Print hours worked per client and project",
+	Long: `The timesheet subcommand reads a ledger from standard input and prints
+the time worked for clients on their projects during each period in CSV
+format.  Time entries are transfers of a unit commodity, such as hours,
+marked with the time-entry transfer modifier:
+
+  Transfer CLIENT PROJECT time-entry -> Transfer
+                          record the transfer's quantity as time worked
+                          for CLIENT on PROJECT
+
+Clients' rates are set with the billing-rate function:
+
+  CLIENT PROJECT AMOUNT COMMODITY billing-rate ->
+                          bill CLIENT AMOUNT COMMODITY per unit of time
+                          worked on PROJECT, or on all of the client's
+                          projects without rates if PROJECT is empty,
+                          starting on the current date
+
+For example:
+
+  HRS Hours commodity
+  Assets:Time open
+  Income:Time open
+  Acme "" 100 USD billing-rate
+  (Acme "Site redesign"
+      Assets:Time 3.5 HRS xfer Acme Website time-entry
+      Income:Time -3.5 HRS xfer
+      xact)
+
+The output includes a header and has one row per period, client,
+project, and commodity, sorted in that order, with these columns:
+
+  first day   the period's first day
+  last day    the period's last day
+  client      the client's name
+  project     the project's name
+  hours       the total time worked
+
+The -i flag adds an amount column holding the invoiced amount: the sum
+of the time entries' quantities times the rates in effect on their
+dates, each rounded to the rate commodity's decimal places, if any.
+Freebean prints warnings to standard error for time entries without
+rates.
+
+The -p flag specifies the period length: week, month (the default),
+or year.  Years are fiscal years.  Periods respect the --week-start,
+--month-start, and --fiscal-year-start flags.
+
+The -s flag specifies the first day of the time entries to print.
+By default, the time entries start at the beginning of the ledger.
+
+The -e flag specifies the last day of the time entries to print.
+Freebean stops parsing at the end of that day.  Freebean parses all
+input by default.  See "freebean help" for the accepted date formats.
+
+The -C flag selects which columns to print and in what order.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runTimesheet()
+	},
+}
+
+var timesheetOptions = struct {
+	Period    string
+	StartDate Date
+	EndDate   EndDate
+	Invoice   bool
+	Columns   []string
+}{}
+
+func init() {
+	rootCmd.AddCommand(timesheetCmd)
+	timesheetCmd.Flags().StringVarP(&timesheetOptions.Period, "period", "p", "month", "period length (week, month, or year)")
+	timesheetCmd.Flags().VarP(&timesheetOptions.StartDate, "start-date", "s", "first day of time entries")
+	timesheetCmd.Flags().VarP(&timesheetOptions.EndDate, "end-date", "e", "last day of time entries")
+	timesheetCmd.Flags().BoolVarP(&timesheetOptions.Invoice, "invoice", "i", false, "print invoiced amounts")
+	timesheetCmd.Flags().StringSliceVarP(&timesheetOptions.Columns, "columns", "C", nil, "columns to print")
+}
+
+// timesheetKey identifies a row of the timesheet subcommand's output.
+type timesheetKey struct {
+	first                    core.Date
+	client, project          string
+	commodity, rateCommodity string
+}
+
+// timesheetRow sums time entries.
+type timesheetRow struct {
+	last   core.Date
+	hours  core.Quantity
+	amount *core.Quantity
+}
+
+func runTimesheet() {
+	var period func(core.Date) (core.Date, core.Date)
+	switch timesheetOptions.Period {
+	case "week":
+		period = calendar.Week
+	case "month":
+		period = calendar.Month
+	case "year":
+		period = calendar.FiscalYear
+	default:
+		fmt.Fprintf(os.Stderr, "unknown period: %v\n", timesheetOptions.Period)
+		os.Exit(1)
+	}
+	parsing, stop := context.WithCancel(context.Background())
+	defer stop()
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	startDate := core.Date(timesheetOptions.StartDate)
+	endDate := core.Date(timesheetOptions.EndDate)
+	rows := map[timesheetKey]*timesheetRow{}
+	var keys []timesheetKey
+
+	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		if err := functions.DateFunction(fn, op, ctx); err != nil {
+			return err
+		} else if !endDate.IsZero() && ctx.Date.After(endDate) {
+			stop()
+		}
+		return nil
+	}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		} else if ctx.Date.Before(startDate) {
+			return nil
+		}
+		for _, t := range xact.Transfers {
+			if len(t.Project) == 0 {
+				continue
+			}
+			first, last := period(ctx.Date)
+			k := timesheetKey{first: first, client: t.Client, project: t.Project, commodity: t.Quantity.Commodity.Name}
+			var amount *core.Quantity
+			if timesheetOptions.Invoice {
+				if rate, ok := ctx.BillingRate(t.Client, t.Project, ctx.Date); ok {
+					amount = &core.Quantity{Commodity: rate.Commodity, Amount: t.Quantity.Amount.Mul(rate.Amount)}
+					if places := rate.Commodity.DecimalPlaces; places >= 0 {
+						amount.Amount = amount.Amount.Round(places)
+					}
+					k.rateCommodity = rate.Commodity.Name
+				} else {
+					fmt.Fprintf(os.Stderr, "warning: %v: no billing rate for client %v's project %v\n", ctx.Date, t.Client, t.Project)
+				}
+			}
+			r, ok := rows[k]
+			if !ok {
+				r = &timesheetRow{last: last, hours: core.Quantity{Commodity: t.Quantity.Commodity}}
+				rows[k] = r
+				keys = append(keys, k)
+			}
+			r.hours.Amount = r.hours.Amount.Add(t.Quantity.Amount)
+			if amount != nil {
+				if r.amount == nil {
+					r.amount = &core.Quantity{Commodity: amount.Commodity}
+				}
+				r.amount.Amount = r.amount.Amount.Add(amount.Amount)
+			}
+		}
+		return nil
+	}
+	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if !a.first.Equal(b.first) {
+			return a.first.Before(b.first)
+		} else if a.client != b.client {
+			return a.client < b.client
+		} else if a.project != b.project {
+			return a.project < b.project
+		} else if a.commodity != b.commodity {
+			return a.commodity < b.commodity
+		}
+		return a.rateCommodity < b.rateCommodity
+	})
+	header := []string{"first day", "last day", "client", "project", "hours"}
+	if timesheetOptions.Invoice {
+		header = append(header, "amount")
+	}
+	w, err := newTableWriter(os.Stdout, header, timesheetOptions.Columns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, k := range keys {
+		r := rows[k]
+		row := []string{formatDate(k.first), formatDate(r.last), k.client, k.project, r.hours.String()}
+		if timesheetOptions.Invoice {
+			if r.amount != nil {
+				row = append(row, r.amount.String())
+			} else {
+				row = append(row, "")
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+}
@@ -27,9 +27,16 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
+	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Date is a date flag.  It accepts dates in any of dateLayouts as well as
+// the relative expressions parsed by parseRelativeDate, which resolve
+// to the first days of periods.
 type Date core.Date
 
 func (d *Date) String() string {
@@ -37,9 +44,160 @@ func (d *Date) String() string {
 }
 
 func (d *Date) Set(v string) error {
-	date, err := core.ParseDate(v)
-	*d = Date(date)
-	return err
+	return setDateFlag((*core.Date)(d), v, false)
 }
 
 func (d *Date) Type() string { return "date" }
+
+// EndDate is like Date except that relative expressions resolve
+// to the last days of periods.  Flags that specify dates on which
+// to stop parsing are EndDates.
+type EndDate core.Date
+
+func (d *EndDate) String() string {
+	return core.Date(*d).String()
+}
+
+func (d *EndDate) Set(v string) error {
+	return setDateFlag((*core.Date)(d), v, true)
+}
+
+func (d *EndDate) Type() string { return "date" }
+
+// dateLayouts lists the layouts that date flags accept, in the order
+// in which they are tried.
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "02.01.2006", "20060102"}
+
+// today returns the current date.  It is a variable so that relative dates
+// can be resolved against other dates.
+var today = func() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FiscalYearStart is a fiscal year start flag formatted "MM-DD".
+type FiscalYearStart core.FiscalYearStart
+
+func (f *FiscalYearStart) String() string {
+	return core.FiscalYearStart(*f).String()
+}
+
+func (f *FiscalYearStart) Set(v string) error {
+	start, err := core.ParseFiscalYearStart(v)
+	if err == nil {
+		*f = FiscalYearStart(start)
+	}
+	return err
+}
+
+func (f *FiscalYearStart) Type() string { return "MM-DD" }
+
+// Weekday is a weekday flag.
+type Weekday time.Weekday
+
+func (wd *Weekday) String() string {
+	return time.Weekday(*wd).String()
+}
+
+func (wd *Weekday) Set(v string) error {
+	day, err := core.ParseWeekday(v)
+	if err == nil {
+		*wd = Weekday(day)
+	}
+	return err
+}
+
+func (wd *Weekday) Type() string { return "weekday" }
+
+// relativeDateFlags holds functions that resolve date flags set to relative
+// expressions.  Their resolution waits until all flags are parsed because
+// it depends on the calendar flags.
+var relativeDateFlags []func()
+
+// resolveRelativeDateFlags resolves date flags set to relative expressions.
+func resolveRelativeDateFlags() {
+	for _, resolve := range relativeDateFlags {
+		resolve()
+	}
+	relativeDateFlags = nil
+}
+
+func setDateFlag(d *core.Date, v string, end bool) error {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			*d = core.FromTime(t)
+			return nil
+		}
+	}
+	if _, ok := parseRelativeDate(v, core.FromTime(today()), end, calendar); !ok {
+		return fmt.Errorf(`invalid date "%v": expected YYYY-MM-DD, YYYY/MM/DD, DD.MM.YYYY, YYYYMMDD, or a relative date`, v)
+	}
+	relativeDateFlags = append(relativeDateFlags, func() {
+		*d, _ = parseRelativeDate(v, core.FromTime(today()), end, calendar)
+	})
+	return nil
+}
+
+// parseRelativeDate resolves a relative date expression against now.
+// These are the expressions:
+//
+//	today, yesterday      the current or previous day
+//	-N(d|w|m|y)           N days, weeks, months, or years ago
+//	+N(d|w|m|y)           N days, weeks, months, or years from now
+//	(this|last)-(week|month|year|fiscal-year)
+//	                      the current or previous period
+//
+// cal determines the periods' boundaries.  Periods resolve to their first
+// days or, if end is true, their last days.
+func parseRelativeDate(v string, now core.Date, end bool, cal core.Calendar) (core.Date, bool) {
+	switch v {
+	case "today":
+		return now, true
+	case "yesterday":
+		return now.AddDays(-1), true
+	}
+	if len(v) >= 3 && (v[0] == '-' || v[0] == '+') {
+		n, err := strconv.Atoi(v[1 : len(v)-1])
+		if err != nil || v[1] < '0' || v[1] > '9' {
+			return now, false // such as "-+1d"
+		} else if v[0] == '-' {
+			n = -n
+		}
+		switch v[len(v)-1] {
+		case 'd':
+			return now.AddDays(n), true
+		case 'w':
+			return now.AddDays(7 * n), true
+		case 'm':
+			return now.AddMonths(n), true
+		case 'y':
+			return now.AddYears(n), true
+		}
+		return now, false
+	}
+	parts := strings.SplitN(v, "-", 2)
+	if len(parts) != 2 || (parts[0] != "this" && parts[0] != "last") {
+		return now, false
+	}
+	var period func(core.Date) (core.Date, core.Date)
+	switch parts[1] {
+	case "week":
+		period = cal.Week
+	case "month":
+		period = cal.Month
+	case "year":
+		period = cal.Year
+	case "fiscal-year":
+		period = cal.FiscalYear
+	default:
+		return now, false
+	}
+	first, last := period(now)
+	if parts[0] == "last" {
+		first, last = period(first.AddDays(-1))
+	}
+	if end {
+		return last, true
+	}
+	return first, true
+}
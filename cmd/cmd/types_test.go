@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"testing"
+	"time"
+)
+
+func TestParseRelativeDate(t *testing.T) {
+	date := func(y, m, d int) core.Date { return core.Date{Year: y, Month: m, Day: d} }
+	cal := core.Calendar{WeekStart: time.Monday, MonthStartDay: 1, FiscalYearStart: core.FiscalYearStart{Month: 7, Day: 1}}
+	now := date(2021, 3, 31) // a Wednesday
+	for _, test := range []struct {
+		v        string
+		end      bool
+		expected core.Date
+	}{
+		{"today", false, date(2021, 3, 31)},
+		{"yesterday", true, date(2021, 3, 30)},
+		{"-1d", false, date(2021, 3, 30)},
+		{"+1d", false, date(2021, 4, 1)},
+		{"-2w", false, date(2021, 3, 17)},
+		{"-1m", false, date(2021, 2, 28)},
+		{"+1m", false, date(2021, 4, 30)},
+		{"-13m", false, date(2020, 2, 29)},
+		{"-1y", false, date(2020, 3, 31)},
+		{"+0d", false, date(2021, 3, 31)},
+		{"this-week", false, date(2021, 3, 29)},
+		{"this-week", true, date(2021, 4, 4)},
+		{"last-week", false, date(2021, 3, 22)},
+		{"this-month", false, date(2021, 3, 1)},
+		{"this-month", true, date(2021, 3, 31)},
+		{"last-month", false, date(2021, 2, 1)},
+		{"last-month", true, date(2021, 2, 28)},
+		{"this-year", true, date(2021, 12, 31)},
+		{"last-year", false, date(2020, 1, 1)},
+		{"this-fiscal-year", false, date(2020, 7, 1)},
+		{"this-fiscal-year", true, date(2021, 6, 30)},
+		{"last-fiscal-year", false, date(2019, 7, 1)},
+		{"last-fiscal-year", true, date(2020, 6, 30)},
+	} {
+		if d, ok := parseRelativeDate(test.v, now, test.end, cal); !ok {
+			t.Errorf("parsing %q (end %v) failed", test.v, test.end)
+		} else if !d.Equal(test.expected) {
+			t.Errorf("parsing %q (end %v) returned %v instead of %v", test.v, test.end, d, test.expected)
+		}
+	}
+
+	// The last day of a leap February ends last-month.
+	if d, _ := parseRelativeDate("last-month", date(2020, 3, 15), true, cal); !d.Equal(date(2020, 2, 29)) {
+		t.Errorf("last-month in March 2020 ended on %v instead of 2020-02-29", d)
+	}
+
+	for _, v := range []string{"", "now", "tomorrow", "-d", "+d", "-1", "--1d", "-+1d", "+1x", "-1.5m", "this", "this-", "next-month", "this-quarter", "last-fiscal", "2021-13-01"} {
+		if _, ok := parseRelativeDate(v, now, false, cal); ok {
+			t.Errorf("parsing %q succeeded but should have failed", v)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"time"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch LEDGER",
+	Short: "Check a ledger whenever it changes",
+	Long: `The watch subcommand checks the specified ledger file for errors
+like the root command does and then checks it again whenever it or a
+file that it includes changes.  After each check, Freebean prints the
+time and either the ledger's first error or the balances that changed
+since the last successful check, one per line:
+
+  ACCOUNT: OLD -> NEW COMMODITY
+
+The first successful check prints nothing but "ok".  Balances
+of accounts that are no longer open or commodities that no longer
+appear in an account are reported as changing to zero.  After the
+balances, Freebean prints the ledger's triggered balance alerts
+(see "freebean help"), one per line:
+
+  alert: ACCOUNT COMPARISON THRESHOLD (balance BALANCE)
+
+Alerts that were triggered by the previous check with the same balances
+are not printed again.  Freebean runs until it is interrupted.
+
+The -i flag specifies how often Freebean checks the files for changes.
+The default is one second.  Freebean checks the files that the ledger
+included the last time that it was parsed, including files that could
+not be read, so creating a missing included file triggers a check.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runWatch(args[0])
+	},
+}
+
+var watchOptions = struct {
+	Interval time.Duration
+}{}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().DurationVarP(&watchOptions.Interval, "interval", "i", time.Second, "how often to check for changes")
+}
+
+// fileState is what watchFiles compares to detect changes to files.
+// Missing files have zero fileStates.
+type fileState struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// watchFiles calls f whenever any of the files whose paths are returned
+// by paths changes, as detected by polling their modification times and
+// sizes every interval.  It calls paths before each poll, so the watched
+// files can change, such as when a ledger includes new files.  Files are
+// compared with their states in the previous poll; new files are not
+// changes, but files that appear or disappear are.  It never returns.
+func watchFiles(paths func() []string, interval time.Duration, f func()) {
+	last := map[string]fileState{}
+	poll := func() (changed bool) {
+		current := map[string]fileState{}
+		for _, path := range paths() {
+			var s fileState
+			if fi, err := os.Stat(path); err == nil {
+				s = fileState{fi.ModTime(), fi.Size()}
+			}
+			if old, ok := last[path]; ok && (!old.ModTime.Equal(s.ModTime) || old.Size != s.Size) {
+				changed = true
+			}
+			current[path] = s
+		}
+		last = current
+		return
+	}
+	poll()
+	for range time.Tick(interval) {
+		if poll() {
+			f()
+		}
+	}
+}
+
+// accountBalance identifies a balance for watch's comparisons.
+type accountBalance struct {
+	Account   string
+	Commodity string
+}
+
+// parseBalances parses the ledger at path and returns the balances
+// of all open accounts, the triggered alerts, and the files that the
+// ledger included before it finished or failed.
+func parseBalances(path string) (map[accountBalance]decimal.Decimal, []string, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	p := functions.NewParser(f)
+	p.SetFileName(path)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		return nil, nil, p.IncludedFiles(), err
+	}
+	ctx := p.Context()
+	balances := map[accountBalance]decimal.Decimal{}
+	for an, a := range ctx.Accounts {
+		if !a.IsClosed(ctx.Date) && inBook(a) {
+			for cn, q := range a.Balances() {
+				balances[accountBalance{an, cn}] = q.Amount
+			}
+		}
+	}
+	return balances, ctx.TriggeredAlerts(), p.IncludedFiles(), nil
+}
+
+// printBalanceChanges prints the differences between two sets of balances.
+func printBalanceChanges(old, new map[accountBalance]decimal.Decimal) {
+	keys := make([]accountBalance, len(new))[:0]
+	for k := range new {
+		keys = append(keys, k)
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Account != keys[j].Account {
+			return keys[i].Account < keys[j].Account
+		}
+		return keys[i].Commodity < keys[j].Commodity
+	})
+	for _, k := range keys {
+		if o, n := old[k], new[k]; !o.Equal(n) {
+			fmt.Printf("%v: %v -> %v %v\n", k.Account, o, n, k.Commodity)
+		}
+	}
+}
+
+func runWatch(path string) {
+	var balances map[accountBalance]decimal.Decimal
+	var included []string
+	alerts := map[string]bool{}
+	check := func() {
+		fmt.Println(time.Now().Format("15:04:05"))
+		newBalances, newAlerts, files, err := parseBalances(path)
+		included = files
+		if err != nil {
+			fmt.Println(err)
+			return
+		} else if balances == nil {
+			fmt.Println("ok")
+		} else {
+			printBalanceChanges(balances, newBalances)
+		}
+		balances = newBalances
+		triggered := map[string]bool{}
+		for _, a := range newAlerts {
+			if !alerts[a] {
+				fmt.Printf("alert: %v\n", a)
+			}
+			triggered[a] = true
+		}
+		alerts = triggered
+	}
+	check()
+	files := func() []string { return append([]string{path}, included...) }
+	watchFiles(files, watchOptions.Interval, check)
+}
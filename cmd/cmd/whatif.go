@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+	"strings"
+)
+
+var whatifCmd = &cobra.Command{
+	Use:   "whatif",
+	Short: "Print balance changes caused by a scenario ledger",
+	Long: `The whatif subcommand reads a ledger from standard input,
+then parses the scenario ledger specified by the -S flag on a copy
+of the resulting state, as though the scenario ledger were appended
+to the main ledger.  It prints the accounts whose balances differ
+between the two states in CSV format.  The output includes a header
+with each account's name, the commodity, the balance before and after
+the scenario, and the difference.  Balances include all lots.
+
+The -S flag specifies the scenario ledger file.  It is required.
+
+The -n flag makes Freebean print net worth changes instead of account
+balance changes.  Net worth is the sum of all Assets and Liabilities
+accounts' balances per commodity.  The account name column is omitted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runWhatif()
+	},
+}
+
+var whatifOptions = struct {
+	Scenario      string
+	PrintNetWorth bool
+}{}
+
+func init() {
+	rootCmd.AddCommand(whatifCmd)
+	whatifCmd.Flags().StringVarP(&whatifOptions.Scenario, "scenario", "S", "", "scenario ledger file")
+	whatifCmd.Flags().BoolVarP(&whatifOptions.PrintNetWorth, "net-worth", "n", false, "print net worth changes instead of account balances")
+}
+
+// balanceTable maps account names (or "" for net worth) to commodity names
+// to balances.
+type balanceTable map[string]map[string]decimal.Decimal
+
+func getBalanceTable(ctx *core.Context, netWorth bool) balanceTable {
+	table := balanceTable{}
+	for an, a := range ctx.Accounts {
+		if !inBook(a) {
+			continue
+		}
+		key := an
+		if netWorth {
+			if !strings.HasPrefix(an, "Assets:") && !strings.HasPrefix(an, "Liabilities:") {
+				continue
+			}
+			key = ""
+		}
+		if _, ok := table[key]; !ok {
+			table[key] = map[string]decimal.Decimal{}
+		}
+		for cn, q := range a.Balances() {
+			table[key][cn] = table[key][cn].Add(q.Amount)
+		}
+	}
+	return table
+}
+
+func runWhatif() {
+	if len(whatifOptions.Scenario) == 0 {
+		fmt.Fprintln(os.Stderr, "no scenario ledger specified")
+		os.Exit(1)
+	}
+	p := functions.NewParser(os.Stdin)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, parser.PrettyError(err))
+		os.Exit(2)
+	}
+	f, err := os.Open(whatifOptions.Scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	sp := functions.NewParserWithContext(f, p.Context().Clone())
+	sp.SetFileName(whatifOptions.Scenario)
+	sp.AddCoreFunctions()
+	if err := sp.Parse(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", whatifOptions.Scenario, err)
+		os.Exit(2)
+	}
+
+	before := getBalanceTable(p.Context(), whatifOptions.PrintNetWorth)
+	after := getBalanceTable(sp.Context(), whatifOptions.PrintNetWorth)
+	keys := map[string]map[string]bool{}
+	for _, table := range []balanceTable{before, after} {
+		for key, balances := range table {
+			if _, ok := keys[key]; !ok {
+				keys[key] = map[string]bool{}
+			}
+			for cn := range balances {
+				keys[key][cn] = true
+			}
+		}
+	}
+	names := make([]string, len(keys))[:0]
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	w := csv.NewWriter(os.Stdout)
+	row := []string{"account name", "commodity", "before", "after", "difference"}
+	if whatifOptions.PrintNetWorth {
+		row = row[1:]
+	}
+	w.Write(row)
+	for _, key := range names {
+		commodities := make([]string, len(keys[key]))[:0]
+		for cn := range keys[key] {
+			commodities = append(commodities, cn)
+		}
+		sort.Strings(commodities)
+		for _, cn := range commodities {
+			b, a := before[key][cn], after[key][cn]
+			if b.Equal(a) {
+				continue
+			}
+			row = row[:0]
+			if !whatifOptions.PrintNetWorth {
+				row = append(row, key)
+			}
+			c := sp.Context().Commodities[cn]
+			row = append(row, cn, c.FormatAmount(b), c.FormatAmount(a), c.FormatAmount(a.Sub(b)))
+			w.Write(row)
+		}
+	}
+	w.Flush()
+}
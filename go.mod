@@ -5,4 +5,6 @@ go 1.15
 require (
 	github.com/shopspring/decimal v1.2.0
 	github.com/spf13/cobra v1.2.1
+	golang.org/x/text v0.3.6
+	gopkg.in/yaml.v2 v2.4.0
 )
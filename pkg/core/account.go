@@ -32,8 +32,12 @@ type Account struct {
 	ClosingDate  Date
 	Commodities  map[string]*Commodity
 	Lots         map[string]map[string]*Lot // lot name -> commodity name -> *Lot
-	Tags         map[string]bool
+	Tags         map[string]string          // keys -> values; see TagTarget
 	Notes        map[string]string
+
+	// Book is the name of the book that contains the account or empty
+	// if the account is in the default book.  See Context.Book.
+	Book string
 }
 
 func NewAccount(name string, creationDate Date) *Account {
@@ -42,31 +46,54 @@ func NewAccount(name string, creationDate Date) *Account {
 		CreationDate: creationDate,
 		Commodities:  map[string]*Commodity{},
 		Lots:         map[string]map[string]*Lot{"": map[string]*Lot{}},
-		Tags:         map[string]bool{},
+		Tags:         map[string]string{},
 		Notes:        map[string]string{}}
 }
 
+// Balances sums the account's lots and returns the totals keyed by
+// commodity name.
+func (a *Account) Balances() map[string]Quantity {
+	balances := map[string]Quantity{}
+	for _, ctol := range a.Lots {
+		for cn, l := range ctol {
+			if q, ok := balances[cn]; ok {
+				q.Amount = q.Amount.Add(l.Balance.Amount)
+				balances[cn] = q
+			} else {
+				balances[cn] = l.Balance
+			}
+		}
+	}
+	return balances
+}
+
 func (a *Account) IsClosed(date Date) bool {
 	return !a.ClosingDate.Equal(Date{}) && date.EqualOrAfter(a.ClosingDate)
 }
 
 func (a *Account) AddTag(tag string) {
-	a.Tags[tag] = true
+	key, value, _ := SplitTag(tag)
+	a.Tags[key] = value
 }
 
 func (a *Account) GetTags() []string {
-	tags := make([]string, len(a.Tags))[:0]
-	for tag, _ := range a.Tags {
-		tags = append(tags, tag)
-	}
-	return tags
+	return tagList(a.Tags)
 }
 
 func (a *Account) HasTag(tag string) bool {
-	_, ok := a.Tags[tag]
-	return ok
+	return hasTag(a.Tags, tag)
 }
 
+// RemoveTag removes the tag if the Account has it.  Tags without values
+// remove their keys regardless of their values.
 func (a *Account) RemoveTag(tag string) {
-	delete(a.Tags, tag)
+	if a.HasTag(tag) {
+		key, _, _ := SplitTag(tag)
+		delete(a.Tags, key)
+	}
+}
+
+func (a *Account) TagValue(key string) (string, bool) {
+	value, ok := a.Tags[key]
+	return value, ok
 }
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// AccountTree organizes accounts by the hierarchy of their colon-separated
+// names.  Each AccountTree is a node named by a full account name, such as
+// "Expenses:Travel", whose children are the nodes whose names extend its
+// name by one component, such as "Expenses:Travel:Lodging".  Nodes exist
+// for every ancestor of every account in the tree, so a node's Account is
+// nil if the tree has no account with the node's name.  The root node
+// has an empty name and no Account.
+type AccountTree struct {
+	Name     string
+	Account  *Account
+	Children []*AccountTree // sorted by name
+}
+
+// NewAccountTree returns the root of a tree of the specified accounts.
+func NewAccountTree(accounts []*Account) *AccountTree {
+	root := &AccountTree{}
+	for _, a := range accounts {
+		node := root
+		for _, component := range strings.Split(a.Name, ":") {
+			name := component
+			if len(node.Name) != 0 {
+				name = node.Name + ":" + component
+			}
+			node = node.child(name)
+		}
+		node.Account = a
+	}
+	return root
+}
+
+// child returns the child of t with the specified name, adding it if
+// necessary.
+func (t *AccountTree) child(name string) *AccountTree {
+	if c := t.find(name); c != nil {
+		return c
+	}
+	n := sort.Search(len(t.Children), func(n int) bool { return t.Children[n].Name >= name })
+	c := &AccountTree{Name: name}
+	t.Children = append(t.Children, nil)
+	copy(t.Children[n+1:], t.Children[n:])
+	t.Children[n] = c
+	return c
+}
+
+// ShortName returns the last component of t's name.
+func (t *AccountTree) ShortName() string {
+	return t.Name[strings.LastIndex(t.Name, ":")+1:]
+}
+
+// Depth returns the number of components in t's name.  The root's depth
+// is zero.
+func (t *AccountTree) Depth() int {
+	if len(t.Name) == 0 {
+		return 0
+	}
+	return strings.Count(t.Name, ":") + 1
+}
+
+// Find returns the node below t with the specified name and whether there
+// is such a node.
+func (t *AccountTree) Find(name string) (*AccountTree, bool) {
+	node := t
+	for n := 0; n < len(name); n++ {
+		if n == len(name)-1 || name[n+1] == ':' {
+			if node = node.find(name[:n+1]); node == nil {
+				return nil, false
+			}
+		}
+	}
+	return node, node != t
+}
+
+// find returns the child of t with the specified name or nil if there is
+// no such child.
+func (t *AccountTree) find(name string) *AccountTree {
+	n := sort.Search(len(t.Children), func(n int) bool { return t.Children[n].Name >= name })
+	if n < len(t.Children) && t.Children[n].Name == name {
+		return t.Children[n]
+	}
+	return nil
+}
+
+// Walk calls f with the nodes below t in sorted order, parents before
+// their children.  It skips nodes deeper than maxDepth unless maxDepth
+// is zero or negative.  Walk stops and returns the error if f returns one.
+func (t *AccountTree) Walk(maxDepth int, f func(*AccountTree) error) error {
+	for _, c := range t.Children {
+		if maxDepth > 0 && c.Depth() > maxDepth {
+			continue
+		} else if err := f(c); err != nil {
+			return err
+		} else if err = c.Walk(maxDepth, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Balances returns the sums of the balances of t's account and
+// the accounts below t, keyed by commodity name.
+func (t *AccountTree) Balances() map[string]Quantity {
+	balances := map[string]Quantity{}
+	var add func(*AccountTree)
+	add = func(node *AccountTree) {
+		if node.Account != nil {
+			for cn, q := range node.Account.Balances() {
+				if b, ok := balances[cn]; ok {
+					b.Amount = b.Amount.Add(q.Amount)
+					balances[cn] = b
+				} else {
+					balances[cn] = q
+				}
+			}
+		}
+		for _, c := range node.Children {
+			add(c)
+		}
+	}
+	add(t)
+	return balances
+}
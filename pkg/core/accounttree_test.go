@@ -0,0 +1,87 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+)
+
+func TestAccountTree(t *testing.T) {
+	date := Date{Year: 2000, Month: 1, Day: 1}
+	usd, eur := NewCommodity("USD", "Dollar", date), NewCommodity("EUR", "Euro", date)
+	account := func(name string, balances ...Quantity) *Account {
+		a := NewAccount(name, date)
+		for _, q := range balances {
+			a.Lots[""][q.Commodity.Name] = &Lot{CreationDate: date, Balance: q}
+		}
+		return a
+	}
+	quantity := func(amount int64, c *Commodity) Quantity {
+		return Quantity{Commodity: c, Amount: decimal.NewFromInt(amount)}
+	}
+	tree := NewAccountTree([]*Account{
+		account("Assets:Checking", quantity(100, usd)),
+		account("Assets:Savings:Emergency", quantity(50, usd), quantity(10, eur)),
+		account("Assets:Savings", quantity(25, usd)),
+		account("Expenses:Travel:Lodging"),
+		account("Equity", quantity(-175, usd), quantity(-10, eur)),
+	})
+	for _, test := range []struct {
+		depth    int
+		expected []string
+	}{
+		{0, []string{"Assets", "Assets:Checking", "Assets:Savings", "Assets:Savings:Emergency", "Equity", "Expenses", "Expenses:Travel", "Expenses:Travel:Lodging"}},
+		{1, []string{"Assets", "Equity", "Expenses"}},
+		{2, []string{"Assets", "Assets:Checking", "Assets:Savings", "Equity", "Expenses", "Expenses:Travel"}},
+	} {
+		var names []string
+		tree.Walk(test.depth, func(node *AccountTree) error {
+			names = append(names, node.Name)
+			return nil
+		})
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("walk to depth %v visited %v instead of %v", test.depth, names, test.expected)
+		}
+	}
+	if node, ok := tree.Find("Assets:Savings"); !ok {
+		t.Errorf("Find did not find Assets:Savings")
+	} else if node.Account == nil || node.ShortName() != "Savings" || node.Depth() != 2 {
+		t.Errorf("Find returned the wrong node: %v", node.Name)
+	} else if b := node.Balances(); b["USD"].String() != "75 USD" || b["EUR"].String() != "10 EUR" {
+		t.Errorf("unexpected rolled-up balances: %v", b)
+	}
+	if node, ok := tree.Find("Assets"); !ok || node.Account != nil || node.Balances()["USD"].String() != "175 USD" {
+		t.Errorf("Find returned the wrong Assets node")
+	}
+	for _, name := range []string{"", "Assets:Sav", "Assets:Savings:Emergency:Fund", "Liabilities"} {
+		if _, ok := tree.Find(name); ok {
+			t.Errorf("Find found nonexistent node %#v", name)
+		}
+	}
+}
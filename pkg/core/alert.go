@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+)
+
+// Alert is a condition on an account's balance in a commodity, such as
+// a checking account's balance dropping below a buffer.  The Alert is
+// triggered when the balance compares to the threshold as specified.
+type Alert struct {
+	Account    string
+	Comparison string // one of <, <=, >, >=, =, or !=
+	Threshold  Quantity
+}
+
+// CheckComparison returns an error if comparison is not a valid
+// Alert comparison.
+func CheckComparison(comparison string) error {
+	switch comparison {
+	case "<", "<=", ">", ">=", "=", "!=":
+		return nil
+	}
+	return fmt.Errorf("invalid comparison %#v: expected <, <=, >, >=, =, or !=", comparison)
+}
+
+// Check returns the balance of the Alert's account in the threshold's
+// commodity (the sum of all of the account's lots) and whether the Alert
+// is triggered.  Alerts on nonexistent or closed accounts are never
+// triggered.
+func (a Alert) Check(ctx *Context) (Quantity, bool) {
+	balance := Quantity{Commodity: a.Threshold.Commodity}
+	acct, ok := ctx.Account(a.Account)
+	if !ok || acct.IsClosed(ctx.Date) {
+		return balance, false
+	} else if q, ok := acct.Balances()[a.Threshold.Commodity.Name]; ok {
+		balance = q
+	}
+	return balance, compare(balance.Amount.Cmp(a.Threshold.Amount), a.Comparison)
+}
+
+// compare returns true if c, the result of comparing two values (-1, 0,
+// or 1), satisfies comparison.
+func compare(c int, comparison string) bool {
+	switch comparison {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	}
+	return false
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%v %v %v", a.Account, a.Comparison, a.Threshold)
+}
+
+// TriggeredAlerts returns messages describing the Context's triggered
+// Alerts in the order in which they were added.
+func (ctx *Context) TriggeredAlerts() []string {
+	var messages []string
+	for _, a := range ctx.Alerts {
+		if balance, ok := a.Check(ctx); ok {
+			messages = append(messages, fmt.Sprintf("%v (balance %v)", a, balance))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+// BillingRate is the rate at which a client is billed for each unit of time
+// worked on a project, such as 120 USD per hour, starting on Date.  Rates
+// with empty Projects apply to the client's projects that have no rates
+// of their own.
+type BillingRate struct {
+	Date    Date
+	Client  string
+	Project string
+	Rate    Quantity
+}
+
+// BillingRate returns the rate at which a client is billed for a project
+// on date and whether there is such a rate.  Rates set for the project
+// take precedence over rates set for all of the client's projects.
+func (ctx *Context) BillingRate(client, project string, date Date) (Quantity, bool) {
+	var fallback *BillingRate
+	for n := len(ctx.BillingRates) - 1; n >= 0; n-- {
+		r := &ctx.BillingRates[n]
+		if r.Client != client || r.Date.After(date) {
+			continue
+		} else if r.Project == project {
+			return r.Rate, true
+		} else if len(r.Project) == 0 && fallback == nil {
+			fallback = r
+		}
+	}
+	if fallback != nil {
+		return fallback.Rate, true
+	}
+	return Quantity{}, false
+}
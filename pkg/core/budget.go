@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+)
+
+// Budget is the amount by which an account's balance in a commodity
+// should change during each period, such as monthly spending on groceries.
+// Positive amounts are maximum increases and negative amounts are maximum
+// decreases.
+type Budget struct {
+	Account string
+	Period  string // one of monthly, quarterly, or yearly
+	Amount  Quantity
+
+	// First and Last are the first and last days of the current period,
+	// and Start is the account's balance in the commodity at its start.
+	First, Last Date
+	Start       decimal.Decimal
+
+	// Results are the outcomes of the ended periods, oldest first.
+	Results []BudgetResult
+}
+
+// BudgetResult is the outcome of one of a Budget's periods.
+type BudgetResult struct {
+	First, Last Date
+	Amount      Quantity // the budgeted change
+	Actual      Quantity // the actual change
+}
+
+// Exceeded returns true if the actual change is greater than the budgeted
+// change in the budgeted change's direction.
+func (r BudgetResult) Exceeded() bool {
+	if r.Amount.Amount.IsNegative() {
+		return r.Actual.Amount.LessThan(r.Amount.Amount)
+	}
+	return r.Actual.Amount.GreaterThan(r.Amount.Amount)
+}
+
+// CheckBudgetPeriod returns an error if period is not a valid Budget period.
+func CheckBudgetPeriod(period string) error {
+	switch period {
+	case "monthly", "quarterly", "yearly":
+		return nil
+	}
+	return fmt.Errorf("invalid budget period %#v: expected monthly, quarterly, or yearly", period)
+}
+
+// Quarter returns the first and last days of the fiscal quarter
+// containing d.  Fiscal quarters are the three-month periods that begin
+// on the first day of the fiscal year.
+func (c Calendar) Quarter(d Date) (first, last Date) {
+	fyFirst, fyLast := c.FiscalYear(d)
+	n := 0
+	for n < 3 && !fyFirst.AddMonths(3*(n+1)).After(d) {
+		n++
+	}
+	if n == 3 {
+		return fyFirst.AddMonths(9), fyLast
+	}
+	return fyFirst.AddMonths(3 * n), fyFirst.AddMonths(3 * (n + 1)).AddDays(-1)
+}
+
+// BudgetPeriod returns the first and last days of the Budget period
+// containing d.  Monthly periods are months, quarterly periods are fiscal
+// quarters, and yearly periods are fiscal years.
+func (c Calendar) BudgetPeriod(period string, d Date) (first, last Date) {
+	switch period {
+	case "monthly":
+		return c.Month(d)
+	case "quarterly":
+		return c.Quarter(d)
+	}
+	return c.FiscalYear(d)
+}
+
+// balance returns the balance of the Budget's account in its commodity.
+func (b *Budget) balance(ctx *Context) decimal.Decimal {
+	if a, ok := ctx.Account(b.Account); ok {
+		if q, ok := a.Balances()[b.Amount.Commodity.Name]; ok {
+			return q.Amount
+		}
+	}
+	return decimal.Zero
+}
+
+// Current returns the outcome of the Budget's current period so far.
+func (b *Budget) Current(ctx *Context) BudgetResult {
+	actual := Quantity{Commodity: b.Amount.Commodity, Amount: b.balance(ctx).Sub(b.Start)}
+	return BudgetResult{First: b.First, Last: b.Last, Amount: b.Amount, Actual: actual}
+}
+
+// SetBudget sets the budgeted change of an account's balance in
+// a commodity per period starting with the period containing ctx.Date.
+// If the account already has a budget in the commodity, its amount changes
+// and its results are kept, but its period may not change.  Otherwise,
+// the budget's first period starts with the account's current balance.
+func (ctx *Context) SetBudget(account, period string, amount Quantity) error {
+	if err := CheckBudgetPeriod(period); err != nil {
+		return err
+	}
+	for n := range ctx.Budgets {
+		b := &ctx.Budgets[n]
+		if b.Account == account && b.Amount.Commodity.Name == amount.Commodity.Name {
+			if b.Period != period {
+				return fmt.Errorf("account %v already has a %v budget in %v", account, b.Period, amount.Commodity.Name)
+			}
+			b.Amount = amount
+			return nil
+		}
+	}
+	b := Budget{Account: account, Period: period, Amount: amount}
+	b.First, b.Last = ctx.Options.Calendar.BudgetPeriod(period, ctx.Date)
+	b.Start = b.balance(ctx)
+	ctx.Budgets = append(ctx.Budgets, b)
+	return nil
+}
+
+// UpdateBudgets ends the Budgets' periods that end before ctx.Date,
+// recording their results, including those of periods without changes.
+// It must be called whenever ctx.Date changes, before any transactions
+// on the new date.
+func (ctx *Context) UpdateBudgets() {
+	for n := range ctx.Budgets {
+		b := &ctx.Budgets[n]
+		for b.Last.Before(ctx.Date) {
+			r := b.Current(ctx)
+			b.Results = append(b.Results, r)
+			b.Start = b.Start.Add(r.Actual.Amount)
+			b.First, b.Last = ctx.Options.Calendar.BudgetPeriod(b.Period, b.Last.AddDays(1))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import "sort"
+
+// BalanceCheckpoint records the balances of a Context's open accounts
+// at the end of a day, usually the last day of a month, so reports that
+// compare periods can read them instead of tracking balances while
+// parsing.  See Options.BalanceCheckpoints and AddBalanceCheckpoint.
+type BalanceCheckpoint struct {
+	Date     Date                           // such as a month's last day
+	Balances map[string]map[string]Quantity // account name -> commodity name -> balance
+}
+
+// Balance returns the named account's balance in the named commodity
+// in the BalanceCheckpoint.  It returns false if the account did not
+// hold the commodity.
+func (bc BalanceCheckpoint) Balance(accountName, commodityName string) (Quantity, bool) {
+	q, ok := bc.Balances[accountName][commodityName]
+	return q, ok
+}
+
+// UpdateBalanceCheckpoints records a BalanceCheckpoint for each month
+// that ends on or after previous, the Context's previous date, and before
+// ctx.Date if the BalanceCheckpoints option is set.  Months respect
+// Options.Calendar.  UpdateBalanceCheckpoints must be called whenever
+// ctx.Date changes, before any transactions on the new date.
+func (ctx *Context) UpdateBalanceCheckpoints(previous Date) {
+	if !ctx.Options.BalanceCheckpoints || previous.IsZero() {
+		return
+	}
+	for _, last := ctx.Options.Calendar.Month(previous); last.Before(ctx.Date); _, last = ctx.Options.Calendar.Month(last.AddDays(1)) {
+		ctx.AddBalanceCheckpoint(last)
+	}
+}
+
+// AddBalanceCheckpoint records the current balances of the accounts that
+// are open on date in a BalanceCheckpoint for date, keeping
+// BalanceCheckpoints in chronological order.  It does nothing if there
+// already is a BalanceCheckpoint for date.  Reports call it before
+// the first transactions after date to checkpoint the days before their
+// periods begin, whether or not the BalanceCheckpoints option is set.
+func (ctx *Context) AddBalanceCheckpoint(date Date) {
+	n := sort.Search(len(ctx.BalanceCheckpoints), func(n int) bool { return ctx.BalanceCheckpoints[n].Date.After(date) })
+	if n > 0 && ctx.BalanceCheckpoints[n-1].Date.Equal(date) {
+		return
+	}
+	bc := BalanceCheckpoint{Date: date, Balances: make(map[string]map[string]Quantity, len(ctx.Accounts))}
+	for an, a := range ctx.Accounts {
+		if !a.IsClosed(date) {
+			bc.Balances[an] = a.Balances()
+		}
+	}
+	ctx.BalanceCheckpoints = append(ctx.BalanceCheckpoints, BalanceCheckpoint{})
+	copy(ctx.BalanceCheckpoints[n+1:], ctx.BalanceCheckpoints[n:])
+	ctx.BalanceCheckpoints[n] = bc
+}
+
+// BalanceCheckpointOn returns the latest BalanceCheckpoint on or before
+// date.  It returns false if there is none.
+func (ctx *Context) BalanceCheckpointOn(date Date) (BalanceCheckpoint, bool) {
+	for n := len(ctx.BalanceCheckpoints) - 1; n >= 0; n-- {
+		if !ctx.BalanceCheckpoints[n].Date.After(date) {
+			return ctx.BalanceCheckpoints[n], true
+		}
+	}
+	return BalanceCheckpoint{}, false
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestContext_AddBalanceCheckpoint(t *testing.T) {
+	ctx := NewContext()
+	ctx.Date = Date{Year: 2000, Month: 1, Day: 1}
+	usd := NewCommodity("USD", "Dollar", ctx.Date)
+	checking, old := NewAccount("Assets:Checking", ctx.Date), NewAccount("Assets:Old", ctx.Date)
+	old.ClosingDate = Date{Year: 2000, Month: 1, Day: 10}
+	ctx.Accounts[checking.Name], ctx.Accounts[old.Name] = checking, old
+	checking.Lots[""]["USD"] = &Lot{CreationDate: ctx.Date, Balance: Quantity{Commodity: usd, Amount: decimal.NewFromInt(100)}}
+
+	ctx.AddBalanceCheckpoint(Date{Year: 2000, Month: 1, Day: 31})
+	ctx.AddBalanceCheckpoint(Date{Year: 2000, Month: 1, Day: 5})
+	checking.Lots[""]["USD"].Balance.Amount = decimal.NewFromInt(50)
+	ctx.AddBalanceCheckpoint(Date{Year: 2000, Month: 1, Day: 31}) // already checkpointed
+	ctx.AddBalanceCheckpoint(Date{Year: 2000, Month: 1, Day: 14})
+	var days []int
+	for _, bc := range ctx.BalanceCheckpoints {
+		days = append(days, bc.Date.Day)
+	}
+	if len(days) != 3 || days[0] != 5 || days[1] != 14 || days[2] != 31 {
+		t.Fatalf("checkpoints are for days %v instead of [5 14 31]", days)
+	}
+	if q, ok := ctx.BalanceCheckpoints[2].Balance("Assets:Checking", "USD"); !ok || q.String() != "100 USD" {
+		t.Errorf("AddBalanceCheckpoint replaced a checkpoint: %v", q)
+	} else if q, ok = ctx.BalanceCheckpoints[1].Balance("Assets:Checking", "USD"); !ok || q.String() != "50 USD" {
+		t.Errorf("unexpected balance: %v", q)
+	} else if _, ok = ctx.BalanceCheckpoints[0].Balances["Assets:Old"]; !ok {
+		t.Errorf("the checkpoint before Assets:Old closed omits it")
+	} else if _, ok = ctx.BalanceCheckpoints[1].Balances["Assets:Old"]; ok {
+		t.Errorf("the checkpoint after Assets:Old closed includes it")
+	}
+}
@@ -26,36 +26,197 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 package core
 
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
 type Commodity struct {
 	Name         string
 	Description  string
 	CreationDate Date
-	Tags         map[string]bool
+	Tags         map[string]string // keys -> values; see TagTarget
+	Notes        map[string]string
+
+	// DecimalPlaces is the maximum number of decimal places in transferred
+	// amounts of the commodity or -1 if there is no maximum.
+	DecimalPlaces int32
+
+	// Rounding is the rounding mode that applies to transferred amounts
+	// with more than DecimalPlaces decimal places.
+	Rounding string
+
+	// RoundingTolerance is the largest amount of the commodity by which
+	// a transaction's transfers may fail to balance before a rounding
+	// transfer to Options.RoundingAccount balances them.  Zero disables
+	// rounding transfers.
+	RoundingTolerance decimal.Decimal
+
+	// SmallestUnit is the smallest transferable amount of the commodity,
+	// such as 0.05 for a currency whose smallest coin is worth five cents.
+	// Transferred amounts must be multiples of it.  Zero disables
+	// the check.
+	SmallestUnit decimal.Decimal
+
+	// Display determines how reports format amounts of the commodity.
+	Display DisplayFormat
+}
+
+// DisplayFormat determines how amounts of a commodity are formatted
+// for display.  The zero DisplayFormat formats amounts as plain decimals
+// followed by the commodity's name.
+type DisplayFormat struct {
+	// Prefix and Suffix are symbols that precede and follow amounts,
+	// such as "$" or "¥".  If both are empty, amounts are followed by
+	// a space and the commodity's name.
+	Prefix, Suffix string
+
+	// ThousandsSeparator separates groups of three digits in
+	// the integer parts of amounts, such as ",".
+	ThousandsSeparator string
+
+	// FixedDecimalPlaces makes amounts rounded or padded to exactly
+	// DecimalPlaces decimal places.  Otherwise, amounts are padded to
+	// the commodity's maximum number of decimal places but not rounded.
+	FixedDecimalPlaces bool
+	DecimalPlaces      int32
+}
+
+// Rounding modes
+const (
+	RejectRounding   = "reject"    // amounts with too many decimal places are errors
+	HalfUpRounding   = "half-up"   // round halves away from zero
+	HalfEvenRounding = "half-even" // round halves to even digits
+	TruncateRounding = "truncate"  // round toward zero
+)
+
+// CheckRoundingMode returns an error if mode is not a rounding mode.
+func CheckRoundingMode(mode string) error {
+	switch mode {
+	case RejectRounding, HalfUpRounding, HalfEvenRounding, TruncateRounding:
+		return nil
+	}
+	return fmt.Errorf("invalid rounding mode %#v: expected reject, half-up, half-even, or truncate", mode)
 }
 
 func NewCommodity(name, description string, creationDate Date) *Commodity {
-	return &Commodity{Name: name, Description: description, CreationDate: creationDate, Tags: make(map[string]bool)}
+	return &Commodity{Name: name, Description: description, CreationDate: creationDate, Tags: make(map[string]string), Notes: make(map[string]string), DecimalPlaces: -1, Rounding: RejectRounding}
+}
+
+// CheckDecimalPlaces returns an error if amount has more decimal places
+// than the Commodity allows.  Trailing zeros are ignored.
+func (c *Commodity) CheckDecimalPlaces(amount decimal.Decimal) error {
+	if c.DecimalPlaces >= 0 && !amount.Equal(amount.Round(c.DecimalPlaces)) {
+		return fmt.Errorf("%v %v has more than %v decimal places", amount, c.Name, c.DecimalPlaces)
+	}
+	return nil
+}
+
+// CheckSmallestUnit returns an error if amount is not a multiple of
+// the Commodity's smallest unit.
+func (c *Commodity) CheckSmallestUnit(amount decimal.Decimal) error {
+	if !c.SmallestUnit.IsZero() && !amount.Mod(c.SmallestUnit).IsZero() {
+		return fmt.Errorf("%v %v is not a multiple of the smallest unit, %v %v", amount, c.Name, c.SmallestUnit, c.Name)
+	}
+	return nil
+}
+
+// ApplyDecimalPlaces returns amount rounded to the Commodity's decimal
+// places according to its rounding mode.  It returns an error if amount
+// has too many decimal places and the rounding mode is RejectRounding.
+func (c *Commodity) ApplyDecimalPlaces(amount decimal.Decimal) (decimal.Decimal, error) {
+	if c.DecimalPlaces < 0 {
+		return amount, nil
+	}
+	switch c.Rounding {
+	case HalfUpRounding:
+		return amount.Round(c.DecimalPlaces), nil
+	case HalfEvenRounding:
+		return amount.RoundBank(c.DecimalPlaces), nil
+	case TruncateRounding:
+		return amount.Truncate(c.DecimalPlaces), nil
+	}
+	return amount, c.CheckDecimalPlaces(amount)
+}
+
+// FormatAmount formats an amount of the Commodity according to its
+// DisplayFormat without its symbols or name.  If the DisplayFormat does
+// not fix the number of decimal places and the Commodity has a maximum
+// number of decimal places, amounts with fewer decimal places are padded
+// with zeros, but amounts with more are not rounded.
+func (c *Commodity) FormatAmount(amount decimal.Decimal) string {
+	if c == nil {
+		return amount.String()
+	}
+	s := amount.String()
+	if c.Display.FixedDecimalPlaces {
+		s = amount.StringFixed(c.Display.DecimalPlaces)
+	} else if c.DecimalPlaces > 0 {
+		if n := strings.IndexByte(s, '.'); n < 0 || int32(len(s)-n-1) < c.DecimalPlaces {
+			s = amount.StringFixed(c.DecimalPlaces)
+		}
+	}
+	if sep := c.Display.ThousandsSeparator; len(sep) != 0 {
+		sign := ""
+		if strings.HasPrefix(s, "-") {
+			sign, s = "-", s[1:]
+		}
+		integer, fraction := s, ""
+		if n := strings.IndexByte(s, '.'); n >= 0 {
+			integer, fraction = s[:n], s[n:]
+		}
+		var b strings.Builder
+		for n, d := range integer {
+			if n != 0 && (len(integer)-n)%3 == 0 {
+				b.WriteString(sep)
+			}
+			b.WriteRune(d)
+		}
+		s = sign + b.String() + fraction
+	}
+	return s
+}
+
+// clone returns a copy of the Commodity with its own tag and note maps.
+func (c *Commodity) clone() *Commodity {
+	cc := *c
+	cc.Tags = make(map[string]string, len(c.Tags))
+	for key, value := range c.Tags {
+		cc.Tags[key] = value
+	}
+	cc.Notes = make(map[string]string, len(c.Notes))
+	for nn, nv := range c.Notes {
+		cc.Notes[nn] = nv
+	}
+	return &cc
 }
 
 func (c *Commodity) AddTag(tag string) {
-	c.Tags[tag] = true
+	key, value, _ := SplitTag(tag)
+	c.Tags[key] = value
 }
 
 func (c *Commodity) GetTags() []string {
-	tags := make([]string, len(c.Tags))[:0]
-	for tag, _ := range c.Tags {
-		tags = append(tags, tag)
-	}
-	return tags
+	return tagList(c.Tags)
 }
 
 func (c *Commodity) HasTag(tag string) bool {
-	_, ok := c.Tags[tag]
-	return ok
+	return hasTag(c.Tags, tag)
 }
 
+// RemoveTag removes the tag if the Commodity has it.  Tags without values
+// remove their keys regardless of their values.
 func (c *Commodity) RemoveTag(tag string) {
-	delete(c.Tags, tag)
+	if c.HasTag(tag) {
+		key, _, _ := SplitTag(tag)
+		delete(c.Tags, key)
+	}
+}
+
+func (c *Commodity) TagValue(key string) (string, bool) {
+	value, ok := c.Tags[key]
+	return value, ok
 }
 
 func (c Commodity) String() string {
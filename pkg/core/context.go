@@ -26,13 +26,552 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 package core
 
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+// Context is the state of a ledger as of its current date.
+//
+// Contexts are not safe for concurrent use while they change.  Parsing
+// changes them through Functions, as do Context.Record, Convert,
+// RenameAccount, UpdateBudgets, and UpdateBalanceCheckpoints; PriceDB.Add;
+// and Account methods such as AddTag and RemoveTag.  The other methods,
+// such as Account, AccountHistory, Tagged, and BalanceCheckpointOn, only
+// read the Context, so any number of goroutines may call them once
+// nothing changes it, such as after parsing finishes.  See View for
+// Contexts that change while goroutines query them.
 type Context struct {
 	Date        Date
 	Accounts    map[string]*Account
 	Commodities map[string]*Commodity
-	Tags        map[string][]TagTarget
+	Tags        map[string][]TagTarget // tag keys -> tagged accounts and commodities
+	Prices      *PriceDB
+	Alerts      []Alert
+	Variables   map[string]string
+
+	// Book is the name of the active book, which contains the accounts
+	// that are opened, or empty if the default book is active.  Books
+	// partition a ledger's accounts, such as into personal and business
+	// accounts, while transactions may transfer between books.
+	Book string
+
+	// ClosedAccounts are closed accounts that were replaced in Accounts
+	// by reopening them, oldest first.  They keep their tags.
+	ClosedAccounts []*Account
+
+	// AccountAliases maps the old names of renamed accounts
+	// to their new names.
+	AccountAliases map[string]string
+
+	// Entities maps the names and aliases of declared entities, such as
+	// payees, to their canonical names.  See Context.CanonicalEntity.
+	Entities map[string]string
+
+	// Conversions maps commodity names to the net quantities of
+	// the commodities that entered accounts without transfers of
+	// the same commodities out of other accounts, such as through
+	// exchanges and stock splits.
+	Conversions map[string]decimal.Decimal
+
+	// Budgets are the accounts' budgets in the order in which
+	// they were set.
+	Budgets []Budget
+
+	// BalanceCheckpoints are the accounts' balances at the ends of
+	// months in chronological order if Options.BalanceCheckpoints is set.
+	BalanceCheckpoints []BalanceCheckpoint
+
+	// Events are the recorded events in chronological order.
+	Events []Event
+
+	// RealizedGains are the gains and losses realized by reducing lots
+	// in the order in which they were realized.
+	RealizedGains []RealizedGain
+
+	// BillingRates are the clients' billing rates in the order in which
+	// they were set.
+	BillingRates []BillingRate
+
+	// Journal holds the executed transactions in the order in which
+	// they were executed if Options.KeepJournal is set.
+	Journal []JournalEntry
+
+	// NoteTypes maps the names of transaction notes to the types of
+	// their values (see CheckNoteType).  Transactions' notes must have
+	// values of their declared types.
+	NoteTypes map[string]string
+
+	// Warnings describe questionable input that was accepted anyway,
+	// such as transactions balanced by rounding transfers, in the order
+	// in which they were issued.
+	Warnings []string
+
+	Options Options
 }
 
 func NewContext() *Context {
-	return &Context{Accounts: make(map[string]*Account), Commodities: make(map[string]*Commodity), Tags: make(map[string][]TagTarget)}
+	return &Context{Accounts: make(map[string]*Account), Commodities: make(map[string]*Commodity), Tags: make(map[string][]TagTarget), Prices: NewPriceDB(), Variables: make(map[string]string), AccountAliases: make(map[string]string), Entities: make(map[string]string), Conversions: make(map[string]decimal.Decimal), NoteTypes: make(map[string]string), Options: NewOptions()}
+}
+
+// Clone returns a deep copy of the Context.  The copy shares no mutable state
+// with the original, so parsing more input into the copy (for example,
+// a what-if scenario) leaves the original untouched.
+func (ctx *Context) Clone() *Context {
+	c := NewContext()
+	c.Date = ctx.Date
+	c.Book = ctx.Book
+	for cn, com := range ctx.Commodities {
+		c.Commodities[cn] = com.clone()
+	}
+	remap := func(com *Commodity) *Commodity {
+		if com == nil {
+			return nil
+		} else if cc, ok := c.Commodities[com.Name]; ok && ctx.Commodities[com.Name] == com {
+			return cc
+		}
+		return com.clone()
+	}
+	accounts := make(map[*Account]*Account, len(ctx.Accounts)+len(ctx.ClosedAccounts))
+	cloneAccount := func(a *Account) *Account {
+		ca := &Account{
+			Name:         a.Name,
+			CreationDate: a.CreationDate,
+			ClosingDate:  a.ClosingDate,
+			Book:         a.Book,
+			Commodities:  make(map[string]*Commodity, len(a.Commodities)),
+			Lots:         make(map[string]map[string]*Lot, len(a.Lots)),
+			Tags:         make(map[string]string, len(a.Tags)),
+			Notes:        make(map[string]string, len(a.Notes))}
+		for cn, com := range a.Commodities {
+			ca.Commodities[cn] = remap(com)
+		}
+		for ln, ctol := range a.Lots {
+			lots := make(map[string]*Lot, len(ctol))
+			for cn, l := range ctol {
+				cl := *l
+				cl.Balance.Commodity = remap(l.Balance.Commodity)
+				if l.ExchangeRate != nil {
+					er := *l.ExchangeRate
+					er.UnitPrice.Commodity = remap(er.UnitPrice.Commodity)
+					er.TotalPrice.Commodity = remap(er.TotalPrice.Commodity)
+					cl.ExchangeRate = &er
+				}
+				if l.Notes != nil {
+					cl.Notes = make(map[string]string, len(l.Notes))
+					for nn, nv := range l.Notes {
+						cl.Notes[nn] = nv
+					}
+				}
+				lots[cn] = &cl
+			}
+			ca.Lots[ln] = lots
+		}
+		for key, value := range a.Tags {
+			ca.Tags[key] = value
+		}
+		for nn, nv := range a.Notes {
+			ca.Notes[nn] = nv
+		}
+		accounts[a] = ca
+		return ca
+	}
+	for an, a := range ctx.Accounts {
+		c.Accounts[an] = cloneAccount(a)
+	}
+	for _, a := range ctx.ClosedAccounts {
+		c.ClosedAccounts = append(c.ClosedAccounts, cloneAccount(a))
+	}
+	for tag, tts := range ctx.Tags {
+		ctts := make([]TagTarget, len(tts))[:0]
+		for _, tt := range tts {
+			switch v := tt.(type) {
+			case *Account:
+				if ca, ok := accounts[v]; ok {
+					ctts = append(ctts, ca)
+				}
+			case *Commodity:
+				ctts = append(ctts, remap(v))
+			default:
+				ctts = append(ctts, tt)
+			}
+		}
+		c.Tags[tag] = ctts
+	}
+	for cn, prices := range ctx.Prices.prices {
+		cprices := make([]Price, len(prices))
+		for n, p := range prices {
+			p.Commodity = remap(p.Commodity)
+			p.Price.Commodity = remap(p.Price.Commodity)
+			cprices[n] = p
+		}
+		c.Prices.prices[cn] = cprices
+	}
+	for _, a := range ctx.Alerts {
+		a.Threshold.Commodity = remap(a.Threshold.Commodity)
+		c.Alerts = append(c.Alerts, a)
+	}
+	for name, value := range ctx.Variables {
+		c.Variables[name] = value
+	}
+	for old, name := range ctx.AccountAliases {
+		c.AccountAliases[old] = name
+	}
+	for alias, name := range ctx.Entities {
+		c.Entities[alias] = name
+	}
+	for cn, q := range ctx.Conversions {
+		c.Conversions[cn] = q
+	}
+	for _, b := range ctx.Budgets {
+		b.Amount.Commodity = remap(b.Amount.Commodity)
+		results := make([]BudgetResult, len(b.Results))
+		for n, r := range b.Results {
+			r.Amount.Commodity = remap(r.Amount.Commodity)
+			r.Actual.Commodity = remap(r.Actual.Commodity)
+			results[n] = r
+		}
+		b.Results = results
+		c.Budgets = append(c.Budgets, b)
+	}
+	for _, bc := range ctx.BalanceCheckpoints {
+		balances := make(map[string]map[string]Quantity, len(bc.Balances))
+		for an, qs := range bc.Balances {
+			balances[an] = make(map[string]Quantity, len(qs))
+			for cn, q := range qs {
+				q.Commodity = remap(q.Commodity)
+				balances[an][cn] = q
+			}
+		}
+		c.BalanceCheckpoints = append(c.BalanceCheckpoints, BalanceCheckpoint{Date: bc.Date, Balances: balances})
+	}
+	c.Events = append([]Event(nil), ctx.Events...)
+	c.Warnings = append([]string(nil), ctx.Warnings...)
+	for nn, typ := range ctx.NoteTypes {
+		c.NoteTypes[nn] = typ
+	}
+	for _, g := range ctx.RealizedGains {
+		g.Quantity.Commodity = remap(g.Quantity.Commodity)
+		g.Proceeds.Commodity = remap(g.Proceeds.Commodity)
+		g.CostBasis.Commodity = remap(g.CostBasis.Commodity)
+		c.RealizedGains = append(c.RealizedGains, g)
+	}
+	for _, r := range ctx.BillingRates {
+		r.Rate.Commodity = remap(r.Rate.Commodity)
+		c.BillingRates = append(c.BillingRates, r)
+	}
+	for _, e := range ctx.Journal {
+		postings := make([]Posting, len(e.Postings))
+		for n, p := range e.Postings {
+			p.Quantity.Commodity = remap(p.Quantity.Commodity)
+			if p.ExchangeRate != nil {
+				er := *p.ExchangeRate
+				er.UnitPrice.Commodity = remap(er.UnitPrice.Commodity)
+				er.TotalPrice.Commodity = remap(er.TotalPrice.Commodity)
+				p.ExchangeRate = &er
+			}
+			postings[n] = p
+		}
+		e.Postings = postings
+		notes := make(map[string]string, len(e.Notes))
+		for nn, nv := range e.Notes {
+			notes[nn] = nv
+		}
+		e.Notes = notes
+		tags := make(map[string]string, len(e.Tags))
+		for key, value := range e.Tags {
+			tags[key] = value
+		}
+		e.Tags = tags
+		c.Journal = append(c.Journal, e)
+	}
+	c.Options = ctx.Options
+	c.Options.AccountRoots = append([]string(nil), ctx.Options.AccountRoots...)
+	return c
+}
+
+// Convert records the conversion of a quantity of one commodity into
+// a quantity of another, such as an exchange.  The commodities may be
+// the same, as in a stock split.
+func (ctx *Context) Convert(from, to Quantity) {
+	ctx.Conversions[from.Commodity.Name] = ctx.Conversions[from.Commodity.Name].Sub(from.Amount)
+	ctx.Conversions[to.Commodity.Name] = ctx.Conversions[to.Commodity.Name].Add(to.Amount)
+}
+
+// CheckEquation checks the accounting equation: the balances of all
+// accounts' lots of each commodity, less the commodity's conversions,
+// must sum to zero.
+func (ctx *Context) CheckEquation() error {
+	sums := map[string]decimal.Decimal{}
+	for cn, q := range ctx.Conversions {
+		sums[cn] = q.Neg()
+	}
+	for _, a := range ctx.Accounts {
+		for _, ctol := range a.Lots {
+			for cn, l := range ctol {
+				sums[cn] = sums[cn].Add(l.Balance.Amount)
+			}
+		}
+	}
+	var imbalances []string
+	for cn, sum := range sums {
+		if !sum.IsZero() {
+			imbalances = append(imbalances, fmt.Sprintf("%v %v", sum, cn))
+		}
+	}
+	if len(imbalances) != 0 {
+		sort.Strings(imbalances)
+		return fmt.Errorf("accounts sum to %v, not zero", strings.Join(imbalances, ", "))
+	}
+	return nil
+}
+
+// Account returns the named account.  If there is no such account
+// but the name is an alias of a renamed account, Account returns
+// the renamed account.  See Name.
+func (ctx *Context) Account(name string) (*Account, bool) {
+	name = ctx.Name(name)
+	if a, ok := ctx.Accounts[name]; ok {
+		return a, true
+	} else if newName, ok := ctx.AccountAliases[name]; ok {
+		a, ok := ctx.Accounts[newName]
+		return a, ok
+	}
+	return nil, false
+}
+
+// Commodity returns the named commodity.  See Name.
+func (ctx *Context) Commodity(name string) (*Commodity, bool) {
+	c, ok := ctx.Commodities[ctx.Name(name)]
+	return c, ok
+}
+
+// AccountPeriod is an interval during which an account was open.
+// ClosingDate is zero if the account is still open.
+type AccountPeriod struct {
+	OpeningDate Date
+	ClosingDate Date
+}
+
+// AccountHistory returns the named account and the closed accounts that
+// it replaced by reopening them, oldest first.  The replaced accounts
+// keep their lots, balances, tags, and notes as of their closing dates.
+// AccountHistory returns nil if there is no such account.
+func (ctx *Context) AccountHistory(name string) []*Account {
+	name = ctx.Name(name)
+	var history []*Account
+	for _, a := range ctx.ClosedAccounts {
+		if a.Name == name {
+			history = append(history, a)
+		}
+	}
+	if a, ok := ctx.Accounts[name]; ok {
+		history = append(history, a)
+	}
+	return history
+}
+
+// AccountPeriods returns the intervals during which the named account
+// was open, oldest first.
+func (ctx *Context) AccountPeriods(name string) []AccountPeriod {
+	history := ctx.AccountHistory(name)
+	periods := make([]AccountPeriod, len(history))
+	for n, a := range history {
+		periods[n] = AccountPeriod{OpeningDate: a.CreationDate, ClosingDate: a.ClosingDate}
+	}
+	return periods
+}
+
+// AccountOn returns the named account as it was open on date, which
+// may be a closed account that reopening the account replaced.  It
+// returns false if the account was not open on date.
+func (ctx *Context) AccountOn(name string, date Date) (*Account, bool) {
+	for _, a := range ctx.AccountHistory(name) {
+		if !date.Before(a.CreationDate) && !a.IsClosed(date) {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// CanonicalEntity returns the canonical name of the entity with the
+// specified name or alias.  If no entities are declared, every name is
+// canonical.  CanonicalEntity returns false if entities are declared but
+// the name is not one of them or their aliases.
+func (ctx *Context) CanonicalEntity(name string) (string, bool) {
+	if len(ctx.Entities) == 0 {
+		return name, true
+	} else if canonical, ok := ctx.Entities[name]; ok {
+		return canonical, true
+	}
+	return name, false
+}
+
+// Ancestors returns the accounts whose names, followed by colons, begin
+// a's name, nearest first.  For example, Expenses:Travel is an ancestor of
+// Expenses:Travel:Lodging.  Missing ancestors are skipped.
+func (ctx *Context) Ancestors(a *Account) []*Account {
+	var ancestors []*Account
+	for name := a.Name; ; {
+		n := strings.LastIndex(name, ":")
+		if n < 0 {
+			return ancestors
+		}
+		name = name[:n]
+		if p, ok := ctx.Accounts[name]; ok {
+			ancestors = append(ancestors, p)
+		}
+	}
+}
+
+// Books returns the names of the books that contain accounts, including
+// closed accounts, sorted.  The default book's name is empty.
+func (ctx *Context) Books() []string {
+	set := map[string]bool{}
+	for _, a := range ctx.Accounts {
+		set[a.Book] = true
+	}
+	for _, a := range ctx.ClosedAccounts {
+		set[a.Book] = true
+	}
+	books := make([]string, len(set))[:0]
+	for b := range set {
+		books = append(books, b)
+	}
+	sort.Strings(books)
+	return books
+}
+
+// AccountTags returns a's tags, sorted.  If the AccountInheritance option
+// is set, they include the tags of a's ancestors, and nearer accounts'
+// tags override farther ones' tags with the same keys.
+func (ctx *Context) AccountTags(a *Account) []string {
+	if !ctx.Options.AccountInheritance {
+		tags := a.GetTags()
+		sort.Strings(tags)
+		return tags
+	}
+	set := map[string]string{}
+	accounts := append([]*Account{a}, ctx.Ancestors(a)...)
+	for n := len(accounts) - 1; n >= 0; n-- {
+		for key, value := range accounts[n].Tags {
+			set[key] = value
+		}
+	}
+	tags := tagList(set)
+	sort.Strings(tags)
+	return tags
+}
+
+// AccountTagValue returns the value of a's tag with the specified key
+// and whether a has it.  If the AccountInheritance option is set and a
+// does not have the key, the nearest ancestor with the key supplies it.
+func (ctx *Context) AccountTagValue(a *Account, key string) (string, bool) {
+	if value, ok := a.Tags[key]; ok || !ctx.Options.AccountInheritance {
+		return value, ok
+	}
+	for _, p := range ctx.Ancestors(a) {
+		if value, ok := p.Tags[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// AccountHasTag returns true if a has the tag or, if the AccountInheritance
+// option is set, inherits it (see AccountTagValue).
+func (ctx *Context) AccountHasTag(a *Account, tag string) bool {
+	key, value, hasValue := SplitTag(tag)
+	v, ok := ctx.AccountTagValue(a, key)
+	return ok && (!hasValue || v == value)
+}
+
+// Tagged returns the accounts and commodities in Tags that have the tag.
+// A tag without a value, such as "trip", matches all of its key's values,
+// and a key=value tag, such as "trip=japan2024", matches only the value.
+// Inherited tags do not count.
+func (ctx *Context) Tagged(tag string) []TagTarget {
+	key, _, _ := SplitTag(tag)
+	var tagged []TagTarget
+	for _, tt := range ctx.Tags[key] {
+		if tt.HasTag(tag) {
+			tagged = append(tagged, tt)
+		}
+	}
+	return tagged
+}
+
+// AccountNotes returns a's notes.  If the AccountInheritance option is set,
+// they include the notes of a's ancestors, and nearer accounts' notes
+// override farther ones' notes with the same names.  The returned map
+// must not be modified.
+func (ctx *Context) AccountNotes(a *Account) map[string]string {
+	if !ctx.Options.AccountInheritance {
+		return a.Notes
+	}
+	ancestors := ctx.Ancestors(a)
+	notes := map[string]string{}
+	for n := len(ancestors) - 1; n >= 0; n-- {
+		for nn, nv := range ancestors[n].Notes {
+			notes[nn] = nv
+		}
+	}
+	for nn, nv := range a.Notes {
+		notes[nn] = nv
+	}
+	return notes
+}
+
+// RenameAccount renames an account.  Tags follow the account, and
+// alerts, budgets, balance checkpoints, and journal postings on the
+// account are updated.  If alias is true, the old name
+// becomes an alias of the new name; otherwise, the old name no longer
+// refers to the account.  Aliases of the account's old name always
+// follow the account.
+func (ctx *Context) RenameAccount(oldName, newName string, alias bool) error {
+	a, ok := ctx.Account(oldName)
+	if !ok {
+		return fmt.Errorf("nonexistent account: %v", oldName)
+	} else if b, ok := ctx.Account(newName); ok && (b != a || ctx.Accounts[newName] == a) {
+		return fmt.Errorf("account already exists: %v", newName)
+	}
+	oldName = a.Name
+	delete(ctx.Accounts, oldName)
+	delete(ctx.AccountAliases, newName)
+	a.Name = newName
+	ctx.Accounts[newName] = a
+	for old, name := range ctx.AccountAliases {
+		if name == oldName {
+			ctx.AccountAliases[old] = newName
+		}
+	}
+	if alias {
+		ctx.AccountAliases[oldName] = newName
+	}
+	for n := range ctx.Alerts {
+		if ctx.Alerts[n].Account == oldName {
+			ctx.Alerts[n].Account = newName
+		}
+	}
+	for n := range ctx.Budgets {
+		if ctx.Budgets[n].Account == oldName {
+			ctx.Budgets[n].Account = newName
+		}
+	}
+	for _, bc := range ctx.BalanceCheckpoints {
+		if qs, ok := bc.Balances[oldName]; ok {
+			delete(bc.Balances, oldName)
+			bc.Balances[newName] = qs
+		}
+	}
+	for i := range ctx.Journal {
+		for j := range ctx.Journal[i].Postings {
+			if ctx.Journal[i].Postings[j].Account == oldName {
+				ctx.Journal[i].Postings[j].Account = newName
+			}
+		}
+	}
+	return nil
 }
@@ -37,6 +37,21 @@ type Date struct {
 	Day   int
 }
 
+// NewDate returns the specified Date.  It returns an error if the Date is
+// not on the Gregorian calendar, such as February 29 in a year that is not
+// a leap year, or if its year is not between 1 and 9999.
+func NewDate(year, month, day int) (Date, error) {
+	d := Date{Year: year, Month: month, Day: day}
+	if year < 1 || year > 9999 {
+		return Date{}, fmt.Errorf("invalid date %v: year is not between 1 and 9999", d)
+	} else if month < 1 || month > 12 {
+		return Date{}, fmt.Errorf("invalid date %v: month is not between 1 and 12", d)
+	} else if day < 1 || FromTime(d.ToTime()) != d {
+		return Date{}, fmt.Errorf("invalid date %v: %v has no day %v", d, time.Month(month), day)
+	}
+	return d, nil
+}
+
 func FromTime(t time.Time) Date {
 	return Date{t.Year(), int(t.Month()), t.Day()}
 }
@@ -75,6 +90,129 @@ func (d Date) EqualOrAfter(u Date) bool {
 
 func (d Date) IsZero() bool { return d.Equal(Date{}) }
 
+// Format formats the Date using a Go time layout, such as "02.01.2006".
+func (d Date) Format(layout string) string {
+	return d.ToTime().Format(layout)
+}
+
 func (d Date) String() string {
 	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
 }
+
+// Weekday returns the day of the week of the Date.
+func (d Date) Weekday() time.Weekday {
+	return d.ToTime().Weekday()
+}
+
+// ISOWeek returns the ISO 8601 year and week number of the Date.
+// Weeks start on Monday, and week 1 of a year contains its first Thursday.
+func (d Date) ISOWeek() (year, week int) {
+	return d.ToTime().ISOWeek()
+}
+
+// AddDays returns the Date n days after d.  n may be negative.
+func (d Date) AddDays(n int) Date {
+	return FromTime(d.ToTime().AddDate(0, 0, n))
+}
+
+// AddMonths returns the Date n months after d.  n may be negative.
+// Days past the end of the resulting month become its last day, so
+// adding one month to January 31 yields the last day of February.
+func (d Date) AddMonths(n int) Date {
+	first := time.Date(d.Year, time.Month(d.Month), 1, 0, 0, 0, 0, time.UTC).AddDate(0, n, 0)
+	r := FromTime(first)
+	if last := r.EndOfMonth(); d.Day > last.Day {
+		return last
+	}
+	r.Day = d.Day
+	return r
+}
+
+// AddYears returns the Date n years after d.  n may be negative.
+// February 29 becomes February 28 in years that are not leap years.
+func (d Date) AddYears(n int) Date {
+	return d.AddMonths(12 * n)
+}
+
+// StartOfMonth returns the first day of the calendar month containing d.
+func (d Date) StartOfMonth() Date {
+	return Date{d.Year, d.Month, 1}
+}
+
+// EndOfMonth returns the last day of the calendar month containing d.
+func (d Date) EndOfMonth() Date {
+	return FromTime(time.Date(d.Year, time.Month(d.Month)+1, 0, 0, 0, 0, 0, time.UTC))
+}
+
+// StartOfQuarter returns the first day of the calendar quarter
+// containing d.  See Calendar.Quarter for fiscal quarters.
+func (d Date) StartOfQuarter() Date {
+	return Date{d.Year, d.Month - (d.Month-1)%3, 1}
+}
+
+// EndOfQuarter returns the last day of the calendar quarter containing d.
+func (d Date) EndOfQuarter() Date {
+	return d.StartOfQuarter().AddMonths(3).AddDays(-1)
+}
+
+// StartOfYear returns the first day of the calendar year containing d.
+func (d Date) StartOfYear() Date {
+	return Date{d.Year, 1, 1}
+}
+
+// EndOfYear returns the last day of the calendar year containing d.
+func (d Date) EndOfYear() Date {
+	return Date{d.Year, 12, 31}
+}
+
+// DaysBetween returns the number of days from one Date to another,
+// which is negative if to is before from.
+func DaysBetween(from, to Date) int {
+	return int((to.ToTime().Unix() - from.ToTime().Unix()) / (24 * 60 * 60))
+}
+
+// FiscalYearStart is the month and day on which fiscal years begin.
+// Fiscal years are named for the calendar years in which they end.
+type FiscalYearStart struct {
+	Month int
+	Day   int
+}
+
+// CalendarYear is the FiscalYearStart for fiscal years that match
+// calendar years.
+var CalendarYear = FiscalYearStart{Month: 1, Day: 1}
+
+// ParseFiscalYearStart parses a FiscalYearStart formatted "MM-DD".
+// February 29 is not allowed.
+func ParseFiscalYearStart(s string) (FiscalYearStart, error) {
+	t, err := time.Parse("2006-01-02", "2001-"+s)
+	if err != nil {
+		return FiscalYearStart{}, fmt.Errorf("invalid fiscal year start %v: expected MM-DD", s)
+	}
+	return FiscalYearStart{Month: int(t.Month()), Day: t.Day()}, nil
+}
+
+// FiscalYear returns the fiscal year containing d.
+func (f FiscalYearStart) FiscalYear(d Date) int {
+	if f == CalendarYear || d.Month < f.Month || (d.Month == f.Month && d.Day < f.Day) {
+		return d.Year
+	}
+	return d.Year + 1
+}
+
+// FirstDay returns the first day of the specified fiscal year.
+func (f FiscalYearStart) FirstDay(fiscalYear int) Date {
+	if f == CalendarYear {
+		return Date{fiscalYear, 1, 1}
+	}
+	return Date{fiscalYear - 1, f.Month, f.Day}
+}
+
+// LastDay returns the last day of the specified fiscal year.
+func (f FiscalYearStart) LastDay(fiscalYear int) Date {
+	return f.FirstDay(fiscalYear + 1).AddDays(-1)
+}
+
+func (f FiscalYearStart) String() string {
+	return fmt.Sprintf("%02d-%02d", f.Month, f.Day)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateArithmetic(t *testing.T) {
+	date := func(y, m, d int) Date { return Date{Year: y, Month: m, Day: d} }
+	for _, test := range []struct {
+		name             string
+		actual, expected Date
+	}{
+		{"AddDays", date(2000, 2, 28).AddDays(1), date(2000, 2, 29)},
+		{"AddDays negative", date(2000, 3, 1).AddDays(-1), date(2000, 2, 29)},
+		{"AddMonths", date(2000, 1, 15).AddMonths(13), date(2001, 2, 15)},
+		{"AddMonths clamped", date(2000, 1, 31).AddMonths(1), date(2000, 2, 29)},
+		{"AddMonths negative", date(2000, 3, 31).AddMonths(-1), date(2000, 2, 29)},
+		{"AddYears leap day", date(2000, 2, 29).AddYears(1), date(2001, 2, 28)},
+		{"StartOfMonth", date(2000, 2, 15).StartOfMonth(), date(2000, 2, 1)},
+		{"EndOfMonth", date(2000, 2, 15).EndOfMonth(), date(2000, 2, 29)},
+		{"EndOfMonth non-leap", date(2001, 2, 1).EndOfMonth(), date(2001, 2, 28)},
+		{"StartOfQuarter", date(2000, 6, 30).StartOfQuarter(), date(2000, 4, 1)},
+		{"EndOfQuarter", date(2000, 10, 1).EndOfQuarter(), date(2000, 12, 31)},
+		{"StartOfYear", date(2000, 6, 30).StartOfYear(), date(2000, 1, 1)},
+		{"EndOfYear", date(2000, 6, 30).EndOfYear(), date(2000, 12, 31)},
+	} {
+		if test.actual != test.expected {
+			t.Errorf("%v returned %v instead of %v", test.name, test.actual, test.expected)
+		}
+	}
+	if n := DaysBetween(date(2000, 1, 1), date(2001, 1, 1)); n != 366 {
+		t.Errorf("DaysBetween returned %v instead of 366", n)
+	} else if n = DaysBetween(date(2000, 3, 1), date(2000, 2, 28)); n != -2 {
+		t.Errorf("DaysBetween returned %v instead of -2", n)
+	}
+	if y, w := date(2021, 1, 3).ISOWeek(); y != 2020 || w != 53 {
+		t.Errorf("ISOWeek returned %v-W%v instead of 2020-W53", y, w)
+	} else if d := date(2000, 1, 1).Weekday(); d != time.Saturday {
+		t.Errorf("Weekday returned %v instead of Saturday", d)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// ContextDiff describes how a new Context's state differs from an old
+// Context's, such as before and after rewriting a ledger.  Its slices
+// are sorted.
+type ContextDiff struct {
+	// OpenedAccounts are open in the new Context but not in the old one.
+	OpenedAccounts []string
+
+	// ClosedAccounts are open in the old Context but not in the new one.
+	ClosedAccounts []string
+
+	// NewCommodities exist in the new Context but not in the old one.
+	NewCommodities []string
+
+	// Lots are the lots of open accounts whose balances differ.
+	Lots []LotDiff
+}
+
+// LotDiff is a change in the balance of an account's lot of a commodity.
+// The balances of missing lots are zero.
+type LotDiff struct {
+	Account   string
+	Lot       string
+	Commodity string
+	Old, New  decimal.Decimal
+}
+
+// lotKey identifies an account's lot of a commodity.
+type lotKey struct {
+	account, lot, commodity string
+}
+
+// openAccounts returns the names of ctx's open accounts.
+func (ctx *Context) openAccounts() map[string]bool {
+	names := map[string]bool{}
+	for an, a := range ctx.Accounts {
+		if !a.IsClosed(ctx.Date) {
+			names[an] = true
+		}
+	}
+	return names
+}
+
+// lotBalances returns the nonzero balances of the lots of ctx's open
+// accounts.
+func (ctx *Context) lotBalances() map[lotKey]decimal.Decimal {
+	balances := map[lotKey]decimal.Decimal{}
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				if !l.Balance.Amount.IsZero() {
+					balances[lotKey{an, ln, cn}] = l.Balance.Amount
+				}
+			}
+		}
+	}
+	return balances
+}
+
+// Diff returns the differences between old and new.  Accounts are open
+// if they are not closed as of their Contexts' dates.
+func Diff(old, new *Context) ContextDiff {
+	var d ContextDiff
+	oldOpen, newOpen := old.openAccounts(), new.openAccounts()
+	for an := range newOpen {
+		if !oldOpen[an] {
+			d.OpenedAccounts = append(d.OpenedAccounts, an)
+		}
+	}
+	for an := range oldOpen {
+		if !newOpen[an] {
+			d.ClosedAccounts = append(d.ClosedAccounts, an)
+		}
+	}
+	for cn := range new.Commodities {
+		if _, ok := old.Commodities[cn]; !ok {
+			d.NewCommodities = append(d.NewCommodities, cn)
+		}
+	}
+	sort.Strings(d.OpenedAccounts)
+	sort.Strings(d.ClosedAccounts)
+	sort.Strings(d.NewCommodities)
+
+	oldBalances, newBalances := old.lotBalances(), new.lotBalances()
+	for k, b := range newBalances {
+		if ob := oldBalances[k]; !ob.Equal(b) {
+			d.Lots = append(d.Lots, LotDiff{Account: k.account, Lot: k.lot, Commodity: k.commodity, Old: ob, New: b})
+		}
+	}
+	for k, b := range oldBalances {
+		if _, ok := newBalances[k]; !ok {
+			d.Lots = append(d.Lots, LotDiff{Account: k.account, Lot: k.lot, Commodity: k.commodity, Old: b})
+		}
+	}
+	sort.Slice(d.Lots, func(i, j int) bool {
+		a, b := d.Lots[i], d.Lots[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		} else if a.Lot != b.Lot {
+			return a.Lot < b.Lot
+		}
+		return a.Commodity < b.Commodity
+	})
+	return d
+}
+
+// IsEmpty returns true if d has no differences.
+func (d ContextDiff) IsEmpty() bool {
+	return len(d.OpenedAccounts) == 0 && len(d.ClosedAccounts) == 0 && len(d.NewCommodities) == 0 && len(d.Lots) == 0
+}
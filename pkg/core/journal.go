@@ -0,0 +1,55 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+// JournalEntry is an executed transaction in a Context's Journal.
+type JournalEntry struct {
+	Date        Date
+	Entity      string
+	Description string
+	Postings    []Posting
+	Notes       map[string]string
+	Tags        map[string]string // keys -> values; see TagTarget
+}
+
+// Posting is a transfer within a JournalEntry.
+type Posting struct {
+	Account      string
+	LotName      string
+	Quantity     Quantity
+	ExchangeRate *ExchangeRate
+	Comment      string
+	Document     string // see Transfer.Document in package functions
+}
+
+// Record appends an entry to the Context's Journal if the Context's
+// KeepJournal option is set.
+func (ctx *Context) Record(e JournalEntry) {
+	if ctx.Options.KeepJournal {
+		ctx.Journal = append(ctx.Journal, e)
+	}
+}
@@ -26,6 +26,8 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 package core
 
+import "github.com/shopspring/decimal"
+
 const (
 	DefaultLotName = ""
 )
@@ -40,6 +42,10 @@ type Lot struct {
 	CreationDate Date
 	Balance      Quantity
 	ExchangeRate *ExchangeRate
+
+	// Notes describe the lot, such as its broker confirmation number
+	// or acquisition documents.  Notes is nil if the lot has no notes.
+	Notes map[string]string
 }
 
 func NewExchangeRateFromUnitPrice(balance, unitPrice Quantity) ExchangeRate {
@@ -53,3 +59,16 @@ func NewExchangeRateFromTotalPrice(balance, totalPrice Quantity) ExchangeRate {
 		TotalPrice: totalPrice,
 		UnitPrice:  Quantity{Commodity: totalPrice.Commodity, Amount: totalPrice.Amount.Div(balance.Amount)}}
 }
+
+// Split multiplies the Lot's balance by numerator/denominator, as in
+// a numerator-for-denominator stock split, and divides its unit price
+// by the same ratio so that its total price is preserved.
+func (l *Lot) Split(numerator, denominator decimal.Decimal) {
+	l.Balance.Amount = l.Balance.Amount.Mul(numerator).Div(denominator)
+	if l.ExchangeRate != nil {
+		// Journal postings share the Lot's ExchangeRate.
+		er := *l.ExchangeRate
+		er.UnitPrice.Amount = er.UnitPrice.Amount.Mul(denominator).Div(numerator)
+		l.ExchangeRate = &er
+	}
+}
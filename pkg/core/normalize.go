@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization modes
+const (
+	NFCNormalization  = "nfc"  // convert names to Unicode normalization form C
+	WarnNormalization = "warn" // like NFCNormalization, but warn about converted names
+	NoNormalization   = "none" // leave names as they are
+)
+
+// CheckUnicodeNormalization returns an error if mode is not a Unicode
+// normalization mode.
+func CheckUnicodeNormalization(mode string) error {
+	switch mode {
+	case NFCNormalization, WarnNormalization, NoNormalization:
+		return nil
+	}
+	return fmt.Errorf("invalid Unicode normalization mode %#v: expected nfc, warn, or none", mode)
+}
+
+// NormalizeName returns name in Unicode normalization form C, so names
+// that look the same are the same, such as "Café" typed on one system
+// and "Café" written with a combining accent (as macOS often stores
+// file names) on another.
+func NormalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// IsNormalizedName returns true if name is in Unicode normalization
+// form C.
+func IsNormalizedName(name string) bool {
+	return norm.NFC.IsNormalString(name)
+}
+
+// Name returns name converted according to ctx's UnicodeNormalization
+// option.  Functions pass the names of accounts, commodities, lots, and
+// tags through Name before looking them up, so names that look alike
+// are the same.  Free text, such as descriptions and notes, is left as
+// written.
+func (ctx *Context) Name(name string) string {
+	if ctx.Options.UnicodeNormalization == NoNormalization {
+		return name
+	}
+	return NormalizeName(name)
+}
+
+// DeclareName is like Name, but in warn mode, it also records a warning
+// if name is not in Unicode normalization form C.  Functions that declare
+// names, such as by opening accounts and creating commodities, call it
+// instead of Name.  Only they warn, so lookups do not change the Context.
+func (ctx *Context) DeclareName(name string) string {
+	if ctx.Options.UnicodeNormalization == WarnNormalization && !IsNormalizedName(name) {
+		ctx.Warnings = append(ctx.Warnings, fmt.Sprintf("%v: %q is not in Unicode normalization form C", ctx.Date, name))
+	}
+	return ctx.Name(name)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strconv"
+	"strings"
+)
+
+// Note value types
+const (
+	StringNote  = "string"  // any text
+	DecimalNote = "decimal" // decimal numbers, such as "12.5"
+	DateNote    = "date"    // dates formatted "YYYY-MM-DD"
+	BoolNote    = "bool"    // "true" or "false"
+)
+
+// CheckNoteType returns an error if typ is not a note value type.
+func CheckNoteType(typ string) error {
+	switch typ {
+	case StringNote, DecimalNote, DateNote, BoolNote:
+		return nil
+	}
+	return fmt.Errorf("invalid note type %#v: expected string, decimal, date, or bool", typ)
+}
+
+// NoteValue is a note's value interpreted as a value of a note type.
+// Only the field for its type is meaningful besides Type and Text.
+type NoteValue struct {
+	Type    string
+	Text    string // the value as written
+	Decimal decimal.Decimal
+	Date    Date
+	Bool    bool
+}
+
+// ParseNoteValue interprets s as a value of the specified note type.
+// If typ is empty, ParseNoteValue infers the type: decimal if s is
+// a decimal number, date if s is a date formatted "YYYY-MM-DD", bool if
+// s is "true" or "false", and string otherwise.
+func ParseNoteValue(typ, s string) (NoteValue, error) {
+	v := NoteValue{Type: typ, Text: s}
+	var err error
+	switch typ {
+	case "":
+		for _, t := range []string{DecimalNote, DateNote, BoolNote} {
+			if v, err = ParseNoteValue(t, s); err == nil {
+				return v, nil
+			}
+		}
+		return NoteValue{Type: StringNote, Text: s}, nil
+	case StringNote:
+	case DecimalNote:
+		if v.Decimal, err = decimal.NewFromString(s); err != nil {
+			return v, fmt.Errorf("not a decimal number: %#v", s)
+		}
+	case DateNote:
+		if v.Date, err = ParseDate(s); err != nil {
+			return v, fmt.Errorf("not a date formatted YYYY-MM-DD: %#v", s)
+		}
+	case BoolNote:
+		if v.Bool, err = strconv.ParseBool(s); err != nil || (s != "true" && s != "false") {
+			return v, fmt.Errorf(`not "true" or "false": %#v`, s)
+		}
+	default:
+		return v, CheckNoteType(typ)
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0, or 1 if v is less than, equal to, or greater
+// than u and whether they are comparable, which they are if they have
+// the same type.  False is less than true.
+func (v NoteValue) Compare(u NoteValue) (int, bool) {
+	if v.Type != u.Type {
+		return 0, false
+	}
+	switch v.Type {
+	case DecimalNote:
+		return v.Decimal.Cmp(u.Decimal), true
+	case DateNote:
+		if v.Date.Before(u.Date) {
+			return -1, true
+		} else if v.Date.After(u.Date) {
+			return 1, true
+		}
+		return 0, true
+	case BoolNote:
+		if v.Bool == u.Bool {
+			return 0, true
+		} else if u.Bool {
+			return -1, true
+		}
+		return 1, true
+	}
+	return strings.Compare(v.Text, u.Text), true
+}
+
+// NoteCondition is a condition on a transaction's note, such as
+// a "miles" note exceeding 100.
+type NoteCondition struct {
+	Note       string
+	Comparison string // one of <, <=, >, >=, =, or !=
+	Value      string
+}
+
+// ParseNoteCondition parses a condition formatted "NOTE COMPARISON VALUE"
+// or `note("NOTE") COMPARISON VALUE`, such as "miles > 100" or
+// `note("miles") > 100`.  Spaces around the comparison are optional.
+func ParseNoteCondition(s string) (NoteCondition, error) {
+	n := strings.IndexAny(s, "<>=!")
+	if n < 0 {
+		return NoteCondition{}, fmt.Errorf("invalid note condition %#v: no comparison", s)
+	}
+	m := n + 1
+	if m < len(s) && s[m] == '=' {
+		m++
+	}
+	c := NoteCondition{Note: strings.TrimSpace(s[:n]), Comparison: s[n:m], Value: strings.TrimSpace(s[m:])}
+	if strings.HasPrefix(c.Note, "note(") && strings.HasSuffix(c.Note, ")") {
+		c.Note = strings.TrimSpace(c.Note[len("note(") : len(c.Note)-1])
+		if unquoted, err := strconv.Unquote(c.Note); err == nil {
+			c.Note = unquoted
+		}
+	}
+	if len(c.Note) == 0 {
+		return c, fmt.Errorf("invalid note condition %#v: no note name", s)
+	} else if err := CheckComparison(c.Comparison); err != nil {
+		return c, fmt.Errorf("invalid note condition %#v: %v", s, err)
+	} else if strings.IndexAny(c.Value, "<>=!") == 0 {
+		return c, fmt.Errorf("invalid note condition %#v: invalid comparison", s)
+	}
+	return c, nil
+}
+
+// Matches returns true if notes has the NoteCondition's note and its value
+// compares to the NoteCondition's value as specified.  types maps note
+// names to their declared types (see Context.NoteTypes); the types
+// of other notes are inferred from their values.  Values that are not
+// comparable never match.
+func (c NoteCondition) Matches(notes map[string]string, types map[string]string) bool {
+	s, ok := notes[c.Note]
+	if !ok {
+		return false
+	}
+	v, err := ParseNoteValue(types[c.Note], s)
+	if err != nil {
+		return false
+	}
+	u, err := ParseNoteValue(v.Type, c.Value)
+	if err != nil {
+		return false
+	}
+	cmp, ok := v.Compare(u)
+	return ok && compare(cmp, c.Comparison)
+}
+
+func (c NoteCondition) String() string {
+	return fmt.Sprintf("%v %v %v", c.Note, c.Comparison, c.Value)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options are ledger-wide options that ledgers can set via pragmas.
+type Options struct {
+	// Strict requires accounts to be opened with the commodities
+	// they may hold and, if any entities are declared, transactions'
+	// entities to be declared.  See Context.Entities.
+	Strict bool
+
+	// AccountRoots are the names of the root accounts.  Account names
+	// must start with a root's name followed by a colon.
+	AccountRoots []string
+
+	// DecimalPlaces is the maximum number of decimal places of new
+	// commodities' amounts or -1 if there is no maximum.
+	DecimalPlaces int32
+
+	// UnitPriceDecimalPlaces is the number of decimal places to which
+	// unit prices computed from total prices are rounded or -1 if they
+	// are not rounded.
+	UnitPriceDecimalPlaces int32
+
+	// AccountInheritance makes accounts inherit the tags and notes of
+	// their ancestors.  See Context.Ancestors.
+	AccountInheritance bool
+
+	// KeepJournal makes Contexts record executed transactions in
+	// their Journals.
+	KeepJournal bool
+
+	// BalanceCheckpoints makes Contexts record their accounts' balances
+	// at the ends of months in their BalanceCheckpoints.
+	BalanceCheckpoints bool
+
+	// LotReduction is the strategy by which xfer-reduce picks the lots
+	// that it reduces.
+	LotReduction string
+
+	// RoundingAccount is the name of the account that receives
+	// the rounding transfers that balance transactions within their
+	// commodities' rounding tolerances or "" if transactions must
+	// balance exactly.  See Commodity.RoundingTolerance.
+	RoundingAccount string
+
+	// Calendar determines the boundaries of budget periods.
+	Calendar Calendar
+
+	// UnicodeNormalization determines whether the names of accounts,
+	// commodities, lots, and tags are converted to Unicode normalization
+	// form C.  See CheckUnicodeNormalization and Context.Name.
+	UnicodeNormalization string
+}
+
+// Lot reduction strategies
+const (
+	FIFOReduction    = "fifo"    // oldest lots first
+	LIFOReduction    = "lifo"    // newest lots first
+	AverageReduction = "average" // oldest lots first at the lots' average cost
+)
+
+// CheckLotReduction returns an error if strategy is not a lot reduction
+// strategy.
+func CheckLotReduction(strategy string) error {
+	switch strategy {
+	case FIFOReduction, LIFOReduction, AverageReduction:
+		return nil
+	}
+	return fmt.Errorf("invalid lot reduction strategy %#v: expected fifo, lifo, or average", strategy)
+}
+
+// DefaultAccountRoots are the default names of the root accounts.
+var DefaultAccountRoots = []string{"Assets", "Liabilities", "Income", "Expenses", "Equity"}
+
+// NewOptions returns the default Options.
+func NewOptions() Options {
+	return Options{AccountRoots: append([]string(nil), DefaultAccountRoots...), DecimalPlaces: -1, UnitPriceDecimalPlaces: -1, LotReduction: FIFOReduction, Calendar: DefaultCalendar, UnicodeNormalization: NFCNormalization}
+}
+
+// CheckAccountName returns an error if an account name does not start
+// with one of the root account names.  "Equity" is also allowed if it is
+// a root account name.
+func (o Options) CheckAccountName(name string) error {
+	for _, root := range o.AccountRoots {
+		if strings.HasPrefix(name, root+":") || (root == "Equity" && name == root) {
+			return nil
+		}
+	}
+	roots := make([]string, len(o.AccountRoots))
+	for n, root := range o.AccountRoots {
+		roots[n] = fmt.Sprintf(`"%v:"`, root)
+	}
+	return fmt.Errorf("account does not start with %v: %v", strings.Join(roots, ", "), name)
+}
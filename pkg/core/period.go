@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Calendar describes the boundaries of the periods into which reports
+// group dates.  Weeks begin on WeekStart.  Months begin on MonthStartDay,
+// which is between 1 and 28, so a MonthStartDay of 15 makes months run
+// from the 15th through the 14th of the following month.  Months are named
+// for the calendar months in which they begin.
+type Calendar struct {
+	WeekStart       time.Weekday
+	MonthStartDay   int
+	FiscalYearStart FiscalYearStart
+}
+
+// DefaultCalendar has weeks beginning on Monday, calendar months,
+// and fiscal years that match calendar years.
+var DefaultCalendar = Calendar{WeekStart: time.Monday, MonthStartDay: 1, FiscalYearStart: CalendarYear}
+
+// ParseWeekday parses an English weekday name or its three-letter
+// abbreviation, ignoring case.
+func ParseWeekday(s string) (time.Weekday, error) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if name := wd.String(); strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return wd, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday: %v", s)
+}
+
+// CheckMonthStartDay returns an error if day is not a valid MonthStartDay.
+func CheckMonthStartDay(day int) error {
+	if day < 1 || day > 28 {
+		return fmt.Errorf("invalid month start day %v: must be between 1 and 28", day)
+	}
+	return nil
+}
+
+// Week returns the first and last days of the week containing d.
+func (c Calendar) Week(d Date) (first, last Date) {
+	first = d.AddDays(-((int(d.Weekday()) - int(c.WeekStart) + 7) % 7))
+	return first, first.AddDays(6)
+}
+
+// Month returns the first and last days of the month containing d.
+func (c Calendar) Month(d Date) (first, last Date) {
+	first = Date{d.Year, d.Month, c.MonthStartDay}
+	if d.Day < c.MonthStartDay {
+		first = first.AddMonths(-1)
+	}
+	return first, first.AddMonths(1).AddDays(-1)
+}
+
+// Year returns the first and last days of the calendar year containing d.
+func (c Calendar) Year(d Date) (first, last Date) {
+	return d.StartOfYear(), d.EndOfYear()
+}
+
+// FiscalYear returns the first and last days of the fiscal year
+// containing d.
+func (c Calendar) FiscalYear(d Date) (first, last Date) {
+	fy := c.FiscalYearStart.FiscalYear(d)
+	return c.FiscalYearStart.FirstDay(fy), c.FiscalYearStart.LastDay(fy)
+}
+
+// CheckClosingDay returns an error if day is not a valid statement
+// closing day.
+func CheckClosingDay(day int) error {
+	if day < 1 || day > 31 {
+		return fmt.Errorf("invalid closing day %v: must be between 1 and 31", day)
+	}
+	return nil
+}
+
+// closingDate returns the statement closing date in the specified month.
+// Closing days beyond the end of the month fall on its last day.
+func closingDate(year int, month time.Month, closingDay int) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	if closingDay > last.Day() {
+		return last
+	}
+	return time.Date(year, month, closingDay, 0, 0, 0, 0, time.UTC)
+}
+
+// StatementCycle returns the first and last days of the statement cycle
+// containing d for statements that close on the specified day of each
+// month, such as a credit card's.  Cycles end on their closing dates.
+func StatementCycle(d Date, closingDay int) (first, last Date) {
+	closing := closingDate(d.Year, time.Month(d.Month), closingDay)
+	if d.Day > closing.Day() {
+		return FromTime(closing.AddDate(0, 0, 1)), FromTime(closingDate(d.Year, time.Month(d.Month)+1, closingDay))
+	}
+	return FromTime(closingDate(d.Year, time.Month(d.Month)-1, closingDay).AddDate(0, 0, 1)), FromTime(closing)
+}
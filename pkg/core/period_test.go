@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFiscalYearStart_July(t *testing.T) {
+	f := FiscalYearStart{Month: 7, Day: 1}
+	for _, test := range []struct {
+		d  Date
+		fy int
+	}{
+		{Date{Year: 2021, Month: 1, Day: 1}, 2021},
+		{Date{Year: 2021, Month: 6, Day: 30}, 2021},
+		{Date{Year: 2021, Month: 7, Day: 1}, 2022},
+		{Date{Year: 2021, Month: 12, Day: 31}, 2022},
+	} {
+		if fy := f.FiscalYear(test.d); fy != test.fy {
+			t.Errorf("FiscalYear(%v): expected %v, got %v", test.d, test.fy, fy)
+		}
+	}
+	if d, expected := f.FirstDay(2022), (Date{Year: 2021, Month: 7, Day: 1}); !d.Equal(expected) {
+		t.Errorf("FirstDay(2022): expected %v, got %v", expected, d)
+	}
+	if d, expected := f.LastDay(2022), (Date{Year: 2022, Month: 6, Day: 30}); !d.Equal(expected) {
+		t.Errorf("LastDay(2022): expected %v, got %v", expected, d)
+	}
+}
+
+func TestCalendar_FiscalPeriods(t *testing.T) {
+	c := DefaultCalendar
+	c.FiscalYearStart = FiscalYearStart{Month: 10, Day: 1}
+	for _, test := range []struct {
+		period      string
+		d           Date
+		first, last Date
+	}{
+		{"yearly", Date{Year: 2021, Month: 9, Day: 30}, Date{Year: 2020, Month: 10, Day: 1}, Date{Year: 2021, Month: 9, Day: 30}},
+		{"yearly", Date{Year: 2021, Month: 10, Day: 1}, Date{Year: 2021, Month: 10, Day: 1}, Date{Year: 2022, Month: 9, Day: 30}},
+		{"quarterly", Date{Year: 2021, Month: 10, Day: 15}, Date{Year: 2021, Month: 10, Day: 1}, Date{Year: 2021, Month: 12, Day: 31}},
+		{"quarterly", Date{Year: 2022, Month: 2, Day: 28}, Date{Year: 2022, Month: 1, Day: 1}, Date{Year: 2022, Month: 3, Day: 31}},
+		{"quarterly", Date{Year: 2022, Month: 9, Day: 30}, Date{Year: 2022, Month: 7, Day: 1}, Date{Year: 2022, Month: 9, Day: 30}},
+		{"monthly", Date{Year: 2022, Month: 2, Day: 28}, Date{Year: 2022, Month: 2, Day: 1}, Date{Year: 2022, Month: 2, Day: 28}},
+	} {
+		first, last := c.BudgetPeriod(test.period, test.d)
+		if !first.Equal(test.first) || !last.Equal(test.last) {
+			t.Errorf("%v period containing %v: expected %v through %v, got %v through %v", test.period, test.d, test.first, test.last, first, last)
+		}
+	}
+	if _, last := c.Year(Date{Year: 2021, Month: 10, Day: 1}); !last.Equal(Date{Year: 2021, Month: 12, Day: 31}) {
+		t.Errorf("Year ignores fiscal years: expected 2021-12-31, got %v", last)
+	}
+}
+
+func TestCalendar_WeekAndMonth(t *testing.T) {
+	date := func(y, m, d int) Date { return Date{Year: y, Month: m, Day: d} }
+	c := Calendar{WeekStart: time.Sunday, MonthStartDay: 25, FiscalYearStart: CalendarYear}
+	for _, test := range []struct {
+		name        string
+		period      func(Date) (Date, Date)
+		d           Date
+		first, last Date
+	}{
+		{"week", c.Week, date(2021, 1, 2), date(2020, 12, 27), date(2021, 1, 2)},
+		{"week", c.Week, date(2021, 1, 3), date(2021, 1, 3), date(2021, 1, 9)},
+		{"month", c.Month, date(2021, 1, 24), date(2020, 12, 25), date(2021, 1, 24)},
+		{"month", c.Month, date(2021, 1, 25), date(2021, 1, 25), date(2021, 2, 24)},
+		{"month", c.Month, date(2020, 2, 29), date(2020, 2, 25), date(2020, 3, 24)},
+		{"month", DefaultCalendar.Month, date(2020, 2, 10), date(2020, 2, 1), date(2020, 2, 29)},
+		{"month", DefaultCalendar.Month, date(2021, 2, 10), date(2021, 2, 1), date(2021, 2, 28)},
+		{"month", DefaultCalendar.Month, date(2021, 12, 31), date(2021, 12, 1), date(2021, 12, 31)},
+		{"year", c.Year, date(2020, 2, 29), date(2020, 1, 1), date(2020, 12, 31)},
+	} {
+		if first, last := test.period(test.d); !first.Equal(test.first) || !last.Equal(test.last) {
+			t.Errorf("%v containing %v: expected %v through %v, got %v through %v", test.name, test.d, test.first, test.last, first, last)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// Price is an observation of a commodity's price on a date.
+type Price struct {
+	Date      Date
+	Commodity *Commodity
+	Price     Quantity
+}
+
+// PriceDB records price observations.  Ledgers move forward in time,
+// so observations are added in chronological order.
+type PriceDB struct {
+	prices map[string][]Price // commodity name -> observations
+}
+
+func NewPriceDB() *PriceDB {
+	return &PriceDB{prices: map[string][]Price{}}
+}
+
+// Add records a price observation.
+func (db *PriceDB) Add(p Price) {
+	db.prices[p.Commodity.Name] = append(db.prices[p.Commodity.Name], p)
+}
+
+// Latest returns the most recent price of the named commodity.
+func (db *PriceDB) Latest(commodityName string) (Price, bool) {
+	if prices := db.prices[commodityName]; len(prices) != 0 {
+		return prices[len(prices)-1], true
+	}
+	return Price{}, false
+}
+
+// LatestIn returns the most recent price of the named commodity
+// in the named quote commodity.
+func (db *PriceDB) LatestIn(commodityName, quoteName string) (Price, bool) {
+	prices := db.prices[commodityName]
+	for n := len(prices) - 1; n >= 0; n-- {
+		if prices[n].Price.Commodity.Name == quoteName {
+			return prices[n], true
+		}
+	}
+	return Price{}, false
+}
+
+// latestOn returns the most recent price of the named commodity in
+// the named quote commodity on or before the specified date.
+func (db *PriceDB) latestOn(commodityName, quoteName string, date Date) (Price, bool) {
+	prices := db.prices[commodityName]
+	for n := len(prices) - 1; n >= 0; n-- {
+		if prices[n].Price.Commodity.Name == quoteName && !prices[n].Date.After(date) {
+			return prices[n], true
+		}
+	}
+	return Price{}, false
+}
+
+// rateOn returns the most recent price on or before the specified date
+// of the base commodity in the quote commodity or, if inverse is true,
+// of the quote commodity in the base commodity, whichever is more recent.
+// Zero inverse prices cannot be inverted, so the direct price is used
+// instead even if it is older.  It returns false if there is no such price.
+func (db *PriceDB) rateOn(base, quote string, date Date) (price decimal.Decimal, inverse, ok bool) {
+	direct, dok := db.latestOn(base, quote, date)
+	inv, iok := db.latestOn(quote, base, date)
+	switch {
+	case dok && (!iok || !direct.Date.Before(inv.Date)):
+		return direct.Price.Amount, false, true
+	case iok && !inv.Price.Amount.IsZero():
+		return inv.Price.Amount, true, true
+	case dok:
+		return direct.Price.Amount, false, true
+	}
+	return decimal.Zero, false, false
+}
+
+// GetRate returns the price of one unit of the base commodity in the quote
+// commodity using the most recent price on or before the specified date,
+// like Convert.  It makes PriceDB a RateProvider.
+func (db *PriceDB) GetRate(date Date, base, quote string) (decimal.Decimal, error) {
+	if base == quote {
+		return decimal.New(1, 0), nil
+	} else if price, inverse, ok := db.rateOn(base, quote, date); !ok {
+		return decimal.Zero, fmt.Errorf("no price of %v in %v on or before %v", base, quote, date)
+	} else if inverse {
+		return decimal.New(1, 0).Div(price), nil
+	} else {
+		return price, nil
+	}
+}
+
+// Convert converts a quantity to the specified commodity using the most
+// recent price on or before the specified date.  Prices of either
+// commodity in the other may be used, whichever is more recent.
+// Convert returns an error if there is no such price.
+func (db *PriceDB) Convert(q Quantity, to *Commodity, date Date) (Quantity, error) {
+	if q.Commodity == to || q.Commodity.Name == to.Name {
+		return q, nil
+	} else if price, inverse, ok := db.rateOn(q.Commodity.Name, to.Name, date); !ok {
+		return Quantity{}, fmt.Errorf("no price of %v in %v on or before %v", q.Commodity.Name, to.Name, date)
+	} else if inverse {
+		return Quantity{Commodity: to, Amount: q.Amount.Div(price)}, nil
+	} else {
+		return Quantity{Commodity: to, Amount: q.Amount.Mul(price)}, nil
+	}
+}
+
+// History returns the named commodity's price observations
+// in chronological order.
+func (db *PriceDB) History(commodityName string) []Price {
+	return append([]Price(nil), db.prices[commodityName]...)
+}
+
+// Commodities returns the names of the commodities with prices, sorted.
+func (db *PriceDB) Commodities() []string {
+	names := make([]string, len(db.prices))[:0]
+	for cn := range db.prices {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestPriceDB_Convert(t *testing.T) {
+	date := func(d int) Date { return Date{Year: 2000, Month: 1, Day: d} }
+	usd, eur, xyz := NewCommodity("USD", "Dollar", date(1)), NewCommodity("EUR", "Euro", date(1)), NewCommodity("XYZ", "Defunct", date(1))
+	price := func(d int, c *Commodity, amount string, quote *Commodity) Price {
+		return Price{Date: date(d), Commodity: c, Price: Quantity{Commodity: quote, Amount: decimal.RequireFromString(amount)}}
+	}
+	db := NewPriceDB()
+	db.Add(price(2, eur, "1.25", usd))
+	db.Add(price(4, usd, "0.5", eur))
+	db.Add(price(5, xyz, "2", usd))
+	db.Add(price(6, usd, "0", xyz))
+	for _, test := range []struct {
+		amount   string
+		from, to *Commodity
+		date     Date
+		expected string
+	}{
+		{"10", eur, usd, date(3), "12.5 USD"},
+		{"10", usd, eur, date(3), "8 EUR"},
+		{"10", eur, usd, date(4), "20 USD"},
+		{"10", usd, eur, date(4), "5 EUR"},
+		{"10", xyz, usd, date(5), "20 USD"},
+		{"10", xyz, usd, date(6), "20 USD"}, // the newer inverse price is zero
+		{"10", usd, usd, date(1), "10 USD"},
+	} {
+		q, err := db.Convert(Quantity{Commodity: test.from, Amount: decimal.RequireFromString(test.amount)}, test.to, test.date)
+		if err != nil {
+			t.Errorf("converting %v %v to %v on %v failed: %v", test.amount, test.from.Name, test.to.Name, test.date, err)
+		} else if q.String() != test.expected {
+			t.Errorf("converting %v %v to %v on %v returned %v instead of %v", test.amount, test.from.Name, test.to.Name, test.date, q, test.expected)
+		}
+	}
+	if _, err := db.Convert(Quantity{Commodity: eur, Amount: decimal.NewFromInt(1)}, usd, date(1)); err == nil {
+		t.Errorf("Convert succeeded before the first price")
+	} else if _, err = db.Convert(Quantity{Commodity: usd, Amount: decimal.NewFromInt(1)}, xyz, date(6)); err != nil {
+		t.Errorf("Convert failed with a zero price: %v", err)
+	}
+}
@@ -29,6 +29,7 @@ package core
 import (
 	"fmt"
 	"github.com/shopspring/decimal"
+	"strings"
 )
 
 type Quantity struct {
@@ -36,6 +37,17 @@ type Quantity struct {
 	Amount    decimal.Decimal
 }
 
+// String formats the Quantity according to its commodity's DisplayFormat.
+// See Commodity.FormatAmount.  Negative amounts' signs precede
+// the commodity's prefix, as in "-$1.00".
 func (q Quantity) String() string {
-	return fmt.Sprintf("%v %v", q.Amount, q.Commodity)
+	s := q.Commodity.FormatAmount(q.Amount)
+	if q.Commodity == nil || (len(q.Commodity.Display.Prefix) == 0 && len(q.Commodity.Display.Suffix) == 0) {
+		return fmt.Sprintf("%v %v", s, q.Commodity)
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	return sign + q.Commodity.Display.Prefix + s + q.Commodity.Display.Suffix
 }
@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// RateProvider provides exchange rates between commodities, such as
+// the prices that a ledger records or rates published by a central bank.
+// Implementations that use the network live outside this package, so
+// the core library stays usable offline.
+type RateProvider interface {
+	// GetRate returns the price of one unit of the base commodity in
+	// the quote commodity on or before the specified date.
+	GetRate(date Date, base, quote string) (decimal.Decimal, error)
+}
+
+// RateProviders is a RateProvider that tries its RateProviders in order
+// and returns the first rate that one of them provides, so a ledger's
+// prices can take precedence over a remote source.
+type RateProviders []RateProvider
+
+func (providers RateProviders) GetRate(date Date, base, quote string) (decimal.Decimal, error) {
+	var errs []string
+	for _, p := range providers {
+		rate, err := p.GetRate(date, base, quote)
+		if err == nil {
+			return rate, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return decimal.Zero, fmt.Errorf("no rate providers for %v in %v", base, quote)
+	}
+	return decimal.Zero, fmt.Errorf("%v", strings.Join(errs, "; "))
+}
+
+// ConvertWith converts a quantity to the specified commodity using
+// the rate that p provides for the specified date.
+func ConvertWith(p RateProvider, q Quantity, to *Commodity, date Date) (Quantity, error) {
+	if q.Commodity == to || q.Commodity.Name == to.Name {
+		return q, nil
+	}
+	rate, err := p.GetRate(date, q.Commodity.Name, to.Name)
+	if err != nil {
+		return Quantity{}, err
+	}
+	return Quantity{Commodity: to, Amount: q.Amount.Mul(rate)}, nil
+}
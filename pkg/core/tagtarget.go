@@ -26,9 +26,58 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 package core
 
+import "strings"
+
+// TagTarget is an object that can be tagged, such as an Account or
+// a Commodity.  Tags are either plain names, such as "bank", or key=value
+// pairs, such as "trip=japan2024", whose keys are their names.  A TagTarget
+// has at most one value per key, so tagging it with an existing key
+// replaces the key's value.  See SplitTag.
 type TagTarget interface {
 	AddTag(string)
 	GetTags() []string
+
+	// HasTag returns true if the TagTarget has the tag.  Tags without
+	// values match all values of their keys.
 	HasTag(string) bool
+
 	RemoveTag(string)
+
+	// TagValue returns the value of the tag with the specified key,
+	// which is empty for plain tags, and whether the TagTarget has it.
+	TagValue(key string) (string, bool)
+}
+
+// SplitTag splits a tag into its key and value at the first "=".
+// hasValue is false if the tag does not contain "=".  Tags with empty
+// values, such as "trip=", are equivalent to plain tags.
+func SplitTag(tag string) (key, value string, hasValue bool) {
+	if n := strings.IndexByte(tag, '='); n >= 0 {
+		return tag[:n], tag[n+1:], true
+	}
+	return tag, "", false
+}
+
+// JoinTag is the inverse of SplitTag.  It returns key if value is empty.
+func JoinTag(key, value string) string {
+	if len(value) == 0 {
+		return key
+	}
+	return key + "=" + value
+}
+
+// tagList returns tags, which map keys to values, as a list of tags.
+func tagList(tags map[string]string) []string {
+	list := make([]string, len(tags))[:0]
+	for key, value := range tags {
+		list = append(list, JoinTag(key, value))
+	}
+	return list
+}
+
+// hasTag returns true if tags, which map keys to values, have tag.
+func hasTag(tags map[string]string, tag string) bool {
+	key, value, hasValue := SplitTag(tag)
+	v, ok := tags[key]
+	return ok && (!hasValue || v == value)
 }
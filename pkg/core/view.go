@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package core
+
+import "sync"
+
+// View is a Context that several goroutines can safely query at once,
+// such as a server's request handlers.  Readers see a consistent
+// snapshot: Update changes a copy of the Context and replaces the
+// snapshot only when the change succeeds.
+type View struct {
+	mu     sync.RWMutex // guards ctx
+	update sync.Mutex   // serializes Update and Replace
+	ctx    *Context
+}
+
+// NewView returns a View of a copy of ctx, so later changes to ctx do
+// not affect the View.
+func NewView(ctx *Context) *View {
+	return &View{ctx: ctx.Clone()}
+}
+
+// Read calls f with the View's Context while holding a read lock.
+// f may call the Context's queries but must not change the Context
+// (see Context) or keep it after returning.
+func (v *View) Read(f func(ctx *Context)) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	f(v.ctx)
+}
+
+// Update calls f with a copy of the View's Context.  If f succeeds,
+// the copy replaces the View's Context.  Readers are not blocked while
+// f runs, but concurrent updates are serialized.
+func (v *View) Update(f func(ctx *Context) error) error {
+	v.update.Lock()
+	defer v.update.Unlock()
+	v.mu.RLock()
+	c := v.ctx.Clone()
+	v.mu.RUnlock()
+	if err := f(c); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	v.ctx = c
+	v.mu.Unlock()
+	return nil
+}
+
+// Replace replaces the View's Context with a copy of ctx, such as
+// after parsing a changed ledger again.
+func (v *View) Replace(ctx *Context) {
+	c := ctx.Clone()
+	v.update.Lock()
+	defer v.update.Unlock()
+	v.mu.Lock()
+	v.ctx = c
+	v.mu.Unlock()
+}
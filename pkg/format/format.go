@@ -0,0 +1,393 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package format prints ledgers in a canonical style.
+package format
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind classifies functions by how the Formatter lays out their calls.
+type Kind int
+
+const (
+	// Plain functions' calls appear on their own lines.
+	Plain Kind = iota
+
+	// Transfer functions push Transfers, which usually precede
+	// a Transaction function's call.
+	Transfer
+
+	// TransferModifier functions modify Transfers.  Their calls appear
+	// on the same lines as the calls that pushed the Transfers.
+	TransferModifier
+
+	// Transaction functions consume Transfers followed by note name
+	// and value pairs.  The Formatter puts each note on its own line
+	// and aligns the values.
+	Transaction
+
+	// Operator functions pop operands and push a single result, such as
+	// a sum.  The Formatter treats their calls and the operands they
+	// consume as a single operand of the following call.
+	Operator
+)
+
+// Syntax describes a function's call syntax.
+type Syntax struct {
+	// Operands is the number of string operands the function pops
+	// or -1 if it pops a variable number of them.  The Formatter moves
+	// string operands that precede a call but that the call does not
+	// consume to their own line.
+	Operands int
+
+	Kind Kind
+}
+
+// GetCoreSyntax returns the syntax of the core functions.
+func GetCoreSyntax() map[string]Syntax {
+	return map[string]Syntax{
+		"add":                 {2, Operator},
+		"add-commodity-notes": {-1, Plain},
+		"add-lot-notes":       {-1, Plain},
+		"add-notes":           {-1, Plain},
+		"alert":               {4, Plain},
+		"assert":              {3, Plain},
+		"assert-budget":       {2, Plain},
+		"assert-equation":     {0, Plain},
+		"assert-lot":          {4, Plain},
+		"assert-lots-sum":     {3, Plain},
+		"assert-note":         {3, Plain},
+		"assert-tag":          {2, Plain},
+		"billing-rate":        {4, Plain},
+		"book":                {1, Plain},
+		"budget":              {4, Plain},
+		"checksum":            {1, Plain},
+		"close":               {1, Plain},
+		"close-lot":           {2, Plain},
+		"comment":             {1, Plain},
+		"commodity":           {2, Plain},
+		"convert":             {3, Plain},
+		"create-lot":          {1, TransferModifier},
+		"date":                {3, Plain},
+		"decimal-places":      {2, Plain},
+		"define":              {1, Plain},
+		"display-format":      {3, Plain},
+		"dump-stack":          {0, Plain},
+		"div":                 {2, Operator},
+		"entity":              {-1, Plain},
+		"event":               {2, Plain},
+		"freebean-version":    {1, Plain},
+		"if-equal":            {2, Plain},
+		"if-zero":             {1, Plain},
+		"include":             {1, Plain},
+		"lot":                 {1, TransferModifier},
+		"mul":                 {2, Operator},
+		"neg":                 {1, Operator},
+		"note-type":           {2, Plain},
+		"open":                {-1, Plain},
+		"pad":                 {4, Plain},
+		"paid-by":             {1, TransferModifier},
+		"pragma":              {2, Plain},
+		"price":               {3, Plain},
+		"quote":               {0, Plain},
+		"recall":              {1, Operator},
+		"rename-account":      {3, Plain},
+		"rounding-tolerance":  {2, Plain},
+		"set-comment":         {1, TransferModifier},
+		"set-document":        {1, TransferModifier},
+		"set-precision":       {3, Plain},
+		"share":               {-1, TransferModifier},
+		"silence":             {0, Plain},
+		"smallest-unit":       {2, Plain},
+		"split-lot":           {5, Plain},
+		"store":               {2, Plain},
+		"sub":                 {2, Operator},
+		"tag":                 {-1, Plain},
+		"tag-commodity":       {-1, Plain},
+		"tag-xact":            {-1, Plain},
+		"time-entry":          {2, TransferModifier},
+		"untag":               {-1, Plain},
+		"when-tagged":         {2, Plain},
+		"xact":                {-1, Transaction},
+		"xfer":                {3, Transfer},
+		"xfer-exch":           {7, Transfer},
+		"xfer-exch-total":     {5, Transfer},
+		"xfer-reduce":         {6, Transfer},
+	}
+}
+
+// Formatter prints ledgers in a canonical style: one function call
+// per line, with the contents of parentheses indented one level,
+// opening parentheses attached to the first line they contain, and
+// closing parentheses attached to the last.  Formatter preserves
+// the original text of every token, so formatting never changes
+// a ledger's meaning, and it keeps single blank lines that separate
+// parts of the original ledger.  Comments that follow tokens on
+// the same line end the formatted lines containing those tokens;
+// other comments get their own lines.
+type Formatter struct {
+	// Functions maps function names to their syntax.  Unquoted strings
+	// not in Functions are operands.
+	Functions map[string]Syntax
+
+	// Indent is the indentation for each level of parentheses.
+	Indent string
+
+	// ColonDefinitions makes the Formatter recognize ":" and ";" word
+	// definitions.  See parser.Parser.ColonDefinitions.
+	ColonDefinitions bool
+}
+
+// NewFormatter creates a Formatter that recognizes the core functions
+// and indents with tabs.
+func NewFormatter() *Formatter {
+	return &Formatter{Functions: GetCoreSyntax(), Indent: "\t"}
+}
+
+type line struct {
+	depth       int
+	blankBefore bool
+	kind        Kind
+	text        strings.Builder
+	comment     string // raw text of the comment ending the line, if any
+}
+
+type formatState struct {
+	f          *Formatter
+	lines      []*line
+	pending    []string // raw operand text
+	depth      int
+	openParens int  // open parentheses awaiting the next line
+	blank      bool // whether a blank line precedes the next line
+	naming     bool // whether the next token names a ":" definition
+	definition int  // index of the first line of a ":" definition's body, or 0
+}
+
+// addLine appends a line containing the specified tokens.
+func (st *formatState) addLine(kind Kind, tokens ...string) *line {
+	l := &line{depth: st.depth - st.openParens, blankBefore: st.blank && len(st.lines) != 0, kind: kind}
+	l.text.WriteString(strings.Repeat("(", st.openParens))
+	l.text.WriteString(strings.Join(tokens, " "))
+	st.openParens = 0
+	st.blank = false
+	st.lines = append(st.lines, l)
+	return l
+}
+
+// flush moves pending operands to their own line.
+func (st *formatState) flush() {
+	if len(st.pending) != 0 {
+		st.addLine(Plain, st.pending...)
+		st.pending = st.pending[:0]
+	}
+}
+
+func (st *formatState) call(fn string, s Syntax) {
+	var last *line
+	if len(st.lines) != 0 {
+		last = st.lines[len(st.lines)-1]
+	}
+	switch {
+	case s.Kind == Operator && s.Operands >= 0 && len(st.pending) >= s.Operands:
+		n := len(st.pending) - s.Operands
+		st.pending = append(st.pending[:n], strings.Join(append(st.pending[n:len(st.pending):len(st.pending)], fn), " "))
+		return
+	case s.Kind == TransferModifier && last != nil && st.openParens == 0 && !st.blank && (last.kind == Transfer || last.kind == TransferModifier):
+		for _, t := range append(st.pending, fn) {
+			last.text.WriteString(" ")
+			last.text.WriteString(t)
+		}
+	case s.Kind == Transaction && len(st.pending)%2 == 0:
+		width := 0
+		for n := 0; n < len(st.pending); n += 2 {
+			if w := utf8.RuneCountInString(st.pending[n]); w > width {
+				width = w
+			}
+		}
+		for n := 0; n < len(st.pending); n += 2 {
+			name := st.pending[n]
+			st.addLine(Plain, name+strings.Repeat(" ", width-utf8.RuneCountInString(name)), st.pending[n+1])
+		}
+		st.addLine(s.Kind, fn)
+	default:
+		operands := st.pending
+		if s.Operands >= 0 && len(operands) > s.Operands {
+			st.addLine(Plain, operands[:len(operands)-s.Operands]...)
+			operands = operands[len(operands)-s.Operands:]
+		}
+		st.addLine(s.Kind, append(operands, fn)...)
+	}
+	st.pending = st.pending[:0]
+}
+
+// name adds the line beginning a ":" definition, which contains ":"
+// and the name, and indents the definition's body.
+func (st *formatState) name(raw string) {
+	st.pending = append(st.pending, raw)
+	st.flush()
+	st.naming = false
+	st.definition = len(st.lines)
+	st.depth++
+}
+
+// endDefinition ends a ":" definition with raw, the ";".  Definitions
+// whose bodies fit on one line are joined into one line.
+func (st *formatState) endDefinition(raw string) {
+	st.flush()
+	if st.depth > 0 {
+		st.depth--
+	}
+	body := st.lines[st.definition:]
+	head, last := st.lines[st.definition-1], st.lines[len(st.lines)-1]
+	switch {
+	case st.openParens != 0:
+		st.addLine(Plain, raw)
+	case len(body) == 1 && len(head.comment) == 0 && len(last.comment) == 0:
+		head.text.WriteString(" " + last.text.String() + " " + raw)
+		st.lines = st.lines[:st.definition]
+	case last.text.Len() != 0 && len(last.comment) == 0:
+		last.text.WriteString(" " + raw)
+	default:
+		st.addLine(Plain, raw)
+	}
+	st.definition = 0
+}
+
+// addComment adds a comment.  A trailing comment, which followed
+// a token on the same line, ends the last line unless the latter already
+// ends with a comment.  Other comments get their own lines.
+func (st *formatState) addComment(raw string, trailing bool) {
+	st.flush()
+	if trailing && st.openParens == 0 && len(st.lines) != 0 {
+		if last := st.lines[len(st.lines)-1]; len(last.comment) == 0 {
+			last.comment = raw
+			return
+		}
+	}
+	st.addLine(Plain).comment = raw
+}
+
+// Format reads tokens from lex and writes the formatted ledger to w.
+// It sets lex's KeepComments field.
+func (f *Formatter) Format(lex *parser.Lexer, w io.Writer) error {
+	lex.KeepComments = true
+	st := &formatState{f: f}
+	var lastLine uint64
+	first := true
+	for {
+		tokenType, text, err := lex.GetNextToken()
+		if tokenType == parser.Error {
+			if err != io.EOF {
+				return fmt.Errorf("%v: syntax error: %v", lex.Position(), err)
+			}
+			break
+		}
+		raw := lex.RawText()
+		trailing := !first && lex.TokenLineNumber() == lastLine
+		if !first && lex.TokenLineNumber() > lastLine+1 {
+			st.blank = true
+		}
+		first = false
+		lastLine = lex.TokenLineNumber() + uint64(strings.Count(raw, "\n"))
+
+		switch tokenType {
+		case parser.String:
+			if st.naming {
+				st.name(raw)
+			} else if text == ":" && f.ColonDefinitions && st.definition == 0 {
+				st.flush()
+				st.pending = append(st.pending, raw)
+				st.naming = true
+			} else if text == ";" && f.ColonDefinitions && st.definition != 0 {
+				st.endDefinition(raw)
+			} else if s, ok := f.Functions[text]; ok {
+				st.call(raw, s)
+			} else {
+				st.pending = append(st.pending, raw)
+			}
+		case parser.QuotedString:
+			if st.naming {
+				st.name(raw)
+			} else {
+				st.pending = append(st.pending, raw)
+			}
+		case parser.OpenParen:
+			st.flush()
+			st.openParens++
+			st.depth++
+		case parser.Comment:
+			st.addComment(raw, trailing)
+		case parser.CloseParen:
+			st.flush()
+			if st.openParens != 0 || len(st.lines) == 0 {
+				st.addLine(Plain, ")")
+			} else if st.lines[len(st.lines)-1].text.Len() == 0 {
+				// The last line is a comment, so the parenthesis gets
+				// its own line outside the parentheses' contents.
+				if l := st.addLine(Plain, ")"); l.depth > 0 {
+					l.depth--
+				}
+			} else {
+				st.lines[len(st.lines)-1].text.WriteString(")")
+			}
+			if st.depth > 0 {
+				st.depth--
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	st.flush()
+	if st.openParens != 0 {
+		st.addLine(Plain)
+	}
+
+	for _, l := range st.lines {
+		if l.blankBefore {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		text := l.text.String()
+		if len(l.comment) != 0 {
+			if len(text) != 0 {
+				text += " "
+			}
+			text += l.comment
+		}
+		if _, err := fmt.Fprintf(w, "%v%v\n", strings.Repeat(f.Indent, l.depth), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
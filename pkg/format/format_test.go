@@ -0,0 +1,210 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package format
+
+import (
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, input string) string {
+	var b strings.Builder
+	if err := NewFormatter().Format(parser.NewLexer(strings.NewReader(input)), &b); err != nil {
+		t.Fatalf("Format failed: %v", err)
+	}
+	return b.String()
+}
+
+func getTokens(input string) []string {
+	var tokens []string
+	lex := parser.NewLexer(strings.NewReader(input))
+	for {
+		tokenType, text, err := lex.GetNextToken()
+		if tokenType == parser.Error {
+			break
+		}
+		tokens = append(tokens, string(rune('0'+tokenType))+text)
+		if err == io.EOF {
+			break
+		}
+	}
+	return tokens
+}
+
+func TestGetCoreSyntax_CoversCoreFunctions(t *testing.T) {
+	syntax := GetCoreSyntax()
+	for fn := range functions.GetCoreFunctions() {
+		if _, ok := syntax[fn]; !ok {
+			t.Errorf("GetCoreSyntax does not describe core function %v", fn)
+		}
+	}
+}
+
+func TestFormatter_Format(t *testing.T) {
+	input := `2021 1 1 date USD "US Dollar" commodity
+Assets:Checking USD open   Expenses:Food open
+
+
+(Grocer   "Weekly \"shop\"" Assets:Checking -50 USD xfer "paid cash" set-comment
+Expenses:Food 50 USD xfer memo weekly longer-name x xact)
+"a comment" comment (silence old stuff)
+`
+	expected := `2021 1 1 date
+USD "US Dollar" commodity
+Assets:Checking USD open
+Expenses:Food open
+
+(Grocer "Weekly \"shop\""
+	Assets:Checking -50 USD xfer "paid cash" set-comment
+	Expenses:Food 50 USD xfer
+	memo        weekly
+	longer-name x
+	xact)
+"a comment" comment
+(silence
+	old stuff)
+`
+	if output := format(t, input); output != expected {
+		t.Errorf("unexpected output:\n%v", output)
+	}
+}
+
+func TestFormatter_Format_Operators(t *testing.T) {
+	input := `Expenses:Food 100 3 div USD xfer
+Assets:Checking 100 3 div neg USD xfer
+2 3 add 4 mul
+`
+	expected := `Expenses:Food 100 3 div USD xfer
+Assets:Checking 100 3 div neg USD xfer
+2 3 add 4 mul
+`
+	if s := format(t, input); s != expected {
+		t.Errorf("unexpected output:\n%v", s)
+	}
+}
+
+func TestFormatter_Format_PreservesTokens(t *testing.T) {
+	input := `(2000 1 1 date
+	Assets:Account open
+		Assets:Account type "regular asset" checking yes add-notes) () esc\ aped "multi
+line" comment ( ( nested ) )`
+	output := format(t, input)
+	if !reflect.DeepEqual(getTokens(input), getTokens(output)) {
+		t.Errorf("formatting changed tokens:\n%v", output)
+	}
+	if again := format(t, output); again != output {
+		t.Errorf("formatting is not idempotent:\n%v\n%v", output, again)
+	}
+}
+
+func TestFormatter_Format_Comments(t *testing.T) {
+	input := `# Opening balances
+2021 1 1 date USD "US Dollar" commodity # dollars
+(Grocer Shopping # weekly
+Assets:Checking -50 USD xfer # cash
+"paid" set-comment
+Expenses:Food 50 USD xfer
+# end
+) # done
+`
+	expected := `# Opening balances
+2021 1 1 date
+USD "US Dollar" commodity # dollars
+(Grocer Shopping # weekly
+	Assets:Checking -50 USD xfer "paid" set-comment # cash
+	Expenses:Food 50 USD xfer
+	# end
+) # done
+`
+	if output := format(t, input); output != expected {
+		t.Errorf("unexpected output:\n%v", output)
+	} else if again := format(t, output); again != output {
+		t.Errorf("formatting is not idempotent:\n%v", again)
+	}
+}
+
+func TestFormatter_Format_BlockComments(t *testing.T) {
+	input := `(Grocer Shopping #| old:
+Assets:Cash -50 USD xfer ) |# Assets:Checking -50 USD xfer
+#| disabled |#)
+`
+	expected := `(Grocer Shopping #| old:
+Assets:Cash -50 USD xfer ) |#
+	Assets:Checking -50 USD xfer
+	#| disabled |#
+)
+`
+	if output := format(t, input); output != expected {
+		t.Errorf("unexpected output:\n%v", output)
+	} else if again := format(t, output); again != output {
+		t.Errorf("formatting is not idempotent:\n%v", again)
+	}
+}
+
+func TestFormatter_Format_ColonDefinitions(t *testing.T) {
+	input := `: double
+2 mul ; : paycheck (Employer Paycheck Assets:Checking 700 USD xfer
+Income:Salary -700 USD xfer xact) ; : noop # nothing
+;
+`
+	expected := `: double 2 mul ;
+: paycheck
+	(Employer Paycheck
+		Assets:Checking 700 USD xfer
+		Income:Salary -700 USD xfer
+		xact) ;
+: noop # nothing
+;
+`
+	f := NewFormatter()
+	f.ColonDefinitions = true
+	formatColon := func(input string) string {
+		var b strings.Builder
+		if err := f.Format(parser.NewLexer(strings.NewReader(input)), &b); err != nil {
+			t.Fatalf("Format failed: %v", err)
+		}
+		return b.String()
+	}
+	if output := formatColon(input); output != expected {
+		t.Errorf("unexpected output:\n%v", output)
+	} else if again := formatColon(output); again != output {
+		t.Errorf("formatting is not idempotent:\n%v", again)
+	} else if output = format(t, ": w a ;\n"); output != ": w a ;\n" {
+		t.Errorf("unexpected output without ColonDefinitions:\n%v", output)
+	}
+}
+
+func TestFormatter_Format_SyntaxError(t *testing.T) {
+	var b strings.Builder
+	if err := NewFormatter().Format(parser.NewLexer(strings.NewReader(`"unterminated`)), &b); err == nil {
+		t.Errorf("Format accepted an unterminated string")
+	}
+}
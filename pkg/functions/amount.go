@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package functions
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+	"unicode"
+)
+
+// ParseAmount parses a decimal or a simple arithmetic expression
+// of decimals, such as "3*19.99", that evaluates to a decimal.
+// Expressions may contain the binary operators +, -, *, and /,
+// which have their usual precedences, unary minus, and parentheses.
+// Parentheses are only possible in quoted strings because the Lexer
+// treats them as separate tokens.  Whitespace is ignored.  Like
+// ParseDecimal, ParseAmount ignores commas in decimals.
+func ParseAmount(s string) (decimal.Decimal, error) {
+	if d, err := ParseDecimal(s); err == nil {
+		return d, nil
+	}
+	e := &amountExpr{s: strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == ',' {
+			return -1
+		}
+		return r
+	}, s)}
+	d, err := e.sum()
+	if err != nil {
+		return decimal.Decimal{}, err
+	} else if e.n != len(e.s) {
+		return decimal.Decimal{}, fmt.Errorf("unexpected %q in expression %v", e.s[e.n], s)
+	}
+	return d, nil
+}
+
+// amountExpr is a recursive descent evaluator for ParseAmount's
+// expressions.  n is the index of the next unread byte in s.
+type amountExpr struct {
+	s string
+	n int
+}
+
+// peek returns the next unread byte of e or zero at the end of e.
+func (e *amountExpr) peek() byte {
+	if e.n < len(e.s) {
+		return e.s[e.n]
+	}
+	return 0
+}
+
+// sum evaluates terms separated by + and -.
+func (e *amountExpr) sum() (decimal.Decimal, error) {
+	d, err := e.product()
+	for err == nil && (e.peek() == '+' || e.peek() == '-') {
+		op := e.peek()
+		e.n++
+		var t decimal.Decimal
+		if t, err = e.product(); err == nil {
+			if op == '+' {
+				d = d.Add(t)
+			} else {
+				d = d.Sub(t)
+			}
+		}
+	}
+	return d, err
+}
+
+// product evaluates factors separated by * and /.
+func (e *amountExpr) product() (decimal.Decimal, error) {
+	d, err := e.factor()
+	for err == nil && (e.peek() == '*' || e.peek() == '/') {
+		op := e.peek()
+		e.n++
+		var f decimal.Decimal
+		if f, err = e.factor(); err != nil {
+			break
+		} else if op == '*' {
+			d = d.Mul(f)
+		} else if f.IsZero() {
+			err = fmt.Errorf("division by zero")
+		} else {
+			d = d.Div(f)
+		}
+	}
+	return d, err
+}
+
+// factor evaluates a decimal, a negated factor, or a parenthesized sum.
+func (e *amountExpr) factor() (decimal.Decimal, error) {
+	switch c := e.peek(); {
+	case c == '-':
+		e.n++
+		d, err := e.factor()
+		return d.Neg(), err
+	case c == '(':
+		e.n++
+		d, err := e.sum()
+		if err != nil {
+			return d, err
+		} else if e.peek() != ')' {
+			return d, fmt.Errorf("missing closing parenthesis in expression %v", e.s)
+		}
+		e.n++
+		return d, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := e.n
+		for c = e.peek(); c == '.' || (c >= '0' && c <= '9'); c = e.peek() {
+			e.n++
+		}
+		return decimal.NewFromString(e.s[start:e.n])
+	case c == 0:
+		return decimal.Decimal{}, fmt.Errorf("incomplete expression %v", e.s)
+	default:
+		return decimal.Decimal{}, fmt.Errorf("unexpected %q in expression %v", c, e.s)
+	}
+}
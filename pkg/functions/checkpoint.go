@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package functions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io/ioutil"
+	"sort"
+	"unicode/utf8"
+)
+
+// Checkpoint is the state of a Parser after parsing its whole input.
+// Parsers created by NewParserFromCheckpoint resume from Checkpoints
+// when their inputs start with the Checkpoints' inputs, such as when
+// transactions are appended to a ledger, so that they only parse
+// the rest of their inputs.
+//
+// Checkpoints can be marshaled as JSON except for their Contexts, which
+// the store package can marshal.
+type Checkpoint struct {
+	// Length is the length of the input in bytes, and Hash is
+	// the input's hexadecimal SHA-256 checksum.
+	Length int
+	Hash   string
+
+	// Included maps the absolute paths of the files included while
+	// parsing the input to their hexadecimal SHA-256 checksums.
+	// Checkpoints do not apply if any of the files changed.
+	Included map[string]string
+
+	// Context is the resulting Context.  Parsers resuming from
+	// the Checkpoint parse into copies of it.
+	Context *core.Context `json:"-"`
+
+	// Words are the words defined while parsing the input.
+	Words map[string]parser.Block
+
+	// Checksum is the state of the Parser's checksum.  See
+	// Parser.Checksum.
+	Checksum []byte
+}
+
+// applies returns true if c's input is a prefix of input and the files
+// that c's input included are unchanged.
+func (c *Checkpoint) applies(input []byte) bool {
+	if c.Length > len(input) || fmt.Sprintf("%x", sha256.Sum256(input[:c.Length])) != c.Hash {
+		return false
+	}
+	for path, sum := range c.Included {
+		content, err := ioutil.ReadFile(path)
+		if err != nil || fmt.Sprintf("%x", sha256.Sum256(content)) != sum {
+			return false
+		}
+	}
+	return true
+}
+
+// NewParserFromCheckpoint creates a Parser that parses input.  If cp is
+// not nil and applies to input, the Parser starts with cp's state and
+// parses only the rest of input, and NewParserFromCheckpoint returns true.
+// Otherwise, the Parser parses all of input.  The Parser's Checkpoint
+// method returns a Checkpoint for input.
+//
+// Clients must add the same Functions to the Parser as to the Parser
+// that created cp, but the Parser does not call them for the part
+// of input that cp covers.  Checkpoints therefore suit clients that
+// only need the final state of the Context.
+func NewParserFromCheckpoint(input []byte, cp *Checkpoint) (*Parser, bool) {
+	if cp != nil && cp.applies(input) {
+		if p, err := resume(input, cp); err == nil {
+			return p, true
+		}
+	}
+	p := NewParser(bytes.NewReader(input))
+	p.input = input
+	return p, false
+}
+
+// resume creates a Parser with cp's state that parses the rest of input.
+func resume(input []byte, cp *Checkpoint) (*Parser, error) {
+	if cp.Context == nil {
+		return nil, fmt.Errorf("checkpoint has no context")
+	}
+	p := NewParserWithContext(bytes.NewReader(input[cp.Length:]), cp.Context.Clone())
+	p.input = input
+	parsed := input[:cp.Length]
+	p.lexer.SetPosition(parser.Position{
+		Line:   uint64(bytes.Count(parsed, []byte("\n")) + 1),
+		Column: uint64(utf8.RuneCount(parsed[bytes.LastIndexByte(parsed, '\n')+1:]) + 1),
+		Offset: uint64(cp.Length)})
+	for path, sum := range cp.Included {
+		p.included[path] = sum
+		p.includes = append(p.includes, path)
+	}
+	sort.Strings(p.includes)
+	for name, b := range cp.Words {
+		p.parser.SetWord(name, b)
+	}
+	if err := p.sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(cp.Checksum); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Checkpoint returns a Checkpoint of p's state, which must follow
+// a successful call to Parse.  p must have been created by
+// NewParserFromCheckpoint.  Checkpoint returns nil if p's input does not
+// end with an unescaped newline, because appended input could then
+// continue the input's last token.
+func (p *Parser) Checkpoint() (*Checkpoint, error) {
+	if p.input == nil {
+		return nil, fmt.Errorf("parser was not created by NewParserFromCheckpoint")
+	} else if !bytes.HasSuffix(p.input, []byte("\n")) || p.pending != nil {
+		return nil, nil
+	}
+	backslashes := 0
+	for n := len(p.input) - 2; n >= 0 && p.input[n] == '\\'; n-- {
+		backslashes++
+	}
+	if backslashes%2 != 0 {
+		return nil, nil
+	}
+	sum, err := p.sum.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{
+		Length:   len(p.input),
+		Hash:     fmt.Sprintf("%x", sha256.Sum256(p.input)),
+		Included: map[string]string{},
+		Context:  p.ctx.Clone(),
+		Words:    p.parser.Words(),
+		Checksum: sum}
+	for path, sum := range p.included {
+		cp.Included[path] = sum
+	}
+	return cp, nil
+}
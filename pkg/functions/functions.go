@@ -35,28 +35,138 @@ import (
 	"strings"
 )
 
+// LanguageVersion is the version of the ledger language that the core
+// functions implement, formatted as MAJOR.MINOR.  The minor version
+// increases when the language gains features; the major version increases
+// when existing features change incompatibly.
+const LanguageVersion = "1.0"
+
 func GetCoreFunctions() map[string]Function {
 	return map[string]Function{
-		"add-notes":       AddNotesFunction,
-		"assert":          AssertFunction,
-		"assert-lot":      AssertLotFunction,
-		"assert-lots-sum": AssertLotsSumFunction,
-		"close":           CloseFunction,
-		"close-lot":       CloseLotFunction,
-		"comment":         CommentFunction,
-		"commodity":       CommodityFunction,
-		"create-lot":      CreateLotFunction,
-		"date":            DateFunction,
-		"lot":             LotFunction,
-		"open":            OpenFunction,
-		"set-comment":     SetCommentFunction,
-		"tag":             TagFunction,
-		"tag-commodity":   TagCommodityFunction,
-		"untag":           UntagFunction,
-		"xact":            XactFunction,     // TODO: test
-		"xfer":            XferFunction,     // TODO: test
-		"xfer-exch":       XferExchFunction, // TODO: test
+		"add":                 AddFunction,
+		"add-commodity-notes": AddCommodityNotesFunction,
+		"add-lot-notes":       AddLotNotesFunction,
+		"add-notes":           AddNotesFunction,
+		"alert":               AlertFunction,
+		"assert":              AssertFunction,
+		"assert-budget":       AssertBudgetFunction,
+		"assert-equation":     AssertEquationFunction,
+		"assert-lot":          AssertLotFunction,
+		"assert-lots-sum":     AssertLotsSumFunction,
+		"assert-note":         AssertNoteFunction,
+		"assert-tag":          AssertTagFunction,
+		"billing-rate":        BillingRateFunction,
+		"book":                BookFunction,
+		"budget":              BudgetFunction,
+		"checksum":            ChecksumFunction,
+		"close":               CloseFunction,
+		"close-lot":           CloseLotFunction,
+		"comment":             CommentFunction,
+		"commodity":           CommodityFunction,
+		"convert":             ConvertFunction,
+		"create-lot":          CreateLotFunction,
+		"date":                DateFunction,
+		"decimal-places":      DecimalPlacesFunction,
+		"display-format":      DisplayFormatFunction,
+		"div":                 DivFunction,
+		"entity":              EntityFunction,
+		"event":               EventFunction,
+		"freebean-version":    FreebeanVersionFunction,
+		"if-equal":            IfEqualFunction,
+		"if-zero":             IfZeroFunction,
+		"include":             IncludeFunction,
+		"lot":                 LotFunction,
+		"mul":                 MulFunction,
+		"neg":                 NegFunction,
+		"note-type":           NoteTypeFunction,
+		"open":                OpenFunction,
+		"pad":                 NewPadFunction(XactFunction),
+		"paid-by":             PaidByFunction,
+		"pragma":              PragmaFunction,
+		"price":               PriceFunction,
+		"recall":              RecallFunction,
+		"rename-account":      RenameAccountFunction,
+		"rounding-tolerance":  RoundingToleranceFunction,
+		"set-comment":         SetCommentFunction,
+		"set-document":        SetDocumentFunction,
+		"set-precision":       SetPrecisionFunction,
+		"share":               ShareFunction,
+		"smallest-unit":       SmallestUnitFunction,
+		"store":               StoreFunction,
+		"split-lot":           SplitLotFunction,
+		"sub":                 SubFunction,
+		"tag":                 TagFunction,
+		"tag-commodity":       TagCommodityFunction,
+		"tag-xact":            TagXactFunction,
+		"time-entry":          TimeEntryFunction,
+		"untag":               UntagFunction,
+		"when-tagged":         WhenTaggedFunction,
+		"xact":                XactFunction,     // TODO: test
+		"xfer":                XferFunction,     // TODO: test
+		"xfer-exch":           XferExchFunction, // TODO: test
+		"xfer-exch-total":     XferExchTotalFunction,
+		"xfer-reduce":         XferReduceFunction,
+	}
+}
+
+// checkAccountName checks that an account name starts with one of
+// the root account names.
+func checkAccountName(fn, an string, ctx *core.Context) error {
+	if err := ctx.Options.CheckAccountName(an); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	return nil
+}
+
+// popDecimals pops n decimal string operands.
+func popDecimals(fn string, op parser.Operands, n int) ([]decimal.Decimal, error) {
+	if op.Length() < n {
+		return nil, fmt.Errorf("%v: %v decimal operands required, but too few given", fn, n)
+	}
+	values := op.Pop(n)
+	decimals := make([]decimal.Decimal, n)
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v: non-string decimal operand: %v", fn, v)
+		}
+		d, err := ParseDecimal(s)
+		if err != nil {
+			return nil, fmt.Errorf("%v: illegal decimal value %v: %v", fn, s, err)
+		}
+		decimals[i] = d
+	}
+	return decimals, nil
+}
+
+// PopStrings pops one string operand for each of names, which describe
+// the operands in error messages, and returns them in order.  Clients
+// use it to implement Functions of their own.
+func PopStrings(fn string, op parser.Operands, names ...string) ([]string, error) {
+	if op.Length() < len(names) {
+		return nil, fmt.Errorf("%v: %v operands required, but too few given", fn, strings.Join(names, ", "))
+	}
+	values := op.Pop(len(names))
+	strs := make([]string, len(values))
+	for n, v := range values {
+		var ok bool
+		if strs[n], ok = v.(string); !ok {
+			return nil, fmt.Errorf("%v: non-string %v: %v", fn, names[n], v)
+		}
 	}
+	return strs, nil
+}
+
+// AddFunction pushes the sum of two decimals.
+//
+// Syntax: AMOUNT AMOUNT add -> AMOUNT
+func AddFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 2)
+	if err != nil {
+		return err
+	}
+	op.Push(d[0].Add(d[1]).String())
+	return nil
 }
 
 // AddNotesFunction adds notes to an account.
@@ -77,7 +187,7 @@ func AddNotesFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	}
 	values = op.Pop(len(values))
 	an := values[0].(string)
-	if a, ok := ctx.Accounts[an]; !ok {
+	if a, ok := ctx.Account(an); !ok {
 		return fmt.Errorf(`%v: nonexistent account: %v`, fn, an)
 	} else if a.IsClosed(ctx.Date) {
 		return fmt.Errorf(`%v: closed account: %v`, fn, an)
@@ -89,6 +199,115 @@ func AddNotesFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	return nil
 }
 
+// AddCommodityNotesFunction adds notes to a commodity.
+//
+// Syntax: COMMODITY (NOTE-NAME NOTE-VALUE)* add-commodity-notes ->
+func AddCommodityNotesFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	values := op.GetValues()
+	for n := len(values) - 1; n >= 0; n-- {
+		if _, ok := values[n].(string); !ok {
+			values = values[n+1 : len(values)]
+			break
+		}
+	}
+	if len(values) < 1 {
+		return fmt.Errorf(`%v: commodity name operand required, but no operands given`, fn)
+	} else if (len(values)-1)%2 != 0 {
+		return fmt.Errorf(`%v: note name and note value operand pairs required, but odd number of operands given`, fn)
+	}
+	values = op.Pop(len(values))
+	cn := values[0].(string)
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf(`%v: nonexistent commodity: %v`, fn, cn)
+	}
+	for n := 1; n < len(values); n += 2 {
+		c.Notes[values[n].(string)] = values[n+1].(string)
+	}
+	return nil
+}
+
+// AddLotNotesFunction adds notes to all of the commodities' lots with
+// the specified name within an account, such as broker confirmation
+// numbers or wash-sale flags.  The lot must exist.
+//
+// Syntax: ACCOUNT LOT (NOTE-NAME NOTE-VALUE)* add-lot-notes ->
+func AddLotNotesFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	values := op.GetValues()
+	for n := len(values) - 1; n >= 0; n-- {
+		if _, ok := values[n].(string); !ok {
+			values = values[n+1 : len(values)]
+			break
+		}
+	}
+	if len(values) < 2 {
+		return fmt.Errorf(`%v: account name and lot name operands required, but too few operands given`, fn)
+	} else if (len(values)-2)%2 != 0 {
+		return fmt.Errorf(`%v: note name and note value operand pairs required, but odd number of operands given`, fn)
+	}
+	values = op.Pop(len(values))
+	an, ln := values[0].(string), values[1].(string)
+	a, ok := ctx.Account(an)
+	if !ok {
+		return fmt.Errorf(`%v: nonexistent account: %v`, fn, an)
+	} else if a.IsClosed(ctx.Date) {
+		return fmt.Errorf(`%v: closed account: %v`, fn, an)
+	}
+	ctol, ok := a.Lots[ln]
+	if !ok || len(ctol) == 0 {
+		return fmt.Errorf(`%v: account %v does not have a lot named "%v"`, fn, an, ln)
+	}
+	for _, l := range ctol {
+		if l.Notes == nil {
+			l.Notes = map[string]string{}
+		}
+		for n := 2; n < len(values); n += 2 {
+			l.Notes[values[n].(string)] = values[n+1].(string)
+		}
+	}
+	return nil
+}
+
+// AlertFunction adds an Alert on an account's balance in a commodity.
+// The Alert is checked when the ledger has been parsed, not immediately.
+//
+// Syntax: ACCOUNT COMPARISON AMOUNT COMMODITY alert ->
+func AlertFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 4 {
+		return fmt.Errorf("%v: account name, comparison, amount, and commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(4)
+	var an, cmp, as, cn string
+	var ok bool
+	if an, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+	} else if cmp, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string comparison: %v", fn, values[1])
+	} else if as, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string amount: %v", fn, values[2])
+	} else if cn, ok = values[3].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[3])
+	}
+	if err := core.CheckComparison(cmp); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	q, err := ParseDecimal(as)
+	if err != nil {
+		return fmt.Errorf("%v: illegal decimal value %v: %v", fn, as, err)
+	}
+	var acct *core.Account
+	var c *core.Commodity
+	if acct, ok = ctx.Account(an); !ok {
+		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
+	} else if acct.IsClosed(ctx.Date) {
+		return fmt.Errorf("%v: closed account: %v", fn, an)
+	} else if c, ok = ctx.Commodity(cn); !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	ctx.Alerts = append(ctx.Alerts, core.Alert{Account: acct.Name, Comparison: cmp, Threshold: core.Quantity{Commodity: c, Amount: q}})
+	return nil
+}
+
 // AssertFunction asserts that the default lot within an account
 // has the specified balance.
 //
@@ -111,14 +330,15 @@ func AssertFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if cn, ok = values[2].(string); !ok {
 		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[2])
 	}
+	cn = ctx.Name(cn)
 	var acct *core.Account
 	var lots map[string]*core.Lot
 	var l *core.Lot
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
-	} else if _, ok = ctx.Commodities[cn]; !ok {
+	} else if _, ok = ctx.Commodity(cn); !ok {
 		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
 	} else if lots, ok = acct.Lots[""]; !ok {
 		return fmt.Errorf("%v: account %v does not have a default lot", fn, an)
@@ -132,6 +352,52 @@ func AssertFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	return nil
 }
 
+// AssertBudgetFunction asserts that an account's balance in a commodity
+// has not changed by more than its budget during the current budget period
+// so far.
+//
+// Syntax: ACCOUNT COMMODITY assert-budget ->
+func AssertBudgetFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: account name and commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var an, cn string
+	var ok bool
+	if an, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+	} else if cn, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[1])
+	}
+	cn = ctx.Name(cn)
+	acct, ok := ctx.Account(an)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
+	}
+	for n := range ctx.Budgets {
+		b := &ctx.Budgets[n]
+		if b.Account == acct.Name && b.Amount.Commodity.Name == cn {
+			if r := b.Current(ctx); r.Exceeded() {
+				return fmt.Errorf("%v: account %v changed by %v from %v through %v, exceeding its %v budget of %v", fn, an, r.Actual, r.First, ctx.Date, b.Period, r.Amount)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: account %v has no budget in %v", fn, an, cn)
+}
+
+// AssertEquationFunction asserts that the balances of all accounts' lots
+// of each commodity sum to zero, less the quantities of the commodity
+// that exchanges and stock splits created or consumed.
+//
+// Syntax: assert-equation ->
+func AssertEquationFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if err := ctx.CheckEquation(); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	return nil
+}
+
 // AssertLotFunction asserts that the specified lot within an account
 // has the specified balance.
 //
@@ -156,14 +422,15 @@ func AssertLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if cn, ok = values[3].(string); !ok {
 		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[3])
 	}
+	ln, cn = ctx.Name(ln), ctx.Name(cn)
 	var acct *core.Account
 	var lots map[string]*core.Lot
 	var l *core.Lot
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
-	} else if _, ok = ctx.Commodities[cn]; !ok {
+	} else if _, ok = ctx.Commodity(cn); !ok {
 		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
 	} else if lots, ok = acct.Lots[ln]; !ok {
 		return fmt.Errorf(`%v: account %v does not have a lot named "%v"`, fn, an, ln)
@@ -199,12 +466,13 @@ func AssertLotsSumFunction(fn string, op parser.Operands, ctx *core.Context) err
 	} else if cn, ok = values[2].(string); !ok {
 		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[2])
 	}
+	cn = ctx.Name(cn)
 	var acct *core.Account
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
-	} else if _, ok = ctx.Commodities[cn]; !ok {
+	} else if _, ok = ctx.Commodity(cn); !ok {
 		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
 	} else {
 		var sum decimal.Decimal
@@ -221,6 +489,179 @@ func AssertLotsSumFunction(fn string, op parser.Operands, ctx *core.Context) err
 	return nil
 }
 
+// AssertNoteFunction asserts that an account or, if there is no account
+// with the specified name, a commodity has a note with the specified value.
+// Accounts' inherited notes count if the account-inheritance option is set
+// (see PragmaFunction).
+//
+// Syntax: ACCOUNT-OR-COMMODITY NOTE VALUE assert-note ->
+func AssertNoteFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: account or commodity name, note name, and note value operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var name, nn, nv string
+	var ok bool
+	if name, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account or commodity name: %v", fn, values[0])
+	} else if nn, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string note name: %v", fn, values[1])
+	} else if nv, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string note value: %v", fn, values[2])
+	}
+	var notes map[string]string
+	if acct, ok := ctx.Account(name); ok {
+		notes = ctx.AccountNotes(acct)
+	} else if c, ok := ctx.Commodity(name); ok {
+		notes = c.Notes
+	} else {
+		return fmt.Errorf("%v: nonexistent account or commodity: %v", fn, name)
+	}
+	if v, ok := notes[nn]; !ok {
+		return fmt.Errorf(`%v: %v does not have a "%v" note`, fn, name, nn)
+	} else if v != nv {
+		return fmt.Errorf(`%v: %v's "%v" note is "%v", not "%v"`, fn, name, nn, v, nv)
+	}
+	return nil
+}
+
+// AssertTagFunction asserts that an account or, if there is no account
+// with the specified name, a commodity has a tag.  Tags without values,
+// such as "trip", match all of their keys' values, while key=value tags,
+// such as "trip=japan2024", match only the values.  Accounts' inherited
+// tags count if the account-inheritance option is set (see PragmaFunction).
+//
+// Syntax: ACCOUNT-OR-COMMODITY TAG assert-tag ->
+func AssertTagFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: account or commodity name and tag operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var name, tag string
+	var ok bool
+	if name, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account or commodity name: %v", fn, values[0])
+	} else if tag, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string tag: %v", fn, values[1])
+	}
+	if hasTag, err := accountOrCommodityHasTag(fn, name, tag, ctx); err != nil {
+		return err
+	} else if !hasTag {
+		return fmt.Errorf("%v: %v does not have tag %v", fn, name, tag)
+	}
+	return nil
+}
+
+// accountOrCommodityHasTag returns true if the account or, if there is
+// no account with the specified name, the commodity has a tag.
+func accountOrCommodityHasTag(fn, name, tag string, ctx *core.Context) (bool, error) {
+	tag = ctx.Name(tag)
+	if acct, ok := ctx.Account(name); ok {
+		return ctx.AccountHasTag(acct, tag), nil
+	} else if c, ok := ctx.Commodity(name); ok {
+		return c.HasTag(tag), nil
+	}
+	return false, fmt.Errorf("%v: nonexistent account or commodity: %v", fn, name)
+}
+
+// BillingRateFunction sets the rate at which a client is billed for each
+// unit of time worked on a project, starting on the current date.
+// An empty PROJECT sets the rate for the client's projects that have
+// no rates of their own.  See TimeEntryFunction.
+//
+// Syntax: CLIENT PROJECT AMOUNT COMMODITY billing-rate ->
+func BillingRateFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 4 {
+		return fmt.Errorf("%v: client, project, amount, and commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(4)
+	var client, project, amount, cn string
+	var ok bool
+	if client, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string client: %v", fn, values[0])
+	} else if project, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string project: %v", fn, values[1])
+	} else if amount, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string amount: %v", fn, values[2])
+	} else if cn, ok = values[3].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[3])
+	} else if len(client) == 0 {
+		return fmt.Errorf("%v: empty client name", fn)
+	}
+	rate, err := ParseAmount(amount)
+	if err != nil || rate.IsNegative() {
+		return fmt.Errorf("%v: illegal rate: %v", fn, amount)
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	ctx.BillingRates = append(ctx.BillingRates, core.BillingRate{Date: ctx.Date, Client: client, Project: project, Rate: core.Quantity{Commodity: c, Amount: rate}})
+	return nil
+}
+
+// BookFunction activates a book, so that accounts opened afterward belong
+// to it.  Books partition a ledger's accounts, such as into personal and
+// business accounts, and reports can be restricted to one of them.
+// Transactions may transfer between accounts in different books.
+// An empty name activates the default book.
+//
+// Syntax: NAME book ->
+func BookFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 1 {
+		return fmt.Errorf("%v: book name operand required, but none given", fn)
+	}
+	name, ok := op.Pop(1)[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string book name: %v", fn, name)
+	}
+	ctx.Book = name
+	return nil
+}
+
+// BudgetFunction sets the amount by which an account's balance in
+// a commodity should change during each monthly, quarterly, or yearly
+// period, starting with the period containing the current date.  Positive
+// amounts are maximum increases, such as spending on an expense account,
+// and negative amounts are maximum decreases.  Changes before the budget
+// is first set do not count against it.  Setting the budget again changes
+// its amount, but not its period, starting with the current period.
+//
+// Syntax: ACCOUNT PERIOD AMOUNT COMMODITY budget ->
+func BudgetFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 4 {
+		return fmt.Errorf("%v: account name, period, amount, and commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(4)
+	var an, period, as, cn string
+	var ok bool
+	if an, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+	} else if period, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string period: %v", fn, values[1])
+	} else if as, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string amount: %v", fn, values[2])
+	} else if cn, ok = values[3].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[3])
+	}
+	q, err := ParseDecimal(as)
+	if err != nil {
+		return fmt.Errorf("%v: illegal decimal value %v: %v", fn, as, err)
+	}
+	var acct *core.Account
+	var c *core.Commodity
+	if acct, ok = ctx.Account(an); !ok {
+		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
+	} else if acct.IsClosed(ctx.Date) {
+		return fmt.Errorf("%v: closed account: %v", fn, an)
+	} else if c, ok = ctx.Commodity(cn); !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	} else if err = ctx.SetBudget(acct.Name, period, core.Quantity{Commodity: c, Amount: q}); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	return nil
+}
+
 // CloseFunction closes an account.
 //
 // Syntax: NAME close ->
@@ -235,7 +676,7 @@ func CloseFunction(fn string, op parser.Operands, ctx *core.Context) error {
 		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
 	}
 	var acct *core.Account
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: account is already closed: %v", fn, an)
@@ -268,9 +709,10 @@ func CloseLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if ln, ok = values[1].(string); !ok {
 		return fmt.Errorf("%v: non-string lot name: %v", fn, values[1])
 	}
+	ln = ctx.Name(ln)
 	var acct *core.Account
 	var lots map[string]*core.Lot
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
@@ -286,7 +728,9 @@ func CloseLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	return nil
 }
 
-// CommentFunction pops a string comment from the operand stack.
+// CommentFunction pops a string comment from the operand stack.  Comments
+// that begin with number signs (see parser.Lexer) are simpler, but unlike
+// them, comment strings are tokens that checksums cover.
 //
 // Syntax: STRING comment ->
 func CommentFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -315,10 +759,13 @@ func CommodityFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if d, ok = values[1].(string); !ok {
 		return fmt.Errorf("%v: non-string description: %v", fn, values[1])
 	}
-	if _, ok = ctx.Commodities[cn]; ok {
+	cn = ctx.DeclareName(cn)
+	if _, ok = ctx.Commodity(cn); ok {
 		return fmt.Errorf("%v: commodity already exists: %v", fn, cn)
 	}
-	ctx.Commodities[cn] = core.NewCommodity(cn, d, ctx.Date)
+	c := core.NewCommodity(cn, d, ctx.Date)
+	c.DecimalPlaces = ctx.Options.DecimalPlaces
+	ctx.Commodities[cn] = c
 	return nil
 }
 
@@ -340,6 +787,7 @@ func CreateLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if ln, ok = values[1].(string); !ok {
 		return fmt.Errorf("%v: non-string lot name: %v", fn, values[1])
 	}
+	ln = ctx.Name(ln)
 	var ctolots map[string]*core.Lot
 	if t.Account.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: transfer refers to closed account: %v", fn, t.Account.Name)
@@ -354,8 +802,48 @@ func CreateLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	return nil
 }
 
+// ConvertFunction converts a quantity to another commodity using
+// the most recent price on or before the current date and pushes
+// the converted quantity.  It fails if no such price is known.
+//
+// Syntax: AMOUNT COMMODITY TO-COMMODITY convert -> AMOUNT TO-COMMODITY
+func ConvertFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: amount, commodity, and target commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var amount, cn, tn string
+	var ok bool
+	if amount, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string amount: %v", fn, values[0])
+	} else if cn, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[1])
+	} else if tn, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string target commodity name: %v", fn, values[2])
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity %v", fn, cn)
+	}
+	to, ok := ctx.Commodity(tn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity %v", fn, tn)
+	}
+	a, err := ParseDecimal(amount)
+	if err != nil {
+		return fmt.Errorf("%v: illegal amount %v: %v", fn, amount, err)
+	}
+	q, err := ctx.Prices.Convert(core.Quantity{Commodity: c, Amount: a}, to, ctx.Date)
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	op.Push(q.Amount.String(), to.Name)
+	return nil
+}
+
 // DateFunction sets the interpreter's current date.  It returns an error
-// if the date jumps back in time.
+// if the date is not a valid calendar date (see core.NewDate) or if it
+// jumps back in time.
 //
 // Syntax: YEAR MONTH DAY date ->
 func DateFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -381,83 +869,801 @@ func DateFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	} else if dy, err = strconv.ParseInt(day, 10, 32); err != nil {
 		return fmt.Errorf("%v: illegal day %v: %v", fn, day, err)
 	}
-	d := core.Date{int(y), int(m), int(dy)}
-	if ctx.Date.After(d) {
+	d, err := core.NewDate(int(y), int(m), int(dy))
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	} else if ctx.Date.After(d) {
 		return fmt.Errorf("%v: specified date %v is before current date %v", fn, d, ctx.Date)
 	}
+	previous := ctx.Date
 	ctx.Date = d
+	ctx.UpdateBudgets()
+	ctx.UpdateBalanceCheckpoints(previous)
 	return nil
 }
 
-// LotFunction adds a lot name to a Transfer object on the operand stack.
-// It asserts that the lot already exists.
+// DecimalPlacesFunction sets the maximum number of decimal places
+// in transferred amounts of a commodity, including exchanges' total prices
+// but not their unit prices.  Transfers with more decimal places fail,
+// which catches amounts that would leave balances that no assertion
+// could match.  See also SetPrecisionFunction.
 //
-// Syntax: Transfer LOT lot -> Transfer
-func LotFunction(fn string, op parser.Operands, ctx *core.Context) error {
+// Syntax: COMMODITY PLACES decimal-places ->
+func DecimalPlacesFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	if op.Length() < 2 {
-		return fmt.Errorf("%v: transfer and lot name operands are required, but too few given", fn)
+		return fmt.Errorf("%v: commodity and decimal places operands required, but too few given", fn)
 	}
 	values := op.Pop(2)
-	var t *Transfer
-	var ln string
+	cn, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	}
+	ps, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string decimal places: %v", fn, values[1])
+	}
+	places, err := strconv.ParseInt(ps, 10, 32)
+	if err != nil || places < 0 {
+		return fmt.Errorf("%v: illegal decimal places: %v", fn, ps)
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	c.DecimalPlaces = int32(places)
+	return nil
+}
+
+// DisplayFormatFunction sets an option of the format in which reports
+// display amounts of a commodity.  The options are:
+//
+//	prefix               a symbol that precedes amounts, such as "$"
+//	suffix               a symbol that follows amounts, such as "€"
+//	thousands-separator  a string that separates groups of three digits,
+//	                     such as ","
+//	places               the number of decimal places to which amounts
+//	                     are rounded or padded or "none" (the default)
+//	                     to pad them to the commodity's maximum number
+//	                     of decimal places without rounding
+//
+// Values may be empty strings.  Amounts are followed by a space and
+// the commodity's name unless the commodity has a prefix or suffix.
+// For example, after "USD prefix $ display-format" and
+// "USD thousands-separator , display-format", reports display
+// 1234.5 USD as "$1,234.5".
+//
+// Syntax: COMMODITY OPTION VALUE display-format ->
+func DisplayFormatFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: commodity, option name, and value operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var cn, name, value string
 	var ok bool
-	if t, ok = values[0].(*Transfer); !ok {
-		return fmt.Errorf("%v: operand is not a transfer: %v", fn, values[0])
-	} else if ln, ok = values[1].(string); !ok {
-		return fmt.Errorf("%v: non-string lot name: %v", fn, values[1])
-	} else if t.Account.IsClosed(ctx.Date) {
-		return fmt.Errorf("%v: transfer refers to closed account: %v", fn, t.Account.Name)
-	} else if _, ok = t.Account.Lots[ln]; !ok {
-		return fmt.Errorf(`%v: account %v does not have a lot named "%v"`, fn, t.Account.Name, ln)
+	if cn, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	} else if name, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string option name: %v", fn, values[1])
+	} else if value, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string option value: %v", fn, values[2])
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	switch name {
+	case "prefix":
+		c.Display.Prefix = value
+	case "suffix":
+		c.Display.Suffix = value
+	case "thousands-separator":
+		c.Display.ThousandsSeparator = value
+	case "places":
+		if value == "none" {
+			c.Display.FixedDecimalPlaces, c.Display.DecimalPlaces = false, 0
+		} else if places, err := strconv.ParseInt(value, 10, 32); err != nil || places < 0 {
+			return fmt.Errorf("%v: illegal decimal places: %v", fn, value)
+		} else {
+			c.Display.FixedDecimalPlaces, c.Display.DecimalPlaces = true, int32(places)
+		}
+	default:
+		return fmt.Errorf("%v: unknown option: %v", fn, name)
 	}
-	t.LotName = ln
-	op.Push(t)
 	return nil
 }
 
-// OpenFunction opens an account.  It returns an error if the specified account
-// already exists and is open.
+// DivFunction pushes the quotient of two decimals.  Quotients that do not
+// terminate are rounded to 16 decimal places.
 //
-// Syntax: NAME COMMODITY* open ->
-func OpenFunction(fn string, op parser.Operands, ctx *core.Context) error {
+// Syntax: DIVIDEND DIVISOR div -> AMOUNT
+func DivFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 2)
+	if err != nil {
+		return err
+	} else if d[1].IsZero() {
+		return fmt.Errorf("%v: division by zero", fn)
+	}
+	op.Push(d[0].Div(d[1]).String())
+	return nil
+}
+
+// parseLanguageVersion parses a MAJOR.MINOR or MAJOR language version.
+func parseLanguageVersion(v string) (major, minor int, err error) {
+	parts := strings.SplitN(v, ".", 2)
+	if major, err = strconv.Atoi(parts[0]); err != nil || major < 0 {
+		return 0, 0, fmt.Errorf("illegal language version: %v", v)
+	} else if len(parts) == 2 {
+		if minor, err = strconv.Atoi(parts[1]); err != nil || minor < 0 {
+			return 0, 0, fmt.Errorf("illegal language version: %v", v)
+		}
+	}
+	return major, minor, nil
+}
+
+// EntityFunction declares an entity, such as a payee, with a canonical
+// name and aliases, such as the names that appear in bank statements.
+// Transactions whose entities are aliases get the canonical names instead,
+// so reports group them together.  Declaring an entity again adds aliases.
+// Once a ledger declares entities, strict mode (see PragmaFunction)
+// rejects transactions with undeclared entities.
+//
+// Syntax: NAME ALIAS* entity ->
+func EntityFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	values := op.GetValues()
 	for n := len(values) - 1; n >= 0; n-- {
 		if _, ok := values[n].(string); !ok {
-			values = values[n+1 : len(values)]
+			values = values[n+1:]
 			break
 		}
 	}
 	if len(values) < 1 {
-		return fmt.Errorf("%v: no operands given", fn)
+		return fmt.Errorf("%v: entity name operand required, but none given", fn)
 	}
 	values = op.Pop(len(values))
-	an := values[0].(string)
-	if !strings.HasPrefix(an, "Assets:") && !strings.HasPrefix(an, "Liabilities:") && !strings.HasPrefix(an, "Income:") && !strings.HasPrefix(an, "Expenses:") && !strings.HasPrefix(an, "Equity:") && an != "Equity" {
-		return fmt.Errorf(`%v: account does not start with "Assets:", "Liabilities:", "Income:", "Expenses:", or "Equity:", and is not named "Equity": %v`, fn, an)
-	}
-	var acct *core.Account
-	if acct, ok := ctx.Accounts[an]; ok {
-		if !acct.IsClosed(ctx.Date) {
-			return fmt.Errorf("%v: account already exists: %v", fn, an)
-		}
+	name := values[0].(string)
+	if len(name) == 0 {
+		return fmt.Errorf("%v: empty entity name", fn)
+	} else if canonical, ok := ctx.Entities[name]; ok && canonical != name {
+		return fmt.Errorf("%v: %v is an alias of entity %v", fn, name, canonical)
 	}
-	acct = core.NewAccount(an, ctx.Date)
-	for _, cn := range values[1:] {
-		cname := cn.(string)
-		if c, ok := ctx.Commodities[cname]; ok {
-			acct.Commodities[cname] = c
-		} else {
-			return fmt.Errorf("%v: nonexistent commodity %v", fn, cname)
+	ctx.Entities[name] = name
+	for _, v := range values[1:] {
+		alias := v.(string)
+		if canonical, ok := ctx.Entities[alias]; ok && canonical != name {
+			return fmt.Errorf("%v: %v is already entity %v or one of its aliases", fn, alias, canonical)
 		}
+		ctx.Entities[alias] = name
 	}
-	ctx.Accounts[an] = acct
 	return nil
 }
 
-// SetCommentFunction sets a Transfer's comment.
+// EventFunction records an event with the specified name and value
+// on the current date.
 //
-// Syntax: Transfer COMMENT set-comment -> Transfer
-func SetCommentFunction(fn string, op parser.Operands, ctx *core.Context) error {
+// Syntax: NAME VALUE event ->
+func EventFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: name and value operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var name, value string
+	var ok bool
+	if name, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string name: %v", fn, values[0])
+	} else if value, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string value: %v", fn, values[1])
+	} else if len(name) == 0 {
+		return fmt.Errorf("%v: empty name", fn)
+	}
+	ctx.Events = append(ctx.Events, core.Event{Date: ctx.Date, Name: name, Value: value})
+	return nil
+}
+
+// FreebeanVersionFunction declares the language version that a ledger
+// requires.  It fails if LanguageVersion has a different major version
+// or an older minor version, so ledgers fail early and clearly rather
+// than on unknown functions or changed behavior.
+//
+// Syntax: VERSION freebean-version ->
+func FreebeanVersionFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 1 {
+		return fmt.Errorf("%v: version operand required, but none given", fn)
+	}
+	v, ok := op.Pop(1)[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string version: %v", fn, v)
+	}
+	major, minor, err := parseLanguageVersion(v)
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	curMajor, curMinor, _ := parseLanguageVersion(LanguageVersion)
+	if major != curMajor || minor > curMinor {
+		return fmt.Errorf("%v: ledger requires language version %v, but this version of Freebean supports version %v", fn, v, LanguageVersion)
+	}
+	return nil
+}
+
+// ChecksumFunction asserts the checksum of the content parsed so far.
+// Only Parsers can compute checksums, so this function always returns
+// an error; Parser.AddCoreFunctions replaces it with one that checks
+// the tokens that the Parser has lexed.  See Parser.Checksum.
+//
+// Syntax: CHECKSUM checksum ->
+func ChecksumFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	return fmt.Errorf("%v: checksums require a Parser", fn)
+}
+
+// IfEqualFunction evaluates the rest of the enclosing parentheses only if
+// two operands are equal.  Operands that are both decimals are compared
+// as such, so "1.50" equals "1.5"; other operands are compared as strings.
+// For example, this pads an account only if a stored variable is "yes":
+//
+//	(padding recall yes if-equal
+//		Assets:Checking Equity:Opening-Balances 100 USD pad)
+//
+// Syntax: ( VALUE VALUE if-equal ... )
+func IfEqualFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: two operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var a, b string
+	var ok bool
+	if a, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string operand: %v", fn, values[0])
+	} else if b, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string operand: %v", fn, values[1])
+	}
+	equal := a == b
+	if da, err := ParseDecimal(a); err == nil {
+		if db, err := ParseDecimal(b); err == nil {
+			equal = da.Equal(db)
+		}
+	}
+	if !equal {
+		return parser.SkipGroup
+	}
+	return nil
+}
+
+// IfZeroFunction evaluates the rest of the enclosing parentheses only if
+// a decimal is zero.
+//
+// Syntax: ( AMOUNT if-zero ... )
+func IfZeroFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 1)
+	if err != nil {
+		return err
+	} else if !d[0].IsZero() {
+		return parser.SkipGroup
+	}
+	return nil
+}
+
+// IncludeFunction parses a ledger file into the Context using the core
+// functions.  Relative paths are resolved against the working directory.
+// Parser.AddCoreFunctions replaces this function with one that parses
+// files with the Parser itself.
+//
+// Syntax: PATH include ->
+func IncludeFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	p := NewParserWithContext(nil, ctx)
+	p.AddCoreFunctions()
+	p.installFunctions()
+	if err := p.include(fn, op, ctx); err != nil {
+		return err
+	}
+	return p.parser.Finish()
+}
+
+// LotFunction adds a lot name to a Transfer object on the operand stack.
+// It asserts that the lot already exists.
+//
+// Syntax: Transfer LOT lot -> Transfer
+func LotFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: transfer and lot name operands are required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var t *Transfer
+	var ln string
+	var ok bool
+	if t, ok = values[0].(*Transfer); !ok {
+		return fmt.Errorf("%v: operand is not a transfer: %v", fn, values[0])
+	} else if ln, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string lot name: %v", fn, values[1])
+	}
+	ln = ctx.Name(ln)
+	if t.Account.IsClosed(ctx.Date) {
+		return fmt.Errorf("%v: transfer refers to closed account: %v", fn, t.Account.Name)
+	} else if _, ok = t.Account.Lots[ln]; !ok {
+		return fmt.Errorf(`%v: account %v does not have a lot named "%v"`, fn, t.Account.Name, ln)
+	}
+	t.LotName = ln
+	op.Push(t)
+	return nil
+}
+
+// MulFunction pushes the product of two decimals.
+//
+// Syntax: AMOUNT AMOUNT mul -> AMOUNT
+func MulFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 2)
+	if err != nil {
+		return err
+	}
+	op.Push(d[0].Mul(d[1]).String())
+	return nil
+}
+
+// NegFunction pushes the negation of a decimal.
+//
+// Syntax: AMOUNT neg -> AMOUNT
+func NegFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 1)
+	if err != nil {
+		return err
+	}
+	op.Push(d[0].Neg().String())
+	return nil
+}
+
+// OpenFunction opens an account.  It returns an error if the specified account
+// already exists and is open.
+//
+// The account belongs to the active book (see BookFunction).
+//
+// Syntax: NAME COMMODITY* open ->
+func OpenFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	values := op.GetValues()
+	for n := len(values) - 1; n >= 0; n-- {
+		if _, ok := values[n].(string); !ok {
+			values = values[n+1 : len(values)]
+			break
+		}
+	}
+	if len(values) < 1 {
+		return fmt.Errorf("%v: no operands given", fn)
+	}
+	values = op.Pop(len(values))
+	an := ctx.DeclareName(values[0].(string))
+	if err := checkAccountName(fn, an, ctx); err != nil {
+		return err
+	} else if ctx.Options.Strict && len(values) == 1 {
+		return fmt.Errorf("%v: no commodities given for account %v in strict mode", fn, an)
+	}
+	var acct *core.Account
+	if acct, ok := ctx.Account(an); ok {
+		if !acct.IsClosed(ctx.Date) {
+			return fmt.Errorf("%v: account already exists: %v", fn, an)
+		} else if ctx.Accounts[an] == acct {
+			ctx.ClosedAccounts = append(ctx.ClosedAccounts, acct)
+		}
+	}
+	delete(ctx.AccountAliases, an)
+	acct = core.NewAccount(an, ctx.Date)
+	acct.Book = ctx.Book
+	for _, cn := range values[1:] {
+		cname := cn.(string)
+		if c, ok := ctx.Commodity(cname); ok {
+			acct.Commodities[c.Name] = c
+		} else {
+			return fmt.Errorf("%v: nonexistent commodity %v", fn, cname)
+		}
+	}
+	ctx.Accounts[an] = acct
+	return nil
+}
+
+// NewPadFunction returns a Function that brings an account's default lot
+// to a target balance by transferring the difference from another account.
+// It generates the transaction's operands and passes them to xact, which
+// should parse and execute them like XactFunction.  The function does
+// nothing if the default lot already has the target balance.
+//
+// Syntax: ACCOUNT TARGET-ACCOUNT AMOUNT COMMODITY pad ->
+func NewPadFunction(xact Function) Function {
+	return func(fn string, op parser.Operands, ctx *core.Context) error {
+		if op.Length() < 4 {
+			return fmt.Errorf("%v: account name, target account name, amount, and commodity operands required, but too few given", fn)
+		}
+		values := op.Pop(4)
+		var an, tn, as, cn string
+		var ok bool
+		if an, ok = values[0].(string); !ok {
+			return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+		} else if tn, ok = values[1].(string); !ok {
+			return fmt.Errorf("%v: non-string target account name: %v", fn, values[1])
+		} else if as, ok = values[2].(string); !ok {
+			return fmt.Errorf("%v: non-string amount: %v", fn, values[2])
+		} else if cn, ok = values[3].(string); !ok {
+			return fmt.Errorf("%v: non-string commodity name: %v", fn, values[3])
+		}
+		cn = ctx.Name(cn)
+		q, err := ParseDecimal(as)
+		if err != nil {
+			return fmt.Errorf("%v: illegal decimal value %v: %v", fn, as, err)
+		}
+		var acct, target *core.Account
+		var c *core.Commodity
+		if acct, ok = ctx.Account(an); !ok {
+			return fmt.Errorf("%v: nonexistent account: %v", fn, an)
+		} else if target, ok = ctx.Account(tn); !ok {
+			return fmt.Errorf("%v: nonexistent account: %v", fn, tn)
+		} else if acct == target {
+			return fmt.Errorf("%v: account %v cannot be padded from itself", fn, an)
+		} else if c, ok = ctx.Commodity(cn); !ok {
+			return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+		}
+		difference := q
+		if l, ok := acct.Lots[""][cn]; ok {
+			difference = q.Sub(l.Balance.Amount)
+		}
+		if difference.IsZero() {
+			return nil
+		}
+		xop := op.Nested()
+		xop.Push(
+			"pad",
+			fmt.Sprintf("Pad %v to %v %v", an, q, cn),
+			&Transfer{Account: acct, Quantity: core.Quantity{Commodity: c, Amount: difference}},
+			&Transfer{Account: target, Quantity: core.Quantity{Commodity: c, Amount: difference.Neg()}})
+		err = xact("xact", xop, ctx)
+		xop.Pop(xop.Length())
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		return nil
+	}
+}
+
+// NoteTypeFunction declares the type of the values of the transaction
+// notes with the specified name: string, decimal, date (formatted
+// "YYYY-MM-DD"), or bool ("true" or "false").  Transactions with notes
+// whose values are not of their declared types fail.  Reports that filter
+// transactions by notes compare declared types' values as such; they
+// infer the types of other notes' values.  Quote "date" because it is
+// also the name of a function.
+//
+// Syntax: NOTE-NAME TYPE note-type ->
+func NoteTypeFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: note name and type operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	name, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string note name: %v", fn, values[0])
+	}
+	typ, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string note type: %v", fn, values[1])
+	} else if err := core.CheckNoteType(typ); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	ctx.NoteTypes[name] = typ
+	return nil
+}
+
+// PaidByFunction names the person who paid a Transfer.
+//
+// Syntax: Transfer PERSON paid-by -> Transfer
+func PaidByFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: transfer and person operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	if t, ok := values[0].(*Transfer); !ok {
+		return fmt.Errorf("%v: not a transfer: %v", fn, values[0])
+	} else if person, ok := values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string person: %v", fn, values[1])
+	} else if len(person) == 0 {
+		return fmt.Errorf("%v: empty person name", fn)
+	} else {
+		t.Payer = person
+		op.Push(t)
+	}
+	return nil
+}
+
+// PragmaFunction sets a ledger option.  The options are:
+//
+//	strict          "true" requires open calls to list the commodities
+//	                that accounts may hold and, if the ledger declares
+//	                entities (see EntityFunction), transactions' entities
+//	                to be declared; "false" (the default) doesn't
+//	account-roots   space-separated root account names (by default,
+//	                "Assets Liabilities Income Expenses Equity")
+//	default-decimal-places
+//	                the maximum number of decimal places of commodities
+//	                created afterwards, or "none" (the default); see
+//	                the decimal-places function
+//	unit-price-decimal-places
+//	                the number of decimal places to which xfer-exch-total
+//	                rounds unit prices, or "none" (the default)
+//	account-inheritance
+//	                "true" makes accounts inherit the tags and notes of
+//	                their ancestors (for example, Expenses:Travel:Lodging
+//	                inherits those of Expenses:Travel) in assertions and
+//	                queries; "false" (the default) doesn't
+//	journal         "true" records executed transactions in the Context's
+//	                journal; "false" (the default) doesn't
+//	balance-checkpoints
+//	                "true" records the accounts' balances at the end of
+//	                each month in the Context; "false" (the default)
+//	                doesn't
+//	lot-reduction   the strategy by which xfer-reduce picks lots: "fifo"
+//	                (the default), "lifo", or "average"
+//	rounding-account
+//	                the account that receives rounding transfers (see
+//	                the rounding-tolerance function) or "none" (the
+//	                default)
+//	unicode-normalization
+//	                "nfc" (the default) converts the names of accounts,
+//	                commodities, lots, and tags to Unicode normalization
+//	                form C, so that names that look alike are the same;
+//	                "warn" does so and warns about each converted name
+//	                when it is declared, which helps migrate ledgers;
+//	                "none" leaves names as written.  Other strings, such
+//	                as descriptions, are always left as written
+//
+// Syntax: OPTION VALUE pragma ->
+func PragmaFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: option name and value operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	name, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string option name: %v", fn, values[0])
+	}
+	value, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string option value: %v", fn, values[1])
+	}
+	switch name {
+	case "strict":
+		strict, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`%v: strict is not "true" or "false": %v`, fn, value)
+		}
+		ctx.Options.Strict = strict
+	case "account-roots":
+		roots := strings.Fields(value)
+		if len(roots) == 0 {
+			return fmt.Errorf("%v: no account roots given", fn)
+		}
+		for _, root := range roots {
+			if strings.Contains(root, ":") {
+				return fmt.Errorf("%v: account root contains a colon: %v", fn, root)
+			}
+		}
+		ctx.Options.AccountRoots = roots
+	case "default-decimal-places":
+		if value == "none" {
+			ctx.Options.DecimalPlaces = -1
+		} else if places, err := strconv.ParseInt(value, 10, 32); err != nil || places < 0 {
+			return fmt.Errorf("%v: illegal decimal places: %v", fn, value)
+		} else {
+			ctx.Options.DecimalPlaces = int32(places)
+		}
+	case "unit-price-decimal-places":
+		if value == "none" {
+			ctx.Options.UnitPriceDecimalPlaces = -1
+		} else if places, err := strconv.ParseInt(value, 10, 32); err != nil || places < 0 {
+			return fmt.Errorf("%v: illegal decimal places: %v", fn, value)
+		} else {
+			ctx.Options.UnitPriceDecimalPlaces = int32(places)
+		}
+	case "account-inheritance":
+		inherit, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`%v: account-inheritance is not "true" or "false": %v`, fn, value)
+		}
+		ctx.Options.AccountInheritance = inherit
+	case "journal":
+		keep, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`%v: journal is not "true" or "false": %v`, fn, value)
+		}
+		ctx.Options.KeepJournal = keep
+	case "balance-checkpoints":
+		record, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf(`%v: balance-checkpoints is not "true" or "false": %v`, fn, value)
+		}
+		ctx.Options.BalanceCheckpoints = record
+	case "lot-reduction":
+		if err := core.CheckLotReduction(value); err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		ctx.Options.LotReduction = value
+	case "rounding-account":
+		if value == "none" {
+			ctx.Options.RoundingAccount = ""
+		} else if err := ctx.Options.CheckAccountName(value); err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		} else {
+			ctx.Options.RoundingAccount = value
+		}
+	case "unicode-normalization":
+		if err := core.CheckUnicodeNormalization(value); err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		ctx.Options.UnicodeNormalization = value
+	default:
+		return fmt.Errorf("%v: unknown option: %v", fn, name)
+	}
+	return nil
+}
+
+// PriceFunction records a commodity's price on the current date.
+//
+// Syntax: COMMODITY AMOUNT QUOTE-COMMODITY price ->
+func PriceFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: commodity, amount, and quote commodity operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var cn, amount, qn string
+	var ok bool
+	if cn, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	} else if amount, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string amount: %v", fn, values[1])
+	} else if qn, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string quote commodity name: %v", fn, values[2])
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity %v", fn, cn)
+	}
+	q, ok := ctx.Commodity(qn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity %v", fn, qn)
+	} else if c == q {
+		return fmt.Errorf("%v: commodity %v cannot be priced in itself", fn, cn)
+	}
+	a, err := ParseDecimal(amount)
+	if err != nil {
+		return fmt.Errorf("%v: illegal amount %v: %v", fn, amount, err)
+	}
+	ctx.Prices.Add(core.Price{Date: ctx.Date, Commodity: c, Price: core.Quantity{Commodity: q, Amount: a}})
+	return nil
+}
+
+// RecallFunction pushes the value of a variable set by the store function.
+//
+// Syntax: NAME recall -> VALUE
+func RecallFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 1 {
+		return fmt.Errorf("%v: variable name operand required, but none given", fn)
+	}
+	name, ok := op.Pop(1)[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string variable name: %v", fn, name)
+	}
+	value, ok := ctx.Variables[name]
+	if !ok {
+		return fmt.Errorf("%v: nonexistent variable %v", fn, name)
+	}
+	op.Push(value)
+	return nil
+}
+
+// RenameAccountFunction renames an account.  MODE is "alias", which makes
+// the old name an alias of the new name, or "strict", which makes later
+// uses of the old name fail.  Tags and alerts follow the account.
+//
+// Syntax: ACCOUNT NEW-NAME MODE rename-account ->
+func RenameAccountFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: account name, new account name, and mode operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var an, newName, mode string
+	var ok bool
+	if an, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+	} else if newName, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string new account name: %v", fn, values[1])
+	} else if mode, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string mode: %v", fn, values[2])
+	} else if mode != "alias" && mode != "strict" {
+		return fmt.Errorf(`%v: mode is not "alias" or "strict": %v`, fn, mode)
+	}
+	newName = ctx.DeclareName(newName)
+	if err := checkAccountName(fn, newName, ctx); err != nil {
+		return err
+	} else if err := ctx.RenameAccount(an, newName, mode == "alias"); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	return nil
+}
+
+// RoundingToleranceFunction sets the largest amount of a commodity by
+// which transactions' transfers may fail to balance.  Transactions that
+// are off by no more than the tolerance get rounding transfers to
+// the rounding-account account (see PragmaFunction) and warnings rather
+// than failing, which eases importing bank data that rounds each line.
+// A zero tolerance, the default, requires transactions to balance exactly.
+//
+// Syntax: COMMODITY AMOUNT rounding-tolerance ->
+func RoundingToleranceFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: commodity and tolerance operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	cn, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	}
+	ts, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string tolerance: %v", fn, values[1])
+	}
+	tolerance, err := ParseDecimal(ts)
+	if err != nil || tolerance.IsNegative() {
+		return fmt.Errorf("%v: illegal tolerance: %v", fn, ts)
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	c.RoundingTolerance = tolerance
+	return nil
+}
+
+// ShareFunction splits a Transfer's quantity among people in proportion
+// to their weights, replacing any previous shares.  Each person owes
+// their share to the Transfer's payer (see PaidByFunction).
+//
+// Syntax: Transfer (PERSON WEIGHT)+ share -> Transfer
+func ShareFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	values := op.GetValues()
+	for n := len(values) - 1; n >= 0; n-- {
+		if _, ok := values[n].(string); !ok {
+			values = values[n:]
+			break
+		}
+	}
+	if len(values) < 3 {
+		return fmt.Errorf("%v: transfer and person and weight operands required, but too few given", fn)
+	} else if (len(values)-1)%2 != 0 {
+		return fmt.Errorf("%v: person and weight operand pairs required, but odd number of operands given", fn)
+	}
+	t, ok := values[0].(*Transfer)
+	if !ok {
+		return fmt.Errorf("%v: not a transfer: %v", fn, values[0])
+	}
+	var shares []Share
+	seen := map[string]bool{}
+	for n := 1; n < len(values); n += 2 {
+		person, weight := values[n].(string), values[n+1].(string)
+		w, err := ParseDecimal(weight)
+		if err != nil {
+			return fmt.Errorf("%v: illegal weight %v: %v", fn, weight, err)
+		} else if !w.IsPositive() {
+			return fmt.Errorf("%v: nonpositive weight for %v: %v", fn, person, weight)
+		} else if len(person) == 0 {
+			return fmt.Errorf("%v: empty person name", fn)
+		} else if seen[person] {
+			return fmt.Errorf("%v: duplicate person: %v", fn, person)
+		}
+		seen[person] = true
+		shares = append(shares, Share{Person: person, Weight: w})
+	}
+	op.Pop(len(values))
+	t.Shares = shares
+	op.Push(t)
+	return nil
+}
+
+// SetCommentFunction sets a Transfer's comment.
+//
+// Syntax: Transfer COMMENT set-comment -> Transfer
+func SetCommentFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	if op.Length() < 2 {
 		return fmt.Errorf(`%v: transfer and comment string operands required, but too few given`, fn)
 	}
@@ -473,7 +1679,201 @@ func SetCommentFunction(fn string, op parser.Operands, ctx *core.Context) error
 	return nil
 }
 
-// TagFunction tags an account.
+// SetDocumentFunction sets the path or URL of a document supporting
+// a Transfer, such as a receipt, replacing any previous one.  Relative
+// paths are relative to the directory that the documents subcommand is
+// told to check.  Documents supporting whole transactions are the values
+// of their "document" notes instead.
+//
+// Syntax: Transfer DOCUMENT set-document -> Transfer
+func SetDocumentFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf(`%v: transfer and document string operands required, but too few given`, fn)
+	}
+	values := op.Pop(2)
+	if t, ok := values[0].(*Transfer); !ok {
+		return fmt.Errorf("%v: not a transfer: %v", fn, values[0])
+	} else if document, ok := values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string document: %v", fn, values[1])
+	} else if len(document) == 0 {
+		return fmt.Errorf("%v: empty document", fn)
+	} else {
+		t.Document = document
+		op.Push(t)
+	}
+	return nil
+}
+
+// SetPrecisionFunction sets the maximum number of decimal places in
+// transferred amounts of a commodity, like DecimalPlacesFunction, and
+// the rounding mode that applies to amounts with more decimal places:
+//
+//	reject     transfers fail, as with decimal-places
+//	half-up    amounts are rounded, with halves rounded away from zero
+//	half-even  amounts are rounded, with halves rounded to even digits
+//	truncate   amounts are rounded toward zero
+//
+// PLACES may be "none" to remove the maximum, in which case MODE must still
+// be valid but has no effect.  Amounts of the commodity are formatted with
+// at least PLACES decimal places.
+//
+// Syntax: COMMODITY PLACES MODE set-precision ->
+func SetPrecisionFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: commodity, decimal places, and rounding mode operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var cn, ps, mode string
+	var ok bool
+	if cn, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	} else if ps, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string decimal places: %v", fn, values[1])
+	} else if mode, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string rounding mode: %v", fn, values[2])
+	}
+	places := int64(-1)
+	if ps != "none" {
+		var err error
+		if places, err = strconv.ParseInt(ps, 10, 32); err != nil || places < 0 {
+			return fmt.Errorf("%v: illegal decimal places: %v", fn, ps)
+		}
+	}
+	if err := core.CheckRoundingMode(mode); err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	c.DecimalPlaces = int32(places)
+	c.Rounding = mode
+	return nil
+}
+
+// SmallestUnitFunction sets the smallest transferable amount of
+// a commodity, such as 1 for JPY or 0.00000001 for BTC.  Transfers
+// whose amounts are not multiples of it fail, so that amounts that
+// cannot change hands, such as 0.03 CHF when the smallest coin is
+// 0.05 CHF, are caught.  Exchanges' total prices are not checked.
+// A zero amount removes the smallest unit.  See also
+// DecimalPlacesFunction.
+//
+// Syntax: COMMODITY AMOUNT smallest-unit ->
+func SmallestUnitFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: commodity and smallest unit operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	cn, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[0])
+	}
+	us, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string smallest unit: %v", fn, values[1])
+	}
+	unit, err := ParseDecimal(us)
+	if err != nil || unit.IsNegative() {
+		return fmt.Errorf("%v: illegal smallest unit: %v", fn, us)
+	}
+	c, ok := ctx.Commodity(cn)
+	if !ok {
+		return fmt.Errorf("%v: nonexistent commodity: %v", fn, cn)
+	}
+	c.SmallestUnit = unit
+	return nil
+}
+
+// SplitLotFunction splits a lot's balance of a commodity by the ratio
+// NEW:OLD, such as 2:1 for a 2-for-1 stock split.  The lot's unit price
+// is divided by the same ratio, so its total price (the cost basis)
+// is preserved.
+//
+// Syntax: ACCOUNT LOT COMMODITY NEW OLD split-lot ->
+func SplitLotFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 5 {
+		return fmt.Errorf("%v: account name, lot name, commodity, and two ratio operands required, but too few given", fn)
+	}
+	values := op.Pop(5)
+	var an, ln, cn string
+	var ok bool
+	if an, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account name: %v", fn, values[0])
+	} else if ln, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string lot name: %v", fn, values[1])
+	} else if cn, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string commodity name: %v", fn, values[2])
+	}
+	ln, cn = ctx.Name(ln), ctx.Name(cn)
+	ratio := make([]decimal.Decimal, 2)
+	for n, v := range values[3:] {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v: non-string ratio operand: %v", fn, v)
+		}
+		d, err := ParseDecimal(s)
+		if err != nil {
+			return fmt.Errorf("%v: illegal decimal value %v: %v", fn, s, err)
+		} else if !d.IsPositive() {
+			return fmt.Errorf("%v: nonpositive ratio operand: %v", fn, s)
+		}
+		ratio[n] = d
+	}
+	var acct *core.Account
+	var lot *core.Lot
+	if acct, ok = ctx.Account(an); !ok {
+		return fmt.Errorf("%v: nonexistent account: %v", fn, an)
+	} else if acct.IsClosed(ctx.Date) {
+		return fmt.Errorf("%v: closed account: %v", fn, an)
+	} else if lot, ok = acct.Lots[ln][cn]; !ok {
+		return fmt.Errorf(`%v: lot "%v" in account %v does not have %v`, fn, ln, an, cn)
+	}
+	balance := lot.Balance
+	lot.Split(ratio[0], ratio[1])
+	ctx.Convert(balance, lot.Balance)
+	return nil
+}
+
+// StoreFunction sets a variable in the Context to a string value, replacing
+// the variable's previous value, if any.  The recall function pushes
+// the value.
+//
+// Syntax: VALUE NAME store ->
+func StoreFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: value and variable name operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	value, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string value: %v", fn, values[0])
+	}
+	name, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string variable name: %v", fn, values[1])
+	} else if len(name) == 0 {
+		return fmt.Errorf("%v: empty variable name", fn)
+	}
+	ctx.Variables[name] = value
+	return nil
+}
+
+// SubFunction pushes the difference of two decimals.
+//
+// Syntax: MINUEND SUBTRAHEND sub -> AMOUNT
+func SubFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	d, err := popDecimals(fn, op, 2)
+	if err != nil {
+		return err
+	}
+	op.Push(d[0].Sub(d[1]).String())
+	return nil
+}
+
+// TagFunction tags an account.  Tags may be plain names, such as "bank",
+// or key=value pairs, such as "trip=japan2024" (see core.TagTarget).
+// Tagging an account with a key that it already has replaces the key's value.
 //
 // Syntax: ACCOUNT TAG+ tag ->
 func TagFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -491,14 +1891,17 @@ func TagFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	an := values[0].(string)
 	var acct *core.Account
 	var ok bool
-	if acct, ok = ctx.Accounts[an]; !ok {
+	if acct, ok = ctx.Account(an); !ok {
 		return fmt.Errorf("%v: tagging nonexistent account: %v", fn, an)
 	} else if acct.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
 	}
 	for _, t := range values[1:] {
-		tag := t.(string)
-		if tts, ok := ctx.Tags[tag]; ok {
+		tag := ctx.DeclareName(t.(string))
+		key, _, _ := core.SplitTag(tag)
+		if len(key) == 0 {
+			return fmt.Errorf("%v: tag without a key: %v", fn, tag)
+		} else if tts, ok := ctx.Tags[key]; ok {
 			found := false
 			for _, tagged := range tts {
 				if tagged == acct {
@@ -507,17 +1910,17 @@ func TagFunction(fn string, op parser.Operands, ctx *core.Context) error {
 				}
 			}
 			if !found {
-				ctx.Tags[tag] = append(tts, acct)
+				ctx.Tags[key] = append(tts, acct)
 			}
 		} else {
-			ctx.Tags[tag] = []core.TagTarget{acct}
+			ctx.Tags[key] = []core.TagTarget{acct}
 		}
 		acct.AddTag(tag)
 	}
 	return nil
 }
 
-// TagCommodityFunction tags a commodity.
+// TagCommodityFunction tags a commodity.  See TagFunction.
 //
 // Syntax: COMMODITY TAG+ tag-commodity ->
 func TagCommodityFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -535,12 +1938,15 @@ func TagCommodityFunction(fn string, op parser.Operands, ctx *core.Context) erro
 	cn := values[0].(string)
 	var c *core.Commodity
 	var ok bool
-	if c, ok = ctx.Commodities[cn]; !ok {
+	if c, ok = ctx.Commodity(cn); !ok {
 		return fmt.Errorf("%v: tagging nonexistent commodity: %v", fn, cn)
 	}
 	for _, t := range values[1:] {
-		tag := t.(string)
-		if tts, ok := ctx.Tags[tag]; ok {
+		tag := ctx.DeclareName(t.(string))
+		key, _, _ := core.SplitTag(tag)
+		if len(key) == 0 {
+			return fmt.Errorf("%v: tag without a key: %v", fn, tag)
+		} else if tts, ok := ctx.Tags[key]; ok {
 			found := false
 			for _, tagged := range tts {
 				if tagged == c {
@@ -549,17 +1955,76 @@ func TagCommodityFunction(fn string, op parser.Operands, ctx *core.Context) erro
 				}
 			}
 			if !found {
-				ctx.Tags[tag] = append(tts, c)
+				ctx.Tags[key] = append(tts, c)
 			}
 		} else {
-			ctx.Tags[tag] = []core.TagTarget{c}
+			ctx.Tags[key] = []core.TagTarget{c}
 		}
 		c.AddTag(tag)
 	}
 	return nil
 }
 
-// UntagFunction untags an account.
+// TagXactFunction pushes a transaction's tags, which may be plain names
+// or key=value pairs like those of accounts (see TagFunction), onto
+// the operand stack.  Its result follows the transaction's transfers.
+//
+// Syntax: TAG+ tag-xact -> TransactionTags
+func TagXactFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	values := op.GetValues()
+	for n := len(values) - 1; n >= 0; n-- {
+		if _, ok := values[n].(string); !ok {
+			values = values[n+1 : len(values)]
+			break
+		}
+	}
+	if len(values) < 1 {
+		return fmt.Errorf("%v: at least one tag operand required, but none given", fn)
+	}
+	values = op.Pop(len(values))
+	tags := make(TransactionTags, len(values))
+	for n, v := range values {
+		tags[n] = ctx.Name(v.(string))
+		if key, _, _ := core.SplitTag(tags[n]); len(key) == 0 {
+			return fmt.Errorf("%v: tag without a key: %v", fn, tags[n])
+		}
+	}
+	op.Push(tags)
+	return nil
+}
+
+// TimeEntryFunction marks a Transfer of a unit commodity, such as hours,
+// as time worked for a client on a project.  The timesheet subcommand
+// summarizes these transfers and values them at the clients' billing
+// rates (see BillingRateFunction).  Mark only one side of each entry,
+// such as the transfer to the account that accumulates time worked.
+//
+// Syntax: Transfer CLIENT PROJECT time-entry -> Transfer
+func TimeEntryFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: transfer, client, and project operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	var t *Transfer
+	var client, project string
+	var ok bool
+	if t, ok = values[0].(*Transfer); !ok {
+		return fmt.Errorf("%v: not a transfer: %v", fn, values[0])
+	} else if client, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string client: %v", fn, values[1])
+	} else if project, ok = values[2].(string); !ok {
+		return fmt.Errorf("%v: non-string project: %v", fn, values[2])
+	} else if len(client) == 0 || len(project) == 0 {
+		return fmt.Errorf("%v: empty client or project name", fn)
+	}
+	t.Client, t.Project = client, project
+	op.Push(t)
+	return nil
+}
+
+// UntagFunction untags an account.  Tags without values remove their
+// keys regardless of their values, and key=value tags remove their keys
+// only if they have the values.
 //
 // Syntax: ACCOUNT TAG+ untag ->
 func UntagFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -575,14 +2040,18 @@ func UntagFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	}
 	values = op.Pop(len(values))
 	an := values[0].(string)
-	if a, ok := ctx.Accounts[an]; !ok {
+	if a, ok := ctx.Account(an); !ok {
 		return fmt.Errorf("%v: tagging nonexistent account: %v", fn, an)
 	} else if a.IsClosed(ctx.Date) {
 		return fmt.Errorf("%v: closed account: %v", fn, an)
 	} else {
 		for _, t := range values[1:] {
-			tag := t.(string)
-			if tts, ok := ctx.Tags[tag]; ok {
+			tag := ctx.Name(t.(string))
+			if !a.HasTag(tag) {
+				continue
+			}
+			key, _, _ := core.SplitTag(tag)
+			if tts, ok := ctx.Tags[key]; ok {
 				n := len(tts)
 				for m := 0; m < n; {
 					if tts[m] == a {
@@ -594,9 +2063,9 @@ func UntagFunction(fn string, op parser.Operands, ctx *core.Context) error {
 				}
 				tts = tts[:n]
 				if len(tts) != 0 {
-					ctx.Tags[tag] = tts
+					ctx.Tags[key] = tts
 				} else {
-					delete(ctx.Tags, tag)
+					delete(ctx.Tags, key)
 				}
 			}
 			a.RemoveTag(tag)
@@ -605,9 +2074,34 @@ func UntagFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	return nil
 }
 
-// XactFunction effects a series of transfers.
+// WhenTaggedFunction evaluates the rest of the enclosing parentheses only
+// if an account or, if there is no account with the specified name,
+// a commodity has a tag.  Tags match as they do in AssertTagFunction.
+//
+// Syntax: ( ACCOUNT-OR-COMMODITY TAG when-tagged ... )
+func WhenTaggedFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: account or commodity name and tag operands required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	var name, tag string
+	var ok bool
+	if name, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string account or commodity name: %v", fn, values[0])
+	} else if tag, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string tag: %v", fn, values[1])
+	}
+	if hasTag, err := accountOrCommodityHasTag(fn, name, tag, ctx); err != nil {
+		return err
+	} else if !hasTag {
+		return parser.SkipGroup
+	}
+	return nil
+}
+
+// XactFunction effects a series of transfers.  See ParseTransaction.
 //
-// Syntax: ENTITY DESCRIPTION Transfer+ (NOTE-NAME NOTE-VALUE)* xact ->
+// Syntax: ENTITY DESCRIPTION Transfer+ TransactionTags? (NOTE-NAME NOTE-VALUE)* xact ->
 func XactFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	t, err := ParseTransaction(op, ctx)
 	if err == nil {
@@ -622,6 +2116,8 @@ func XactFunction(fn string, op parser.Operands, ctx *core.Context) error {
 
 // XferFunction pushes a Transfer object onto the operand stack.
 // It does not create an exchange rate and it targets the default lot.
+// AMOUNT may be an arithmetic expression, such as "3*19.99", which saves
+// adding up receipts by hand.  See ParseAmount.
 //
 // Syntax: ACCOUNT AMOUNT COMMODITY xfer -> Transfer
 func XferFunction(fn string, op parser.Operands, ctx *core.Context) error {
@@ -635,7 +2131,8 @@ func XferFunction(fn string, op parser.Operands, ctx *core.Context) error {
 }
 
 // XferExchFunction pushes a Transfer object onto the operand stack with an
-// exchange rate.
+// exchange rate.  Like XferFunction's, its amounts may be arithmetic
+// expressions.
 //
 // Syntax: ACCOUNT AMOUNT COMMODITY UNIT-AMOUNT UNIT-COMMODITY
 // TOTAL-AMOUNT TOTAL-COMMODITY xfer-exch -> Transfer
@@ -648,3 +2145,39 @@ func XferExchFunction(fn string, op parser.Operands, ctx *core.Context) error {
 	}
 	return err
 }
+
+// XferExchTotalFunction pushes a Transfer object onto the operand stack
+// with an exchange rate computed from the total price, as on brokerage
+// statements that only give totals.  The unit price is the total price
+// divided by the amount, rounded according to the unit-price-decimal-places
+// option (see PragmaFunction).
+//
+// Syntax: ACCOUNT AMOUNT COMMODITY TOTAL-AMOUNT TOTAL-COMMODITY
+// xfer-exch-total -> Transfer
+func XferExchTotalFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	t, err := ParseTransferWithTotalPrice(op, ctx)
+	if err == nil {
+		op.Push(t)
+	} else {
+		err = fmt.Errorf("%v: %v", fn, err)
+	}
+	return err
+}
+
+// XferReduceFunction pushes Transfer objects that reduce an account's lots
+// of a commodity, such as when selling shares, and realize the gain or
+// loss.  The lot-reduction option (see PragmaFunction) chooses the lots.
+// See ParseLotReduction.
+//
+// Syntax: ACCOUNT AMOUNT COMMODITY UNIT-AMOUNT UNIT-COMMODITY
+// GAINS-ACCOUNT xfer-reduce -> Transfer+
+func XferReduceFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	transfers, err := ParseLotReduction(op, ctx)
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	for _, t := range transfers {
+		op.Push(t)
+	}
+	return nil
+}
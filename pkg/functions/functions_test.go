@@ -27,13 +27,18 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package functions
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/parser"
 	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -58,6 +63,75 @@ func TestAddCoreFunctions(t *testing.T) {
 	}
 }
 
+func TestArithmeticFunctions(t *testing.T) {
+	p := createParser(`
+		1.5 2.25 add test-check
+		1.5 2.25 sub test-check
+		1.5 -2 mul test-check
+		100 8 div test-check
+		1 3 div test-check
+		-7.5 neg test-check
+		10 3 div 3 mul test-check`)
+	var results []string
+	p.Functions["test-check"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		results = append(results, op.Pop(1)[0].(string))
+		return nil
+	}
+	if e := p.Parse(); e != nil {
+		t.Fatalf("arithmetic functions failed: %v", e)
+	}
+	expected := "3.75, -0.75, -3, 12.5, 0.3333333333333333, 7.5, 9.9999999999999999"
+	if s := strings.Join(results, ", "); s != expected {
+		t.Errorf("arithmetic functions pushed %v instead of %v", s, expected)
+	}
+}
+
+func TestArithmeticFunctions_InTransfers(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Expenses:Food open
+		Entity Description
+			Expenses:Food 90 3 div USD xfer
+			Assets:Account 90 3 div neg USD xfer
+			xact
+		Expenses:Food 30 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("arithmetic functions failed: %v", e)
+	}
+}
+
+func TestAddFunction_TooFewOperands(t *testing.T) {
+	p := createParser(`1 add`)
+	if p.Parse() == nil {
+		t.Errorf("add function succeeded but should have failed")
+	}
+}
+
+func TestAddFunction_IllegalDecimal(t *testing.T) {
+	p := createParser(`1 abc add`)
+	if p.Parse() == nil {
+		t.Errorf("add function succeeded but should have failed")
+	}
+}
+
+func TestDivFunction_DivisionByZero(t *testing.T) {
+	p := createParser(`1 0 div`)
+	if p.Parse() == nil {
+		t.Errorf("div function succeeded but should have failed")
+	}
+}
+
+func TestNegFunction_NonStringOperand(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account 1 USD xfer neg`)
+	if p.Parse() == nil {
+		t.Errorf("neg function succeeded but should have failed")
+	}
+}
+
 func TestAddNotesFunction(t *testing.T) {
 	p := createParser(`
 		(2000 1 1 date
@@ -194,6 +268,84 @@ func TestAddNotesFunction_DuplicateNotes(t *testing.T) {
 	}
 }
 
+func TestAlertFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Equity open
+		Entity Description
+			Assets:Checking 400 USD xfer
+			Equity -400 USD xfer
+			xact
+		Assets:Checking < 500 USD alert
+		Assets:Checking >= 1000 USD alert
+		Equity != 0 USD alert`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("alert function failed: %v", e)
+	}
+	expected := "Assets:Checking < 500 USD (balance 400 USD); Equity != 0 USD (balance -400 USD)"
+	if s := strings.Join(p.Context().TriggeredAlerts(), "; "); s != expected {
+		t.Errorf("alert function triggered the wrong alerts: %v", s)
+	}
+}
+
+func TestAlertFunction_CheckedAtEnd(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Equity open
+		Assets:Checking < 500 USD alert
+		Entity Description
+			Assets:Checking 600 USD xfer
+			Equity -600 USD xfer
+			xact`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("alert function failed: %v", e)
+	} else if alerts := p.Context().TriggeredAlerts(); len(alerts) != 0 {
+		t.Errorf("alert function triggered alerts: %v", alerts)
+	}
+}
+
+func TestAlertFunction_TooFewOperands(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Checking open
+		Assets:Checking 500 USD alert`)
+	if p.Parse() == nil {
+		t.Errorf("alert function succeeded but should have failed")
+	}
+}
+
+func TestAlertFunction_InvalidComparison(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Checking open
+		Assets:Checking << 500 USD alert`)
+	if p.Parse() == nil {
+		t.Errorf("alert function succeeded but should have failed")
+	}
+}
+
+func TestAlertFunction_NonexistentAccount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Checking < 500 USD alert`)
+	if p.Parse() == nil {
+		t.Errorf("alert function succeeded but should have failed")
+	}
+}
+
+func TestAlertFunction_NonexistentCommodity(t *testing.T) {
+	p := createParser(`
+		Assets:Checking open
+		Assets:Checking < 500 USD alert`)
+	if p.Parse() == nil {
+		t.Errorf("alert function succeeded but should have failed")
+	}
+}
+
 func TestAssertFunction(t *testing.T) {
 	p := createParser(`
 		2000 1 1 date
@@ -1018,6 +1170,86 @@ func TestCommodityFunction_ExistingCommodity(t *testing.T) {
 	}
 }
 
+func TestConvertFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		VTI 100 USD price
+		2000 1 2 date
+		VTI 150 USD price
+		10 VTI USD convert test-check-converted
+		300 USD VTI convert test-check-converted
+		7 USD USD convert test-check-converted`)
+	var converted []string
+	p.Functions["test-check-converted"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		values := op.Pop(2)
+		converted = append(converted, fmt.Sprintf("%v %v", values[0], values[1]))
+		return nil
+	}
+	if e := p.Parse(); e != nil {
+		t.Fatalf("convert failed: %v", e)
+	} else if s := strings.Join(converted, ", "); s != "1500 USD, 2 VTI, 7 USD" {
+		t.Errorf("convert pushed the wrong quantities: %v", s)
+	}
+}
+
+func TestConvertFunction_WithAssert(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		Assets:Account open
+		Equity open
+		Entity Description
+			Assets:Account 15 VTI 100 USD 1500 USD xfer-exch
+			Equity -1500 USD xfer
+			xact
+		Equity -15 VTI USD convert assert`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("convert failed: %v", e)
+	}
+}
+
+func TestConvertFunction_NoPrice(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		10 VTI USD convert`)
+	if p.Parse() == nil {
+		t.Errorf("convert function succeeded but should have failed")
+	}
+}
+
+func TestConvertFunction_TooFewOperands(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		VTI USD convert`)
+	if p.Parse() == nil {
+		t.Errorf("convert function succeeded but should have failed")
+	}
+}
+
+func TestConvertFunction_NonexistentCommodity(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		10 VTI USD convert`)
+	if p.Parse() == nil {
+		t.Errorf("convert function succeeded but should have failed")
+	}
+}
+
+func TestConvertFunction_IllegalAmount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		VTI 100 USD price
+		abc VTI USD convert`)
+	if p.Parse() == nil {
+		t.Errorf("convert function succeeded but should have failed")
+	}
+}
+
 func TestCreateLotFunction_LotExistsWithCommodity(t *testing.T) {
 	p := createParser(`
 		2000 1 1 date
@@ -1214,6 +1446,33 @@ func TestDateFunction_InvalidDay(t *testing.T) {
 	}
 }
 
+func TestDateFunction_InvalidCalendarDates(t *testing.T) {
+	for _, program := range []string{
+		`2000 13 1 date`,
+		`2000 0 1 date`,
+		`2000 1 45 date`,
+		`2000 1 0 date`,
+		`2000 4 31 date`,
+		`1900 2 29 date`,
+		`2001 2 29 date`,
+		`0 1 1 date`,
+	} {
+		if p := createParser(program); p.Parse() == nil {
+			t.Errorf(`"%v" succeeded but should have failed`, program)
+		}
+	}
+}
+
+func TestDateFunction_LeapYears(t *testing.T) {
+	p := createParser(`
+		1996 2 29 date
+		2000 2 29 date
+		2004 2 29 date`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("date function failed: %v", e)
+	}
+}
+
 func TestDateFunction_DateGoesBackwardsInTime(t *testing.T) {
 	p := createParser(`
 		2000 1 1 date
@@ -1520,96 +1779,444 @@ func TestOpenFunction_ClosedAccount(t *testing.T) {
 	}
 }
 
-func TestSetCommentFunction(t *testing.T) {
-	checkComment := func(fn string, op parser.Operands, ctx *core.Context) error {
-		if op.Length() != 1 {
-			t.Errorf("set-comment did not leave exactly one operand on the stack, left %v", op.Length())
-			return fmt.Errorf("test failed")
-		}
-		values := op.Pop(1)
-		if xfer, ok := values[0].(*Transfer); !ok {
-			t.Errorf("set-comment did not push a *Transfer onto the stack, pushed %v", values[0])
-			return fmt.Errorf("test failed")
-		} else if xfer.Comment != "test comment" {
-			t.Errorf("set-comment did not set the Transfer's comment correctly, set: %v", xfer.Comment)
-			return fmt.Errorf("test failed")
+func TestOpenFunction_ClosedAccountKeepsTags(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		Assets:Account open
+		Assets:Account old tag
+		2000 1 2 date
+		Assets:Account close
+		2000 1 3 date
+		Assets:Account open
+		Assets:Account new tag`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	ctx := p.Context().Clone()
+	if len(ctx.ClosedAccounts) != 1 {
+		t.Fatalf("open kept %v closed accounts instead of 1", len(ctx.ClosedAccounts))
+	} else if closed := ctx.ClosedAccounts[0]; !closed.HasTag("old") || closed.HasTag("new") {
+		t.Errorf("open kept a closed account with the wrong tags: %v", closed.GetTags())
+	} else if tts := ctx.Tags["old"]; len(tts) != 1 || tts[0] != closed {
+		t.Errorf("open did not keep the closed account's tag: %v", tts)
+	} else if ctx.Accounts["Assets:Account"].HasTag("old") {
+		t.Errorf("open created an account with the closed account's tags")
+	}
+}
+
+func TestOpenFunction_AccountHistory(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		Assets:Account open
+		Assets:Account bank BigBank add-notes
+		2000 1 2 date
+		Assets:Account close
+		2000 1 3 date
+		Assets:Account open
+		2000 1 4 date
+		Assets:Account close
+		2000 1 5 date
+		Assets:Account open`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	ctx := p.Context()
+	history := ctx.AccountHistory("Assets:Account")
+	if len(history) != 3 || history[2] != ctx.Accounts["Assets:Account"] {
+		t.Fatalf("AccountHistory returned the wrong accounts: %v", history)
+	} else if history[0].Notes["bank"] != "BigBank" {
+		t.Errorf("AccountHistory's first account lost its notes: %v", history[0].Notes)
+	}
+	expected := []core.AccountPeriod{
+		{OpeningDate: core.Date{Year: 2000, Month: 1, Day: 1}, ClosingDate: core.Date{Year: 2000, Month: 1, Day: 2}},
+		{OpeningDate: core.Date{Year: 2000, Month: 1, Day: 3}, ClosingDate: core.Date{Year: 2000, Month: 1, Day: 4}},
+		{OpeningDate: core.Date{Year: 2000, Month: 1, Day: 5}},
+	}
+	if periods := ctx.AccountPeriods("Assets:Account"); !reflect.DeepEqual(periods, expected) {
+		t.Errorf("AccountPeriods returned the wrong periods: %v", periods)
+	}
+	for date, n := range map[core.Date]int{
+		{Year: 2000, Month: 1, Day: 1}: 0,
+		{Year: 2000, Month: 1, Day: 3}: 1,
+		{Year: 2000, Month: 1, Day: 6}: 2,
+	} {
+		if a, ok := ctx.AccountOn("Assets:Account", date); !ok || a != history[n] {
+			t.Errorf("AccountOn(%v) returned the wrong account: %v", date, a)
 		}
-		return nil
 	}
+	if _, ok := ctx.AccountOn("Assets:Account", core.Date{Year: 2000, Month: 1, Day: 4}); ok {
+		t.Errorf("AccountOn returned an account on a day when it was closed")
+	} else if len(ctx.AccountHistory("Assets:Nothing")) != 0 {
+		t.Errorf("AccountHistory returned accounts for a nonexistent account")
+	}
+}
+
+func TestPadFunction(t *testing.T) {
 	p := createParser(`
-		(2000 1 1 date
+		2000 1 1 date
 		USD Dollar commodity
-		Assets:Account open)
-		Assets:Account 5 USD xfer
-		"test comment" set-comment
-		test-check-comment`)
-	p.Functions["test-check-comment"] = checkComment
+		Assets:Account open
+		Equity open
+		Entity Description
+			Assets:Account 10 USD xfer
+			Equity -10 USD xfer
+			xact
+		Assets:Account Equity 25.5 USD pad
+		Assets:Account 25.5 USD assert
+		Equity -25.5 USD assert
+		Assets:Account Equity 25.5 USD pad
+		Assets:Account 25.5 USD assert`)
 	if e := p.Parse(); e != nil {
-		t.Errorf("set-comment failed: %v", e)
+		t.Errorf("pad function failed: %v", e)
 	}
 }
 
-func TestSetCommentFunction_TooFewOperands(t *testing.T) {
-	for _, prog := range []string{`set-comment`, `Assets:Account set-comment`} {
-		p := createParser(prog)
-		if p.Parse() == nil {
-			t.Errorf("set-comment succeeded but should have failed for program: %v", prog)
+func TestPadFunction_EmptyAccount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Equity open
+		Assets:Account Equity -3 USD pad
+		Assets:Account -3 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("pad function failed: %v", e)
+	}
+}
+
+func TestPadFunction_UsesXactFunction(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Equity open
+		(Assets:Account Equity 5 USD pad)`)
+	var xacts []Transaction
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := ParseTransaction(op, ctx)
+		if err == nil {
+			err = xact.Execute(ctx)
 		}
+		xacts = append(xacts, xact)
+		return err
+	}
+	if e := p.Parse(); e != nil {
+		t.Fatalf("pad function failed: %v", e)
+	} else if len(xacts) != 1 {
+		t.Fatalf("pad called xact %v times instead of once", len(xacts))
+	} else if xacts[0].Transfers[0].Account.Name != "Assets:Account" || xacts[0].Transfers[1].Quantity.String() != "-5 USD" {
+		t.Errorf("pad generated the wrong transfers: %v, %v", xacts[0].Transfers[0].Account.Name, xacts[0].Transfers[1].Quantity)
 	}
 }
 
-func TestSetCommentFunction_NonTransferOperand(t *testing.T) {
-	p := createParser(`"foo transfer" "overwritten comment" set-comment`)
+func TestPadFunction_TooFewOperands(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account 5 USD pad`)
 	if p.Parse() == nil {
-		t.Errorf("set-comment succeeded but should have failed")
+		t.Errorf("pad function succeeded but should have failed")
 	}
 }
 
-func TestSetCommentFunction_NonStringComment(t *testing.T) {
+func TestPadFunction_NonexistentTargetAccount(t *testing.T) {
 	p := createParser(`
-		(2000 1 1 date
 		USD Dollar commodity
-		Assets:Account open)
-		Assets:Account 5 USD xfer
-		123 atoi set-comment`)
-	p.Functions["atoi"] = atoi
+		Assets:Account open
+		Assets:Account Equity 5 USD pad`)
 	if p.Parse() == nil {
-		t.Errorf("set-comment succeeded but should have failed")
+		t.Errorf("pad function succeeded but should have failed")
 	}
 }
 
-func TestSetCommentFunction_Repeated(t *testing.T) {
-	checkComment := func(fn string, op parser.Operands, ctx *core.Context) error {
+func TestPadFunction_SameAccount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account Assets:Account 5 USD pad`)
+	if p.Parse() == nil {
+		t.Errorf("pad function succeeded but should have failed")
+	}
+}
+
+func TestPadFunction_IllegalAmount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Equity open
+		Assets:Account Equity abc USD pad`)
+	if p.Parse() == nil {
+		t.Errorf("pad function succeeded but should have failed")
+	}
+}
+
+func TestPaidByFunction(t *testing.T) {
+	checkPayer := func(fn string, op parser.Operands, ctx *core.Context) error {
 		if op.Length() != 1 {
-			t.Errorf("set-comment did not leave exactly one operand on the stack, left %v", op.Length())
+			t.Errorf("paid-by did not leave exactly one operand on the stack, left %v", op.Length())
 			return fmt.Errorf("test failed")
-		}
-		values := op.Pop(1)
-		if xfer, ok := values[0].(*Transfer); !ok {
-			t.Errorf("set-comment did not push a *Transfer onto the stack, pushed %v", values[0])
+		} else if xfer, ok := op.Pop(1)[0].(*Transfer); !ok {
+			t.Errorf("paid-by did not push a *Transfer onto the stack")
 			return fmt.Errorf("test failed")
-		} else if xfer.Comment != "test comment" {
-			t.Errorf("set-comment did not set the Transfer's comment correctly, set: %v", xfer.Comment)
+		} else if xfer.Payer != "alice" {
+			t.Errorf("paid-by did not set the Transfer's payer correctly, set: %v", xfer.Payer)
 			return fmt.Errorf("test failed")
 		}
 		return nil
 	}
 	p := createParser(`
-		(2000 1 1 date
-		USD Dollar commodity
+		(USD Dollar commodity
 		Assets:Account open)
-		Assets:Account 5 USD xfer
-		"overwritten comment" set-comment
-		"test comment" set-comment
-		test-check-comment`)
-	p.Functions["test-check-comment"] = checkComment
+		Assets:Account 10 USD xfer alice paid-by
+		test-check-payer`)
+	p.Functions["test-check-payer"] = checkPayer
 	if e := p.Parse(); e != nil {
-		t.Errorf("set-comment failed: %v", e)
+		t.Errorf("paid-by failed: %v", e)
 	}
 }
 
-func TestTagFunction(t *testing.T) {
-	p := createParser(`
+func TestPaidByFunction_NonTransferOperand(t *testing.T) {
+	p := createParser(`foo alice paid-by`)
+	if p.Parse() == nil {
+		t.Errorf("paid-by function succeeded but should have failed")
+	}
+}
+
+func TestPriceFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		VTI 140.25 USD price
+		2000 1 2 date
+		VTI 141 USD price`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("price function failed: %v", e)
+	}
+	if price, ok := p.Context().Prices.Latest("VTI"); !ok {
+		t.Errorf("price function did not record a price")
+	} else if price.Price.String() != "141 USD" || price.Date.Day != 2 {
+		t.Errorf("price function recorded the wrong latest price: %v on %v", price.Price, price.Date)
+	} else if h := p.Context().Prices.History("VTI"); len(h) != 2 {
+		t.Errorf("price function recorded %v prices instead of 2", len(h))
+	}
+}
+
+func TestPriceFunction_RecordsExchangeRates(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		JPY Yen commodity
+		Assets:Account open
+		Equity open
+		Entity Description
+			Assets:Account 10 JPY 0.01 USD 0.1 USD xfer-exch
+			Equity -0.1 USD xfer
+			xact`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("xact failed: %v", e)
+	}
+	if price, ok := p.Context().Prices.LatestIn("JPY", "USD"); !ok || price.Price.String() != "0.01 USD" {
+		t.Errorf("xact did not record the exchange rate's unit price")
+	}
+}
+
+func TestPriceFunction_TooFewOperands(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		USD 1 price`)
+	if p.Parse() == nil {
+		t.Errorf("price function succeeded but should have failed")
+	}
+}
+
+func TestPriceFunction_IllegalAmount(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		VTI "Total Market" commodity
+		VTI abc USD price`)
+	if p.Parse() == nil {
+		t.Errorf("price function succeeded but should have failed")
+	}
+}
+
+func TestPriceFunction_NonexistentCommodity(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		VTI 140 USD price`)
+	if p.Parse() == nil {
+		t.Errorf("price function succeeded but should have failed")
+	}
+}
+
+func TestPriceFunction_NonexistentQuoteCommodity(t *testing.T) {
+	p := createParser(`
+		VTI "Total Market" commodity
+		VTI 140 USD price`)
+	if p.Parse() == nil {
+		t.Errorf("price function succeeded but should have failed")
+	}
+}
+
+func TestPriceFunction_SameCommodity(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		USD 1 USD price`)
+	if p.Parse() == nil {
+		t.Errorf("price function succeeded but should have failed")
+	}
+}
+
+func TestSetCommentFunction(t *testing.T) {
+	checkComment := func(fn string, op parser.Operands, ctx *core.Context) error {
+		if op.Length() != 1 {
+			t.Errorf("set-comment did not leave exactly one operand on the stack, left %v", op.Length())
+			return fmt.Errorf("test failed")
+		}
+		values := op.Pop(1)
+		if xfer, ok := values[0].(*Transfer); !ok {
+			t.Errorf("set-comment did not push a *Transfer onto the stack, pushed %v", values[0])
+			return fmt.Errorf("test failed")
+		} else if xfer.Comment != "test comment" {
+			t.Errorf("set-comment did not set the Transfer's comment correctly, set: %v", xfer.Comment)
+			return fmt.Errorf("test failed")
+		}
+		return nil
+	}
+	p := createParser(`
+		(2000 1 1 date
+		USD Dollar commodity
+		Assets:Account open)
+		Assets:Account 5 USD xfer
+		"test comment" set-comment
+		test-check-comment`)
+	p.Functions["test-check-comment"] = checkComment
+	if e := p.Parse(); e != nil {
+		t.Errorf("set-comment failed: %v", e)
+	}
+}
+
+func TestSetCommentFunction_TooFewOperands(t *testing.T) {
+	for _, prog := range []string{`set-comment`, `Assets:Account set-comment`} {
+		p := createParser(prog)
+		if p.Parse() == nil {
+			t.Errorf("set-comment succeeded but should have failed for program: %v", prog)
+		}
+	}
+}
+
+func TestSetCommentFunction_NonTransferOperand(t *testing.T) {
+	p := createParser(`"foo transfer" "overwritten comment" set-comment`)
+	if p.Parse() == nil {
+		t.Errorf("set-comment succeeded but should have failed")
+	}
+}
+
+func TestSetCommentFunction_NonStringComment(t *testing.T) {
+	p := createParser(`
+		(2000 1 1 date
+		USD Dollar commodity
+		Assets:Account open)
+		Assets:Account 5 USD xfer
+		123 atoi set-comment`)
+	p.Functions["atoi"] = atoi
+	if p.Parse() == nil {
+		t.Errorf("set-comment succeeded but should have failed")
+	}
+}
+
+func TestSetCommentFunction_Repeated(t *testing.T) {
+	checkComment := func(fn string, op parser.Operands, ctx *core.Context) error {
+		if op.Length() != 1 {
+			t.Errorf("set-comment did not leave exactly one operand on the stack, left %v", op.Length())
+			return fmt.Errorf("test failed")
+		}
+		values := op.Pop(1)
+		if xfer, ok := values[0].(*Transfer); !ok {
+			t.Errorf("set-comment did not push a *Transfer onto the stack, pushed %v", values[0])
+			return fmt.Errorf("test failed")
+		} else if xfer.Comment != "test comment" {
+			t.Errorf("set-comment did not set the Transfer's comment correctly, set: %v", xfer.Comment)
+			return fmt.Errorf("test failed")
+		}
+		return nil
+	}
+	p := createParser(`
+		(2000 1 1 date
+		USD Dollar commodity
+		Assets:Account open)
+		Assets:Account 5 USD xfer
+		"overwritten comment" set-comment
+		"test comment" set-comment
+		test-check-comment`)
+	p.Functions["test-check-comment"] = checkComment
+	if e := p.Parse(); e != nil {
+		t.Errorf("set-comment failed: %v", e)
+	}
+}
+
+func TestShareFunction(t *testing.T) {
+	checkShares := func(fn string, op parser.Operands, ctx *core.Context) error {
+		if op.Length() != 1 {
+			t.Errorf("share did not leave exactly one operand on the stack, left %v", op.Length())
+			return fmt.Errorf("test failed")
+		} else if xfer, ok := op.Pop(1)[0].(*Transfer); !ok {
+			t.Errorf("share did not push a *Transfer onto the stack")
+			return fmt.Errorf("test failed")
+		} else if s := fmt.Sprint(xfer.Shares); s != "[{alice 1} {bob 2.5}]" {
+			t.Errorf("share did not set the Transfer's shares correctly, set: %v", s)
+			return fmt.Errorf("test failed")
+		}
+		return nil
+	}
+	p := createParser(`
+		(USD Dollar commodity
+		Assets:Account open)
+		Assets:Account 10 USD xfer alice 1 bob 2.5 share
+		test-check-shares`)
+	p.Functions["test-check-shares"] = checkShares
+	if e := p.Parse(); e != nil {
+		t.Errorf("share failed: %v", e)
+	}
+}
+
+func TestShareFunction_OddNumberOfOperands(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account 10 USD xfer alice 1 bob share`)
+	if p.Parse() == nil {
+		t.Errorf("share function succeeded but should have failed")
+	}
+}
+
+func TestShareFunction_NonTransferOperand(t *testing.T) {
+	p := createParser(`foo alice 1 share`)
+	if p.Parse() == nil {
+		t.Errorf("share function succeeded but should have failed")
+	}
+}
+
+func TestShareFunction_NonpositiveWeight(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account 10 USD xfer alice 0 share`)
+	if p.Parse() == nil {
+		t.Errorf("share function succeeded but should have failed")
+	}
+}
+
+func TestShareFunction_DuplicatePerson(t *testing.T) {
+	p := createParser(`
+		USD Dollar commodity
+		Assets:Account open
+		Assets:Account 10 USD xfer alice 1 alice 2 share`)
+	if p.Parse() == nil {
+		t.Errorf("share function succeeded but should have failed")
+	}
+}
+
+func TestTagFunction(t *testing.T) {
+	p := createParser(`
 		2000 1 1 date
 		Assets:Account open
 		Assets:Account foo bar tag`)
@@ -1751,6 +2358,55 @@ func TestTagFunction_TwoAccounts(t *testing.T) {
 	}
 }
 
+func TestTagFunction_KeyValueTags(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		Assets:Checking open
+		Assets:Cash open
+		Assets:Checking bank trip=japan2024 tag
+		Assets:Cash trip=paris tag
+		Assets:Cash trip=rome tag
+		Assets:Checking trip assert-tag
+		Assets:Checking trip=japan2024 assert-tag
+		Assets:Cash trip=rome assert-tag`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf(`tag failed: %v`, err)
+	}
+	ctx := p.Context()
+	checking, cash := ctx.Accounts["Assets:Checking"], ctx.Accounts["Assets:Cash"]
+	if v, ok := checking.TagValue("trip"); !ok || v != "japan2024" {
+		t.Errorf(`Assets:Checking's trip tag has value %#v`, v)
+	} else if v, ok := checking.TagValue("bank"); !ok || v != "" {
+		t.Errorf(`Assets:Checking's bank tag has value %#v`, v)
+	} else if cash.HasTag("trip=paris") || !cash.HasTag("trip") || len(cash.GetTags()) != 1 {
+		t.Errorf(`retagging Assets:Cash did not replace its trip value: %v`, cash.GetTags())
+	} else if tagged := ctx.Tags["trip"]; len(tagged) != 2 {
+		t.Errorf(`the "trip" tag has %v objects instead of 2`, len(tagged))
+	} else if tagged := ctx.Tagged("trip=rome"); len(tagged) != 1 || tagged[0] != cash {
+		t.Errorf(`Tagged("trip=rome") returned %v`, tagged)
+	} else if tagged := ctx.Tagged("trip"); len(tagged) != 2 {
+		t.Errorf(`Tagged("trip") returned %v objects instead of 2`, len(tagged))
+	} else if tagged := ctx.Tagged("trip=paris"); len(tagged) != 0 {
+		t.Errorf(`Tagged("trip=paris") returned %v`, tagged)
+	}
+
+	p = createParser(`
+		2000 1 1 date
+		Assets:Checking open
+		Assets:Checking trip=japan2024 tag
+		Assets:Checking trip=paris assert-tag`)
+	if err := p.Parse(); err == nil {
+		t.Errorf(`assert-tag accepted the wrong tag value`)
+	}
+	p = createParser(`
+		2000 1 1 date
+		Assets:Checking open
+		Assets:Checking =japan2024 tag`)
+	if err := p.Parse(); err == nil {
+		t.Errorf(`tag accepted a tag without a key`)
+	}
+}
+
 func TestTagCommodityFunction(t *testing.T) {
 	p := createParser(`USD Dollar commodity USD foo bar tag-commodity`)
 	if err := p.Parse(); err != nil {
@@ -2019,3 +2675,1946 @@ func TestUntagFunction_TwoAccounts(t *testing.T) {
 		t.Errorf(`Assets:Foo has %v tags instead of 0`, len(a.GetTags()))
 	}
 }
+
+func TestUntagFunction_KeyValueTags(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		Assets:Checking open
+		Assets:Cash open
+		Assets:Checking trip=japan2024 tag
+		Assets:Cash trip=paris tag
+		Assets:Checking trip=paris untag
+		Assets:Cash trip untag`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf(`untag failed: %v`, err)
+	}
+	ctx := p.Context()
+	if !ctx.Accounts["Assets:Checking"].HasTag("trip=japan2024") {
+		t.Errorf(`untag removed a tag with a different value`)
+	} else if ctx.Accounts["Assets:Cash"].HasTag("trip") {
+		t.Errorf(`untag did not remove a tag without a value`)
+	} else if tagged := ctx.Tags["trip"]; len(tagged) != 1 || tagged[0] != ctx.Accounts["Assets:Checking"] {
+		t.Errorf(`the "trip" tag has the wrong objects: %v`, tagged)
+	}
+}
+
+func TestTagXactFunction(t *testing.T) {
+	p := createParser(`
+		journal true pragma
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Expenses:Travel open
+		(Me Flight
+			Expenses:Travel 10 USD xfer
+			Assets:Checking -10 USD xfer
+			trip=japan2024 reimbursable tag-xact
+			receipt 123
+			xact)
+		(Me Taxi
+			Expenses:Travel 5 USD xfer
+			Assets:Checking -5 USD xfer
+			xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf(`tag-xact failed: %v`, err)
+	}
+	journal := p.Context().Journal
+	if len(journal) != 2 {
+		t.Fatalf(`the journal has %v entries instead of 2`, len(journal))
+	} else if tags := journal[0].Tags; len(tags) != 2 || tags["trip"] != "japan2024" || tags["reimbursable"] != "" {
+		t.Errorf(`the first transaction has the wrong tags: %v`, tags)
+	} else if journal[0].Notes["receipt"] != "123" {
+		t.Errorf(`the first transaction has the wrong notes: %v`, journal[0].Notes)
+	} else if len(journal[1].Tags) != 0 {
+		t.Errorf(`the second transaction has tags: %v`, journal[1].Tags)
+	}
+
+	p = createParser(`
+		2000 1 1 date
+		tag-xact`)
+	if err := p.Parse(); err == nil {
+		t.Errorf(`tag-xact accepted zero tags`)
+	}
+}
+
+func TestColonDefinitions(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Income:Salary open
+		: paycheck
+			(Employer Paycheck
+				Assets:Checking 1000 USD xfer
+				Income:Salary -1000 USD xfer
+				xact) ;
+		paycheck
+		2000 1 15 date
+		paycheck
+		Assets:Checking 2000 USD assert`)
+	p.ColonDefinitions = true
+	if e := p.Parse(); e != nil {
+		t.Errorf("colon definition failed: %v", e)
+	}
+}
+
+func TestDefinedWords(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Income:Salary open
+		Expenses:Taxes open
+		(quote
+			(Employer Paycheck
+				Assets:Checking 700 USD xfer
+				Expenses:Taxes 300 USD xfer
+				Income:Salary -1000 USD xfer
+				xact))
+		paycheck define
+		paycheck
+		2000 1 15 date
+		paycheck
+		Assets:Checking 1400 USD assert
+		Income:Salary -2000 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("defined word failed: %v", e)
+	}
+}
+
+func writeLedgerFile(t *testing.T, path string, program string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncludeFunction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freebean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLedgerFile(t, filepath.Join(dir, "main.fbn"), `
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Income:Salary open
+		"years/2000.fbn" include
+		Assets:Checking 1500 USD assert`)
+	writeLedgerFile(t, filepath.Join(dir, "years", "2000.fbn"), `
+		(quote
+			(Employer Paycheck
+				Assets:Checking 500 USD xfer
+				Income:Salary -500 USD xfer
+				xact))
+		paycheck define
+		paycheck
+		"2000-extra.fbn" include`)
+	writeLedgerFile(t, filepath.Join(dir, "years", "2000-extra.fbn"), `
+		paycheck
+		paycheck`)
+	f, err := os.Open(filepath.Join(dir, "main.fbn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := NewParser(f)
+	p.SetFileName(filepath.Join(dir, "main.fbn"))
+	p.AddCoreFunctions()
+	if e := p.Parse(); e != nil {
+		t.Errorf("include function failed: %v", e)
+	} else if files := p.IncludedFiles(); len(files) != 2 || files[0] != filepath.Join(dir, "years", "2000.fbn") || files[1] != filepath.Join(dir, "years", "2000-extra.fbn") {
+		t.Errorf("unexpected included files: %v", files)
+	}
+}
+
+func TestIncludeFunction_Cycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freebean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLedgerFile(t, filepath.Join(dir, "a.fbn"), `"b.fbn" include`)
+	writeLedgerFile(t, filepath.Join(dir, "b.fbn"), `"a.fbn" include`)
+	p := createParser(fmt.Sprintf("%q include", filepath.Join(dir, "a.fbn")))
+	if e := p.Parse(); e == nil {
+		t.Errorf("include function succeeded but should have failed")
+	} else if !strings.Contains(e.Error(), "include cycle") {
+		t.Errorf("include function failed with an unexpected error: %v", e)
+	}
+}
+
+func TestIncludeFunction_Failures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freebean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLedgerFile(t, filepath.Join(dir, "unbalanced.fbn"), `(2000 1 1 date`)
+	writeLedgerFile(t, filepath.Join(dir, "unterminated.fbn"), `: paycheck 2000 1 1 date`)
+	for _, program := range []string{
+		`include`,
+		fmt.Sprintf("%q include", filepath.Join(dir, "missing.fbn")),
+		fmt.Sprintf("%q include", filepath.Join(dir, "unbalanced.fbn")),
+		fmt.Sprintf("%q include ;", filepath.Join(dir, "unterminated.fbn")),
+	} {
+		p := createParser(program)
+		if e := p.Parse(); e == nil {
+			t.Errorf("include function succeeded but should have failed: %v", program)
+		}
+	}
+
+	// Missing files are still reported as included.
+	missing := filepath.Join(dir, "missing.fbn")
+	p := createParser(fmt.Sprintf("%q include", missing))
+	if p.Parse() == nil {
+		t.Errorf("include function succeeded but should have failed")
+	} else if files := p.IncludedFiles(); len(files) != 1 || files[0] != missing {
+		t.Errorf("unexpected included files: %v", files)
+	}
+}
+
+func TestIncludeFunction_ErrorLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freebean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLedgerFile(t, filepath.Join(dir, "bad.fbn"), "2000 1 1 date\n  Assets:Missing close\n")
+	p := createParser("\n\"bad.fbn\" include")
+	p.SetFileName(filepath.Join(dir, "main.fbn"))
+	var pe *parser.ParseError
+	if e := p.Parse(); !errors.As(e, &pe) {
+		t.Fatalf("include function did not return a ParseError: %v", e)
+	} else if pe.Position != (parser.Position{Line: 2, Column: 11, Offset: 11}) || pe.Function != "include" {
+		t.Errorf("outer error has position %v and function %v", pe.Position, pe.Function)
+	} else if !errors.As(pe.Err, &pe) || pe.File != "bad.fbn" || pe.Position.String() != "2:18" || pe.Function != "close" || pe.Source != "  Assets:Missing close" {
+		t.Errorf("inner error has unexpected fields: %+v", pe)
+	}
+}
+
+func TestChecksumFunction(t *testing.T) {
+	locked := `
+		2000 1 1 date
+		USD "US Dollar" commodity
+		Assets:Checking open`
+	p := createParser(locked)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	sum := p.Checksum()
+
+	// Whitespace does not affect checksums, and checksum calls are
+	// not hashed.
+	p = createParser(fmt.Sprintf(`2000 1 1 date USD   "US Dollar"
+		commodity Assets:Checking open %q checksum
+		2000 1 2 date %q checksum`, sum, sum))
+	if err := p.Parse(); err == nil {
+		t.Errorf("checksum function succeeded after more content was parsed")
+	}
+	p = createParser(fmt.Sprintf(`2000 1 1 date USD   "US Dollar"
+		commodity Assets:Checking open %q checksum
+		%q checksum`, sum, strings.ToUpper(sum)))
+	if err := p.Parse(); err != nil {
+		t.Errorf("checksum function failed: %v", err)
+	}
+
+	// Checksums include content that ends in a quoted string, and
+	// checksum calls can follow quoted strings.
+	p = createParser(``)
+	if err := p.ParseMore(strings.NewReader(locked + ` "memo"`)); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	} else if memoSum := p.Checksum(); memoSum == sum {
+		t.Errorf("the checksum excludes the trailing quoted string")
+	} else if p.Checksum() != memoSum {
+		t.Errorf("Checksum changed the checksum")
+	} else if err = p.ParseMore(strings.NewReader(fmt.Sprintf(`%q checksum`, memoSum))); err != nil {
+		t.Errorf("checksum function failed after a quoted string: %v", err)
+	}
+	p = createParser(``)
+	if err := p.ParseMore(strings.NewReader(fmt.Sprintf(`%v %q checksum`, locked, sum))); err != nil {
+		t.Errorf("checksum function failed: %v", err)
+	} else if p.Checksum() != sum {
+		t.Errorf("the checksum call changed the checksum")
+	}
+}
+
+func TestChecksumFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`checksum`,
+		`2000 1 1 date 0123 checksum`,
+		`2000 1 1 date "" checksum`,
+	} {
+		p := createParser(program)
+		if e := p.Parse(); e == nil {
+			t.Errorf("checksum function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestDumpStackFunction(t *testing.T) {
+	var b strings.Builder
+	p := createParser(``)
+	p.DebugOutput = &b
+	if err := p.ParseMore(strings.NewReader(`a (b dump-stack`)); err != nil {
+		t.Errorf("dump-stack failed: %v", err)
+	} else if !strings.Contains(b.String(), "\t1:4\tstring\t\"b\"\n") {
+		t.Errorf("dump-stack wrote %q", b.String())
+	}
+
+	// Without DebugOutput, dump-stack discards the stack.
+	p = createParser(``)
+	if err := p.ParseMore(strings.NewReader(`a dump-stack`)); err != nil {
+		t.Errorf("dump-stack failed: %v", err)
+	} else if s := fmt.Sprint(p.Stack()); s != "[a]" {
+		t.Errorf("dump-stack changed the stack: %v", s)
+	}
+}
+
+func TestEntityFunction(t *testing.T) {
+	setup := `
+		2000 1 1 date
+		journal true pragma
+		USD Dollar commodity
+		Assets:Checking open
+		Expenses:Shopping open
+		Amazon "AMZN Mktp" amazon.com entity
+		Amazon "Amazon Marketplace" entity
+		Grocer entity
+`
+	p := createParser(setup + `
+		("AMZN Mktp" Books Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)
+		("Amazon Marketplace" Books Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)
+		(Grocer Food Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)
+		(Unknown Stuff Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("entity failed: %v", err)
+	}
+	ctx := p.Context()
+	for n, entity := range []string{"Amazon", "Amazon", "Grocer", "Unknown"} {
+		if e := ctx.Journal[n].Entity; e != entity {
+			t.Errorf("transaction %v has entity %v instead of %v", n, e, entity)
+		}
+	}
+	if len(ctx.Entities) != 5 {
+		t.Errorf("entity declared the wrong entities: %v", ctx.Entities)
+	}
+	for _, program := range []string{
+		`entity`,
+		`"" entity`,
+		`amazon.com entity`,
+		`Grocer amazon.com entity`,
+		`Other Grocer entity`,
+		`strict true pragma (Unknown Stuff Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)`,
+	} {
+		if p = createParser(setup + program); p.Parse() == nil {
+			t.Errorf("entity program succeeded but should have failed: %v", program)
+		}
+	}
+	p = createParser(`
+		2000 1 1 date
+		strict true pragma
+		USD Dollar commodity
+		Assets:Checking USD open
+		Expenses:Shopping USD open
+		(Unknown Stuff Expenses:Shopping 20 USD xfer Assets:Checking -20 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Errorf("strict mode rejected an entity without entity declarations: %v", err)
+	}
+}
+
+func TestEventFunction(t *testing.T) {
+	p := createParser(`
+		2021 1 1 date
+		location Tokyo event
+		2021 7 15 date
+		job Acme event
+		location Osaka event`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	ctx := p.Context()
+	if len(ctx.Events) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(ctx.Events))
+	} else if e := ctx.Events[2]; e != (core.Event{Date: core.Date{Year: 2021, Month: 7, Day: 15}, Name: "location", Value: "Osaka"}) {
+		t.Errorf("unexpected event: %v", e)
+	}
+	for _, test := range []struct {
+		date  core.Date
+		value string
+		ok    bool
+	}{
+		{core.Date{Year: 2020, Month: 12, Day: 31}, "", false},
+		{core.Date{Year: 2021, Month: 7, Day: 14}, "Tokyo", true},
+		{core.Date{Year: 2021, Month: 7, Day: 15}, "Osaka", true},
+	} {
+		if value, ok := ctx.EventValue("location", test.date); value != test.value || ok != test.ok {
+			t.Errorf("location on %v is %#v (%v) instead of %#v (%v)", test.date, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestEventFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`event`,
+		`2021 1 1 date Tokyo event`,
+		`2021 1 1 date "" Tokyo event`,
+		`2021 1 1 date location (quote Tokyo) event`,
+	} {
+		p := createParser(program)
+		if e := p.Parse(); e == nil {
+			t.Errorf("event function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestPragmaFunction_Journal(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Income:Salary open
+		(Employer Paycheck Assets:Checking 500 USD xfer Income:Salary -500 USD xfer xact)
+		journal true pragma
+		2000 1 15 date
+		(Employer Paycheck
+			Assets:Checking 500 USD xfer "direct deposit" set-comment
+			Income:Salary -500 USD xfer
+			period January
+			xact)
+		journal false pragma
+		(Employer Paycheck Assets:Checking 500 USD xfer Income:Salary -500 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	ctx := p.Context()
+	if len(ctx.Journal) != 1 {
+		t.Fatalf("expected one journal entry, got %v", len(ctx.Journal))
+	}
+	e := ctx.Journal[0]
+	if e.Date != (core.Date{Year: 2000, Month: 1, Day: 15}) || e.Entity != "Employer" || e.Notes["period"] != "January" {
+		t.Errorf("unexpected journal entry: %v", e)
+	} else if len(e.Postings) != 2 || e.Postings[0].Account != "Assets:Checking" || e.Postings[0].Comment != "direct deposit" || e.Postings[1].Quantity.String() != "-500 USD" {
+		t.Errorf("unexpected postings: %v", e.Postings)
+	}
+	c := ctx.Clone()
+	c.Journal[0].Notes["period"] = "February"
+	if e.Notes["period"] != "January" {
+		t.Errorf("Clone shares journal entries' notes")
+	}
+}
+
+func TestStoreAndRecallFunctions(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		1500 rent store
+		Assets:Checking checking store
+		checking recall open
+		Expenses:Rent open
+		(Landlord Rent
+			checking recall rent recall neg USD xfer
+			Expenses:Rent rent recall USD xfer
+			xact)
+		1600 rent store
+		(Landlord Rent
+			checking recall rent recall neg USD xfer
+			Expenses:Rent rent recall USD xfer
+			xact)
+		Expenses:Rent 3100 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("store and recall functions failed: %v", e)
+	} else if v := p.Context().Variables["rent"]; v != "1600" {
+		t.Errorf("store set rent to %v instead of 1600", v)
+	}
+}
+
+func TestStoreFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`store`,
+		`rent store`,
+		`1500 "" store`,
+	} {
+		p := createParser(program)
+		if p.Parse() == nil {
+			t.Errorf("store function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestRecallFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`recall`,
+		`rent recall`,
+	} {
+		p := createParser(program)
+		if p.Parse() == nil {
+			t.Errorf("recall function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestRenameAccountFunction(t *testing.T) {
+	p := createParser(`
+		journal true pragma
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Bank open
+		Equity:Opening open
+		Assets:Bank cash tag
+		Assets:Bank < 0 USD alert
+		(Me Opening Assets:Bank 100 USD xfer Equity:Opening -100 USD xfer xact)
+		Assets:Bank Assets:Checking alias rename-account
+		(Me Deposit Assets:Bank 50 USD xfer Equity:Opening -50 USD xfer xact)
+		Assets:Checking 150 USD assert
+		Assets:Bank 150 USD assert
+		Assets:Checking Assets:Current strict rename-account
+		Assets:Bank 150 USD assert
+		Assets:Current 150 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("rename-account function failed: %v", e)
+	}
+	ctx := p.Context()
+	if _, ok := ctx.Accounts["Assets:Checking"]; ok {
+		t.Errorf("rename-account did not remove the old account name")
+	} else if a, ok := ctx.Accounts["Assets:Current"]; !ok || a.Name != "Assets:Current" {
+		t.Errorf("rename-account did not add the new account name")
+	} else if !a.HasTag("cash") || len(ctx.Tags["cash"]) != 1 || ctx.Tags["cash"][0] != a {
+		t.Errorf("rename-account did not preserve the account's tags")
+	} else if ctx.Alerts[0].Account != "Assets:Current" {
+		t.Errorf("rename-account did not update the alert, which is on %v", ctx.Alerts[0].Account)
+	}
+	for _, e := range ctx.Journal {
+		if account := e.Postings[0].Account; account != "Assets:Current" {
+			t.Errorf("rename-account did not update the journal's %v posting, which is on %v", e.Description, account)
+		}
+	}
+	if len(ctx.Journal) != 2 {
+		t.Errorf("the journal has %v entries instead of 2", len(ctx.Journal))
+	}
+}
+
+func TestRenameAccountFunction_Strict(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Bank open
+		Assets:Bank Assets:Checking strict rename-account
+		Assets:Bank 0 USD assert`)
+	if p.Parse() == nil {
+		t.Errorf("rename-account function succeeded but should have failed")
+	}
+}
+
+func TestRenameAccountFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Assets:Bank Assets:Checking rename-account`,
+		`Assets:Bank Assets:Checking alias rename-account`,
+		`Assets:Bank open Assets:Bank Checking alias rename-account`,
+		`Assets:Bank open Assets:Bank Assets:Checking maybe rename-account`,
+		`Assets:Bank open Assets:Checking open Assets:Bank Assets:Checking alias rename-account`,
+	} {
+		p := createParser("2000 1 1 date " + program)
+		if p.Parse() == nil {
+			t.Errorf("rename-account function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestDecimalPlacesFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		USD 2 decimal-places
+		Assets:Checking open
+		Equity:Opening open
+		(Me Opening Assets:Checking 10.50 USD xfer Equity:Opening -10.500 USD xfer xact)`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("decimal-places function failed: %v", e)
+	} else if c := p.Context().Commodities["USD"]; c.DecimalPlaces != 2 {
+		t.Errorf("decimal-places set USD's decimal places to %v instead of 2", c.DecimalPlaces)
+	}
+}
+
+func TestDecimalPlacesFunction_TooManyPlaces(t *testing.T) {
+	for _, program := range []string{
+		`(Me Dust Assets:Checking 10.00001 USD xfer Equity:Opening -10.00001 USD xfer xact)`,
+		`(Me Buy Assets:Checking 1 EUR 1.123 USD 1.123 USD xfer-exch Equity:Opening -1.123 USD xfer xact)`,
+	} {
+		p := createParser(`
+			2000 1 1 date
+			USD Dollar commodity
+			EUR Euro commodity
+			USD 2 decimal-places
+			Assets:Checking open
+			Equity:Opening open
+			` + program)
+		if p.Parse() == nil {
+			t.Errorf("transfer succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestDecimalPlacesFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`decimal-places`,
+		`USD 2 decimal-places`,
+		`USD Dollar commodity USD -1 decimal-places`,
+		`USD Dollar commodity USD two decimal-places`,
+	} {
+		p := createParser(program)
+		if p.Parse() == nil {
+			t.Errorf("decimal-places function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestSplitLotFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		ACME Stock commodity
+		Assets:Broker open
+		Assets:Checking open
+		(Broker Buy
+			Assets:Broker 10 ACME 30 USD 300 USD xfer-exch lot1 create-lot
+			Assets:Checking -300 USD xfer
+			xact)
+		Assets:Broker lot1 ACME 3 2 split-lot
+		Assets:Broker lot1 15 ACME assert-lot`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("split-lot function failed: %v", e)
+	}
+	l := p.Context().Accounts["Assets:Broker"].Lots["lot1"]["ACME"]
+	if !l.ExchangeRate.UnitPrice.Amount.Equal(decimal.NewFromInt(20)) {
+		t.Errorf("split-lot set the unit price to %v instead of 20", l.ExchangeRate.UnitPrice)
+	} else if !l.ExchangeRate.TotalPrice.Amount.Equal(decimal.NewFromInt(300)) {
+		t.Errorf("split-lot changed the total price to %v", l.ExchangeRate.TotalPrice)
+	}
+}
+
+func TestSplitLotFunction_Journal(t *testing.T) {
+	p := createParser(`
+		journal true pragma
+		2000 1 1 date
+		USD Dollar commodity
+		ACME Stock commodity
+		Assets:Broker open
+		Assets:Checking open
+		(Broker Buy
+			Assets:Broker 10 ACME 5 USD 50 USD xfer-exch lot1 create-lot
+			Assets:Checking -50 USD xfer
+			xact)
+		Assets:Broker lot1 ACME 2 1 split-lot`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("split-lot function failed: %v", e)
+	}
+	ctx := p.Context()
+	if er := ctx.Journal[0].Postings[0].ExchangeRate; !er.UnitPrice.Amount.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("split-lot changed the journal's unit price to %v", er.UnitPrice)
+	} else if l := ctx.Accounts["Assets:Broker"].Lots["lot1"]["ACME"]; !l.ExchangeRate.UnitPrice.Amount.Equal(decimal.NewFromFloat(2.5)) {
+		t.Errorf("split-lot set the unit price to %v instead of 2.5", l.ExchangeRate.UnitPrice)
+	}
+}
+
+func TestSplitLotFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Assets:Broker lot1 ACME 2 split-lot`,
+		`Assets:Nope lot1 ACME 2 1 split-lot`,
+		`Assets:Broker lot2 ACME 2 1 split-lot`,
+		`Assets:Broker lot1 USD 2 1 split-lot`,
+		`Assets:Broker lot1 ACME 0 1 split-lot`,
+		`Assets:Broker lot1 ACME 2 x split-lot`,
+	} {
+		p := createParser(`
+			2000 1 1 date
+			USD Dollar commodity
+			ACME Stock commodity
+			Assets:Broker open
+			Assets:Checking open
+			(Broker Buy
+				Assets:Broker 10 ACME 30 USD 300 USD xfer-exch lot1 create-lot
+				Assets:Checking -300 USD xfer
+				xact)
+			` + program)
+		if p.Parse() == nil {
+			t.Errorf("split-lot function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestAssertEquationFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		ACME Stock commodity
+		Assets:Broker open
+		Assets:Checking open
+		Equity:Opening open
+		(Me Opening Assets:Checking 1000 USD xfer Equity:Opening -1000 USD xfer xact)
+		(Broker Buy
+			Assets:Broker 10 ACME 30 USD 300 USD xfer-exch lot1 create-lot
+			Assets:Checking -300 USD xfer
+			xact)
+		assert-equation
+		Assets:Broker lot1 ACME 2 1 split-lot
+		assert-equation`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("assert-equation function failed: %v", e)
+	}
+}
+
+func TestAssertEquationFunction_Unbalanced(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Equity:Opening open
+		(Me Opening Assets:Checking 1000 USD xfer Equity:Opening -1000 USD xfer xact)
+		test-corrupt-balance
+		assert-equation`)
+	p.Functions["test-corrupt-balance"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		ctx.Accounts["Assets:Checking"].Lots[core.DefaultLotName]["USD"].Balance.Amount = decimal.NewFromInt(999)
+		return nil
+	}
+	if e := p.Parse(); e == nil {
+		t.Errorf("assert-equation function succeeded but should have failed")
+	} else if !strings.Contains(e.Error(), "-1 USD") {
+		t.Errorf("assert-equation did not report the difference: %v", e)
+	}
+}
+
+func TestPragmaFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		default-decimal-places 2 pragma
+		USD Dollar commodity
+		default-decimal-places none pragma
+		ACME Stock commodity
+		account-roots "Assets Equity Budget" pragma
+		Budget:Food open
+		strict true pragma
+		Assets:Checking USD open`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("pragma function failed: %v", e)
+	}
+	ctx := p.Context()
+	if ctx.Commodities["USD"].DecimalPlaces != 2 {
+		t.Errorf("decimal-places pragma did not apply to USD")
+	} else if ctx.Commodities["ACME"].DecimalPlaces != -1 {
+		t.Errorf("decimal-places pragma applied to ACME")
+	} else if !ctx.Options.Strict {
+		t.Errorf("strict pragma did not enable strict mode")
+	}
+}
+
+func TestPragmaFunction_BalanceCheckpoints(t *testing.T) {
+	p := createParser(`
+		2000 1 15 date
+		balance-checkpoints true pragma
+		USD Dollar commodity
+		Assets:Checking open
+		Assets:Savings open
+		Income:Salary open
+		(Employer Paycheck Assets:Checking 500 USD xfer Income:Salary -500 USD xfer xact)
+		2000 1 31 date
+		(Employer Paycheck Assets:Checking 500 USD xfer Income:Salary -500 USD xfer xact)
+		2000 4 10 date
+		Assets:Savings close
+		(Employer Paycheck Assets:Checking 500 USD xfer Income:Salary -500 USD xfer xact)
+		2000 5 1 date`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	ctx := p.Context()
+	if len(ctx.BalanceCheckpoints) != 4 {
+		t.Fatalf("expected four balance checkpoints, got %v", ctx.BalanceCheckpoints)
+	}
+	for n, expected := range []struct {
+		date    core.Date
+		balance string
+	}{
+		{core.Date{Year: 2000, Month: 1, Day: 31}, "1000 USD"},
+		{core.Date{Year: 2000, Month: 2, Day: 29}, "1000 USD"},
+		{core.Date{Year: 2000, Month: 3, Day: 31}, "1000 USD"},
+		{core.Date{Year: 2000, Month: 4, Day: 30}, "1500 USD"},
+	} {
+		bc := ctx.BalanceCheckpoints[n]
+		if q, ok := bc.Balance("Assets:Checking", "USD"); bc.Date != expected.date || !ok || q.String() != expected.balance {
+			t.Errorf("balance checkpoint %v is dated %v with balance %v instead of %v with %v", n, bc.Date, q, expected.date, expected.balance)
+		}
+	}
+	if _, ok := ctx.BalanceCheckpoints[3].Balances["Assets:Savings"]; ok {
+		t.Errorf("balance checkpoint included a closed account")
+	} else if bc, ok := ctx.BalanceCheckpointOn(core.Date{Year: 2000, Month: 3, Day: 15}); !ok || bc.Date != (core.Date{Year: 2000, Month: 2, Day: 29}) {
+		t.Errorf("BalanceCheckpointOn returned the wrong checkpoint: %v", bc)
+	} else if _, ok := ctx.BalanceCheckpointOn(core.Date{Year: 2000, Month: 1, Day: 30}); ok {
+		t.Errorf("BalanceCheckpointOn returned a checkpoint before the first one")
+	}
+}
+
+func TestPragmaFunction_UnicodeNormalization(t *testing.T) {
+	// The account, commodity, lot, and tag are declared in normalization
+	// form D and used in form C.  The entity is free text and is left
+	// as written.
+	const ledger = " pragma\n" +
+		"journal true pragma\n" +
+		"Cafe\u0301 Dollar commodity\n" +
+		"Assets:Checking open\n" +
+		"Expenses:Cafe\u0301 open\n" +
+		"Expenses:Caf\u00e9 cafe\u0301 tag\n" +
+		"(Cafe\u0301 Lunch Expenses:Caf\u00e9 12 Caf\u00e9 xfer lo\u0301t create-lot Assets:Checking -12 Caf\u00e9 xfer xact)\n" +
+		"Expenses:Cafe\u0301 l\u00f3t 12 Cafe\u0301 assert-lot\n" +
+		"Expenses:Cafe\u0301 caf\u00e9 assert-tag"
+	for _, test := range []struct {
+		mode     string
+		succeeds bool
+		warnings int
+	}{
+		{"nfc", true, 0},
+		{"warn", true, 3},
+		{"none", false, 0},
+	} {
+		p := createParser("2000 1 1 date unicode-normalization " + test.mode + ledger)
+		if err := p.Parse(); (err == nil) != test.succeeds {
+			t.Errorf("%v: parsing returned %v", test.mode, err)
+		} else if ctx := p.Context(); len(ctx.Warnings) != test.warnings {
+			t.Errorf("%v: expected %v warnings, got %v", test.mode, test.warnings, ctx.Warnings)
+		} else if !test.succeeds {
+			continue
+		} else if _, ok := ctx.Accounts["Expenses:Caf\u00e9"]; !ok {
+			t.Errorf("%v: account name was not normalized", test.mode)
+		} else if _, ok := ctx.Commodities["Caf\u00e9"]; !ok {
+			t.Errorf("%v: commodity name was not normalized", test.mode)
+		} else if e := ctx.Journal[0].Entity; e != "Cafe\u0301" {
+			t.Errorf("%v: entity was changed to %q", test.mode, e)
+		}
+	}
+}
+
+func TestPragmaFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`strict pragma`,
+		`unknown true pragma`,
+		`strict maybe pragma`,
+		`default-decimal-places -2 pragma`,
+		`account-roots "" pragma`,
+		`account-roots "Assets:Cash" pragma`,
+		`strict true pragma Assets:Checking open`,
+		`account-roots "Assets Equity" pragma Expenses:Food open`,
+		`balance-checkpoints yes pragma`,
+		`unicode-normalization maybe pragma`,
+	} {
+		p := createParser("2000 1 1 date " + program)
+		if p.Parse() == nil {
+			t.Errorf("pragma function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestAssertNoteAndAssertTagFunctions(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		USD currency tag-commodity
+		Assets:Brokerage open
+		Assets:Brokerage taxable tag
+		Assets:Brokerage tax-id 12-345 add-notes
+		Assets:Brokerage tax-id 12-345 assert-note
+		Assets:Brokerage taxable assert-tag
+		USD currency assert-tag`)
+	if e := p.Parse(); e != nil {
+		t.Errorf("assert-note or assert-tag function failed: %v", e)
+	}
+}
+
+func TestAssertNoteAndAssertTagFunctions_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Assets:Brokerage tax-id 99 assert-note`,
+		`Assets:Brokerage broker 12-345 assert-note`,
+		`Assets:Nope tax-id 12-345 assert-note`,
+		`tax-id 12-345 assert-note`,
+		`Assets:Brokerage retirement assert-tag`,
+		`USD taxable assert-tag`,
+		`EUR currency assert-tag`,
+		`currency assert-tag`,
+	} {
+		p := createParser(`
+			2000 1 1 date
+			USD Dollar commodity
+			USD currency tag-commodity
+			Assets:Brokerage open
+			Assets:Brokerage taxable tag
+			Assets:Brokerage tax-id 12-345 add-notes
+			` + program)
+		if p.Parse() == nil {
+			t.Errorf("assertion succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestAddCommodityNotesFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		ACME "Acme Corporation" commodity
+		ACME isin US0000000001 exchange NYSE add-commodity-notes
+		ACME isin US0000000001 assert-note`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("add-commodity-notes function failed: %v", e)
+	}
+	c := p.Context().Commodities["ACME"]
+	if len(c.Notes) != 2 {
+		t.Errorf("add-commodity-notes did not add 2 notes, added: %v", c.Notes)
+	} else if n := c.Notes["exchange"]; n != "NYSE" {
+		t.Errorf(`add-commodity-notes set "exchange" note to "%v" instead of "NYSE"`, n)
+	}
+}
+
+func TestAddCommodityNotesFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`add-commodity-notes`,
+		`USD isin add-commodity-notes`,
+		`EUR isin X add-commodity-notes`,
+	} {
+		p := createParser("2000 1 1 date USD Dollar commodity " + program)
+		if p.Parse() == nil {
+			t.Errorf("add-commodity-notes function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestAddLotNotesFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		ACME "Acme Corporation" commodity
+		Assets:Broker open
+		Assets:Checking open
+		(Broker Buy
+			Assets:Broker 10 ACME 30 USD 300 USD xfer-exch lot1 create-lot
+			Assets:Checking -300 USD xfer
+			xact)
+		Assets:Broker lot1 confirmation C-123 "wash sale" no add-lot-notes
+		Assets:Broker lot1 "wash sale" yes add-lot-notes
+		(Broker Sell
+			Assets:Broker -4 ACME 35 USD -140 USD xfer-exch lot1 lot
+			Assets:Checking 140 USD xfer
+			xact)`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("add-lot-notes function failed: %v", e)
+	}
+	l := p.Context().Accounts["Assets:Broker"].Lots["lot1"]["ACME"]
+	if len(l.Notes) != 2 {
+		t.Errorf("add-lot-notes did not add 2 notes, added: %v", l.Notes)
+	} else if n := l.Notes["confirmation"]; n != "C-123" {
+		t.Errorf(`add-lot-notes set "confirmation" note to "%v" instead of "C-123"`, n)
+	} else if n := l.Notes["wash sale"]; n != "yes" {
+		t.Errorf(`add-lot-notes set "wash sale" note to "%v" instead of "yes"`, n)
+	} else if cl := p.Context().Clone().Accounts["Assets:Broker"].Lots["lot1"]["ACME"]; cl.Notes["confirmation"] != "C-123" {
+		t.Errorf("Clone did not copy lot notes: %v", cl.Notes)
+	} else if cl.Notes["wash sale"] = "no"; l.Notes["wash sale"] != "yes" {
+		t.Errorf("Clone shares lot notes with the original")
+	}
+}
+
+func TestAddLotNotesFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`add-lot-notes`,
+		`Assets:Checking add-lot-notes`,
+		`Assets:Checking "" isin add-lot-notes`,
+		`Assets:Checking lot1 isin X add-lot-notes`,
+		`Assets:Savings "" isin X add-lot-notes`,
+	} {
+		p := createParser("2000 1 1 date USD Dollar commodity Assets:Checking open " + program)
+		if p.Parse() == nil {
+			t.Errorf("add-lot-notes function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestFreebeanVersionFunction(t *testing.T) {
+	for _, program := range []string{
+		LanguageVersion + ` freebean-version`,
+		`1 freebean-version`,
+		`1.0 freebean-version`,
+	} {
+		p := createParser(program)
+		if e := p.Parse(); e != nil {
+			t.Errorf("freebean-version function failed: %v: %v", program, e)
+		}
+	}
+}
+
+func TestFreebeanVersionFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`freebean-version`,
+		`1.999 freebean-version`,
+		`2.0 freebean-version`,
+		`0.9 freebean-version`,
+		`one freebean-version`,
+		`1.x freebean-version`,
+	} {
+		p := createParser(program)
+		if p.Parse() == nil {
+			t.Errorf("freebean-version function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestBookFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		business book
+		Assets:Business-Checking open
+		Income:Consulting open
+		"" book
+		Equity:Opening open
+		(Me Opening Assets:Checking 100 USD xfer Equity:Opening -100 USD xfer xact)
+		(Me Investment Assets:Checking -50 USD xfer Assets:Business-Checking 50 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("book failed: %v", err)
+	}
+	ctx := p.Context()
+	for an, b := range map[string]string{"Assets:Checking": "", "Assets:Business-Checking": "business", "Income:Consulting": "business", "Equity:Opening": ""} {
+		if a := ctx.Accounts[an]; a.Book != b {
+			t.Errorf("%v is in book %#v instead of %#v", an, a.Book, b)
+		}
+	}
+	if ctx.Book != "" {
+		t.Errorf("book did not activate the default book: %#v", ctx.Book)
+	} else if books := ctx.Books(); !reflect.DeepEqual(books, []string{"", "business"}) {
+		t.Errorf("Books returned the wrong books: %v", books)
+	} else if b := ctx.Accounts["Assets:Business-Checking"].Balances()["USD"]; b.String() != "50 USD" {
+		t.Errorf("inter-book transaction transferred the wrong amount: %v", b)
+	}
+}
+
+func TestBookFunction_NoOperands(t *testing.T) {
+	if err := createParser(`book`).Parse(); err == nil {
+		t.Errorf("book succeeded without operands")
+	}
+}
+
+func TestBudgetFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Expenses:Food open
+		(Store Groceries Assets:Checking -50 USD xfer Expenses:Food 50 USD xfer xact)
+		Expenses:Food monthly 100 USD budget
+		(Store Groceries Assets:Checking -80 USD xfer Expenses:Food 80 USD xfer xact)
+		Expenses:Food USD assert-budget
+		2000 3 5 date
+		(Store Groceries Assets:Checking -120 USD xfer Expenses:Food 120 USD xfer xact)
+		Expenses:Food monthly 150 USD budget
+		Expenses:Food USD assert-budget`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("budget function failed: %v", e)
+	}
+	budgets := p.Context().Budgets
+	if len(budgets) != 1 {
+		t.Fatalf("budget function set %v budgets instead of 1", len(budgets))
+	}
+	var actuals []string
+	for _, r := range budgets[0].Results {
+		actuals = append(actuals, r.First.String()+" "+r.Actual.String())
+	}
+	expected := "2000-01-01 80 USD; 2000-02-01 0 USD"
+	if s := strings.Join(actuals, "; "); s != expected {
+		t.Errorf("budget function recorded the wrong results: %v", s)
+	} else if r := budgets[0].Current(p.Context()); r.Actual.String() != "120 USD" || r.Amount.String() != "150 USD" {
+		t.Errorf("budget function has the wrong current period: %v of %v", r.Actual, r.Amount)
+	}
+}
+
+func TestBudgetFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Expenses:Food 100 USD budget`,
+		`Expenses:Food weekly 100 USD budget`,
+		`Expenses:Food monthly X USD budget`,
+		`Expenses:Food monthly 100 EUR budget`,
+		`Expenses:Nothing monthly 100 USD budget`,
+		`Expenses:Food monthly 100 USD budget Expenses:Food yearly 100 USD budget`,
+	} {
+		p := createParser("2000 1 1 date USD Dollar commodity Expenses:Food open " + program)
+		if p.Parse() == nil {
+			t.Errorf("budget function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestAssertBudgetFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Expenses:Food assert-budget`,
+		`Expenses:Nothing USD assert-budget`,
+		`Assets:Checking USD assert-budget`,
+		`(A B Assets:Checking -101 USD xfer Expenses:Food 101 USD xfer xact) Expenses:Food USD assert-budget`,
+		`(A B Assets:Checking 201 USD xfer Income:Salary -201 USD xfer xact) Income:Salary USD assert-budget`,
+	} {
+		p := createParser(`
+			2000 1 1 date
+			USD Dollar commodity
+			Assets:Checking open
+			Expenses:Food open
+			Income:Salary open
+			Expenses:Food quarterly 100 USD budget
+			Income:Salary yearly -200 USD budget
+			` + program)
+		if p.Parse() == nil {
+			t.Errorf("assert-budget function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	const ledger = `
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Assets:Old open
+		Equity open
+		(Me Opening Assets:Checking 100 USD xfer Equity -100 USD xfer xact)
+	`
+	old := createParser(ledger)
+	if err := old.Parse(); err != nil {
+		t.Fatalf("parsing old ledger failed: %v", err)
+	}
+	new := createParser(ledger + `
+		EUR Euro commodity
+		Assets:New open
+		Assets:Old close
+		(Me Deposit Assets:Checking 5 USD xfer Equity -5 USD xfer xact)`)
+	if err := new.Parse(); err != nil {
+		t.Fatalf("parsing new ledger failed: %v", err)
+	}
+	d := core.Diff(old.Context(), new.Context())
+	if !reflect.DeepEqual(d.OpenedAccounts, []string{"Assets:New"}) || !reflect.DeepEqual(d.ClosedAccounts, []string{"Assets:Old"}) || !reflect.DeepEqual(d.NewCommodities, []string{"EUR"}) {
+		t.Errorf("unexpected account and commodity differences: %+v", d)
+	} else if len(d.Lots) != 2 || d.Lots[0].Account != "Assets:Checking" || d.Lots[0].New.String() != "105" || d.Lots[1].Account != "Equity" || d.Lots[1].Old.String() != "-100" {
+		t.Errorf("unexpected lot differences: %+v", d.Lots)
+	}
+	if d = core.Diff(old.Context(), old.Context().Clone()); !d.IsEmpty() {
+		t.Errorf("Diff found differences between a Context and its clone: %+v", d)
+	}
+}
+
+func TestPragmaFunction_AccountInheritance(t *testing.T) {
+	ledger := `
+		2000 1 1 date
+		Expenses:Travel open
+		Expenses:Travel deductible tag
+		Expenses:Travel category travel policy strict add-notes
+		Expenses:Travel:Lodging open
+		Expenses:Travel:Lodging policy lenient add-notes
+		Expenses:Travel:Lodging:Hotels:Chains open
+		Expenses:Travel:Lodging:Hotels:Chains deductible assert-tag
+		Expenses:Travel:Lodging:Hotels:Chains category travel assert-note
+		Expenses:Travel:Lodging:Hotels:Chains policy lenient assert-note`
+	p := createParser("account-inheritance true pragma " + ledger)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("account-inheritance pragma failed: %v", e)
+	}
+	ctx := p.Context()
+	a := ctx.Accounts["Expenses:Travel:Lodging:Hotels:Chains"]
+	if ancestors := ctx.Ancestors(a); len(ancestors) != 2 || ancestors[0].Name != "Expenses:Travel:Lodging" {
+		t.Errorf("account has the wrong ancestors: %v", ancestors)
+	} else if tags := ctx.AccountTags(a); len(tags) != 1 || tags[0] != "deductible" {
+		t.Errorf("account inherited the wrong tags: %v", tags)
+	} else if a.HasTag("deductible") || len(a.Notes) != 0 {
+		t.Errorf("inheritance modified the account's own tags or notes")
+	}
+	if createParser(ledger).Parse() == nil {
+		t.Errorf("assertions of inherited tags and notes succeeded without the account-inheritance pragma")
+	}
+}
+
+func TestXferExchTotalFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		ACME Stock commodity
+		Assets:Broker open
+		Assets:Checking open
+		(Broker Buy
+			Assets:Broker 3 ACME 100 USD xfer-exch-total lot1 create-lot
+			Assets:Checking -100 USD xfer
+			xact)
+		unit-price-decimal-places 4 pragma
+		(Broker Buy
+			Assets:Broker 3 ACME 100 USD xfer-exch-total lot2 create-lot
+			Assets:Checking -100 USD xfer
+			xact)
+		Assets:Checking -200 USD assert`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("xfer-exch-total function failed: %v", e)
+	}
+	lots := p.Context().Accounts["Assets:Broker"].Lots
+	if up := lots["lot1"]["ACME"].ExchangeRate.UnitPrice.String(); up != "33.3333333333333333 USD" {
+		t.Errorf("xfer-exch-total computed the wrong unit price: %v", up)
+	} else if up := lots["lot2"]["ACME"].ExchangeRate.UnitPrice.String(); up != "33.3333 USD" {
+		t.Errorf("xfer-exch-total did not round the unit price: %v", up)
+	} else if tp := lots["lot2"]["ACME"].ExchangeRate.TotalPrice.String(); tp != "100 USD" {
+		t.Errorf("xfer-exch-total recorded the wrong total price: %v", tp)
+	}
+}
+
+func TestXferExchTotalFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Assets:Broker 3 ACME xfer-exch-total`,
+		`Assets:Broker 3 ACME X USD xfer-exch-total`,
+		`Assets:Broker 3 ACME 100 EUR xfer-exch-total`,
+		`Assets:Broker 0 ACME 100 USD xfer-exch-total`,
+		`Assets:Nothing 3 ACME 100 USD xfer-exch-total`,
+		`Assets:Broker 3 ACME 100.001 USD xfer-exch-total`,
+		`unit-price-decimal-places -1 pragma`,
+	} {
+		p := createParser("2000 1 1 date USD Dollar commodity USD 2 decimal-places ACME Stock commodity Assets:Broker open " + program)
+		if p.Parse() == nil {
+			t.Errorf("xfer-exch-total function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+const lotReductionLedger = `
+	2000 1 1 date
+	USD Dollar commodity
+	USD 2 decimal-places
+	ACME Stock commodity
+	Assets:Broker open
+	Assets:Checking open
+	Income:Gains open
+	Equity open
+	(Broker Buy Assets:Broker 10 ACME 100 USD 1000 USD xfer-exch b create-lot Equity -1000 USD xfer xact)
+	2000 2 1 date
+	(Broker Buy Assets:Broker 10 ACME 120 USD 1200 USD xfer-exch a create-lot Equity -1200 USD xfer xact)
+	2000 3 1 date
+`
+
+func TestXferReduceFunction(t *testing.T) {
+	p := createParser(lotReductionLedger)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing the ledger failed: %v", err)
+	}
+	prices := len(p.Context().Prices.History("ACME"))
+	for _, test := range []struct {
+		strategy string
+		a, b     string
+		gains    string
+	}{
+		{"fifo", "5 ACME", "0 ACME", "-350.00 USD"},
+		{"lifo", "0 ACME", "5 ACME", "-250.00 USD"},
+		{"average", "5 ACME", "0 ACME", "-300.00 USD"},
+	} {
+		p = createParser(lotReductionLedger + `lot-reduction ` + test.strategy + ` pragma
+			(Broker Sell
+				Assets:Broker -15 ACME 130 USD Income:Gains xfer-reduce
+				Assets:Checking 1950 USD xfer
+				xact)`)
+		if err := p.Parse(); err != nil {
+			t.Errorf("xfer-reduce function failed with strategy %v: %v", test.strategy, err)
+			continue
+		}
+		ctx := p.Context()
+		lots := ctx.Accounts["Assets:Broker"].Lots
+		if a, b := lots["a"]["ACME"].Balance.String(), lots["b"]["ACME"].Balance.String(); a != test.a || b != test.b {
+			t.Errorf("%v reduced lots to %v and %v instead of %v and %v", test.strategy, a, b, test.a, test.b)
+		} else if gains := ctx.Accounts["Income:Gains"].Balances()["USD"].String(); gains != test.gains {
+			t.Errorf("%v realized %v instead of %v", test.strategy, gains, test.gains)
+		} else if price, _ := ctx.Prices.Latest("ACME"); price.Price.String() != "130.00 USD" {
+			t.Errorf("%v recorded the wrong price: %v", test.strategy, price.Price)
+		} else if n := len(ctx.Prices.History("ACME")); n != prices+1 {
+			t.Errorf("%v recorded %v prices instead of 1", test.strategy, n-prices)
+		}
+		realized := decimal.Decimal{}
+		for _, g := range ctx.RealizedGains {
+			realized = realized.Add(g.Gain().Amount)
+		}
+		if expected := "-" + realized.StringFixed(2) + " USD"; expected != test.gains {
+			t.Errorf("%v recorded realized gains of %v instead of %v", test.strategy, realized, test.gains)
+		}
+	}
+}
+
+func TestRealizedGains(t *testing.T) {
+	p := createParser(lotReductionLedger + `
+		(Broker Sell Assets:Broker -4 ACME 110 USD -440 USD xfer-exch a lot Assets:Checking 440 USD xfer xact)
+		(Broker Buy Assets:Broker 1 ACME 130 USD 130 USD xfer-exch Assets:Checking -130 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing sale failed: %v", err)
+	}
+	gains := p.Context().RealizedGains
+	if len(gains) != 1 {
+		t.Fatalf("recorded %v realized gains instead of 1", len(gains))
+	}
+	g := gains[0]
+	if g.Account != "Assets:Broker" || g.LotName != "a" || g.Date != (core.Date{Year: 2000, Month: 3, Day: 1}) {
+		t.Errorf("recorded realized gain for the wrong lot: %v", g)
+	} else if q, proceeds, basis, gain := g.Quantity.String(), g.Proceeds.String(), g.CostBasis.String(), g.Gain().String(); q != "4 ACME" || proceeds != "440.00 USD" || basis != "480.00 USD" || gain != "-40.00 USD" {
+		t.Errorf("recorded realized gain of %v (%v - %v) on %v", gain, proceeds, basis, q)
+	}
+}
+
+func TestXferReduceFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`Assets:Broker -15 ACME 130 USD xfer-reduce`,
+		`Assets:Broker 15 ACME 130 USD Income:Gains xfer-reduce`,
+		`Assets:Broker -21 ACME 130 USD Income:Gains xfer-reduce`,
+		`Assets:Broker -15 ACME X USD Income:Gains xfer-reduce`,
+		`Assets:Broker -15 ACME 130 EUR Income:Gains xfer-reduce`,
+		`Assets:Broker -15 ACME 130 USD Income:Nothing xfer-reduce`,
+		`Assets:Nothing -15 ACME 130 USD Income:Gains xfer-reduce`,
+		`lot-reduction hifo pragma`,
+	} {
+		p := createParser(lotReductionLedger + program)
+		if p.Parse() == nil {
+			t.Errorf("xfer-reduce function succeeded but should have failed: %v", program)
+		}
+	}
+
+	// Failed transactions do not record sale prices.
+	p := createParser(lotReductionLedger)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing the ledger failed: %v", err)
+	}
+	prices := len(p.Context().Prices.History("ACME"))
+	if p.ParseMore(strings.NewReader(`(Broker Sell Assets:Broker -15 ACME 130 USD Income:Gains xfer-reduce Assets:Checking 1950 USD xfer Assets:Nothing 1 USD xfer xact)`)) == nil {
+		t.Errorf("selling to a nonexistent account succeeded")
+	} else if n := len(p.Context().Prices.History("ACME")); n != prices {
+		t.Errorf("a failed sale recorded %v prices", n-prices)
+	}
+}
+
+func TestSetDocumentFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		journal true pragma
+		USD Dollar commodity
+		Assets:Checking open
+		Expenses:Office open
+		(Store Paper
+			Expenses:Office 20 USD xfer receipts/paper.pdf set-document
+			Assets:Checking -20 USD xfer
+			document https://example.com/invoice/1
+			xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("set-document failed: %v", err)
+	}
+	e := p.Context().Journal[0]
+	if d := e.Postings[0].Document; d != "receipts/paper.pdf" {
+		t.Errorf("set-document set the wrong document: %v", d)
+	} else if d := e.Postings[1].Document; len(d) != 0 {
+		t.Errorf("set-document set another transfer's document: %v", d)
+	} else if d := e.Notes[DocumentNote]; d != "https://example.com/invoice/1" {
+		t.Errorf("transaction has the wrong document note: %v", d)
+	}
+	for _, program := range []string{
+		`set-document`,
+		`receipt.pdf set-document`,
+		`Assets:Checking receipt.pdf set-document`,
+		`Assets:Checking 5 USD xfer "" set-document`,
+	} {
+		if p = createParser(`2000 1 1 date USD Dollar commodity Assets:Checking open ` + program); p.Parse() == nil {
+			t.Errorf("set-document succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestSetPrecisionFunction(t *testing.T) {
+	for _, test := range []struct{ mode, amount, expected string }{
+		{"half-up", "1.125", "1.13 USD"},
+		{"half-even", "1.125", "1.12 USD"},
+		{"truncate", "1.129", "1.12 USD"},
+		{"reject", "1.1", "1.10 USD"},
+	} {
+		p := createParser(`
+			2000 1 1 date
+			USD Dollar commodity
+			USD 2 ` + test.mode + ` set-precision
+			Assets:Checking open
+			Equity open
+			(Me Opening Assets:Checking ` + test.amount + ` USD xfer Equity -` + test.amount + ` USD xfer xact)`)
+		if e := p.Parse(); e != nil {
+			t.Errorf("set-precision function failed: %v: %v", test.mode, e)
+		} else if b := p.Context().Accounts["Assets:Checking"].Balances()["USD"].String(); b != test.expected {
+			t.Errorf("set-precision %v: balance is %v, not %v", test.mode, b, test.expected)
+		}
+	}
+}
+
+func TestSetPrecisionFunction_Failures(t *testing.T) {
+	for _, program := range []string{
+		`USD 2 set-precision`,
+		`USD -1 reject set-precision`,
+		`USD 2 round set-precision`,
+		`EUR 2 reject set-precision`,
+		`USD 2 reject set-precision (Me Opening Assets:Checking 1.125 USD xfer Equity -1.125 USD xfer xact)`,
+	} {
+		p := createParser("2000 1 1 date USD Dollar commodity Assets:Checking open Equity open " + program)
+		if p.Parse() == nil {
+			t.Errorf("set-precision function succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestPopStrings(t *testing.T) {
+	var popped []string
+	p := createParser(`a b pair (c pair) (d (quote e) pair)`)
+	p.Functions["pair"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		values, err := PopStrings(fn, op, "first", "second")
+		popped = append(popped, values...)
+		return err
+	}
+	p.KeepGoing = true
+	errs, ok := p.Parse().(parser.ParseErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Parse returned unexpected errors: %v", errs)
+	} else if !strings.HasSuffix(errs[0].Error(), "pair: first, second operands required, but too few given") {
+		t.Errorf("unexpected error for too few operands: %v", errs[0])
+	} else if !strings.Contains(errs[1].Error(), "pair: non-string second: ") {
+		t.Errorf("unexpected error for a non-string operand: %v", errs[1])
+	} else if !reflect.DeepEqual(popped, []string{"a", "b"}) {
+		t.Errorf("PopStrings returned %v", popped)
+	}
+}
+
+func TestParser_KeepGoing(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking USD open
+		Assets:Savings USD open
+		Assets:Checking 5 USD assert
+		(Lunch Cafe
+`)
+	p.KeepGoing = true
+	errs, ok := p.Parse().(parser.ParseErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Parse returned unexpected errors: %v", errs)
+	} else if !strings.Contains(errs[0].Error(), "assert") {
+		t.Errorf("the first error is not the failed assertion: %v", errs[0])
+	} else if s := errs[1].Error(); !strings.Contains(s, "2000-01-01: ") || !strings.Contains(s, "unconsumed tokens left on stack at EOF") {
+		t.Errorf("the last error is not Finish's: %v", s)
+	}
+}
+
+func TestParser_ReadOnly(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Equity open
+		(Me Opening Assets:Checking 10 USD xfer Equity -10 USD xfer xact)`)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("parsing ledger failed: %v", e)
+	}
+	for _, program := range []string{
+		`Assets:Checking 10 USD assert`,
+		`(1 2 add 3 sub neg 0 add comment)`,
+		`1.0 freebean-version`,
+	} {
+		q := NewParserWithContext(strings.NewReader(program), p.Context())
+		q.AddCoreFunctions()
+		q.ReadOnly = true
+		if e := q.Parse(); e != nil {
+			t.Errorf("read-only parser failed: %v: %v", program, e)
+		}
+	}
+	for _, program := range []string{
+		`Assets:Savings open`,
+		`Assets:Checking close`,
+		`Assets:Checking cash tag`,
+		`2001 1 1 date`,
+		`(Me Again Assets:Checking 1 USD xfer Equity -1 USD xfer xact)`,
+		`dump-stack`,
+	} {
+		q := NewParserWithContext(strings.NewReader(program), p.Context())
+		q.AddCoreFunctions()
+		q.ReadOnly = true
+		q.DebugOutput = ioutil.Discard
+		if q.Parse() == nil {
+			t.Errorf("read-only parser succeeded but should have failed: %v", program)
+		}
+	}
+	ctx := p.Context()
+	if _, ok := ctx.Accounts["Assets:Savings"]; ok || ctx.Accounts["Assets:Checking"].IsClosed(ctx.Date) || len(ctx.Tags) != 0 || ctx.Date != (core.Date{Year: 2000, Month: 1, Day: 1}) {
+		t.Errorf("read-only parser changed the Context")
+	} else if b := ctx.Accounts["Assets:Checking"].Balances()["USD"].String(); b != "10 USD" {
+		t.Errorf("read-only parser changed Assets:Checking's balance to %v", b)
+	}
+
+	// xfer-reduce only pushes Transfers, so it does not record prices.
+	p = createParser(lotReductionLedger)
+	if e := p.Parse(); e != nil {
+		t.Fatalf("parsing ledger failed: %v", e)
+	}
+	ctx = p.Context()
+	prices := len(ctx.Prices.History("ACME"))
+	q := NewParserWithContext(strings.NewReader(""), ctx)
+	q.AddCoreFunctions()
+	q.ReadOnly = true
+	if e := q.ParseMore(strings.NewReader(`Assets:Broker -5 ACME 130 USD Income:Gains xfer-reduce`)); e != nil {
+		t.Errorf("read-only parser failed: xfer-reduce: %v", e)
+	} else if n := len(ctx.Prices.History("ACME")); n != prices {
+		t.Errorf("read-only xfer-reduce changed ACME's price history from %v to %v prices", prices, n)
+	} else if b := ctx.Accounts["Assets:Broker"].Balances()["ACME"].String(); b != "20 ACME" {
+		t.Errorf("read-only xfer-reduce changed Assets:Broker's balance to %v", b)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for _, test := range []struct{ expr, expected string }{
+		{"12.5", "12.5"},
+		{"-1,000.25", "-1000.25"},
+		{"3*19.99", "59.97"},
+		{"1+2*3", "7"},
+		{"10-4-3", "3"},
+		{"(1 + 2) * 3", "9"},
+		{"-2*-3", "6"},
+		{"10/4", "2.5"},
+		{"1,000/8+.5", "125.5"},
+	} {
+		if d, err := ParseAmount(test.expr); err != nil {
+			t.Errorf("ParseAmount(%q) failed: %v", test.expr, err)
+		} else if d.String() != test.expected {
+			t.Errorf("ParseAmount(%q) returned %v instead of %v", test.expr, d, test.expected)
+		}
+	}
+	for _, expr := range []string{"", "x", "1+", "3*", "(1+2", "1+2)", "1/0", "2**3", "1.2.3"} {
+		if d, err := ParseAmount(expr); err == nil {
+			t.Errorf("ParseAmount(%q) returned %v but should have failed", expr, d)
+		}
+	}
+}
+
+func TestXferFunction_AmountExpressions(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		USD 2 decimal-places
+		ACME Stock commodity
+		Assets:Checking open
+		Assets:Broker open
+		Expenses:Food open
+		(Store Groceries Expenses:Food 3*19.99 USD xfer Expenses:Food "2 * (1.50 + 0.25)" USD xfer Assets:Checking -63.47 USD xfer xact)
+		(Broker Buy Assets:Broker 2+3 ACME 10 USD 5*10 USD xfer-exch Assets:Checking -50 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("amount expressions failed: %v", err)
+	}
+	if b := p.Context().Accounts["Expenses:Food"].Balances()["USD"].String(); b != "63.47 USD" {
+		t.Errorf("Expenses:Food's balance is %v instead of 63.47 USD", b)
+	} else if b = p.Context().Accounts["Assets:Broker"].Balances()["ACME"].String(); b != "5 ACME" {
+		t.Errorf("Assets:Broker's balance is %v instead of 5 ACME", b)
+	}
+	if p = createParser(`2000 1 1 date USD Dollar commodity USD 2 decimal-places Assets:Checking open Assets:Checking 1/3 USD xfer`); p.Parse() == nil {
+		t.Errorf("xfer accepted an expression with too many decimal places")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	ledger := `
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Equity open
+		(quote Me Deposit Assets:Checking 10 USD xfer Equity -10 USD xfer xact) deposit define
+		deposit
+`
+	p, resumed := NewParserFromCheckpoint([]byte(ledger), nil)
+	p.AddCoreFunctions()
+	if resumed {
+		t.Errorf("NewParserFromCheckpoint resumed without a checkpoint")
+	} else if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	cp, err := p.Checkpoint()
+	if err != nil || cp == nil {
+		t.Fatalf("Checkpoint failed: %v, %v", cp, err)
+	}
+
+	appended := ledger + "2000 1 2 date deposit\nAssets:Checking 20 USD assert\n"
+	full := createParser(appended)
+	if err = full.Parse(); err != nil {
+		t.Fatalf("parsing the appended ledger failed: %v", err)
+	}
+	p, resumed = NewParserFromCheckpoint([]byte(appended), cp)
+	p.AddCoreFunctions()
+	if !resumed {
+		t.Errorf("NewParserFromCheckpoint did not resume")
+	} else if err = p.Parse(); err != nil {
+		t.Errorf("resuming failed: %v", err)
+	} else if p.Checksum() != full.Checksum() {
+		t.Errorf("resumed checksum %v differs from %v", p.Checksum(), full.Checksum())
+	} else if d := p.Context().Date; d != (core.Date{Year: 2000, Month: 1, Day: 2}) {
+		t.Errorf("resumed parse ended on %v", d)
+	}
+
+	bad := ledger + "\nAssets:Checking 0 USD assert\n"
+	expected := createParser(bad).Parse()
+	p, _ = NewParserFromCheckpoint([]byte(bad), cp)
+	p.AddCoreFunctions()
+	if err = p.Parse(); err == nil || expected == nil || err.Error() != expected.Error() {
+		t.Errorf("resumed parse returned %v instead of %v", err, expected)
+	}
+
+	for _, changed := range []string{strings.Replace(appended, "10 USD", "11 USD", -1), ledger[:len(ledger)-5]} {
+		if _, resumed = NewParserFromCheckpoint([]byte(changed), cp); resumed {
+			t.Errorf("NewParserFromCheckpoint resumed from a checkpoint of different input")
+		}
+	}
+	p, _ = NewParserFromCheckpoint([]byte("2000 1 1 date"), nil)
+	p.AddCoreFunctions()
+	if err = p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	} else if cp, err = p.Checkpoint(); cp != nil || err != nil {
+		t.Errorf("Checkpoint returned a checkpoint for input without a final newline: %v", err)
+	}
+}
+
+func TestRoundingToleranceFunction(t *testing.T) {
+	setup := `
+		2000 1 1 date
+		USD Dollar commodity
+		USD 2 decimal-places
+		USD 0.02 rounding-tolerance
+		Assets:Checking open
+		Expenses:Food open
+		Expenses:Rounding open
+		rounding-account Expenses:Rounding pragma
+`
+	p := createParser(setup + `(Bank Groceries Expenses:Food 3.34 USD xfer Expenses:Food 3.33 USD xfer Assets:Checking -6.68 USD xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("rounding transfer failed: %v", err)
+	}
+	ctx := p.Context()
+	if b := ctx.Accounts["Expenses:Rounding"].Balances()["USD"].String(); b != "0.01 USD" {
+		t.Errorf("Expenses:Rounding's balance is %v instead of 0.01 USD", b)
+	} else if len(ctx.Warnings) != 1 {
+		t.Errorf("expected one warning, got %v", ctx.Warnings)
+	}
+	for _, program := range []string{
+		`(Bank Groceries Expenses:Food 3.34 USD xfer Assets:Checking -3.30 USD xfer xact)`,
+		`rounding-account none pragma (Bank Groceries Expenses:Food 3.34 USD xfer Assets:Checking -3.33 USD xfer xact)`,
+		`Expenses:Rounding close (Bank Groceries Expenses:Food 3.34 USD xfer Assets:Checking -3.33 USD xfer xact)`,
+		`USD 0 rounding-tolerance (Bank Groceries Expenses:Food 3.34 USD xfer Assets:Checking -3.33 USD xfer xact)`,
+		`USD -1 rounding-tolerance`,
+		`EUR 1 rounding-tolerance`,
+		`rounding-account Rounding pragma`,
+	} {
+		if p = createParser(setup + program); p.Parse() == nil {
+			t.Errorf("rounding succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestSmallestUnitFunction(t *testing.T) {
+	setup := `
+		2000 1 1 date
+		CHF Franc commodity
+		JPY Yen commodity
+		CHF 0.05 smallest-unit
+		JPY 1 smallest-unit
+		Assets:Cash open
+		Equity:Opening open
+`
+	p := createParser(setup + `(Me Opening Assets:Cash 10.15 CHF xfer Equity:Opening -10.15 CHF xfer xact)
+		(Me Exchange Assets:Cash 1500 JPY 0.0061 CHF 9.15 CHF xfer-exch Assets:Cash -9.15 CHF xfer xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("smallest-unit failed: %v", err)
+	} else if u := p.Context().Commodities["CHF"].SmallestUnit.String(); u != "0.05" {
+		t.Errorf("CHF's smallest unit is %v instead of 0.05", u)
+	}
+	for _, program := range []string{
+		`(Me Opening Assets:Cash 10.13 CHF xfer Equity:Opening -10.13 CHF xfer xact)`,
+		`(Me Opening Assets:Cash 100.5 JPY xfer Equity:Opening -100.5 JPY xfer xact)`,
+		`(Me Exchange Assets:Cash 1500.5 JPY 0.0061 CHF 9.15 CHF xfer-exch Assets:Cash -9.15 CHF xfer xact)`,
+		`CHF -0.05 smallest-unit`,
+		`CHF X smallest-unit`,
+		`EUR 0.01 smallest-unit`,
+		`CHF smallest-unit`,
+	} {
+		if p = createParser(setup + program); p.Parse() == nil {
+			t.Errorf("smallest unit program succeeded but should have failed: %v", program)
+		}
+	}
+	if err := createParser(setup + `CHF 0 smallest-unit (Me Opening Assets:Cash 10.13 CHF xfer Equity:Opening -10.13 CHF xfer xact)`).Parse(); err != nil {
+		t.Errorf("zero smallest unit did not remove the check: %v", err)
+	}
+}
+
+func TestDisplayFormatFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		USD 2 decimal-places
+		USD prefix $ display-format
+		USD thousands-separator , display-format
+		JPY Yen commodity
+		JPY suffix ¥ display-format
+		JPY places 0 display-format
+		EUR Euro commodity
+		EUR thousands-separator . display-format`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("display-format failed: %v", err)
+	}
+	ctx := p.Context()
+	for _, test := range []struct{ amount, commodity, expected string }{
+		{"1234567.5", "USD", "$1,234,567.50"},
+		{"-1234.5", "USD", "-$1,234.50"},
+		{"123", "USD", "$123.00"},
+		{"1234.6", "JPY", "1235¥"},
+		{"-1234567", "EUR", "-1.234.567 EUR"},
+	} {
+		q := core.Quantity{Commodity: ctx.Commodities[test.commodity], Amount: decimal.RequireFromString(test.amount)}
+		if s := q.String(); s != test.expected {
+			t.Errorf("%v %v is displayed as %v instead of %v", test.amount, test.commodity, s, test.expected)
+		}
+	}
+	for _, program := range []string{
+		`USD prefix display-format`,
+		`GBP prefix £ display-format`,
+		`USD symbol $ display-format`,
+		`USD places -1 display-format`,
+	} {
+		if p = createParser("USD Dollar commodity " + program); p.Parse() == nil {
+			t.Errorf("display-format succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestTimeEntryFunction(t *testing.T) {
+	checkEntry := func(fn string, op parser.Operands, ctx *core.Context) error {
+		if op.Length() != 1 {
+			t.Errorf("time-entry did not leave exactly one operand on the stack, left %v", op.Length())
+			return fmt.Errorf("test failed")
+		} else if xfer, ok := op.Pop(1)[0].(*Transfer); !ok {
+			t.Errorf("time-entry did not push a *Transfer onto the stack")
+			return fmt.Errorf("test failed")
+		} else if xfer.Client != "Acme" || xfer.Project != "Website" {
+			t.Errorf("time-entry set the client and project to %v and %v", xfer.Client, xfer.Project)
+			return fmt.Errorf("test failed")
+		}
+		return nil
+	}
+	p := createParser(`
+		(HRS Hours commodity
+		Assets:Time open)
+		Assets:Time 3.5 HRS xfer Acme Website time-entry
+		test-check-entry`)
+	p.Functions["test-check-entry"] = checkEntry
+	if e := p.Parse(); e != nil {
+		t.Errorf("time-entry failed: %v", e)
+	}
+	for _, program := range []string{
+		`foo Acme Website time-entry`,
+		`Assets:Time 1 HRS xfer Acme "" time-entry`,
+		`Assets:Time 1 HRS xfer Website time-entry`,
+	} {
+		if p = createParser("HRS Hours commodity Assets:Time open " + program); p.Parse() == nil {
+			t.Errorf("time-entry succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestBillingRateFunction(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Acme "" 100 USD billing-rate
+		Acme Website 120 USD billing-rate
+		2000 2 1 date
+		Acme Website 130 USD billing-rate`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("billing-rate failed: %v", err)
+	}
+	ctx := p.Context()
+	for _, test := range []struct {
+		client, project string
+		date            core.Date
+		expected        string
+	}{
+		{"Acme", "Website", core.Date{Year: 2000, Month: 1, Day: 15}, "120 USD"},
+		{"Acme", "Website", core.Date{Year: 2000, Month: 2, Day: 1}, "130 USD"},
+		{"Acme", "Support", core.Date{Year: 2000, Month: 2, Day: 1}, "100 USD"},
+		{"Beta", "Website", core.Date{Year: 2000, Month: 2, Day: 1}, ""},
+		{"Acme", "Website", core.Date{Year: 1999, Month: 12, Day: 31}, ""},
+	} {
+		rate, ok := ctx.BillingRate(test.client, test.project, test.date)
+		if s := rate.String(); ok != (len(test.expected) != 0) || (ok && s != test.expected) {
+			t.Errorf("%v's rate for %v on %v is %v (%v) instead of %#v", test.client, test.project, test.date, s, ok, test.expected)
+		}
+	}
+	for _, program := range []string{
+		`Acme Website 100 billing-rate`,
+		`"" Website 100 USD billing-rate`,
+		`Acme Website -1 USD billing-rate`,
+		`Acme Website 100 EUR billing-rate`,
+	} {
+		if p = createParser("USD Dollar commodity " + program); p.Parse() == nil {
+			t.Errorf("billing-rate succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestNoteTypeFunction(t *testing.T) {
+	setup := `
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Expenses:Car open
+		miles decimal note-type
+		due "date" note-type
+		reimbursed bool note-type
+`
+	p := createParser(setup + `(Me Trip Expenses:Car 10 USD xfer Assets:Checking -10 USD xfer miles 120.5 due 2000-02-01 reimbursed false xact)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("note-type failed: %v", err)
+	} else if typ := p.Context().NoteTypes["due"]; typ != core.DateNote {
+		t.Errorf("due note's type is %v instead of date", typ)
+	}
+	for _, program := range []string{
+		`miles integer note-type`,
+		`miles note-type`,
+		`(Me Trip Expenses:Car 10 USD xfer Assets:Checking -10 USD xfer miles many xact)`,
+		`(Me Trip Expenses:Car 10 USD xfer Assets:Checking -10 USD xfer due 2000-02-30 xact)`,
+		`(Me Trip Expenses:Car 10 USD xfer Assets:Checking -10 USD xfer reimbursed yes xact)`,
+	} {
+		if p = createParser(setup + program); p.Parse() == nil {
+			t.Errorf("note-type succeeded but should have failed: %v", program)
+		}
+	}
+}
+
+func TestNoteCondition(t *testing.T) {
+	notes := map[string]string{"miles": "120", "code": "120", "due": "2000-02-01", "paid": "true", "payee": "Bob"}
+	types := map[string]string{"code": core.StringNote}
+	for _, test := range []struct {
+		condition string
+		expected  bool
+	}{
+		{`note("miles") > 100`, true},
+		{`miles>99.5`, true},
+		{`miles <= 100`, false},
+		{`miles = 120.00`, true},
+		{`code = 120.00`, false},
+		{`code >= 1200`, false},
+		{`due < 2000-03-01`, true},
+		{`due != 2000-02-01`, false},
+		{`paid = true`, true},
+		{`paid > false`, true},
+		{`payee = Bob`, true},
+		{`miles > abc`, false},
+		{`missing != 1`, false},
+	} {
+		c, err := core.ParseNoteCondition(test.condition)
+		if err != nil {
+			t.Errorf("ParseNoteCondition(%q) failed: %v", test.condition, err)
+		} else if m := c.Matches(notes, types); m != test.expected {
+			t.Errorf("%q matched %v instead of %v", test.condition, m, test.expected)
+		}
+	}
+	for _, s := range []string{"miles", "> 100", "miles => 100", `note("") = 1`} {
+		if c, err := core.ParseNoteCondition(s); err == nil {
+			t.Errorf("ParseNoteCondition(%q) returned %v but should have failed", s, c)
+		}
+	}
+}
+
+func TestView(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity
+		Assets:Checking open
+		Income:Salary open`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	v := core.NewView(p.Context())
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				v.Read(func(ctx *core.Context) {
+					p := NewParserWithContext(strings.NewReader("assert-equation"), ctx)
+					p.AddCoreFunctions()
+					p.ReadOnly = true
+					if err := p.Parse(); err != nil {
+						t.Errorf("read-only parsing failed: %v", err)
+					}
+				})
+			}
+		}()
+	}
+	for n := 0; n < 10; n++ {
+		if err := v.Update(func(ctx *core.Context) error {
+			p := NewParserWithContext(strings.NewReader("(Employer Paycheck Assets:Checking 10 USD xfer Income:Salary -10 USD xfer xact)"), ctx)
+			p.AddCoreFunctions()
+			return p.Parse()
+		}); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+	if err := v.Update(func(ctx *core.Context) error { return fmt.Errorf("failed") }); err == nil {
+		t.Errorf("failed update succeeded")
+	}
+	wg.Wait()
+	v.Read(func(ctx *core.Context) {
+		if q := ctx.Accounts["Assets:Checking"].Balances()["USD"]; q.String() != "100 USD" {
+			t.Errorf("expected a balance of 100 USD, got %v", q)
+		}
+	})
+	if q := p.Context().Accounts["Assets:Checking"].Balances()["USD"]; !q.Amount.IsZero() {
+		t.Errorf("updating the view changed the original context: %v", q)
+	}
+}
+
+func TestConditionalFunctions(t *testing.T) {
+	p := createParser(`
+		2000 1 1 date
+		USD Dollar commodity USD currency tag-commodity
+		Assets:Checking open Assets:Checking bank=first tag
+		(1.50 1.5 if-equal yes equal-decimals store)
+		(1.50 1.6 if-equal yes unequal-decimals store)
+		(abc abc if-equal yes equal-strings store)
+		(abc 1.5 if-equal yes unequal-strings store)
+		(0.00 if-zero yes zero store)
+		(-1 if-zero yes nonzero store)
+		(Assets:Checking bank when-tagged yes tagged-account store)
+		(Assets:Checking bank=second when-tagged yes untagged-account store)
+		(USD currency when-tagged yes tagged-commodity store)
+		(1 2 if-equal (silenced (parentheses)) yes nested store)`)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	for _, name := range []string{"equal-decimals", "equal-strings", "zero", "tagged-account", "tagged-commodity"} {
+		if _, ok := p.Context().Variables[name]; !ok {
+			t.Errorf("the conditional storing %v was skipped", name)
+		}
+	}
+	for _, name := range []string{"unequal-decimals", "unequal-strings", "nonzero", "untagged-account", "nested"} {
+		if _, ok := p.Context().Variables[name]; ok {
+			t.Errorf("the conditional storing %v was not skipped", name)
+		}
+	}
+}
+
+func TestConditionalFunctions_Failures(t *testing.T) {
+	for _, program := range []string{
+		`1 2 if-equal`,
+		`(1 if-equal)`,
+		`(abc if-zero)`,
+		`(Assets:Nonexistent tag when-tagged)`,
+		`(Assets:Checking when-tagged)`,
+	} {
+		if createParser(program).Parse() == nil {
+			t.Errorf("%q succeeded", program)
+		}
+	}
+}
@@ -27,10 +27,18 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package functions
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
 	"fmt"
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/parser"
+	"hash"
 	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 )
 
 type Function func(string, parser.Operands, *core.Context) error
@@ -38,40 +46,402 @@ type Function func(string, parser.Operands, *core.Context) error
 type Parser struct {
 	Functions map[string]Function
 
+	// Preprocessors transform lexed tokens before they are evaluated.
+	// See parser.Parser.
+	Preprocessors []parser.Preprocessor
+
+	// ReadOnly disables all Functions except those named in
+	// ReadOnlyFunctions, so that parsed code can query and assert
+	// the Context but cannot change it.  Disabled Functions return
+	// errors when called.  ReadOnly Parsers do not change their Contexts
+	// at all, so several may parse concurrently with one Context that
+	// nothing else changes.
+	ReadOnly bool
+
+	// ColonDefinitions enables Forth-style ":" and ";" word definitions.
+	// See parser.Parser.ColonDefinitions.
+	ColonDefinitions bool
+
+	// KeepGoing makes Parse report all of the errors in the input rather
+	// than stop at the first one.  See parser.Parser.KeepGoing.
+	KeepGoing bool
+
+	// BeforeCall and AfterCall trace or profile Function calls.
+	// See parser.Parser.BeforeCall.
+	BeforeCall func(fn string, op parser.Operands, pos parser.Position)
+	AfterCall  func(fn string, op parser.Operands, pos parser.Position, err error)
+
+	// DebugOutput is where the dump-stack function writes, and
+	// DumpStackOnError dumps the operand stack there when parsing fails.
+	// Nil discards the dumps.  See parser.Parser.DumpStack.
+	DebugOutput      io.Writer
+	DumpStackOnError bool
+
 	ctx    *core.Context
 	lexer  *parser.Lexer
 	parser *parser.Parser
+	files  []string // absolute paths of the files being parsed, outermost first
+
+	// includes lists the absolute paths of the files that p has tried to
+	// include, including unreadable ones, without duplicates.
+	includes []string
+
+	// input is the input given to NewParserFromCheckpoint, if any, and
+	// included maps the absolute paths of included files to their
+	// hexadecimal SHA-256 checksums.  See Checkpoint.
+	input    []byte
+	included map[string]string
+
+	// sum hashes the normalized tokens lexed so far except checksum calls.
+	// pending is the most recently lexed quoted string, which is hashed
+	// when the next token is lexed unless the latter is "checksum".
+	sum     hash.Hash
+	pending *parser.Token
 }
 
 func NewParser(r io.Reader) *Parser {
-	ctx := core.NewContext()
+	return NewParserWithContext(r, core.NewContext())
+}
+
+// NewParserWithContext creates a Parser that parses into an existing Context
+// rather than a new one.
+func NewParserWithContext(r io.Reader, ctx *core.Context) *Parser {
 	return &Parser{
 		Functions: make(map[string]Function),
 		ctx:       ctx,
 		lexer:     parser.NewLexer(r),
-		parser:    parser.NewParser(ctx)}
+		parser:    parser.NewParser(ctx),
+		included:  map[string]string{},
+		sum:       sha256.New()}
 }
 
 func (p *Parser) Context() *core.Context { return p.ctx }
 
+// LineNumber returns the line number on which the token most recently
+// lexed from p's input began.  Tokens lexed from included files do not
+// change it.
+func (p *Parser) LineNumber() uint64 { return p.lexer.TokenLineNumber() }
+
+// Position returns the Position at which the token most recently lexed
+// from p's input began.  Tokens lexed from included files do not change it.
+func (p *Parser) Position() parser.Position { return p.lexer.TokenPosition() }
+
+// SetFileName sets the name of the file that p parses.  The include
+// function resolves relative paths against the file's directory rather
+// than the working directory and detects files that include themselves.
+func (p *Parser) SetFileName(name string) {
+	if abs, err := filepath.Abs(name); err == nil {
+		name = abs
+	}
+	p.files = []string{name}
+}
+
+// AddCoreFunctions adds the core functions to p.  The pad function
+// passes its transactions to p's xact function, so replacing the latter
+// also affects the former.  The include function parses files with p,
+// so included files share p's functions, operand stack, and words.
+// The checksum function checks the tokens that p has lexed, and the
+// dump-stack function writes p's operand stack to DebugOutput.
 func (p *Parser) AddCoreFunctions() {
 	for fn, f := range GetCoreFunctions() {
 		p.Functions[fn] = f
 	}
+	p.Functions["pad"] = NewPadFunction(func(fn string, op parser.Operands, ctx *core.Context) error {
+		return p.Functions["xact"](fn, op, ctx)
+	})
+	p.Functions["include"] = p.include
+	p.Functions["checksum"] = p.checksum
+	p.Functions["dump-stack"] = p.dumpStack
 }
 
-func (p *Parser) Parse() error {
+// hashToken is a Preprocessor that adds lexed tokens to p's checksum.
+// Tokens are normalized so that whitespace and line breaks do not affect
+// the checksum.  Calls to checksum (a quoted string followed by
+// "checksum") are not hashed, so checksums can be added to ledgers
+// without changing later checksums.
+func (p *Parser) hashToken(t parser.Token) ([]parser.Token, error) {
+	if p.pending != nil {
+		if t.Type == parser.String && t.Text == "checksum" {
+			p.pending = nil
+			return []parser.Token{t}, nil
+		}
+		writeToken(p.sum, *p.pending)
+		p.pending = nil
+	}
+	if t.Type == parser.QuotedString {
+		p.pending = &t
+	} else {
+		writeToken(p.sum, t)
+	}
+	return []parser.Token{t}, nil
+}
+
+// writeToken writes a normalized token to w.
+func writeToken(w io.Writer, t parser.Token) {
+	switch t.Type {
+	case parser.String:
+		fmt.Fprintln(w, t.Text)
+	case parser.QuotedString:
+		fmt.Fprintln(w, parser.Quote(t.Text))
+	case parser.OpenParen:
+		fmt.Fprintln(w, "(")
+	case parser.CloseParen:
+		fmt.Fprintln(w, ")")
+	}
+}
+
+// Checksum returns the hexadecimal SHA-256 checksum of the normalized
+// tokens that p has lexed so far, excluding calls to checksum.  A
+// pending quoted string is included, since p does not yet know whether
+// it is a checksum.
+func (p *Parser) Checksum() string {
+	sum := p.sum
+	if p.pending != nil {
+		// Hash the pending token in a copy so that it stays pending.
+		state, err := p.sum.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		sum = sha256.New()
+		if err = sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			panic(err)
+		}
+		writeToken(sum, *p.pending)
+	}
+	return fmt.Sprintf("%x", sum.Sum(nil))
+}
+
+// checksum implements the checksum function for p.
+//
+// Syntax: CHECKSUM checksum ->
+func (p *Parser) checksum(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 1 {
+		return fmt.Errorf("%v: checksum operand required, but none given", fn)
+	}
+	expected, ok := op.Pop(1)[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string checksum: %v", fn, expected)
+	} else if actual := p.Checksum(); !strings.EqualFold(expected, actual) {
+		return fmt.Errorf("%v: content parsed so far has checksum %v instead of %v", fn, actual, expected)
+	}
+	return nil
+}
+
+// dumpStack implements the dump-stack function for p.  It does not
+// change the operand stack.
+//
+// Syntax: dump-stack ->
+func (p *Parser) dumpStack(fn string, op parser.Operands, ctx *core.Context) error {
+	if p.DebugOutput == nil {
+		return nil
+	}
+	return p.parser.DumpStack(p.DebugOutput)
+}
+
+// IncludedFiles returns the absolute paths of the files that p has
+// included so far in the order in which p first included them, or
+// sorted for Parsers resumed from Checkpoints.  Files that p could not
+// read are included so that callers can detect when they appear.
+func (p *Parser) IncludedFiles() []string {
+	return append([]string(nil), p.includes...)
+}
+
+// addInclude adds path to p's IncludedFiles if it is not there already.
+func (p *Parser) addInclude(path string) {
+	for _, f := range p.includes {
+		if f == path {
+			return
+		}
+	}
+	p.includes = append(p.includes, path)
+}
+
+// include implements the include function for p.
+//
+// Syntax: PATH include ->
+func (p *Parser) include(fn string, op parser.Operands, ctx *core.Context) error {
+	if op.Length() < 1 {
+		return fmt.Errorf("%v: path operand required, but none given", fn)
+	}
+	path, ok := op.Pop(1)[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string path: %v", fn, path)
+	}
+	resolved := path
+	if !filepath.IsAbs(resolved) && len(p.files) != 0 {
+		resolved = filepath.Join(filepath.Dir(p.files[len(p.files)-1]), resolved)
+	}
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	for n, f := range p.files {
+		if f == abs {
+			return fmt.Errorf("%v: include cycle: %v", fn, strings.Join(append(p.files[n:len(p.files):len(p.files)], abs), " -> "))
+		}
+	}
+	p.addInclude(abs)
+	content, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	p.included[abs] = fmt.Sprintf("%x", sha256.Sum256(content))
+	p.files = append(p.files, abs)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+	depth := p.parser.Depth()
+	if err = p.parser.Parse(parser.NewLexer(bytes.NewReader(content))); err != nil {
+		if pe, ok := err.(*parser.ParseError); ok {
+			pe.File = path
+			return fmt.Errorf("%v: %w", fn, pe)
+		} else if errs, ok := err.(parser.ParseErrors); ok {
+			for _, pe := range errs {
+				pe.File = path
+			}
+			return fmt.Errorf("%v: %w", fn, errs)
+		}
+		return fmt.Errorf("%v: %v: %v", fn, path, err)
+	} else if p.parser.Depth() != depth {
+		return fmt.Errorf("%v: %v: unbalanced parentheses", fn, path)
+	} else if p.parser.Defining() {
+		return fmt.Errorf(`%v: %v: unterminated ":" definition`, fn, path)
+	}
+	return nil
+}
+
+// ReadOnlyFunctions is the set of names of core functions that do not
+// change Contexts.  They only manipulate the operand stack, read
+// the Context, or check assertions.  Parsers in ReadOnly mode call
+// only these Functions.
+var ReadOnlyFunctions = map[string]bool{
+	"add":              true,
+	"assert":           true,
+	"assert-budget":    true,
+	"assert-equation":  true,
+	"assert-lot":       true,
+	"assert-lots-sum":  true,
+	"assert-note":      true,
+	"assert-tag":       true,
+	"checksum":         true,
+	"comment":          true,
+	"convert":          true,
+	"create-lot":       true,
+	"div":              true,
+	"freebean-version": true,
+	"if-equal":         true,
+	"if-zero":          true,
+	"lot":              true,
+	"mul":              true,
+	"neg":              true,
+	"paid-by":          true,
+	"recall":           true,
+	"set-comment":      true,
+	"set-document":     true,
+	"share":            true,
+	"sub":              true,
+	"tag-xact":         true,
+	"time-entry":       true,
+	"when-tagged":      true,
+	"xfer":             true,
+	"xfer-exch":        true,
+	"xfer-exch-total":  true,
+	"xfer-reduce":      true,
+}
+
+// readOnlyError is the Function that replaces disabled Functions
+// in ReadOnly mode.
+func readOnlyError(fn string, op parser.Operands, ctx *core.Context) error {
+	return fmt.Errorf("%v: function disabled in read-only mode", fn)
+}
+
+func (p *Parser) installFunctions() {
 	for fn, f := range p.Functions {
 		f := f
+		if p.ReadOnly && !ReadOnlyFunctions[fn] {
+			f = readOnlyError
+		}
 		p.parser.Functions[fn] = func(fn string, op parser.Operands, _ interface{}) error {
 			return f(fn, op, p.ctx)
 		}
 	}
-	err := p.parser.Parse(p.lexer)
-	if err != nil {
-		err = fmt.Errorf(`%v: %v`, p.ctx.Date, err)
+	p.parser.KeepGoing = p.KeepGoing
+	p.parser.ColonDefinitions = p.ColonDefinitions
+	p.parser.BeforeCall, p.parser.AfterCall = p.BeforeCall, p.AfterCall
+	p.parser.DebugOutput, p.parser.DumpStackOnError = p.DebugOutput, p.DumpStackOnError
+	p.parser.Preprocessors = append([]parser.Preprocessor{p.hashToken}, p.Preprocessors...)
+}
+
+// Parse parses p's input.  Errors in the input wrap *parser.ParseError
+// values, which parser.PrettyError formats with excerpts of the input.
+// If p is in KeepGoing mode, Parse returns parser.ParseErrors, which are
+// not prefixed with dates.  They end with the error that checking for
+// leftover operands and unclosed parentheses at the end of the input
+// reports, if any, so that the collected errors are complete.
+func (p *Parser) Parse() error {
+	return p.ParseContext(context.Background())
+}
+
+// ParseContext is like Parse, but it stops parsing when ctx is done and
+// returns ctx.Err() (see parser.Parser.ParseContext).  For example, Functions
+// can cancel ctx to stop parsing after the dates that interest clients:
+//
+//	parsing, stop := context.WithCancel(context.Background())
+//	p.Functions["date"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+//		if err := DateFunction(fn, op, ctx); err != nil {
+//			return err
+//		} else if ctx.Date.After(end) {
+//			stop()
+//		}
+//		return nil
+//	}
+//	if err := p.ParseContext(parsing); err != nil && err != parsing.Err() {
+//		return err
+//	}
+func (p *Parser) ParseContext(ctx context.Context) error {
+	p.installFunctions()
+	err := p.parser.ParseContext(ctx, p.lexer)
+	if errs, ok := err.(parser.ParseErrors); ok {
+		if ctx.Err() == nil {
+			if ferr := p.parser.Finish(); ferr != nil {
+				errs = append(errs, &parser.ParseError{Position: p.lexer.Position(), Stack: p.parser.Stack(), Err: fmt.Errorf(`%v: %w`, p.ctx.Date, ferr)})
+			}
+		}
+		return errs
+	} else if err != nil && err == ctx.Err() {
+		return err
+	} else if err != nil {
+		err = fmt.Errorf(`%v: %w`, p.ctx.Date, err)
 	} else {
 		err = p.parser.Finish()
 	}
 	return err
 }
+
+// ParseMore parses r as a continuation of the input parsed so far.
+// Unlike Parse, it leaves unconsumed operands and unclosed parentheses
+// on the stacks so that later calls can continue where it left off.
+func (p *Parser) ParseMore(r io.Reader) error {
+	return p.ParseMoreContext(context.Background(), r)
+}
+
+// ParseMoreContext is like ParseMore, but it stops parsing when ctx is
+// done, as ParseContext does.
+func (p *Parser) ParseMoreContext(ctx context.Context, r io.Reader) error {
+	p.installFunctions()
+	err := p.parser.ParseContext(ctx, parser.NewLexer(r))
+	if _, ok := err.(parser.ParseErrors); ok || (err != nil && err == ctx.Err()) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf(`%v: %w`, p.ctx.Date, err)
+	}
+	return nil
+}
+
+// Stack returns a copy of the operand stack, bottom first.
+func (p *Parser) Stack() []interface{} { return p.parser.Stack() }
+
+// Depth returns the number of open parentheses that have not been closed.
+func (p *Parser) Depth() int { return p.parser.Depth() }
+
+// DumpStack writes the operand stack to w with the Positions and types
+// of the operands.  See parser.Parser.DumpStack.
+func (p *Parser) DumpStack(w io.Writer) error { return p.parser.DumpStack(w) }
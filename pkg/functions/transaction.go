@@ -37,9 +37,22 @@ type Transaction struct {
 	Description string
 	Transfers   []*Transfer
 	Notes       map[string]string
+	Tags        map[string]string // keys -> values; see core.TagTarget
 }
 
-func getTransferAndNoteOperandStartIndices(op parser.Operands) (transferStartIndex, noteStartIndex int) {
+// DocumentNote is the name of the transaction note whose value is
+// the path or URL of a document supporting the whole transaction, such as
+// an invoice.  Transfers have their own documents; see SetDocumentFunction.
+const DocumentNote = "document"
+
+// TransactionTags are the tags of a transaction.  TagXactFunction pushes
+// them onto the operand stack, and ParseTransaction pops them.
+type TransactionTags []string
+
+// getTransferAndNoteOperandStartIndices returns the indices of the first
+// Transfer and note operands of a transaction.  tagsIndex is the index
+// of the TransactionTags operand or -1 if there is none.
+func getTransferAndNoteOperandStartIndices(op parser.Operands) (transferStartIndex, tagsIndex, noteStartIndex int) {
 	values := op.GetValues()
 	for noteStartIndex = len(values) - 1; noteStartIndex >= 0; noteStartIndex-- {
 		if _, ok := values[noteStartIndex].(string); !ok {
@@ -47,7 +60,15 @@ func getTransferAndNoteOperandStartIndices(op parser.Operands) (transferStartInd
 			break
 		}
 	}
-	for transferStartIndex = noteStartIndex - 1; transferStartIndex >= 0; transferStartIndex-- {
+	tagsIndex = -1
+	transferEndIndex := noteStartIndex
+	if noteStartIndex > 0 {
+		if _, ok := values[noteStartIndex-1].(TransactionTags); ok {
+			tagsIndex = noteStartIndex - 1
+			transferEndIndex--
+		}
+	}
+	for transferStartIndex = transferEndIndex - 1; transferStartIndex >= 0; transferStartIndex-- {
 		if _, ok := values[transferStartIndex].(*Transfer); !ok {
 			transferStartIndex++
 			break
@@ -56,33 +77,66 @@ func getTransferAndNoteOperandStartIndices(op parser.Operands) (transferStartInd
 	return
 }
 
-func checkTransfers(transfers []*Transfer) error {
+// sumTransfers returns the sum of the transfers' quantities.  It returns
+// an error if they are in different commodities.
+func sumTransfers(transfers []*Transfer) (core.Quantity, error) {
 	q := transfers[0].GetTransferQuantity()
 	for _, t := range transfers[1:] {
 		tq := t.GetTransferQuantity()
 		if tq.Commodity != q.Commodity {
-			return fmt.Errorf("transfer to %v uses commodity %v but transfer to %v uses %v", t.Account.Name, tq.Commodity, transfers[0].Account.Name, q.Commodity)
+			return q, fmt.Errorf("transfer to %v uses commodity %v but transfer to %v uses %v", t.Account.Name, tq.Commodity, transfers[0].Account.Name, q.Commodity)
 		}
 		q.Amount = q.Amount.Add(tq.Amount)
 	}
-	if !q.Amount.IsZero() {
-		return fmt.Errorf("transfers sum to %v, not zero", q)
+	return q, nil
+}
+
+// roundingTransfer returns a Transfer to the Context's rounding account
+// that cancels sum, the nonzero sum of a transaction's transfers.  It
+// returns an error if there is no rounding account or if sum exceeds
+// its commodity's rounding tolerance.
+func roundingTransfer(sum core.Quantity, ctx *core.Context) (*Transfer, error) {
+	an := ctx.Options.RoundingAccount
+	tolerance := sum.Commodity.RoundingTolerance
+	if len(an) == 0 || sum.Amount.Abs().GreaterThan(tolerance) {
+		return nil, fmt.Errorf("transfers sum to %v, not zero", sum)
 	}
-	return nil
+	a, ok := ctx.Account(an)
+	if !ok {
+		return nil, fmt.Errorf("transfers sum to %v, but rounding account %v does not exist", sum, an)
+	} else if a.IsClosed(ctx.Date) {
+		return nil, fmt.Errorf("transfers sum to %v, but rounding account %v is closed", sum, an)
+	} else if len(a.Commodities) != 0 {
+		if _, ok = a.Commodities[sum.Commodity.Name]; !ok {
+			return nil, fmt.Errorf("transfers sum to %v, but rounding account %v cannot hold %v", sum, an, sum.Commodity)
+		}
+	}
+	return &Transfer{Account: a, Quantity: core.Quantity{Commodity: sum.Commodity, Amount: sum.Amount.Neg()}, Comment: "rounding"}, nil
 }
 
-// Syntax: ENTITY DESCRIPTION Transfer+ (NOTE-NAME NOTE-VALUE)* xact ->
+// ParseTransaction pops a transaction's operands.  If the transfers
+// do not balance but are off by no more than their commodity's rounding
+// tolerance, ParseTransaction adds a transfer to the rounding account
+// that balances them and records a warning in the Context.  Entities'
+// aliases are replaced with their canonical names (see EntityFunction),
+// and undeclared entities are errors in strict mode.
+//
+// Syntax: ENTITY DESCRIPTION Transfer+ TransactionTags? (NOTE-NAME NOTE-VALUE)* xact ->
 func ParseTransaction(op parser.Operands, ctx *core.Context) (Transaction, error) {
 	t := Transaction{}
 	var ok bool
 	values := op.GetValues()
-	transferStartIndex, noteStartIndex := getTransferAndNoteOperandStartIndices(op)
+	transferStartIndex, tagsIndex, noteStartIndex := getTransferAndNoteOperandStartIndices(op)
 	if transferStartIndex == 0 {
 		return t, fmt.Errorf("entity and description operands are required")
 	} else if transferStartIndex == 1 {
 		return t, fmt.Errorf("description operand is required")
 	}
-	numTransfers := noteStartIndex - transferStartIndex
+	numTags := 0
+	if tagsIndex >= 0 {
+		numTags = 1
+	}
+	numTransfers := noteStartIndex - numTags - transferStartIndex
 	if numTransfers < 2 {
 		return t, fmt.Errorf("there must be at least two transfers")
 	}
@@ -90,31 +144,85 @@ func ParseTransaction(op parser.Operands, ctx *core.Context) (Transaction, error
 	if numNotes%2 != 0 {
 		return t, fmt.Errorf("the number of notes must be a multiple of two, got %v", numNotes)
 	}
-	values = op.Pop(numTransfers + numNotes + 2)
+	values = op.Pop(numTransfers + numTags + numNotes + 2)
 	if t.Entity, ok = values[0].(string); !ok {
 		return t, fmt.Errorf("non-string entity: %v", values[0])
 	} else if t.Description, ok = values[1].(string); !ok {
 		return t, fmt.Errorf("non-string description: %v", values[1])
 	}
+	if canonical, ok := ctx.CanonicalEntity(t.Entity); ok {
+		t.Entity = canonical
+	} else if ctx.Options.Strict {
+		return t, fmt.Errorf("undeclared entity in strict mode: %v", t.Entity)
+	}
 	t.Transfers = make([]*Transfer, numTransfers)[:0]
 	for _, transfer := range values[2 : numTransfers+2] {
 		t.Transfers = append(t.Transfers, transfer.(*Transfer))
 	}
-	if err := checkTransfers(t.Transfers); err != nil {
+	sum, err := sumTransfers(t.Transfers)
+	if err != nil {
 		return t, err
+	} else if !sum.Amount.IsZero() {
+		rt, err := roundingTransfer(sum, ctx)
+		if err != nil {
+			return t, err
+		}
+		t.Transfers = append(t.Transfers, rt)
+		ctx.Warnings = append(ctx.Warnings, fmt.Sprintf("%v: %v %#v: transfers sum to %v; transferred %v to %v", ctx.Date, t.Entity, t.Description, sum, rt.Quantity, rt.Account.Name))
+	}
+	t.Tags = map[string]string{}
+	if numTags != 0 {
+		for _, tag := range values[numTransfers+2].(TransactionTags) {
+			key, value, _ := core.SplitTag(tag)
+			t.Tags[key] = value
+		}
 	}
 	t.Notes = make(map[string]string, numNotes)
-	for n := numTransfers + 2; n < len(values); n += 2 {
-		t.Notes[values[n].(string)] = values[n+1].(string)
+	for n := numTransfers + numTags + 2; n < len(values); n += 2 {
+		name, value := values[n].(string), values[n+1].(string)
+		if typ, ok := ctx.NoteTypes[name]; ok {
+			if _, err := core.ParseNoteValue(typ, value); err != nil {
+				return t, fmt.Errorf("note %v: %v", name, err)
+			}
+		}
+		t.Notes[name] = value
 	}
 	return t, nil
 }
 
+// Execute executes the Transaction's transfers.  It also records
+// the unit prices of transfers with exchange rates (except those at cost)
+// and the sale prices of transfers at cost in the Context's price database,
+// the exchanges in the Context's conversions, and the Transaction
+// in the Context's journal.
 func (t *Transaction) Execute(ctx *core.Context) error {
 	for _, transfer := range t.Transfers {
 		if err := transfer.ExecuteTransfer(ctx); err != nil {
 			return err
 		}
 	}
+	sales := map[*core.Quantity]bool{} // sale prices recorded so far
+	for _, transfer := range t.Transfers {
+		if transfer.SalePrice != nil && !sales[transfer.SalePrice] {
+			sales[transfer.SalePrice] = true
+			ctx.Prices.Add(core.Price{Date: ctx.Date, Commodity: transfer.Quantity.Commodity, Price: *transfer.SalePrice})
+		}
+		if transfer.ExchangeRate != nil {
+			if !transfer.AtCost {
+				ctx.Prices.Add(core.Price{Date: ctx.Date, Commodity: transfer.Quantity.Commodity, Price: transfer.ExchangeRate.UnitPrice})
+			}
+			ctx.Convert(transfer.ExchangeRate.TotalPrice, transfer.Quantity)
+		}
+	}
+	ctx.Record(t.JournalEntry(ctx.Date))
 	return nil
 }
+
+// JournalEntry returns the Transaction as a JournalEntry dated date.
+func (t *Transaction) JournalEntry(date core.Date) core.JournalEntry {
+	e := core.JournalEntry{Date: date, Entity: t.Entity, Description: t.Description, Postings: make([]core.Posting, len(t.Transfers)), Notes: t.Notes, Tags: t.Tags}
+	for n, transfer := range t.Transfers {
+		e.Postings[n] = core.Posting{Account: transfer.Account.Name, LotName: transfer.LotName, Quantity: transfer.Quantity, ExchangeRate: transfer.ExchangeRate, Comment: transfer.Comment, Document: transfer.Document}
+	}
+	return e
+}
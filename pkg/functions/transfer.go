@@ -31,6 +31,7 @@ import (
 	"github.com/jtvaughan/freebean/pkg/core"
 	"github.com/jtvaughan/freebean/pkg/parser"
 	"github.com/shopspring/decimal"
+	"sort"
 	"strings"
 )
 
@@ -41,6 +42,55 @@ type Transfer struct {
 	Quantity     core.Quantity
 	ExchangeRate *core.ExchangeRate
 	Comment      string
+
+	// Document is the path or URL of a document supporting the Transfer,
+	// such as a receipt.  See SetDocumentFunction.
+	Document string
+
+	// AtCost indicates that ExchangeRate is the cost basis of the lot
+	// that the Transfer reduces rather than a market price, so executing
+	// the Transfer does not record a price.  See ParseLotReduction.
+	AtCost bool
+
+	// Proceeds is the amount received for an AtCost Transfer's reduction
+	// of its lot, which determines the realized gain.  It is nil for
+	// other Transfers, whose proceeds are their exchange rates'
+	// total prices.
+	Proceeds *core.Quantity
+
+	// SalePrice is the unit price at which an AtCost Transfer sold its
+	// commodity, which executing the Transfer's Transaction records
+	// as a price.  Transfers that reduce several lots at once share it.
+	SalePrice *core.Quantity
+
+	// Shares split the Transfer's quantity among people and Payer
+	// names the person who paid it.  See ShareFunction.
+	Shares []Share
+	Payer  string
+
+	// Client and Project identify the work for which a Transfer records
+	// time.  See TimeEntryFunction.
+	Client, Project string
+}
+
+// Share is a person's share of a Transfer.  Weights are relative to
+// the weights of the Transfer's other shares.
+type Share struct {
+	Person string
+	Weight decimal.Decimal
+}
+
+// String returns the Transfer's account, quantity, exchange rate, if any,
+// and lot name, if any, such as for debugging.
+func (t *Transfer) String() string {
+	s := fmt.Sprintf("%v %v", t.Account.Name, t.Quantity)
+	if t.ExchangeRate != nil {
+		s += fmt.Sprintf(" @ %v", t.ExchangeRate.UnitPrice)
+	}
+	if len(t.LotName) != 0 {
+		s += " lot " + parser.Quote(t.LotName)
+	}
+	return s
 }
 
 func (t Transfer) Lot(creationDate core.Date) *core.Lot {
@@ -68,6 +118,9 @@ func (t *Transfer) ExecuteTransfer(ctx *core.Context) error {
 			return fmt.Errorf(`account %v does not have a lot named "%v"`, t.Account.Name, t.LotName)
 		}
 	} else if l, ok := ctol[t.Quantity.Commodity.Name]; ok {
+		if g, ok := t.realizedGain(ctx, l); ok {
+			ctx.RealizedGains = append(ctx.RealizedGains, g)
+		}
 		l.Balance.Amount = l.Balance.Amount.Add(t.Quantity.Amount)
 	} else {
 		ctol[t.Quantity.Commodity.Name] = t.Lot(ctx.Date)
@@ -75,6 +128,33 @@ func (t *Transfer) ExecuteTransfer(ctx *core.Context) error {
 	return nil
 }
 
+// realizedGain returns the gain or loss realized by reducing lot l with t
+// and whether t realizes one.  Only negative Transfers with exchange rates
+// that reduce lots with exchange rates in the same commodity realize
+// gains.  Unless t is AtCost, the cost basis is the reduction times
+// l's unit price, rounded to the commodity's decimal places, if any.
+func (t *Transfer) realizedGain(ctx *core.Context, l *core.Lot) (core.RealizedGain, bool) {
+	if !t.Quantity.Amount.IsNegative() || t.ExchangeRate == nil || l.ExchangeRate == nil {
+		return core.RealizedGain{}, false
+	}
+	g := core.RealizedGain{Date: ctx.Date, Account: t.Account.Name, LotName: t.LotName, Quantity: core.Quantity{Commodity: t.Quantity.Commodity, Amount: t.Quantity.Amount.Neg()}}
+	if t.AtCost {
+		if t.Proceeds == nil {
+			return core.RealizedGain{}, false
+		}
+		g.Proceeds = *t.Proceeds
+		g.CostBasis = core.Quantity{Commodity: t.ExchangeRate.TotalPrice.Commodity, Amount: t.ExchangeRate.TotalPrice.Amount.Neg()}
+	} else {
+		c := l.ExchangeRate.UnitPrice.Commodity
+		if t.ExchangeRate.TotalPrice.Commodity != c {
+			return core.RealizedGain{}, false
+		}
+		g.Proceeds = core.Quantity{Commodity: c, Amount: t.ExchangeRate.TotalPrice.Amount.Neg()}
+		g.CostBasis = core.Quantity{Commodity: c, Amount: roundAmount(c, g.Quantity.Amount.Mul(l.ExchangeRate.UnitPrice.Amount))}
+	}
+	return g, true
+}
+
 func ParseDecimal(q string) (decimal.Decimal, error) {
 	return decimal.NewFromString(strings.ReplaceAll(q, ",", ""))
 }
@@ -96,20 +176,25 @@ func ParseTransfer(op parser.Operands, ctx *core.Context) (*Transfer, error) {
 		return t, fmt.Errorf("non-string quantity: %v", values[1])
 	} else if cn, ok = values[2].(string); !ok {
 		return t, fmt.Errorf("non-string commodity name: %v", values[2])
-	} else if t.Quantity.Amount, e = ParseDecimal(q); e != nil {
+	} else if t.Quantity.Amount, e = ParseAmount(q); e != nil {
 		return t, fmt.Errorf("illegal decimal value %v: %v", q, e)
 	}
-	if t.Account, ok = ctx.Accounts[an]; !ok {
+	if t.Account, ok = ctx.Account(an); !ok {
 		return t, fmt.Errorf("nonexistent account: %v", an)
 	} else if t.Account.IsClosed(ctx.Date) {
 		return t, fmt.Errorf("closed account: %v", an)
-	} else if c, ok = ctx.Commodities[cn]; !ok {
+	} else if c, ok = ctx.Commodity(cn); !ok {
 		return t, fmt.Errorf("nonexistent commodity: %v", cn)
 	} else if len(t.Account.Commodities) != 0 {
 		if _, ok = t.Account.Commodities[cn]; !ok {
 			return t, fmt.Errorf("cannot transfer %v to or from account %v", cn, an)
 		}
 	}
+	if t.Quantity.Amount, e = c.ApplyDecimalPlaces(t.Quantity.Amount); e != nil {
+		return t, e
+	} else if e = c.CheckSmallestUnit(t.Quantity.Amount); e != nil {
+		return t, e
+	}
 	t.Quantity.Commodity = c
 	return t, nil
 }
@@ -139,41 +224,215 @@ func ParseTransferWithExchange(op parser.Operands, ctx *core.Context) (*Transfer
 		return t, fmt.Errorf("non-string quantity: %v", values[1])
 	} else if cn, ok = values[2].(string); !ok {
 		return t, fmt.Errorf("non-string commodity name: %v", values[2])
-	} else if t.Quantity.Amount, e = ParseDecimal(q); e != nil {
+	} else if t.Quantity.Amount, e = ParseAmount(q); e != nil {
 		return t, fmt.Errorf("illegal decimal value %v: %v", q, e)
 	} else if upq, ok = values[3].(string); !ok {
 		return t, fmt.Errorf("non-string unit price quantity: %v", values[3])
 	} else if upcn, ok = values[4].(string); !ok {
 		return t, fmt.Errorf("non-string unit price commodity name: %v", values[4])
-	} else if t.ExchangeRate.UnitPrice.Amount, e = ParseDecimal(upq); e != nil {
+	} else if t.ExchangeRate.UnitPrice.Amount, e = ParseAmount(upq); e != nil {
 		return t, fmt.Errorf("illegal decimal value %v: %v", upq, e)
 	} else if tpq, ok = values[5].(string); !ok {
 		return t, fmt.Errorf("non-string total price quantity: %v", values[5])
 	} else if tpcn, ok = values[6].(string); !ok {
 		return t, fmt.Errorf("non-string total price commodity name: %v", values[6])
-	} else if t.ExchangeRate.TotalPrice.Amount, e = ParseDecimal(tpq); e != nil {
+	} else if t.ExchangeRate.TotalPrice.Amount, e = ParseAmount(tpq); e != nil {
 		return t, fmt.Errorf("illegal decimal value %v: %v", tpq, e)
 	}
-	if t.Account, ok = ctx.Accounts[an]; !ok {
+	if t.Account, ok = ctx.Account(an); !ok {
 		return t, fmt.Errorf("nonexistent account: %v", an)
 	} else if t.Account.IsClosed(ctx.Date) {
 		return t, fmt.Errorf("closed account: %v", an)
 	}
-	if c, ok = ctx.Commodities[cn]; !ok {
+	if c, ok = ctx.Commodity(cn); !ok {
 		return t, fmt.Errorf("nonexistent commodity: %v", cn)
 	} else if len(t.Account.Commodities) != 0 {
 		if _, ok = t.Account.Commodities[cn]; !ok {
 			return t, fmt.Errorf("cannot transfer %v to or from account %v", cn, an)
 		}
 	}
+	if t.Quantity.Amount, e = c.ApplyDecimalPlaces(t.Quantity.Amount); e != nil {
+		return t, e
+	} else if e = c.CheckSmallestUnit(t.Quantity.Amount); e != nil {
+		return t, e
+	}
 	t.Quantity.Commodity = c
-	if c, ok = ctx.Commodities[upcn]; !ok {
+	if c, ok = ctx.Commodity(upcn); !ok {
 		return t, fmt.Errorf("nonexistent unit price commodity: %v", upcn)
 	}
 	t.ExchangeRate.UnitPrice.Commodity = c
-	if c, ok = ctx.Commodities[tpcn]; !ok {
+	if c, ok = ctx.Commodity(tpcn); !ok {
 		return t, fmt.Errorf("nonexistent total price commodity: %v", tpcn)
+	} else if t.ExchangeRate.TotalPrice.Amount, e = c.ApplyDecimalPlaces(t.ExchangeRate.TotalPrice.Amount); e != nil {
+		return t, e
 	}
 	t.ExchangeRate.TotalPrice.Commodity = c
 	return t, nil
 }
+
+// ParseTransferWithTotalPrice is like ParseTransferWithExchange, but it
+// computes the unit price by dividing the total price by the quantity.
+// Unit prices are rounded to ctx.Options.UnitPriceDecimalPlaces decimal
+// places if it is not negative.
+//
+// Syntax: ACCOUNT AMOUNT COMMODITY TOTAL-AMOUNT TOTAL-COMMODITY -> Transfer
+func ParseTransferWithTotalPrice(op parser.Operands, ctx *core.Context) (*Transfer, error) {
+	if op.Length() < 5 {
+		return &Transfer{}, fmt.Errorf("account name, quantity, commodity name, total price amount, and total price commodity name operands are required, but too few given")
+	}
+	values := op.Pop(2)
+	var tpq, tpcn string
+	var total core.Quantity
+	var ok bool
+	var e error
+	if tpq, ok = values[0].(string); !ok {
+		return &Transfer{}, fmt.Errorf("non-string total price quantity: %v", values[0])
+	} else if tpcn, ok = values[1].(string); !ok {
+		return &Transfer{}, fmt.Errorf("non-string total price commodity name: %v", values[1])
+	} else if total.Amount, e = ParseAmount(tpq); e != nil {
+		return &Transfer{}, fmt.Errorf("illegal decimal value %v: %v", tpq, e)
+	}
+	t, e := ParseTransfer(op, ctx)
+	if e != nil {
+		return t, e
+	} else if total.Commodity, ok = ctx.Commodity(tpcn); !ok {
+		return t, fmt.Errorf("nonexistent total price commodity: %v", tpcn)
+	} else if total.Amount, e = total.Commodity.ApplyDecimalPlaces(total.Amount); e != nil {
+		return t, e
+	} else if t.Quantity.Amount.IsZero() {
+		return t, fmt.Errorf("cannot compute the unit price of a zero quantity")
+	}
+	unitPrice := core.Quantity{Commodity: total.Commodity, Amount: total.Amount.Div(t.Quantity.Amount)}
+	if places := ctx.Options.UnitPriceDecimalPlaces; places >= 0 {
+		unitPrice.Amount = unitPrice.Amount.Round(places)
+	}
+	t.ExchangeRate = &core.ExchangeRate{UnitPrice: unitPrice, TotalPrice: total}
+	return t, nil
+}
+
+// roundAmount rounds amount to c's decimal places, if any.
+func roundAmount(c *core.Commodity, amount decimal.Decimal) decimal.Decimal {
+	if c.DecimalPlaces >= 0 {
+		return amount.Round(c.DecimalPlaces)
+	}
+	return amount
+}
+
+// ParseLotReduction returns Transfers that reduce an account's named lots
+// of a commodity by a negative amount, as in a sale, and realize the gain
+// or loss relative to the lots' exchange rates.  The lots are chosen
+// according to ctx.Options.LotReduction:
+//
+//	fifo     oldest lots first
+//	lifo     newest lots first
+//	average  oldest lots first, but every lot is reduced at the average
+//	         unit price of all of the lots, weighted by their balances
+//
+// Lots are ordered by creation date and then by name.  Only lots with
+// positive balances and exchange rates in the unit price's commodity
+// are reduced.  Each reduced lot gets a Transfer whose exchange rate is
+// its cost basis (see Transfer.AtCost).  If the proceeds (the amount
+// times the unit price) differ from the total cost basis, a final
+// Transfer moves the difference to or from the gains account: gains are
+// negative, like income.  Each reduced lot's Transfer records its share
+// of the proceeds (see Transfer.Proceeds), so executing the Transfers
+// records the realized gains in ctx.  Amounts in the unit price's commodity are
+// rounded to its decimal places, if any.  Executing the Transfers' Transaction
+// records the unit price in ctx's price database (see Transfer.SalePrice);
+// ParseLotReduction does not change ctx.
+//
+// Syntax: ACCOUNT AMOUNT COMMODITY UNIT-AMOUNT UNIT-COMMODITY
+// GAINS-ACCOUNT -> Transfer+
+func ParseLotReduction(op parser.Operands, ctx *core.Context) ([]*Transfer, error) {
+	if op.Length() < 6 {
+		return nil, fmt.Errorf("account name, quantity, commodity name, unit price amount, unit price commodity name, and gains account name operands are required, but too few given")
+	}
+	values := op.Pop(3)
+	var upq, upcn, gn string
+	var price core.Quantity
+	var gains *core.Account
+	var ok bool
+	var e error
+	if upq, ok = values[0].(string); !ok {
+		return nil, fmt.Errorf("non-string unit price quantity: %v", values[0])
+	} else if upcn, ok = values[1].(string); !ok {
+		return nil, fmt.Errorf("non-string unit price commodity name: %v", values[1])
+	} else if gn, ok = values[2].(string); !ok {
+		return nil, fmt.Errorf("non-string gains account name: %v", values[2])
+	} else if price.Amount, e = ParseAmount(upq); e != nil {
+		return nil, fmt.Errorf("illegal decimal value %v: %v", upq, e)
+	}
+	t, e := ParseTransfer(op, ctx)
+	if e != nil {
+		return nil, e
+	} else if !t.Quantity.Amount.IsNegative() {
+		return nil, fmt.Errorf("cannot reduce lots by a nonnegative amount: %v", t.Quantity)
+	} else if price.Commodity, ok = ctx.Commodity(upcn); !ok {
+		return nil, fmt.Errorf("nonexistent unit price commodity: %v", upcn)
+	} else if gains, ok = ctx.Account(gn); !ok {
+		return nil, fmt.Errorf("nonexistent account: %v", gn)
+	} else if gains.IsClosed(ctx.Date) {
+		return nil, fmt.Errorf("closed account: %v", gn)
+	} else if len(gains.Commodities) != 0 {
+		if _, ok = gains.Commodities[upcn]; !ok {
+			return nil, fmt.Errorf("cannot transfer %v to or from account %v", upcn, gn)
+		}
+	}
+
+	var lots []*core.Lot
+	available, cost := decimal.Decimal{}, decimal.Decimal{}
+	for ln, ctol := range t.Account.Lots {
+		if l, ok := ctol[t.Quantity.Commodity.Name]; ok && len(ln) != 0 && l.Balance.Amount.IsPositive() && l.ExchangeRate != nil && l.ExchangeRate.UnitPrice.Commodity == price.Commodity {
+			lots = append(lots, l)
+			available = available.Add(l.Balance.Amount)
+			cost = cost.Add(l.Balance.Amount.Mul(l.ExchangeRate.UnitPrice.Amount))
+		}
+	}
+	amount := t.Quantity.Amount.Neg()
+	if available.LessThan(amount) {
+		return nil, fmt.Errorf("account %v has only %v %v in lots with exchange rates in %v, but %v are needed", t.Account.Name, available, t.Quantity.Commodity, upcn, amount)
+	}
+	sort.Slice(lots, func(i, j int) bool {
+		if !lots[i].CreationDate.Equal(lots[j].CreationDate) {
+			return lots[i].CreationDate.Before(lots[j].CreationDate)
+		}
+		return lots[i].Name < lots[j].Name
+	})
+	if ctx.Options.LotReduction == core.LIFOReduction {
+		for i, j := 0, len(lots)-1; i < j; i, j = i+1, j-1 {
+			lots[i], lots[j] = lots[j], lots[i]
+		}
+	}
+
+	var transfers []*Transfer
+	basis := decimal.Decimal{}
+	for _, l := range lots {
+		if amount.IsZero() {
+			break
+		}
+		reduction := decimal.Min(amount, l.Balance.Amount)
+		amount = amount.Sub(reduction)
+		unitPrice := l.ExchangeRate.UnitPrice
+		if ctx.Options.LotReduction == core.AverageReduction {
+			unitPrice.Amount = cost.Div(available)
+			if places := ctx.Options.UnitPriceDecimalPlaces; places >= 0 {
+				unitPrice.Amount = unitPrice.Amount.Round(places)
+			}
+		}
+		total := core.Quantity{Commodity: price.Commodity, Amount: roundAmount(price.Commodity, reduction.Mul(unitPrice.Amount)).Neg()}
+		basis = basis.Sub(total.Amount)
+		rt := *t
+		rt.LotName = l.Name
+		rt.Quantity.Amount = reduction.Neg()
+		rt.ExchangeRate = &core.ExchangeRate{UnitPrice: unitPrice, TotalPrice: total}
+		rt.AtCost = true
+		rt.Proceeds = &core.Quantity{Commodity: price.Commodity, Amount: roundAmount(price.Commodity, reduction.Mul(price.Amount))}
+		rt.SalePrice = &price
+		transfers = append(transfers, &rt)
+	}
+	proceeds := roundAmount(price.Commodity, t.Quantity.Amount.Neg().Mul(price.Amount))
+	if gain := proceeds.Sub(basis); !gain.IsZero() {
+		transfers = append(transfers, &Transfer{Account: gains, Quantity: core.Quantity{Commodity: price.Commodity, Amount: gain.Neg()}})
+	}
+	return transfers, nil
+}
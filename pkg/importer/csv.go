@@ -0,0 +1,331 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Classifier assigns an account to CSV rows whose entity or description
+// matches Pattern.
+type Classifier struct {
+	Pattern *regexp.Regexp
+	Account string
+}
+
+// CSVRules describes how to convert a bank statement CSV file into ledger
+// syntax.  Rules files are YAML documents; see ParseCSVRules.
+type CSVRules struct {
+	// Columns maps field names to 1-based column numbers or header names.
+	Columns        map[string]string
+	Separator      rune
+	SkipRows       int
+	HasHeader      bool
+	DateFormat     string
+	Account        string
+	Commodity      string
+	DefaultAccount string
+	Negate         bool
+	Classifiers    []Classifier
+}
+
+// CSV field names recognized in rules files' columns.  Fields named
+// "note:NAME" become transaction notes named NAME.
+const (
+	DateField        = "date"
+	EntityField      = "entity"
+	DescriptionField = "description"
+	AmountField      = "amount"
+	DebitField       = "debit"
+	CreditField      = "credit"
+	notePrefix       = "note:"
+)
+
+// csvRulesFile is the YAML form of CSVRules.
+type csvRulesFile struct {
+	Account        string            `yaml:"account"`
+	Commodity      string            `yaml:"commodity"`
+	DefaultAccount string            `yaml:"default-account"`
+	DateFormat     string            `yaml:"date-format"`
+	Separator      string            `yaml:"separator"`
+	SkipRows       int               `yaml:"skip-rows"`
+	Header         bool              `yaml:"header"`
+	Negate         bool              `yaml:"negate"`
+	Columns        map[string]string `yaml:"columns"`
+	Rules          []struct {
+		Match   string `yaml:"match"`
+		Account string `yaml:"account"`
+	} `yaml:"rules"`
+}
+
+// ParseCSVRules parses a YAML rules file, such as:
+//
+//	account: Assets:Checking
+//	commodity: USD
+//	date-format: 01/02/2006
+//	header: true
+//	columns:
+//	  date: Date
+//	  entity: Payee
+//	  description: 3
+//	  amount: Amount
+//	  note:check: 5
+//	rules:
+//	  - match: (?i)coffee
+//	    account: Expenses:Dining
+//	default-account: Expenses:Unknown
+//
+// Columns map fields to 1-based column numbers or header names.  Rules
+// match regular expressions against rows' entities and descriptions;
+// the first matching rule's account is the counter account, and rows
+// that match no rule use default-account.  separator (default ","),
+// skip-rows (rows to skip before the header), and negate (negate all
+// amounts) are optional, and date-format defaults to "2006-01-02".
+// Unknown keys are errors.
+func ParseCSVRules(r io.Reader) (*CSVRules, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	file := csvRulesFile{Separator: ",", DateFormat: "2006-01-02"}
+	if err = yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	rules := &CSVRules{Columns: map[string]string{}, SkipRows: file.SkipRows, HasHeader: file.Header, DateFormat: file.DateFormat, Account: file.Account, Commodity: file.Commodity, DefaultAccount: file.DefaultAccount, Negate: file.Negate}
+	for field, column := range file.Columns {
+		switch field {
+		case DateField, EntityField, DescriptionField, AmountField, DebitField, CreditField:
+		default:
+			if !strings.HasPrefix(field, notePrefix) || len(field) == len(notePrefix) {
+				return nil, fmt.Errorf("unknown field: %v", field)
+			}
+		}
+		rules.Columns[field] = column
+	}
+	for n, rule := range file.Rules {
+		if len(rule.Match) == 0 || len(rule.Account) == 0 {
+			return nil, fmt.Errorf("rule %v: match and account are required", n+1)
+		}
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v: illegal pattern %v: %v", n+1, rule.Match, err)
+		}
+		rules.Classifiers = append(rules.Classifiers, Classifier{Pattern: re, Account: rule.Account})
+	}
+	if utf8.RuneCountInString(file.Separator) != 1 {
+		return nil, fmt.Errorf("separator must be a single character: %v", file.Separator)
+	} else if file.SkipRows < 0 {
+		return nil, fmt.Errorf("illegal row count: %v", file.SkipRows)
+	}
+	rules.Separator, _ = utf8.DecodeRuneInString(file.Separator)
+	if len(rules.Account) == 0 {
+		return nil, fmt.Errorf("rules do not specify an account")
+	} else if len(rules.Commodity) == 0 {
+		return nil, fmt.Errorf("rules do not specify a commodity")
+	} else if _, ok := rules.Columns[DateField]; !ok {
+		return nil, fmt.Errorf("rules do not specify a date column")
+	}
+	_, hasAmount := rules.Columns[AmountField]
+	_, hasDebit := rules.Columns[DebitField]
+	_, hasCredit := rules.Columns[CreditField]
+	if !hasAmount && !hasDebit && !hasCredit {
+		return nil, fmt.Errorf("rules do not specify an amount, debit, or credit column")
+	}
+	return rules, nil
+}
+
+// Classify returns the account of the first Classifier matching the entity
+// or description, or DefaultAccount if none match.
+func (rules *CSVRules) Classify(entity, description string) string {
+	for _, c := range rules.Classifiers {
+		if c.Pattern.MatchString(entity) || c.Pattern.MatchString(description) {
+			return c.Account
+		}
+	}
+	return rules.DefaultAccount
+}
+
+// resolveColumns maps field names to 0-based column indices.
+func (rules *CSVRules) resolveColumns(header []string) (map[string]int, error) {
+	indices := make(map[string]int, len(rules.Columns))
+	for field, column := range rules.Columns {
+		if n, err := strconv.Atoi(column); err == nil {
+			if n < 1 {
+				return nil, fmt.Errorf("illegal column number for %v: %v", field, column)
+			}
+			indices[field] = n - 1
+			continue
+		}
+		found := false
+		for n, h := range header {
+			if strings.TrimSpace(h) == column {
+				indices[field] = n
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no column named %v for %v", column, field)
+		}
+	}
+	return indices, nil
+}
+
+func parseCSVAmount(s string) (decimal.Decimal, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return decimal.Decimal{}, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if negative {
+		s = s[1 : len(s)-1]
+	}
+	s = strings.TrimLeft(s, "$€£¥ ")
+	d, err := functions.ParseDecimal(s)
+	if negative {
+		d = d.Neg()
+	}
+	return d, err
+}
+
+// ConvertCSV reads bank statement CSV data from r and returns the
+// corresponding entries.
+func (rules *CSVRules) ConvertCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = rules.Separator
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if rules.SkipRows > len(records) {
+		return nil, nil
+	}
+	records = records[rules.SkipRows:]
+	rowNumber := rules.SkipRows + 1
+	var header []string
+	if rules.HasHeader && len(records) != 0 {
+		header = records[0]
+		records = records[1:]
+		rowNumber++
+	}
+	indices, err := rules.resolveColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	get := func(record []string, field string) (string, error) {
+		if n, ok := indices[field]; !ok {
+			return "", nil
+		} else if n >= len(record) {
+			return "", fmt.Errorf("missing %v column", field)
+		} else {
+			return strings.TrimSpace(record[n]), nil
+		}
+	}
+
+	entries := make([]Entry, len(records))[:0]
+	for n, record := range records {
+		e, err := rules.convertRecord(record, get)
+		if err != nil {
+			return nil, fmt.Errorf("row %v: %v", rowNumber+n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (rules *CSVRules) convertRecord(record []string, get func([]string, string) (string, error)) (Entry, error) {
+	e := Entry{}
+	var ds, entity, description, as string
+	var err error
+	if ds, err = get(record, DateField); err != nil {
+		return e, err
+	} else if entity, err = get(record, EntityField); err != nil {
+		return e, err
+	} else if description, err = get(record, DescriptionField); err != nil {
+		return e, err
+	}
+	t, err := time.Parse(rules.DateFormat, ds)
+	if err != nil {
+		return e, fmt.Errorf("illegal date %v: %v", ds, err)
+	}
+	var amount decimal.Decimal
+	for _, field := range []string{AmountField, CreditField, DebitField} {
+		if as, err = get(record, field); err != nil {
+			return e, err
+		}
+		d, err := parseCSVAmount(as)
+		if err != nil {
+			return e, fmt.Errorf("illegal %v %v: %v", field, as, err)
+		} else if field == DebitField {
+			d = d.Abs().Neg()
+		}
+		amount = amount.Add(d)
+	}
+	if rules.Negate {
+		amount = amount.Neg()
+	}
+	if len(entity) == 0 {
+		entity = description
+	}
+	account := rules.Classify(entity, description)
+	if len(account) == 0 {
+		return e, fmt.Errorf("no classification rule matches %v and no default account is specified", entity)
+	}
+
+	e.Date = core.FromTime(t)
+	e.Entity = entity
+	e.Description = description
+	e.Postings = []Posting{
+		{Account: rules.Account, Amount: amount, Commodity: rules.Commodity},
+		{Account: account, Amount: amount.Neg(), Commodity: rules.Commodity}}
+	for field := range rules.Columns {
+		if strings.HasPrefix(field, notePrefix) {
+			v, err := get(record, field)
+			if err != nil {
+				return e, err
+			} else if len(v) != 0 {
+				e.Notes = append(e.Notes, [2]string{field[len(notePrefix):], v})
+			}
+		}
+	}
+	sort.Slice(e.Notes, func(i, j int) bool { return e.Notes[i][0] < e.Notes[j][0] })
+	return e, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRules = `
+account: Assets:Checking
+commodity: USD
+date-format: 01/02/2006
+header: true
+columns:
+  date: Date
+  entity: Payee
+  description: 3
+  amount: Amount
+  note:check: 5
+rules:
+  - match: (?i)coffee
+    account: Expenses:Dining
+  - match: ^PAYROLL
+    account: Income:Salary
+default-account: Expenses:Unknown
+`
+
+const testCSV = `Date,Payee,Memo,Amount,Check
+03/12/2021,Corner Coffee,card 1234,-4.50,
+03/11/2021,PAYROLL,"Direct deposit, March","1,200.00",
+03/13/2021,Landlord,"Rent ""March""",(900.00),1001
+`
+
+func TestParseCSVRules(t *testing.T) {
+	rules, err := ParseCSVRules(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("ParseCSVRules failed: %v", err)
+	}
+	if !rules.HasHeader || rules.DateFormat != "01/02/2006" || rules.Account != "Assets:Checking" || rules.Commodity != "USD" || rules.DefaultAccount != "Expenses:Unknown" {
+		t.Errorf("ParseCSVRules returned unexpected rules: %+v", rules)
+	} else if len(rules.Classifiers) != 2 {
+		t.Errorf("ParseCSVRules returned %v classifiers instead of 2", len(rules.Classifiers))
+	} else if rules.Columns[AmountField] != "Amount" || rules.Columns[DescriptionField] != "3" {
+		t.Errorf("ParseCSVRules returned unexpected columns: %v", rules.Columns)
+	}
+}
+
+func TestParseCSVRules_Failures(t *testing.T) {
+	const columns = "columns: {date: 1, amount: 2}\n"
+	for _, rules := range []string{
+		"commodity: USD\n" + columns,
+		"account: A:B\n" + columns,
+		"account: A:B\ncommodity: USD\ncolumns: {amount: 2}",
+		"account: A:B\ncommodity: USD\ncolumns: {date: 1}",
+		"account: A:B\ncommodity: USD\ncolumns: {date: 1, payee: 2, amount: 3}",
+		"account: A:B\ncommodity: USD\nseparator: ';;'\n" + columns,
+		"account: A:B\ncommodity: USD\nskip-rows: -1\n" + columns,
+		"account: A:B\ncommodity: USD\nrules: [{match: '(', account: X:Y}]\n" + columns,
+		"account: A:B\ncommodity: USD\nrules: [{match: Store}]\n" + columns,
+		"account: A:B\ncommodity: USD\nclassify: X:Y\n" + columns,
+		"account: [A:B]",
+	} {
+		if _, err := ParseCSVRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("ParseCSVRules succeeded but should have failed: %q", rules)
+		}
+	}
+}
+
+func TestCSVRules_ConvertCSV(t *testing.T) {
+	rules, err := ParseCSVRules(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatalf("ParseCSVRules failed: %v", err)
+	}
+	entries, err := rules.ConvertCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("ConvertCSV failed: %v", err)
+	}
+	var b strings.Builder
+	if err = WriteEntries(&b, entries); err != nil {
+		t.Fatalf("WriteEntries failed: %v", err)
+	}
+	expected := `2021 3 11 date
+("PAYROLL" "Direct deposit, March"
+	Assets:Checking 1200 USD xfer
+	Income:Salary -1200 USD xfer
+	xact)
+
+2021 3 12 date
+("Corner Coffee" "card 1234"
+	Assets:Checking -4.5 USD xfer
+	Expenses:Dining 4.5 USD xfer
+	xact)
+
+2021 3 13 date
+("Landlord" "Rent \"March\""
+	Assets:Checking -900 USD xfer
+	Expenses:Unknown 900 USD xfer
+	"check" "1001"
+	xact)
+`
+	if b.String() != expected {
+		t.Errorf("unexpected output:\n%v", b.String())
+	}
+}
+
+func TestCSVRules_ConvertCSV_DebitAndCreditColumns(t *testing.T) {
+	rules, err := ParseCSVRules(strings.NewReader(`
+account: Liabilities:Card
+commodity: USD
+default-account: Expenses:Misc
+negate: true
+columns: {date: 1, entity: 2, debit: 3, credit: 4}
+`))
+	if err != nil {
+		t.Fatalf("ParseCSVRules failed: %v", err)
+	}
+	entries, err := rules.ConvertCSV(strings.NewReader("2021-01-02,Store,10.00,\n2021-01-03,Refund,,2.5\n"))
+	if err != nil {
+		t.Fatalf("ConvertCSV failed: %v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("ConvertCSV returned %v entries instead of 2", len(entries))
+	} else if a := entries[0].Postings[0].Amount.String(); a != "10" {
+		t.Errorf("debit converted to %v instead of 10", a)
+	} else if a := entries[1].Postings[0].Amount.String(); a != "-2.5" {
+		t.Errorf("credit converted to %v instead of -2.5", a)
+	}
+}
+
+func TestCSVRules_ConvertCSV_IllegalDate(t *testing.T) {
+	rules, err := ParseCSVRules(strings.NewReader("account: A:B\ncommodity: USD\ndefault-account: X:Y\ncolumns: {date: 1, amount: 2}"))
+	if err != nil {
+		t.Fatalf("ParseCSVRules failed: %v", err)
+	}
+	if _, err = rules.ConvertCSV(strings.NewReader("2021-13-40,1\n")); err == nil {
+		t.Errorf("ConvertCSV accepted an illegal date")
+	}
+}
+
+func TestCSVRules_ConvertCSV_SeparatorAndSkipRows(t *testing.T) {
+	rules, err := ParseCSVRules(strings.NewReader(`
+account: Assets:Checking
+commodity: EUR
+default-account: Expenses:Misc
+separator: ";"
+skip-rows: 1
+header: true
+columns: {date: Datum, description: Text, amount: Betrag}
+`))
+	if err != nil {
+		t.Fatalf("ParseCSVRules failed: %v", err)
+	}
+	entries, err := rules.ConvertCSV(strings.NewReader("Kontoauszug\nDatum;Text;Betrag\n2021-05-01;Bakery;-3.20\n"))
+	if err != nil {
+		t.Fatalf("ConvertCSV failed: %v", err)
+	} else if len(entries) != 1 {
+		t.Fatalf("ConvertCSV returned %v entries instead of 1", len(entries))
+	} else if e := entries[0]; e.Entity != "Bakery" || e.Postings[0].Amount.String() != "-3.2" || e.Postings[1].Account != "Expenses:Misc" {
+		t.Errorf("ConvertCSV returned an unexpected entry: %+v", e)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package importer converts foreign financial data formats into Freebean
+// ledger syntax.
+package importer
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Posting is a single transfer within an imported Entry.
+type Posting struct {
+	Account   string
+	Amount    decimal.Decimal
+	Commodity string
+	Lot       string // empty for the default lot
+	Comment   string
+}
+
+// Entry is an imported transaction.
+type Entry struct {
+	Date        core.Date
+	Entity      string
+	Description string
+	Postings    []Posting
+	Notes       [][2]string // (name, value) pairs in order
+}
+
+// WriteEntries writes the specified entries to w as ledger syntax.
+// Entries are sorted by date (preserving the relative order of entries
+// sharing a date) and a date call precedes each run of entries sharing
+// a date.
+func WriteEntries(w io.Writer, entries []Entry) error {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+	var date core.Date
+	for n, e := range sorted {
+		if n == 0 || !e.Date.Equal(date) {
+			date = e.Date
+			if n != 0 {
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%v %v %v date\n", date.Year, date.Month, date.Day); err != nil {
+				return err
+			}
+		}
+		if err := writeEntry(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// token returns s unchanged if the Lexer would lex it as a single unquoted
+// string or quoted otherwise.
+func token(s string) string {
+	if len(s) == 0 || strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '(' || r == ')' || r == '\\'
+	}) != -1 {
+		return parser.Quote(s)
+	}
+	return s
+}
+
+func writeEntry(w io.Writer, e Entry) error {
+	if _, err := fmt.Fprintf(w, "(%v %v\n", parser.Quote(e.Entity), parser.Quote(e.Description)); err != nil {
+		return err
+	}
+	for _, p := range e.Postings {
+		comment := ""
+		if len(p.Lot) != 0 {
+			comment = fmt.Sprintf(" %v lot", token(p.Lot))
+		}
+		if len(p.Comment) != 0 {
+			comment += fmt.Sprintf(" %v set-comment", parser.Quote(p.Comment))
+		}
+		if _, err := fmt.Fprintf(w, "\t%v %v %v xfer%v\n", token(p.Account), p.Amount, token(p.Commodity), comment); err != nil {
+			return err
+		}
+	}
+	for _, n := range e.Notes {
+		if _, err := fmt.Fprintf(w, "\t%v %v\n", parser.Quote(n[0]), parser.Quote(n[1])); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "\txact)")
+	return err
+}
@@ -0,0 +1,273 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/shopspring/decimal"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// QIFOptions controls how Quicken Interchange Format data is converted.
+type QIFOptions struct {
+	// Account is the account affected by the QIF transactions.
+	// Account blocks ("!Account") in the QIF data override it.
+	Account string
+
+	// Commodity is the commodity of all transfers.
+	Commodity string
+
+	// ExpensePrefix, IncomePrefix, and TransferPrefix are prepended
+	// (with a colon) to expense categories, income categories, and
+	// transfer categories ("[Account Name]"), respectively.  Categories
+	// are expenses if money leaves Account and income otherwise.
+	ExpensePrefix  string
+	IncomePrefix   string
+	TransferPrefix string
+
+	// UncategorizedAccount receives transfers without categories.
+	UncategorizedAccount string
+
+	// DayFirst makes dates day/month/year rather than month/day/year.
+	DayFirst bool
+}
+
+type qifSplit struct {
+	category string
+	memo     string
+	amount   decimal.Decimal
+}
+
+type qifRecord struct {
+	date     core.Date
+	amount   decimal.Decimal
+	payee    string
+	memo     string
+	category string
+	number   string
+	splits   []qifSplit
+}
+
+// ConvertQIF reads QIF bank, cash, credit card, or asset/liability account
+// data from r and returns the corresponding entries.  Each record becomes
+// a transaction with one transfer for the account and one for each split
+// (or one for the record's category if it has no splits).  Memos become
+// transfer comments and check numbers become "number" notes.
+func ConvertQIF(r io.Reader, opts QIFOptions) ([]Entry, error) {
+	scanner := bufio.NewScanner(r)
+	var entries []Entry
+	account := opts.Account
+	inAccountBlock := false
+	skipping := false
+	rec := qifRecord{}
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		code, value := line[0], strings.TrimSpace(line[1:])
+		if code == '!' {
+			header := strings.ToLower(value)
+			inAccountBlock = header == "account"
+			skipping = false
+			if strings.HasPrefix(header, "type:") {
+				switch t := header[len("type:"):]; t {
+				case "bank", "cash", "ccard", "oth a", "oth l":
+				case "cat", "class", "memorized":
+					skipping = true
+				default:
+					return nil, fmt.Errorf("line %v: unsupported QIF account type: %v", lineNumber, value)
+				}
+			} else if strings.HasPrefix(header, "option") || strings.HasPrefix(header, "clear") {
+				skipping = true
+			}
+			continue
+		} else if skipping {
+			continue
+		} else if inAccountBlock {
+			if code == 'N' {
+				account = joinAccount(opts.TransferPrefix, value)
+			} else if code == '^' {
+				inAccountBlock = false
+			}
+			continue
+		}
+
+		var err error
+		switch code {
+		case 'D':
+			rec.date, err = parseQIFDate(value, opts.DayFirst)
+		case 'T', 'U':
+			rec.amount, err = functions.ParseDecimal(value)
+		case 'P':
+			rec.payee = value
+		case 'M':
+			rec.memo = value
+		case 'L':
+			rec.category = value
+		case 'N':
+			rec.number = value
+		case 'S':
+			rec.splits = append(rec.splits, qifSplit{category: value})
+		case 'E':
+			if len(rec.splits) == 0 {
+				err = fmt.Errorf("split memo precedes split category")
+			} else {
+				rec.splits[len(rec.splits)-1].memo = value
+			}
+		case '$':
+			if len(rec.splits) == 0 {
+				err = fmt.Errorf("split amount precedes split category")
+			} else {
+				rec.splits[len(rec.splits)-1].amount, err = functions.ParseDecimal(value)
+			}
+		case '^':
+			if len(account) == 0 {
+				err = fmt.Errorf("no account specified")
+			} else {
+				var e Entry
+				if e, err = rec.toEntry(account, opts); err == nil {
+					entries = append(entries, e)
+				}
+			}
+			rec = qifRecord{}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// joinAccount joins an account prefix and a QIF account or category name.
+// QIF class names ("Category/Class") are dropped.
+func joinAccount(prefix, name string) string {
+	if n := strings.Index(name, "/"); n != -1 {
+		name = name[:n]
+	}
+	if len(prefix) == 0 {
+		return name
+	}
+	return prefix + ":" + name
+}
+
+// categoryAccount returns the account for a category whose transfer
+// has the specified amount.
+func categoryAccount(category string, amount decimal.Decimal, opts QIFOptions) string {
+	category = strings.TrimSpace(category)
+	if strings.HasPrefix(category, "[") {
+		if n := strings.Index(category, "]"); n != -1 {
+			return joinAccount(opts.TransferPrefix, category[1:n])
+		}
+	}
+	if n := strings.Index(category, "/"); n != -1 {
+		category = category[:n]
+	}
+	if len(category) == 0 {
+		return opts.UncategorizedAccount
+	} else if amount.IsNegative() {
+		return joinAccount(opts.IncomePrefix, category)
+	}
+	return joinAccount(opts.ExpensePrefix, category)
+}
+
+func (rec qifRecord) toEntry(account string, opts QIFOptions) (Entry, error) {
+	e := Entry{Date: rec.date, Entity: rec.payee}
+	if rec.date.IsZero() {
+		return e, fmt.Errorf("record has no date")
+	}
+	e.Postings = append(e.Postings, Posting{Account: account, Amount: rec.amount, Commodity: opts.Commodity, Comment: rec.memo})
+	if len(rec.splits) == 0 {
+		amount := rec.amount.Neg()
+		e.Postings = append(e.Postings, Posting{Account: categoryAccount(rec.category, amount, opts), Amount: amount, Commodity: opts.Commodity})
+	} else {
+		var sum decimal.Decimal
+		for _, s := range rec.splits {
+			amount := s.amount.Neg()
+			sum = sum.Add(s.amount)
+			e.Postings = append(e.Postings, Posting{Account: categoryAccount(s.category, amount, opts), Amount: amount, Commodity: opts.Commodity, Comment: s.memo})
+		}
+		if !sum.Equal(rec.amount) {
+			return e, fmt.Errorf("splits sum to %v, not the record's amount %v", sum, rec.amount)
+		}
+	}
+	for _, p := range e.Postings {
+		if len(p.Account) == 0 {
+			return e, fmt.Errorf("uncategorized transfer and no uncategorized account specified")
+		}
+	}
+	if len(rec.number) != 0 {
+		e.Notes = append(e.Notes, [2]string{"number", rec.number})
+	}
+	return e, nil
+}
+
+// parseQIFDate parses QIF dates such as "3/11/2021", "03/11/21", "3/11'21",
+// and "3/11' 5".  Two-digit years after an apostrophe are in the 2000s;
+// other two-digit years are in the 1900s if they are 50 or greater
+// and in the 2000s otherwise.
+func parseQIFDate(s string, dayFirst bool) (core.Date, error) {
+	apostrophe := strings.Contains(s, "'")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '\'' || r == ' '
+	})
+	if len(fields) != 3 {
+		return core.Date{}, fmt.Errorf("illegal date: %v", s)
+	}
+	var nums [3]int
+	for n, f := range fields {
+		var err error
+		if nums[n], err = strconv.Atoi(f); err != nil {
+			return core.Date{}, fmt.Errorf("illegal date: %v", s)
+		}
+	}
+	m, d, y := nums[0], nums[1], nums[2]
+	if dayFirst {
+		m, d = d, m
+	}
+	if len(fields[2]) <= 2 {
+		if apostrophe || y < 50 {
+			y += 2000
+		} else {
+			y += 1900
+		}
+	}
+	if m < 1 || m > 12 || d < 1 || d > 31 {
+		return core.Date{}, fmt.Errorf("illegal date: %v", s)
+	}
+	return core.Date{Year: y, Month: m, Day: d}, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"strings"
+	"testing"
+)
+
+var testQIFOptions = QIFOptions{
+	Account:              "Assets:Checking",
+	Commodity:            "USD",
+	ExpensePrefix:        "Expenses",
+	IncomePrefix:         "Income",
+	TransferPrefix:       "Assets",
+	UncategorizedAccount: "Expenses:Uncategorized",
+}
+
+const testQIF = `!Type:Bank
+D3/11'21
+T-1,234.56
+N1001
+PLandlord
+MMarch rent
+LHousing:Rent
+^
+D3/12/2021
+T2000.00
+PEmployer
+SSalary
+ESalary
+$2500.00
+STaxes:Federal
+EWithholding
+$-500.00
+^
+D03/13/21
+T-100
+PTransfer
+L[Savings Account]
+^
+`
+
+func TestConvertQIF(t *testing.T) {
+	entries, err := ConvertQIF(strings.NewReader(testQIF), testQIFOptions)
+	if err != nil {
+		t.Fatalf("ConvertQIF failed: %v", err)
+	}
+	var b strings.Builder
+	if err = WriteEntries(&b, entries); err != nil {
+		t.Fatalf("WriteEntries failed: %v", err)
+	}
+	expected := `2021 3 11 date
+("Landlord" ""
+	Assets:Checking -1234.56 USD xfer "March rent" set-comment
+	Expenses:Housing:Rent 1234.56 USD xfer
+	"number" "1001"
+	xact)
+
+2021 3 12 date
+("Employer" ""
+	Assets:Checking 2000 USD xfer
+	Income:Salary -2500 USD xfer "Salary" set-comment
+	Expenses:Taxes:Federal 500 USD xfer "Withholding" set-comment
+	xact)
+
+2021 3 13 date
+("Transfer" ""
+	Assets:Checking -100 USD xfer
+	"Assets:Savings Account" 100 USD xfer
+	xact)
+`
+	if b.String() != expected {
+		t.Errorf("unexpected output:\n%v", b.String())
+	}
+}
+
+func TestConvertQIF_AccountBlocks(t *testing.T) {
+	entries, err := ConvertQIF(strings.NewReader(`!Account
+NCredit Card
+TCCard
+^
+!Type:CCard
+D1/2/2021
+T-5
+^
+`), QIFOptions{Commodity: "USD", ExpensePrefix: "Expenses", TransferPrefix: "Liabilities", UncategorizedAccount: "Expenses:Misc"})
+	if err != nil {
+		t.Fatalf("ConvertQIF failed: %v", err)
+	} else if len(entries) != 1 {
+		t.Fatalf("ConvertQIF returned %v entries instead of 1", len(entries))
+	} else if a := entries[0].Postings[0].Account; a != "Liabilities:Credit Card" {
+		t.Errorf("account block set account to %v", a)
+	} else if a := entries[0].Postings[1].Account; a != "Expenses:Misc" {
+		t.Errorf("uncategorized transfer went to %v", a)
+	}
+}
+
+func TestConvertQIF_SplitsDoNotSum(t *testing.T) {
+	_, err := ConvertQIF(strings.NewReader("!Type:Bank\nD1/2/2021\nT-5\nSA\n$-3\n^\n"), testQIFOptions)
+	if err == nil {
+		t.Errorf("ConvertQIF accepted splits that do not sum to the total")
+	}
+}
+
+func TestConvertQIF_UnsupportedType(t *testing.T) {
+	if _, err := ConvertQIF(strings.NewReader("!Type:Invst\nD1/2/2021\n^\n"), testQIFOptions); err == nil {
+		t.Errorf("ConvertQIF accepted investment data")
+	}
+}
+
+func TestParseQIFDate(t *testing.T) {
+	for s, expected := range map[string]core.Date{
+		"3/11/2021": {Year: 2021, Month: 3, Day: 11},
+		"03/11/99":  {Year: 1999, Month: 3, Day: 11},
+		"3/11'21":   {Year: 2021, Month: 3, Day: 11},
+		"3/11' 5":   {Year: 2005, Month: 3, Day: 11},
+	} {
+		if d, err := parseQIFDate(s, false); err != nil {
+			t.Errorf("parseQIFDate(%v) failed: %v", s, err)
+		} else if !d.Equal(expected) {
+			t.Errorf("parseQIFDate(%v) returned %v instead of %v", s, d, expected)
+		}
+	}
+	if d, err := parseQIFDate("11.03.2021", true); err != nil || !d.Equal(core.Date{Year: 2021, Month: 3, Day: 11}) {
+		t.Errorf("parseQIFDate with day first returned %v, %v", d, err)
+	}
+	if _, err := parseQIFDate("13/1/2021", false); err == nil {
+		t.Errorf("parseQIFDate accepted month 13")
+	}
+}
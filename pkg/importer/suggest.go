@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+// Suggestion is a suggested counter account for a transaction.
+type Suggestion struct {
+	Account string
+
+	// Count is the number of the entity's past transactions that
+	// transferred amounts with the same sign to the account.
+	Count int
+}
+
+// pastTransfer is a transfer to a candidate account in a Suggester's
+// history.
+type pastTransfer struct {
+	account string
+	amount  decimal.Decimal
+}
+
+// Suggester suggests counter accounts for transactions, such as imported
+// ones, based on the accounts that ledgers' past transactions with
+// the same entities used.
+type Suggester struct {
+	history map[string][]pastTransfer // normalized entity -> transfers
+}
+
+// normalizeEntity makes entities that differ only in case or whitespace
+// equal.
+func normalizeEntity(entity string) string {
+	return strings.ToLower(strings.Join(strings.Fields(entity), " "))
+}
+
+// NewSuggester returns a Suggester that suggests the accounts of
+// the transfers in journal whose accounts' names begin with one of
+// the specified prefixes, such as "Expenses", followed by colons.
+func NewSuggester(journal []core.JournalEntry, prefixes []string) *Suggester {
+	s := &Suggester{history: make(map[string][]pastTransfer)}
+	for _, e := range journal {
+		entity := normalizeEntity(e.Entity)
+		for _, p := range e.Postings {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(p.Account, prefix+":") {
+					s.history[entity] = append(s.history[entity], pastTransfer{p.Account, p.Quantity.Amount})
+					break
+				}
+			}
+		}
+	}
+	return s
+}
+
+// Suggest returns suggested counter accounts for a transaction with
+// the specified entity that transfers amount to its counter account,
+// most likely first.  Accounts are ranked by the number of the entity's
+// past transfers with the same sign as amount, then by how close their
+// most recent such transfers were to amount, then by name.  Zero amounts
+// match transfers of either sign.  Suggest returns nothing if the entity
+// has no such transfers.
+func (s *Suggester) Suggest(entity string, amount decimal.Decimal) []Suggestion {
+	counts := map[string]int{}
+	distances := map[string]decimal.Decimal{}
+	for _, t := range s.history[normalizeEntity(entity)] {
+		if !amount.IsZero() && t.amount.Sign() != amount.Sign() {
+			continue
+		}
+		counts[t.account]++
+		distances[t.account] = t.amount.Sub(amount).Abs()
+	}
+	suggestions := make([]Suggestion, len(counts))[:0]
+	for an, count := range counts {
+		suggestions = append(suggestions, Suggestion{Account: an, Count: count})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		} else if c := distances[a.Account].Cmp(distances[b.Account]); c != 0 {
+			return c < 0
+		}
+		return a.Account < b.Account
+	})
+	return suggestions
+}
+
+// Categorize replaces the fallback account, such as a CSV rules file's
+// default account, in entries' postings with the Suggester's best
+// suggestion for the entries' entities and the postings' amounts,
+// if there is one.
+func (s *Suggester) Categorize(entries []Entry, fallback string) {
+	for _, e := range entries {
+		for n, p := range e.Postings {
+			if p.Account != fallback {
+				continue
+			} else if suggestions := s.Suggest(e.Entity, p.Amount); len(suggestions) != 0 {
+				e.Postings[n].Account = suggestions[0].Account
+			}
+		}
+	}
+}
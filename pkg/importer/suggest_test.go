@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package importer
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/testsupport"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"testing"
+)
+
+const suggestLedger = `
+	2021 1 1 date
+	journal true pragma
+	USD Dollar commodity
+	Assets:Checking open
+	Expenses:Dining open
+	Expenses:Groceries open
+	Income:Refunds open
+	("Corner Coffee" Latte Assets:Checking -4.50 USD xfer Expenses:Dining 4.50 USD xfer xact)
+	("Corner Coffee" Beans Assets:Checking -18 USD xfer Expenses:Groceries 18 USD xfer xact)
+	("Corner Coffee" Latte Assets:Checking -5 USD xfer Expenses:Dining 5 USD xfer xact)
+	("Corner Coffee" Refund Assets:Checking 5 USD xfer Income:Refunds -5 USD xfer xact)
+	(Market Food Assets:Checking -60 USD xfer Expenses:Groceries 60 USD xfer xact)`
+
+func TestSuggester(t *testing.T) {
+	s := NewSuggester(testsupport.Parse(t, suggestLedger).Journal, []string{"Expenses", "Income"})
+	for _, test := range []struct {
+		entity   string
+		amount   string
+		expected []Suggestion
+	}{
+		{"Corner Coffee", "4", []Suggestion{{"Expenses:Dining", 2}, {"Expenses:Groceries", 1}}},
+		{"corner   COFFEE", "-5", []Suggestion{{"Income:Refunds", 1}}},
+		{"Market", "0", []Suggestion{{"Expenses:Groceries", 1}}},
+		{"Nobody", "4", nil},
+	} {
+		suggestions := s.Suggest(test.entity, decimal.RequireFromString(test.amount))
+		if len(suggestions) == 0 {
+			suggestions = nil
+		}
+		if !reflect.DeepEqual(suggestions, test.expected) {
+			t.Errorf("suggestions for %v %v are %v instead of %v", test.entity, test.amount, suggestions, test.expected)
+		}
+	}
+
+	// Ties are broken by the distances of the most recent transfers.
+	s = NewSuggester([]core.JournalEntry{
+		{Entity: "Store", Postings: []core.Posting{{Account: "Expenses:A", Quantity: core.Quantity{Amount: decimal.NewFromInt(10)}}}},
+		{Entity: "Store", Postings: []core.Posting{{Account: "Expenses:B", Quantity: core.Quantity{Amount: decimal.NewFromInt(100)}}}},
+	}, []string{"Expenses"})
+	if suggestions := s.Suggest("Store", decimal.NewFromInt(90)); len(suggestions) != 2 || suggestions[0].Account != "Expenses:B" {
+		t.Errorf("unexpected suggestions: %v", suggestions)
+	}
+}
+
+func TestSuggester_Categorize(t *testing.T) {
+	s := NewSuggester(testsupport.Parse(t, suggestLedger).Journal, []string{"Expenses"})
+	entries := []Entry{
+		{Entity: "Corner Coffee", Postings: []Posting{{Account: "Assets:Checking", Amount: decimal.NewFromInt(-4)}, {Account: "Expenses:Unknown", Amount: decimal.NewFromInt(4)}}},
+		{Entity: "Nobody", Postings: []Posting{{Account: "Assets:Checking", Amount: decimal.NewFromInt(-4)}, {Account: "Expenses:Unknown", Amount: decimal.NewFromInt(4)}}},
+	}
+	s.Categorize(entries, "Expenses:Unknown")
+	if an := entries[0].Postings[1].Account; an != "Expenses:Dining" {
+		t.Errorf("Categorize chose %v instead of Expenses:Dining", an)
+	} else if an = entries[1].Postings[1].Account; an != "Expenses:Unknown" {
+		t.Errorf("Categorize changed an unknown entity's account to %v", an)
+	} else if an = entries[0].Postings[0].Account; an != "Assets:Checking" {
+		t.Errorf("Categorize changed a non-fallback account to %v", an)
+	}
+}
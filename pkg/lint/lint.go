@@ -0,0 +1,350 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package lint
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// Rule is a named check that Checker applies to transactions or accounts.
+// Exactly one of CheckTransaction and CheckAccount is set.  Each returns
+// a message for every violation it finds.
+type Rule struct {
+	Name             string
+	Description      string
+	CheckTransaction func(*Config, *functions.Transaction) []string
+	CheckAccount     func(*Config, *core.Account) []string
+}
+
+// Names of the rules returned by GetRules.
+const (
+	UncommentedTransfers    = "uncommented-transfers"
+	UndeclaredPayee         = "undeclared-payee"
+	MissingReceiptNote      = "missing-receipt-note"
+	AccountNamingConvention = "account-naming-convention"
+)
+
+func GetRules() map[string]Rule {
+	return map[string]Rule{
+		UncommentedTransfers: {
+			Name:             UncommentedTransfers,
+			Description:      "every transfer has a comment",
+			CheckTransaction: checkUncommentedTransfers},
+		UndeclaredPayee: {
+			Name:             UndeclaredPayee,
+			Description:      "every transaction's entity is a declared payee",
+			CheckTransaction: checkUndeclaredPayee},
+		MissingReceiptNote: {
+			Name:             MissingReceiptNote,
+			Description:      "transactions affecting receipt accounts have receipt notes",
+			CheckTransaction: checkMissingReceiptNote},
+		AccountNamingConvention: {
+			Name:         AccountNamingConvention,
+			Description:  "account names match the account pattern",
+			CheckAccount: checkAccountNamingConvention},
+	}
+}
+
+// RuleNames returns the names of the rules returned by GetRules, sorted.
+func RuleNames() []string {
+	rules := GetRules()
+	names := make([]string, len(rules))[:0]
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkUncommentedTransfers(config *Config, xact *functions.Transaction) []string {
+	var messages []string
+	for _, t := range xact.Transfers {
+		if len(t.Comment) == 0 {
+			messages = append(messages, fmt.Sprintf("%v: transfer to %v has no comment", xact.Entity, t.Account.Name))
+		}
+	}
+	return messages
+}
+
+func checkUndeclaredPayee(config *Config, xact *functions.Transaction) []string {
+	if !config.Payees[xact.Entity] {
+		return []string{fmt.Sprintf("%v is not a declared payee", xact.Entity)}
+	}
+	return nil
+}
+
+func checkMissingReceiptNote(config *Config, xact *functions.Transaction) []string {
+	if _, ok := xact.Notes[config.ReceiptNote]; ok {
+		return nil
+	}
+	for _, t := range xact.Transfers {
+		if config.ReceiptAccounts.MatchString(t.Account.Name) {
+			return []string{fmt.Sprintf(`%v: transfer to %v but no "%v" note`, xact.Entity, t.Account.Name, config.ReceiptNote)}
+		}
+	}
+	return nil
+}
+
+func checkAccountNamingConvention(config *Config, a *core.Account) []string {
+	if !config.AccountPattern.MatchString(a.Name) {
+		return []string{fmt.Sprintf("account name %v does not match %v", a.Name, config.AccountPattern)}
+	}
+	return nil
+}
+
+// Config controls which rules Checker applies and how they behave.
+// Configuration files are written in the ledger language; see
+// GetConfigFunctions for the available functions.
+type Config struct {
+	// Disabled holds the names of rules that Checker skips.
+	Disabled map[string]bool
+
+	// Payees holds the entities that the undeclared-payee rule accepts.
+	Payees map[string]bool
+
+	// ReceiptNote is the name of the note that the missing-receipt-note
+	// rule requires of transactions with transfers to accounts matching
+	// ReceiptAccounts.
+	ReceiptNote     string
+	ReceiptAccounts *regexp.Regexp
+
+	// AccountPattern is the pattern that the account-naming-convention
+	// rule requires account names to match.
+	AccountPattern *regexp.Regexp
+}
+
+// NewConfig creates a Config that enables all rules with default settings.
+func NewConfig() *Config {
+	return &Config{
+		Disabled:        map[string]bool{},
+		Payees:          map[string]bool{},
+		ReceiptNote:     "receipt",
+		ReceiptAccounts: regexp.MustCompile(`^Expenses(:|$)`),
+		AccountPattern:  regexp.MustCompile(`^[A-Z][A-Za-z0-9]*(:[A-Z][A-Za-z0-9]*)*$`)}
+}
+
+// Enable enables or disables the named rule.
+func (config *Config) Enable(rule string, enabled bool) error {
+	if _, ok := GetRules()[rule]; !ok {
+		return fmt.Errorf("unknown rule: %v", rule)
+	}
+	if enabled {
+		delete(config.Disabled, rule)
+	} else {
+		config.Disabled[rule] = true
+	}
+	return nil
+}
+
+// ConfigFunction is a function that can appear in a configuration file.
+type ConfigFunction func(string, parser.Operands, *Config) error
+
+func GetConfigFunctions() map[string]ConfigFunction {
+	return map[string]ConfigFunction{
+		"account-pattern":  accountPatternFunction,
+		"disable":          disableFunction,
+		"enable":           enableFunction,
+		"payee":            payeeFunction,
+		"receipt-accounts": receiptAccountsFunction,
+		"receipt-note":     receiptNoteFunction,
+	}
+}
+
+// ParseConfig parses a configuration file into config.
+func ParseConfig(r io.Reader, config *Config) error {
+	p := parser.NewParser(config)
+	for fn, f := range GetConfigFunctions() {
+		f := f
+		p.Functions[fn] = func(fn string, op parser.Operands, _ interface{}) error {
+			return f(fn, op, config)
+		}
+	}
+	if err := p.Parse(parser.NewLexer(r)); err != nil {
+		return err
+	}
+	return p.Finish()
+}
+
+func popPattern(fn string, op parser.Operands) (*regexp.Regexp, error) {
+	values, err := functions.PopStrings(fn, op, "pattern")
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(values[0])
+	if err != nil {
+		return nil, fmt.Errorf("%v: illegal pattern %v: %v", fn, values[0], err)
+	}
+	return re, nil
+}
+
+// Syntax: PATTERN account-pattern ->
+func accountPatternFunction(fn string, op parser.Operands, config *Config) error {
+	re, err := popPattern(fn, op)
+	if err == nil {
+		config.AccountPattern = re
+	}
+	return err
+}
+
+// Syntax: RULE+ disable ->
+func disableFunction(fn string, op parser.Operands, config *Config) error {
+	return enableRules(fn, op, config, false)
+}
+
+// Syntax: RULE+ enable ->
+func enableFunction(fn string, op parser.Operands, config *Config) error {
+	return enableRules(fn, op, config, true)
+}
+
+func enableRules(fn string, op parser.Operands, config *Config, enabled bool) error {
+	if op.Length() == 0 {
+		return fmt.Errorf("%v: at least one rule name is required", fn)
+	}
+	for _, v := range op.Pop(op.Length()) {
+		if rule, ok := v.(string); !ok {
+			return fmt.Errorf("%v: non-string rule name: %v", fn, v)
+		} else if err := config.Enable(rule, enabled); err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+	}
+	return nil
+}
+
+// Syntax: ENTITY+ payee ->
+func payeeFunction(fn string, op parser.Operands, config *Config) error {
+	if op.Length() == 0 {
+		return fmt.Errorf("%v: at least one entity is required", fn)
+	}
+	for _, v := range op.Pop(op.Length()) {
+		entity, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v: non-string entity: %v", fn, v)
+		}
+		config.Payees[entity] = true
+	}
+	return nil
+}
+
+// Syntax: PATTERN receipt-accounts ->
+func receiptAccountsFunction(fn string, op parser.Operands, config *Config) error {
+	re, err := popPattern(fn, op)
+	if err == nil {
+		config.ReceiptAccounts = re
+	}
+	return err
+}
+
+// Syntax: NOTE-NAME receipt-note ->
+func receiptNoteFunction(fn string, op parser.Operands, config *Config) error {
+	values, err := functions.PopStrings(fn, op, "note name")
+	if err == nil {
+		config.ReceiptNote = values[0]
+	}
+	return err
+}
+
+// Violation is a rule violation found by Checker.
+type Violation struct {
+	Date    core.Date
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v: %v: %v", v.Date, v.Rule, v.Message)
+}
+
+// Checker applies a Config's enabled rules to a ledger.  Install its
+// XactFunction in a functions.Parser in place of functions.XactFunction,
+// parse the ledger, and then call Finish.
+type Checker struct {
+	Config     *Config
+	Violations []Violation
+}
+
+func NewChecker(config *Config) *Checker {
+	return &Checker{Config: config}
+}
+
+func (c *Checker) enabledRules() []Rule {
+	rules := GetRules()
+	enabled := make([]Rule, len(rules))[:0]
+	for _, name := range RuleNames() {
+		if !c.Config.Disabled[name] {
+			enabled = append(enabled, rules[name])
+		}
+	}
+	return enabled
+}
+
+// XactFunction behaves like functions.XactFunction but also checks
+// each transaction.
+//
+// Syntax: ENTITY DESCRIPTION Transfer+ (NOTE-NAME NOTE-VALUE)* xact ->
+func (c *Checker) XactFunction(fn string, op parser.Operands, ctx *core.Context) error {
+	xact, err := functions.ParseTransaction(op, ctx)
+	if err == nil {
+		err = xact.Execute(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", fn, err)
+	}
+	for _, rule := range c.enabledRules() {
+		if rule.CheckTransaction != nil {
+			for _, msg := range rule.CheckTransaction(c.Config, &xact) {
+				c.Violations = append(c.Violations, Violation{Date: ctx.Date, Rule: rule.Name, Message: msg})
+			}
+		}
+	}
+	return nil
+}
+
+// Finish checks the Context's accounts and sorts Violations by date.
+// Account violations are dated by the accounts' creation dates.
+func (c *Checker) Finish(ctx *core.Context) {
+	accounts := make([]*core.Account, len(ctx.Accounts))[:0]
+	for _, a := range ctx.Accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	for _, rule := range c.enabledRules() {
+		if rule.CheckAccount != nil {
+			for _, a := range accounts {
+				for _, msg := range rule.CheckAccount(c.Config, a) {
+					c.Violations = append(c.Violations, Violation{Date: a.CreationDate, Rule: rule.Name, Message: msg})
+				}
+			}
+		}
+	}
+	sort.SliceStable(c.Violations, func(i, j int) bool { return c.Violations[i].Date.Before(c.Violations[j].Date) })
+}
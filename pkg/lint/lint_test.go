@@ -0,0 +1,98 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package lint
+
+import (
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLedger = `
+	2021 1 1 date
+	USD "US Dollar" commodity
+	Assets:Checking USD open
+	Expenses:Food open
+	Expenses:misc open
+	2021 1 2 date
+	(Grocer Food
+		Assets:Checking -50 USD xfer "card" set-comment
+		Expenses:Food 50 USD xfer "milk" set-comment
+		receipt r1.pdf
+		xact)
+	2021 1 3 date
+	(Stranger Misc
+		Assets:Checking -5 USD xfer
+		Expenses:misc 5 USD xfer "gum" set-comment
+		xact)`
+
+func check(t *testing.T, configText string) []string {
+	config := NewConfig()
+	if err := ParseConfig(strings.NewReader(configText), config); err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	checker := NewChecker(config)
+	p := functions.NewParser(strings.NewReader(testLedger))
+	p.AddCoreFunctions()
+	p.Functions["xact"] = checker.XactFunction
+	if err := p.Parse(); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	checker.Finish(p.Context())
+	var violations []string
+	for _, v := range checker.Violations {
+		violations = append(violations, v.String())
+	}
+	return violations
+}
+
+func TestChecker(t *testing.T) {
+	expected := []string{
+		"2021-01-01: account-naming-convention: account name Expenses:misc does not match ^[A-Z][A-Za-z0-9]*(:[A-Z][A-Za-z0-9]*)*$",
+		"2021-01-03: uncommented-transfers: Stranger: transfer to Assets:Checking has no comment",
+		"2021-01-03: undeclared-payee: Stranger is not a declared payee",
+	}
+	if violations := check(t, `Grocer payee missing-receipt-note disable`); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("unexpected violations:\n%v", strings.Join(violations, "\n"))
+	}
+}
+
+func TestChecker_MissingReceiptNote(t *testing.T) {
+	expected := []string{`2021-01-03: missing-receipt-note: Stranger: transfer to Expenses:misc but no "receipt" note`}
+	config := `receipt receipt-note
+		uncommented-transfers undeclared-payee account-naming-convention disable`
+	if violations := check(t, config); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("unexpected violations:\n%v", strings.Join(violations, "\n"))
+	}
+}
+
+func TestParseConfig_UnknownRule(t *testing.T) {
+	if err := ParseConfig(strings.NewReader(`no-such-rule disable`), NewConfig()); err == nil {
+		t.Errorf("ParseConfig accepted an unknown rule")
+	}
+}
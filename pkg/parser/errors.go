@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseError is an error that occurred while parsing a token, such as
+// a syntax error or an error returned by a Function.
+type ParseError struct {
+	// File is the name of the parsed file or empty if it is unknown.
+	// Parsers do not set it; their clients may.
+	File string
+
+	// Position is the Position of the token that caused the error.
+	Position Position
+
+	// Function is the name of the Function, special function, or word
+	// that failed or empty if the error did not occur in one.
+	Function string
+
+	// Stack holds the operand stack's values, bottom first, when
+	// the error occurred.  Failed Functions might have popped some
+	// of their operands.
+	Stack []interface{}
+
+	// Source is the text of the line containing Position or empty
+	// if the Lexer no longer had it.
+	Source string
+
+	// Err is the error.
+	Err error
+}
+
+// Error returns the error prefixed with "FILE:LINE:COLUMN" or, if File
+// is empty, "LINE:COLUMN".
+func (e *ParseError) Error() string {
+	if len(e.File) != 0 {
+		return fmt.Sprintf("%v:%v: %v", e.File, e.Position, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Position, e.Err)
+}
+
+// Unwrap returns Err.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Pretty returns the error followed by an excerpt of the source that
+// marks the token that caused the error with a caret, the name of the
+// failed Function, and the operand stack.  If Err wraps ParseErrors, such
+// as errors in included files, then the excerpt, Function, and stack come
+// from the innermost one, where the problem lies.  For example:
+//
+//	3:23: assert: Assets:Checking has 10 USD, not 0 USD
+//	    3 | Assets:Checking 0 USD assert
+//	      |                       ^
+//	function: assert
+func (e *ParseError) Pretty() string {
+	var b strings.Builder
+	b.WriteString(e.Error())
+	inner := e
+	for {
+		var next *ParseError
+		if !errors.As(inner.Err, &next) {
+			break
+		}
+		inner = next
+	}
+	inner.writeExcerpt(&b)
+	if len(inner.Function) != 0 {
+		fmt.Fprintf(&b, "\nfunction: %v", inner.Function)
+	}
+	if len(inner.Stack) != 0 {
+		b.WriteString("\nstack:")
+		for _, v := range inner.Stack {
+			if s, ok := v.(string); ok {
+				v = Quote(s)
+			}
+			fmt.Fprintf(&b, " %v", v)
+		}
+	}
+	return b.String()
+}
+
+// writeExcerpt writes the source line and a caret beneath the column
+// of the token that caused the error.
+func (e *ParseError) writeExcerpt(b *strings.Builder) {
+	if len(e.Source) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%5d | %v\n      | ", e.Position.Line, e.Source)
+	column := uint64(1)
+	for _, r := range e.Source {
+		if column == e.Position.Column {
+			break
+		} else if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		column++
+	}
+	b.WriteByte('^')
+}
+
+// ParseErrors are the errors that a Parser in KeepGoing mode collected,
+// in the order in which they occurred.
+type ParseErrors []*ParseError
+
+// Error returns the errors' texts, one per line.
+func (errs ParseErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for n, e := range errs {
+		msgs[n] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is returns true if any of the errors is target, so that errors.Is
+// finds context.Canceled in errors that ParseContext returns.
+func (errs ParseErrors) Is(target error) bool {
+	for _, e := range errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorOrNil returns errs or nil if errs is empty.
+func (errs ParseErrors) errorOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// PrettyError returns err's Pretty text if err is or wraps a ParseError
+// and err's text otherwise.  Errors that wrap ParseErrors, such as those
+// prefixed with dates, keep their prefixes.  PrettyError formats each of
+// the errors in ParseErrors and separates them with blank lines.
+func PrettyError(err error) string {
+	if errs, ok := err.(ParseErrors); ok {
+		texts := make([]string, len(errs))
+		for n, e := range errs {
+			texts[n] = e.Pretty()
+		}
+		return strings.Join(texts, "\n\n")
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return err.Error()
+	}
+	pretty := pe.Pretty()
+	if msg, inner := err.Error(), pe.Error(); msg != inner && strings.HasSuffix(msg, inner) {
+		pretty = strings.TrimSuffix(msg, inner) + pretty
+	}
+	return pretty
+}
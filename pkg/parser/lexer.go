@@ -28,15 +28,19 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"unicode"
 )
 
 var (
-	escapingAtEofError error = errors.New("unfinished escape at end of file")
-	inStringAtEofError error = errors.New("unfinished quoted string at end of file")
+	escapingAtEofError  error = errors.New("unfinished escape at end of file")
+	inStringAtEofError  error = errors.New("unfinished quoted string at end of file")
+	inCommentAtEofError error = errors.New("unfinished block comment at end of file")
 )
 
 // TokenType is an enum representing different types of lexed tokens.
@@ -46,7 +50,12 @@ const (
 	// String indicates an unquoted string.
 	String TokenType = iota
 
-	// QuotedString indicates a quoted string.
+	// QuotedString indicates a quoted string, which is delimited by
+	// double quotes ('"'), or a raw string, which is delimited by
+	// backticks ('`').  Backslashes escape characters in quoted strings
+	// but not in raw strings, which may contain any characters except
+	// backticks.  Carriage returns are removed from raw strings' text,
+	// so multi-line raw strings are the same on every platform.
 	QuotedString
 
 	// OpenParen indicates an opening parenthesis ('(').
@@ -58,33 +67,116 @@ const (
 	// Error represents a syntax error or io.EOF.
 	Error
 
+	// Comment indicates a comment, which begins with a number sign ('#')
+	// that does not continue a string and ends at the end of the line,
+	// or a block comment, which begins with "#|" and ends with a matching
+	// "|#".  Block comments may span lines and nest.  Lexers return
+	// Comments only if KeepComments is set.  The text of a Comment
+	// excludes its delimiters.
+	Comment
+
 	// none is an internal TokenType indicating that no token has been
 	// lexed yet.
 	none
 )
 
-// Lexer is a simple token lexer.
+// Position is a location in a Lexer's input.
+type Position struct {
+	Line   uint64 // line number, starting at 1
+	Column uint64 // column in characters (not bytes), starting at 1
+	Offset uint64 // byte offset, starting at 0
+}
+
+// String returns the Position as "LINE:COLUMN".
+func (p Position) String() string {
+	return fmt.Sprintf("%v:%v", p.Line, p.Column)
+}
+
+// Lexer is a simple token lexer.  It skips comments unless KeepComments
+// is set.  Escaped number signs ("\\#") begin strings, not comments.
 type Lexer struct {
+	// KeepComments makes GetNextToken return Comment tokens rather than
+	// skip them, such as for formatters.
+	KeepComments bool
+
 	reader           *bufio.Reader
-	lineNumber       uint64
+	position         Position // of the next rune
+	runePosition     Position // of the rune being lexed
+	parenPosition    Position // of the pending openParenSet or closeParenSet
 	isEscaping       bool
 	isInString       bool
 	isInQuotedString bool // only meaningful when isInString
+	quote            rune // the quoted string's delimiter: '"' or '`'
+	isInComment      bool
+	commentOpening   bool // whether the comment's '#' was the last rune
+	commentDepth     int  // nesting depth of block comments; 0 in line comments
+	commentPrevious  rune // previous rune in a block comment if it might start a delimiter
 	token            strings.Builder
 	openParenSet     bool
 	closeParenSet    bool
+
+	// raw holds the source text of the token being lexed, which began
+	// at rawPosition.  lastRaw and lastPosition describe the token
+	// most recently returned by GetNextToken.
+	raw          strings.Builder
+	rawPosition  Position
+	lastRaw      string
+	lastPosition Position
+
+	// line holds the text of the current line read so far, and
+	// previousLine holds the text of the line before it.
+	line         strings.Builder
+	previousLine string
+
+	// err is the error that ended Tokens' stream, if any.
+	err error
 }
 
 // NewLexer constructs a Lexer for the specified io.Reader.
 func NewLexer(r io.Reader) *Lexer {
 	return &Lexer{
-		reader:     bufio.NewReader(r),
-		lineNumber: 1}
+		reader:   bufio.NewReader(r),
+		position: Position{Line: 1, Column: 1}}
 }
 
 // Get the Lexer's current line number.
 func (l *Lexer) LineNumber() uint64 {
-	return l.lineNumber
+	return l.position.Line
+}
+
+// SetLineNumber sets the Lexer's current line number, such as when
+// the Lexer's io.Reader starts in the middle of a file.
+func (l *Lexer) SetLineNumber(n uint64) {
+	l.position.Line = n
+}
+
+// Position returns the Position of the next rune that the Lexer will read.
+func (l *Lexer) Position() Position {
+	return l.position
+}
+
+// SetPosition sets the Position of the next rune that the Lexer will read,
+// such as when the Lexer's io.Reader starts in the middle of a file.
+func (l *Lexer) SetPosition(p Position) {
+	l.position = p
+}
+
+// RawText returns the source text of the token most recently returned by
+// GetNextToken, including quotes and escape characters.
+func (l *Lexer) RawText() string {
+	return l.lastRaw
+}
+
+// TokenLineNumber returns the line number on which the token most recently
+// returned by GetNextToken began.
+func (l *Lexer) TokenLineNumber() uint64 {
+	return l.lastPosition.Line
+}
+
+// TokenPosition returns the Position at which the token most recently
+// returned by GetNextToken began.
+func (l *Lexer) TokenPosition() Position {
+	return l.lastPosition
 }
 
 // GetNextToken lexes the next token from the Lexer's io.Reader.
@@ -92,23 +184,26 @@ func (l *Lexer) LineNumber() uint64 {
 // If the returned TokenType is Error, then the returned error is either
 // a syntax error or io.EOF.  Note that GetNextToken may return io.EOF
 // even when the TokenType is not Error.  The returned string is valid only
-// when th TokenType is either String or QuotedString.
+// when th TokenType is String, QuotedString, or Comment.
 func (l *Lexer) GetNextToken() (TokenType, string, error) {
 	if l.openParenSet {
 		l.openParenSet = false
+		l.setParenRaw("(", l.parenPosition)
 		return OpenParen, "", nil
 	} else if l.closeParenSet {
 		l.closeParenSet = false
+		l.setParenRaw(")", l.parenPosition)
 		return CloseParen, "", nil
 	}
 	for {
-		r, _, err := l.reader.ReadRune()
+		r, size, err := l.reader.ReadRune()
 		if err != nil {
 			if err == io.EOF {
 				return l.getFinalToken()
 			}
 			return Error, "", err
 		}
+		l.advance(r, size)
 		tokenType, token := l.addRuneAndGetToken(r)
 		if tokenType == OpenParen || tokenType == CloseParen {
 			return tokenType, "", nil
@@ -118,82 +213,293 @@ func (l *Lexer) GetNextToken() (TokenType, string, error) {
 	}
 }
 
+// advance records the Position of a rune of size bytes and moves
+// the Lexer's Position past it.
+func (l *Lexer) advance(r rune, size int) {
+	l.runePosition = l.position
+	l.position.Offset += uint64(size)
+	if r == '\n' {
+		l.position.Line++
+		l.position.Column = 1
+		l.previousLine = l.line.String()
+		l.line.Reset()
+	} else {
+		l.position.Column++
+		l.line.WriteRune(r)
+	}
+}
+
+// SourceLine returns the text of line n without its line terminator if
+// n is the current or previous line.  The text of the current line
+// includes as much of its rest as the Lexer has buffered but not lexed.
+func (l *Lexer) SourceLine(n uint64) (string, bool) {
+	var line string
+	if n == l.position.Line {
+		rest, _ := l.reader.Peek(l.reader.Buffered())
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[:i]
+		}
+		line = l.line.String() + string(rest)
+	} else if n+1 == l.position.Line {
+		line = l.previousLine
+	} else {
+		return "", false
+	}
+	return strings.TrimSuffix(line, "\r"), true
+}
+
+// Tokens lexes the rest of the Lexer's input in a new goroutine and sends
+// the tokens, with their Positions, on the returned channel.  It closes
+// the channel at EOF, after a syntax or I/O error, or when ctx is done;
+// Err then returns the error, if any.  Other goroutines must not use
+// the Lexer until the channel is closed.  For example:
+//
+//	for t := range lex.Tokens(ctx) {
+//		fmt.Println(t.Position, t.Text)
+//	}
+//	if err := lex.Err(); err != nil {
+//		return err
+//	}
+func (l *Lexer) Tokens(ctx context.Context) <-chan Token {
+	tokens := make(chan Token)
+	go func() {
+		defer close(tokens)
+		for {
+			if l.err = ctx.Err(); l.err != nil {
+				return
+			}
+			tokenType, text, err := l.GetNextToken()
+			if tokenType == Error {
+				if err != io.EOF {
+					l.err = err
+				}
+				return
+			}
+			select {
+			case tokens <- Token{Type: tokenType, Text: text, Position: l.TokenPosition()}:
+			case <-ctx.Done():
+				l.err = ctx.Err()
+				return
+			}
+			if err == io.EOF {
+				return
+			}
+		}
+	}()
+	return tokens
+}
+
+// Err returns the error that ended the stream of tokens from Tokens or nil
+// if the stream reached EOF.  It is valid only after the stream's channel
+// is closed.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// startRaw records the beginning of a token's source text.
+func (l *Lexer) startRaw(r rune) {
+	l.raw.Reset()
+	l.raw.WriteRune(r)
+	l.rawPosition = l.runePosition
+}
+
+// finishRaw records the end of a token's source text.
+func (l *Lexer) finishRaw() {
+	l.lastRaw = l.raw.String()
+	l.lastPosition = l.rawPosition
+	l.raw.Reset()
+}
+
+// setParenRaw records a parenthesis token's source text and Position.
+func (l *Lexer) setParenRaw(paren string, p Position) {
+	l.lastRaw = paren
+	l.lastPosition = p
+}
+
 // addRuneAndGetToken processes the specified rune and returns a token, if any.
 func (l *Lexer) addRuneAndGetToken(r rune) (tokenType TokenType, token string) {
 	tokenType = none
 	token = ""
-	isNewline := r == '\n'
 	isSpace := unicode.IsSpace(r)
-	if isNewline {
-		l.lineNumber++
-	}
 
-	if l.isEscaping {
+	if l.isInComment {
+		return l.addCommentRune(r)
+	} else if l.isInQuotedString && l.quote == '`' {
+		l.raw.WriteRune(r)
+		if r == '`' {
+			token = l.token.String()
+			l.token.Reset()
+			l.isInString = false
+			l.isInQuotedString = false
+			l.finishRaw()
+			tokenType = QuotedString
+		} else if r != '\r' {
+			l.token.WriteRune(r)
+		}
+	} else if l.isEscaping {
 		l.token.WriteRune(r)
+		l.raw.WriteRune(r)
 		l.isEscaping = false
 		if !l.isInString {
 			l.isInString = true
 		}
 	} else if r == '\\' {
+		if l.isInString {
+			l.raw.WriteRune(r)
+		} else {
+			l.startRaw(r)
+		}
 		l.isEscaping = true
 	} else if l.isInQuotedString {
+		l.raw.WriteRune(r)
 		if r == '"' {
 			token = l.token.String()
 			l.token.Reset()
 			l.isInString = false
 			l.isInQuotedString = false
+			l.finishRaw()
 			tokenType = QuotedString
 		} else {
 			l.token.WriteRune(r)
 		}
 	} else if l.isInString {
-		if r == '"' {
+		if r == '"' || r == '`' {
 			token = l.token.String()
 			l.token.Reset()
 			l.isInQuotedString = true
+			l.quote = r
+			l.finishRaw()
+			l.startRaw(r)
 			tokenType = String
 		} else if r == '(' {
 			token = l.token.String()
 			l.token.Reset()
 			l.isInString = false
 			l.openParenSet = true
+			l.parenPosition = l.runePosition
+			l.finishRaw()
 			tokenType = String
 		} else if r == ')' {
 			token = l.token.String()
 			l.token.Reset()
 			l.isInString = false
 			l.closeParenSet = true
+			l.parenPosition = l.runePosition
+			l.finishRaw()
 			tokenType = String
 		} else if isSpace {
 			token = l.token.String()
 			l.token.Reset()
 			l.isInString = false
+			l.finishRaw()
 			tokenType = String
 		} else {
 			l.token.WriteRune(r)
+			l.raw.WriteRune(r)
 		}
 	} else if isSpace {
 		// do nothing
-	} else if r == '"' {
+	} else if r == '"' || r == '`' {
 		l.isInString = true
 		l.isInQuotedString = true
+		l.quote = r
+		l.startRaw(r)
+	} else if r == '#' {
+		l.isInComment = true
+		l.commentOpening = true
+		l.startRaw(r)
 	} else if r == '(' {
+		l.setParenRaw("(", l.runePosition)
 		tokenType = OpenParen
 	} else if r == ')' {
+		l.setParenRaw(")", l.runePosition)
 		tokenType = CloseParen
 	} else {
 		l.token.WriteRune(r)
 		l.isInString = true
+		l.startRaw(r)
 	}
 	return
 }
 
+// addCommentRune processes a rune within a comment and returns
+// the Comment if the rune ends it and the Lexer keeps comments.
+func (l *Lexer) addCommentRune(r rune) (TokenType, string) {
+	if l.commentOpening {
+		l.commentOpening = false
+		if r == '|' {
+			l.commentDepth = 1
+			l.commentPrevious = 0
+			l.raw.WriteRune(r)
+			return none, ""
+		}
+	}
+	if l.commentDepth == 0 && r == '\n' {
+		return l.finishComment(strings.TrimSuffix(l.token.String(), "\r"))
+	}
+	l.token.WriteRune(r)
+	l.raw.WriteRune(r)
+	if l.commentDepth != 0 {
+		if l.commentPrevious == '|' && r == '#' {
+			l.commentDepth--
+			r = 0
+		} else if l.commentPrevious == '#' && r == '|' {
+			l.commentDepth++
+			r = 0
+		}
+		l.commentPrevious = r
+		if l.commentDepth == 0 {
+			text := l.token.String()
+			return l.finishComment(text[:len(text)-len("|#")])
+		}
+	}
+	return none, ""
+}
+
+// finishComment ends the comment being lexed, whose text is text.
+// It returns the Comment if the Lexer keeps comments and no token
+// otherwise.
+func (l *Lexer) finishComment(text string) (tokenType TokenType, token string) {
+	tokenType, token = none, ""
+	l.isInComment = false
+	if l.KeepComments {
+		tokenType, token = Comment, text
+		l.lastRaw = strings.TrimSuffix(l.raw.String(), "\r")
+		l.lastPosition = l.rawPosition
+	}
+	l.token.Reset()
+	l.raw.Reset()
+	return
+}
+
+// Quote returns s as a quoted string token, escaping backslashes and double
+// quotes, so that the Lexer will lex it as a single QuotedString with
+// the original text.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
 // getFinalToken returns the stream's final token or an error if the Lexer
 // is in an invalid state at EOF.  This should be called only when the
 // Lexer reaches its io.Reader's EOF.
 func (l *Lexer) getFinalToken() (tokenType TokenType, token string, e error) {
 	tokenType = Error
-	if l.isInQuotedString {
+	if l.isInComment && l.commentDepth != 0 {
+		e = inCommentAtEofError
+	} else if l.isInComment {
+		if tokenType, token = l.finishComment(l.token.String()); tokenType == none {
+			tokenType, e = Error, io.EOF
+		}
+	} else if l.isInQuotedString {
 		e = inStringAtEofError
 	} else if l.isEscaping {
 		e = escapingAtEofError
@@ -203,6 +509,7 @@ func (l *Lexer) getFinalToken() (tokenType TokenType, token string, e error) {
 		tokenType = String
 		token = l.token.String()
 		l.isInString = false
+		l.finishRaw()
 	}
 	return
 }
@@ -27,7 +27,9 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package parser
 
 import (
+	"context"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -106,3 +108,132 @@ func TestGetNextToken_QuotedAndUnquotedStrings(t *testing.T) {
 func TestGetNextToken_QuotesTerminateStrings(t *testing.T) {
 	checkLexer(t, "unq1\"q 1\"unq2\"q 2\"\"q 3\"", []token{{String, "unq1"}, {QuotedString, "q 1"}, {String, "unq2"}, {QuotedString, "q 2"}, {QuotedString, "q 3"}})
 }
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{`plain`, `a "quoted" (word)`, `back\slash`, "multi\nline", ``} {
+		lex := NewLexer(strings.NewReader(Quote(s)))
+		if tokenType, text, e := lex.GetNextToken(); tokenType != QuotedString || text != s || e != nil {
+			t.Errorf("Quote(%#v) lexed as type %v, text %#v, error %v", s, tokenType, text, e)
+		}
+	}
+	if q := Quote(`say "hi"`); q != `"say \"hi\""` {
+		t.Errorf("Quote returned unexpected string: %v", q)
+	}
+}
+
+func TestGetNextToken_RawText(t *testing.T) {
+	lex := NewLexer(strings.NewReader("unq\\ 1(\"q \\\"1\\\"\"\n  a\"b\nc\")\\(x"))
+	expected := []struct {
+		raw  string
+		line uint64
+	}{{`unq\ 1`, 1}, {`(`, 1}, {`"q \"1\""`, 1}, {`a`, 2}, {"\"b\nc\"", 2}, {`)`, 3}, {`\(x`, 3}}
+	for n, e := range expected {
+		if _, _, err := lex.GetNextToken(); err != nil && err != io.EOF {
+			t.Fatalf("unexpected error at token %v: %v", n, err)
+		} else if lex.RawText() != e.raw {
+			t.Errorf("token %v has raw text %#v instead of %#v", n, lex.RawText(), e.raw)
+		} else if lex.TokenLineNumber() != e.line {
+			t.Errorf("token %v is on line %v instead of %v", n, lex.TokenLineNumber(), e.line)
+		}
+	}
+}
+
+func TestGetNextToken_Positions(t *testing.T) {
+	lex := NewLexer(strings.NewReader("ab (\"é\" c)\n\té(d"))
+	expected := []Position{{1, 1, 0}, {1, 4, 3}, {1, 5, 4}, {1, 9, 9}, {1, 10, 10}, {2, 2, 13}, {2, 3, 15}, {2, 4, 16}}
+	for n, e := range expected {
+		if _, _, err := lex.GetNextToken(); err != nil && err != io.EOF {
+			t.Fatalf("unexpected error at token %v: %v", n, err)
+		} else if lex.TokenPosition() != e {
+			t.Errorf("token %v (%#v) is at %+v instead of %+v", n, lex.RawText(), lex.TokenPosition(), e)
+		}
+	}
+	if p := lex.Position(); p != (Position{2, 5, 17}) {
+		t.Errorf("lexer ended at %+v", p)
+	}
+	if s := (Position{Line: 3, Column: 7}).String(); s != "3:7" {
+		t.Errorf("Position.String returned %v", s)
+	}
+}
+
+func TestGetNextToken_Comments(t *testing.T) {
+	checkLexer(t, "a # comment (\nb#c \"#q\" \\#d # end", []token{{String, "a"}, {String, "b#c"}, {QuotedString, "#q"}, {String, "#d"}})
+
+	lex := NewLexer(strings.NewReader("a # one\n# two"))
+	lex.KeepComments = true
+	for n, expected := range []token{{String, "a"}, {Comment, " one"}, {Comment, " two"}} {
+		if tokenType, text, _ := lex.GetNextToken(); tokenType != expected.tokenType || text != expected.text {
+			t.Errorf("token %v is type %v with text %#v", n, tokenType, text)
+		} else if raw := lex.RawText(); expected.tokenType == Comment && raw != "#"+expected.text {
+			t.Errorf("comment %v has raw text %#v", n, raw)
+		}
+	}
+	if tokenType, _, err := lex.GetNextToken(); tokenType != Error || err != io.EOF {
+		t.Errorf("expected EOF after the comments, got type %v and error %v", tokenType, err)
+	}
+}
+
+func TestGetNextToken_BlockComments(t *testing.T) {
+	checkLexer(t, "a #| ( b \"c\n#| nested |# d |#e #|#||#|#f #||#", []token{{String, "a"}, {String, "e"}, {String, "f"}})
+
+	lex := NewLexer(strings.NewReader("#| one\ntwo |# a"))
+	lex.KeepComments = true
+	if tokenType, text, _ := lex.GetNextToken(); tokenType != Comment || text != " one\ntwo " || lex.RawText() != "#| one\ntwo |#" {
+		t.Errorf("block comment lexed as type %v with text %#v and raw text %#v", tokenType, text, lex.RawText())
+	} else if tokenType, text, _ = lex.GetNextToken(); tokenType != String || text != "a" || lex.TokenLineNumber() != 2 {
+		t.Errorf("token after block comment lexed as type %v with text %#v on line %v", tokenType, text, lex.TokenLineNumber())
+	}
+
+	lex = NewLexer(strings.NewReader("#| #| |# "))
+	if tokenType, _, err := lex.GetNextToken(); tokenType != Error || err != inCommentAtEofError {
+		t.Errorf("unfinished block comment lexed as type %v with error %v", tokenType, err)
+	}
+}
+
+func TestLexer_Tokens(t *testing.T) {
+	lex := NewLexer(strings.NewReader("a (\"b\"\n c) # d"))
+	var tokens []Token
+	for token := range lex.Tokens(context.Background()) {
+		tokens = append(tokens, token)
+	}
+	expected := []Token{{String, "a", Position{1, 1, 0}}, {OpenParen, "", Position{1, 3, 2}}, {QuotedString, "b", Position{1, 4, 3}}, {String, "c", Position{2, 2, 8}}, {CloseParen, "", Position{2, 3, 9}}}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Tokens sent %v instead of %v", tokens, expected)
+	} else if err := lex.Err(); err != nil {
+		t.Errorf("Err returned %v after EOF", err)
+	}
+
+	lex = NewLexer(strings.NewReader("a \"b"))
+	for range lex.Tokens(context.Background()) {
+	}
+	if err := lex.Err(); err != inStringAtEofError {
+		t.Errorf("Err returned %v after a syntax error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lex = NewLexer(strings.NewReader("a b c"))
+	tokens = nil
+	for token := range lex.Tokens(ctx) {
+		tokens = append(tokens, token)
+	}
+	if len(tokens) != 0 || lex.Err() != context.Canceled {
+		t.Errorf("Tokens sent %v and Err returned %v after cancellation", tokens, lex.Err())
+	}
+}
+
+func TestGetNextToken_RawStrings(t *testing.T) {
+	checkLexer(t, "a`b \"c\" \\d`e `(#\r\nf)`", []token{{String, "a"}, {QuotedString, "b \"c\" \\d"}, {String, "e"}, {QuotedString, "(#\nf)"}})
+
+	lex := NewLexer(strings.NewReader("`x\r\ny` z"))
+	if tokenType, text, _ := lex.GetNextToken(); tokenType != QuotedString || text != "x\ny" || lex.RawText() != "`x\r\ny`" {
+		t.Errorf("raw string lexed as type %v with text %#v and raw text %#v", tokenType, text, lex.RawText())
+	} else if _, _, _ = lex.GetNextToken(); lex.TokenLineNumber() != 2 {
+		t.Errorf("token after raw string is on line %v", lex.TokenLineNumber())
+	}
+
+	lex = NewLexer(strings.NewReader("`unfinished"))
+	if tokenType, _, err := lex.GetNextToken(); tokenType != Error || err != inStringAtEofError {
+		t.Errorf("unfinished raw string lexed as type %v with error %v", tokenType, err)
+	}
+}
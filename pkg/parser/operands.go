@@ -36,6 +36,9 @@ type Operands struct {
 
 	// where the operands start in stack
 	stackIndex int
+
+	// if not nil, the lowest length of stack after calls of Pop
+	popped *int
 }
 
 // GetValues returns all of the Operands values.
@@ -55,6 +58,13 @@ func (op *Operands) Push(values ...interface{}) {
 	*op.stack = append(*op.stack, values...)
 }
 
+// Nested returns Operands that view the same stack but start at its top,
+// as though a parenthesis had been opened.  Functions can use them
+// to call other Functions without exposing their own operands.
+func (op *Operands) Nested() Operands {
+	return Operands{stack: op.stack, stackIndex: len(*op.stack), popped: op.popped}
+}
+
 // Pop pops the specified number of values from the associated Parser's
 // operand stack and returns them.  Pop will not pop more than Length values.
 func (op *Operands) Pop(numValues int) []interface{} {
@@ -65,5 +75,8 @@ func (op *Operands) Pop(numValues int) []interface{} {
 	stackIndex := len(*op.stack) - numValues
 	values := (*op.stack)[stackIndex:]
 	*op.stack = (*op.stack)[0:stackIndex]
+	if op.popped != nil && stackIndex < *op.popped {
+		*op.popped = stackIndex
+	}
 	return values
 }
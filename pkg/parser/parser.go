@@ -27,8 +27,12 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"strings"
 )
 
 // Function is a custom function that can be registered with a Parser.
@@ -37,6 +41,20 @@ import (
 // the Operands for the function call, and the Parser's context to the Function.
 type Function func(string, Operands, interface{}) error
 
+// SkipGroup is returned by Functions to silence the rest of the innermost
+// pair of parentheses, as the "silence" special function does (see Parser).
+// It is not an error: Parse continues after the closing parenthesis.
+// Functions that return it, such as conditionals, must be called within
+// parentheses.
+var SkipGroup = errors.New("skip the rest of the parentheses")
+
+// Preprocessor transforms a lexed token before a Parser evaluates it.
+// It returns the tokens to evaluate in the token's place: none, the token
+// itself, or several tokens.  Preprocessors let clients extend the syntax
+// of the language, such as by rewriting literals into function calls,
+// without changing the Lexer or the Parser.
+type Preprocessor func(Token) ([]Token, error)
+
 // Parser treats a stream of lexed tokens as a reverse Polish notation language.
 // It maintains two stacks: an operand stack containing arbitrary values
 // and a "marker stack" of indices into the operand stack.  The top value in
@@ -57,7 +75,56 @@ type Function func(string, Operands, interface{}) error
 // into comments or to disable them for debugging without having to turn
 // them into comment strings.  "silence" MUST appear within a pair
 // of parentheses: Parsers return errors when they encounter "silence"
-// outside of parentheses.
+// outside of parentheses.  Block comments ("#| ... |#"; see Comment) are
+// an alternative that the Lexer skips, so the code within them need not
+// be valid or have balanced parentheses.
+//
+// Functions can also silence the rest of their parentheses by returning
+// SkipGroup, which lets clients define conditionals.  For example, if
+// "if-equal" is a Function that pops two operands and returns SkipGroup
+// unless they are equal, this evaluates "x" only if a equals b:
+//
+//	(a b if-equal x)
+//
+// Parser also provides two special functions, "quote" and "define", that let
+// parsed code define new words.  "quote" MUST be the first token within
+// a pair of parentheses.  It defers the evaluation of the tokens that follow
+// it until the closing parenthesis and pushes them onto the operand stack
+// as a Block.  "define" pops a Block and a name and defines a word with
+// that name; afterwards, each lexed unquoted String matching the word's name
+// evaluates the Block's tokens as though they appeared in its place.
+// For example, if "mul" is a Function that multiplies two numbers,
+// this defines and uses a word named "double":
+//
+//	(quote 2 mul) double define
+//	21 double
+//
+// If ColonDefinitions is set, the special functions ":" and ";" define
+// words as in Forth.  The token after ":" names the word, and the tokens
+// between the name and ";" are its Block, so this also defines "double":
+//
+//	: double 2 mul ;
+//
+// Unlike "quote", ":" can appear outside parentheses.  The parentheses
+// between the name and ";" must balance, and ":" definitions cannot
+// contain ":".  Definitions inside words' Blocks must end within them.
+//
+// Words cannot share names with Functions or special functions.  Words
+// are looked up when they are evaluated, not when they are defined, so
+// a word can call words that are defined later and can call itself.
+// MaxExpansionDepth limits such recursion, and MaxExpansionSteps limits
+// the tokens that each word evaluates, including the tokens of the words
+// that it expands, so that words that double the work of the words that
+// they call cannot run for hours.  Defining a word that is
+// already defined replaces it, even within Blocks that use it, unless
+// ForbidRedefinition is set.
+//
+// Clients can transform the token stream via the Preprocessors field.
+// Each lexed token passes through the Preprocessors in order; the tokens
+// returned by one Preprocessor pass through the next.  Tokens are
+// preprocessed as they are lexed, so the Blocks of words contain
+// preprocessed tokens and are not preprocessed again when they are
+// evaluated.
 //
 // Clients can give Parsers arbitrary context values.  Parser passes the context
 // objects to Functions; this allows the latter to maintain state.
@@ -66,9 +133,71 @@ type Parser struct {
 	markerStack  []int
 	silenced     int
 
+	positions []Position // of the tokens that pushed the operands
+	popped    int        // lowest operand stack length while evaluating a token
+
+	quoting    int   // marker stack depth of the "quote" being read, if any
+	quoted     Block // tokens read since the "quote"
+	words      map[string]Block
+	expansions int // depth of nested word expansions
+	steps      int // tokens evaluated by the outermost word being expanded
+
+	defining          int // 1 while reading a ":" definition's name, 2 while reading its Block
+	definedName       string
+	defined           Block // tokens read since the name
+	definedParens     int   // open parentheses in defined
+	definedExpansions int   // expansion depth of the ":"
+
+	// parsing is the context.Context of the current ParseContext call,
+	// if any, so that nested calls and word expansions can be cancelled.
+	parsing context.Context
+
 	// Functions is a case-senstitive registry of Functions.
 	Functions map[string]Function
 
+	// MaxExpansionDepth limits nested word expansions so that recursive
+	// words fail instead of exhausting the stack.  Zero means
+	// DefaultMaxExpansionDepth.
+	MaxExpansionDepth int
+
+	// MaxExpansionSteps limits the tokens that each word evaluates,
+	// including the tokens of the words that it expands.  Zero means
+	// DefaultMaxExpansionSteps.
+	MaxExpansionSteps int
+
+	// ColonDefinitions enables the ":" and ";" special functions.
+	// Otherwise ":" and ";" are ordinary Strings.
+	ColonDefinitions bool
+
+	// ForbidRedefinition makes "define" and ":" fail for words that are
+	// already defined instead of replacing them.
+	ForbidRedefinition bool
+
+	// DebugOutput is where DumpStackOnError dumps the operand stack.
+	// Nil discards the dumps.
+	DebugOutput io.Writer
+
+	// DumpStackOnError makes Parse dump the operand stack to DebugOutput
+	// when a token causes an error, before KeepGoing discards operands.
+	DumpStackOnError bool
+
+	// Preprocessors transform lexed tokens in order.
+	Preprocessors []Preprocessor
+
+	// KeepGoing makes Parse recover from errors in Functions, words,
+	// and Preprocessors rather than stop at the first one.  Parse then
+	// returns all of the errors as ParseErrors.  See recoverFromError.
+	KeepGoing bool
+
+	// BeforeCall and AfterCall, if not nil, are called before and after
+	// each call of a Function with the Function's name, its Operands,
+	// and the Position of the token that called it, such as to trace
+	// or profile calls.  AfterCall also receives the Function's error.
+	// Tokens in words' Blocks keep the Positions at which they were
+	// defined.  Special functions do not call the hooks.
+	BeforeCall func(fn string, op Operands, pos Position)
+	AfterCall  func(fn string, op Operands, pos Position, err error)
+
 	// Context is an arbitrary value that Parser will pass to
 	// called Functions.
 	Context interface{}
@@ -78,73 +207,538 @@ type Parser struct {
 // The Parser will have empty operand and marker stacks and will have
 // no Functions.
 func NewParser(context interface{}) *Parser {
-	return &Parser{operandStack: make([]interface{}, 0), markerStack: make([]int, 0), Functions: make(map[string]Function), Context: context}
+	return &Parser{operandStack: make([]interface{}, 0), markerStack: make([]int, 0), words: make(map[string]Block), Functions: make(map[string]Function), Context: context}
+}
+
+// Token is a lexed token.  Position is where the token began in
+// the Lexer's input.  Preprocessors should give the tokens that they
+// return the Positions of the tokens that they replace.
+type Token struct {
+	Type     TokenType
+	Text     string
+	Position Position
+}
+
+// Block is a sequence of tokens whose evaluation is deferred.
+// The "quote" special function creates Blocks.
+type Block []Token
+
+// String returns the Block as a "quote" expression.
+func (b Block) String() string {
+	var s strings.Builder
+	s.WriteString("(quote")
+	opened := false
+	for _, t := range b {
+		if t.Type != CloseParen && !opened {
+			s.WriteByte(' ')
+		}
+		opened = t.Type == OpenParen
+		switch t.Type {
+		case QuotedString:
+			s.WriteString(Quote(t.Text))
+		case OpenParen:
+			s.WriteByte('(')
+		case CloseParen:
+			s.WriteByte(')')
+		default:
+			s.WriteString(t.Text)
+		}
+	}
+	s.WriteByte(')')
+	return s.String()
+}
+
+// DefaultMaxExpansionDepth is the default limit of nested word expansions.
+// See Parser.MaxExpansionDepth.
+const DefaultMaxExpansionDepth = 100
+
+// DefaultMaxExpansionSteps is the default limit of tokens that each word
+// evaluates.
+const DefaultMaxExpansionSteps = 1000000
+
+// formatError returns a ParseError for err, which token t caused.
+func (p *Parser) formatError(lex *Lexer, t Token, err error) *ParseError {
+	e := &ParseError{Position: t.Position, Stack: p.Stack(), Err: err}
+	e.Source, _ = lex.SourceLine(t.Position.Line)
+	if t.Type == String && p.isCallable(t.Text) {
+		e.Function = t.Text
+	}
+	return e
 }
 
-func (p *Parser) formatError(lex *Lexer, err error) error {
-	return fmt.Errorf(`%v: %v`, lex.LineNumber(), err)
+// isSpecial returns true if name is the name of a special function.
+func (p *Parser) isSpecial(name string) bool {
+	switch name {
+	case "silence", "quote", "define":
+		return true
+	case ":", ";":
+		return p.ColonDefinitions
+	}
+	return false
+}
+
+// isCallable returns true if name is the name of a special function,
+// a word, or a Function.
+func (p *Parser) isCallable(name string) bool {
+	if p.isSpecial(name) {
+		return true
+	} else if _, ok := p.words[name]; ok {
+		return true
+	}
+	_, ok := p.Functions[name]
+	return ok
 }
 
 // Parse executes the stream of tokens from the specified Lexer.
 // It returns nil when the Lexer reaches EOF without problems.
-// If a called Function returns an error, Parse stops and returns it unmodified.
+// If a called Function returns an error, Parse stops and returns it wrapped
+// in a ParseError, which prefixes it with the Position ("LINE:COLUMN")
+// of the token that called the Function.  If KeepGoing is set, Parse
+// continues after such errors and returns them as ParseErrors when
+// the Lexer reaches EOF or a syntax error.
+//
+// If Parse is called by a Function while another call of ParseContext is
+// parsing, such as to parse an included file, the latter's context.Context
+// can cancel it.
 func (p *Parser) Parse(lex *Lexer) error {
+	ctx := p.parsing
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return p.ParseContext(ctx, lex)
+}
+
+// ParseContext is like Parse, but it stops before evaluating the next token,
+// including the next token in a word's Block, when ctx is done and returns
+// ctx.Err().  It does not wrap the error or, in KeepGoing mode, recover
+// from it, so clients can check for cancellation by comparing the returned
+// error with ctx.Err().  ctx can thus stop long parses early, such as
+// when clients do not need the rest of the input.  In KeepGoing mode,
+// if ParseContext collected errors before ctx was done, it returns them
+// as ParseErrors followed by a ParseError wrapping ctx.Err(), which
+// errors.Is finds.
+func (p *Parser) ParseContext(ctx context.Context, lex *Lexer) error {
+	saved := p.parsing
+	p.parsing = ctx
+	defer func() { p.parsing = saved }()
+	var errs ParseErrors
+	cancelled := func(t Token) error {
+		if len(errs) == 0 {
+			return ctx.Err()
+		}
+		return append(errs, p.formatError(lex, t, ctx.Err()))
+	}
+	fail := func(t Token, err error) error {
+		var nested ParseErrors
+		var pe *ParseError
+		if ctx.Err() != nil {
+			return cancelled(t)
+		} else if p.DumpStackOnError && !errors.As(err, &pe) && !errors.As(err, &nested) {
+			p.DumpStack(p.debugOutput()) // nested errors were dumped already
+		}
+		if !p.KeepGoing {
+			return p.formatError(lex, t, err)
+		} else if errors.As(err, &nested) {
+			errs = append(errs, nested...) // such as errors in included files
+		} else {
+			errs = append(errs, p.formatError(lex, t, err))
+		}
+		p.recoverFromError()
+		return nil
+	}
 	for {
+		if ctx.Err() != nil {
+			return cancelled(Token{Type: Error, Position: lex.Position()})
+		}
 		tokenType, text, e := lex.GetNextToken()
-		switch tokenType {
-		case String:
-			if p.silenced == 0 {
-				if text == "silence" {
-					if len(p.markerStack) == 0 {
-						return p.formatError(lex, fmt.Errorf(`found "silence" outside parentheses`))
-					}
-					p.silenced = len(p.markerStack)
-				} else if f, ok := p.Functions[text]; ok {
-					if e = f(text, p.getOperands(), p.Context); e != nil {
-						return p.formatError(lex, e)
-					}
-				} else {
-					p.pushString(text)
+		if tokenType == Error {
+			if e != io.EOF {
+				if err := fail(Token{Type: Error, Position: lex.Position()}, fmt.Errorf(`syntax error: %v`, e)); err != nil {
+					return err
 				}
 			}
-		case QuotedString:
-			if p.silenced == 0 {
-				p.pushString(text)
-			}
-		case OpenParen:
-			p.markerStack = append(p.markerStack, len(p.operandStack))
-		case CloseParen:
-			if e = p.onCloseParen(); e != nil {
-				return p.formatError(lex, e)
+			return errs.errorOrNil()
+		} else if tokenType == Comment {
+			continue
+		}
+		token := Token{Type: tokenType, Text: text, Position: lex.TokenPosition()}
+		tokens, err := p.preprocess(token)
+		if err != nil {
+			if err = fail(token, err); err != nil {
+				return err
 			}
-		case Error:
-			if e == io.EOF {
-				return nil
+			tokens = nil
+		}
+		for _, t := range tokens {
+			if ctx.Err() != nil {
+				return cancelled(t)
+			} else if err = p.evaluate(t); err != nil {
+				if err = fail(t, err); err != nil {
+					return err
+				}
+				break
 			}
-			return p.formatError(lex, fmt.Errorf(`syntax error: %v`, e))
-		default:
-			panic("unexpected TokenType")
 		}
 
 		if e == io.EOF {
+			return errs.errorOrNil()
+		}
+	}
+}
+
+// recoverFromError discards the operands within the innermost pair
+// of parentheses, or all operands if there is none, after an error in
+// KeepGoing mode.  It also silences the rest of the parentheses so
+// that the tokens that would have consumed the discarded operands,
+// such as the rest of a transaction, do not cause more errors.
+func (p *Parser) recoverFromError() {
+	p.quoting, p.quoted = 0, nil
+	p.endDefinition()
+	if n := len(p.markerStack); n != 0 {
+		p.operandStack = p.operandStack[:p.markerStack[n-1]]
+		if p.silenced == 0 {
+			p.silenced = n
+		}
+	} else {
+		p.operandStack = p.operandStack[:0]
+	}
+	if len(p.positions) > len(p.operandStack) {
+		p.positions = p.positions[:len(p.operandStack)]
+	}
+}
+
+// preprocess passes a token through the Preprocessors.
+func (p *Parser) preprocess(t Token) ([]Token, error) {
+	tokens := []Token{t}
+	for _, pp := range p.Preprocessors {
+		var out []Token
+		for _, t := range tokens {
+			ts, err := pp(t)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, ts...)
+		}
+		tokens = out
+	}
+	return tokens, nil
+}
+
+// evaluate executes a single token and records the Positions of
+// the operands that it pushes.  Operands pushed by Functions and words
+// get the Positions of the tokens that called them.
+func (p *Parser) evaluate(t Token) error {
+	outer := p.popped
+	p.popped = len(p.operandStack)
+	err := p.execute(t)
+	if len(p.positions) > p.popped {
+		p.positions = p.positions[:p.popped]
+	}
+	for len(p.positions) < len(p.operandStack) {
+		p.positions = append(p.positions, t.Position)
+	}
+	if outer < p.popped {
+		p.popped = outer // for the token that is expanding a word, if any
+	}
+	return err
+}
+
+// execute executes a single token.
+func (p *Parser) execute(t Token) error {
+	if p.quoting != 0 {
+		return p.quote(t)
+	} else if p.defining != 0 {
+		return p.colon(t)
+	}
+	text := t.Text
+	switch t.Type {
+	case String:
+		if p.silenced == 0 {
+			if text == "silence" {
+				return p.silence(text)
+			} else if text == "quote" {
+				if len(p.markerStack) == 0 || p.markerStack[len(p.markerStack)-1] != len(p.operandStack) {
+					return fmt.Errorf(`found "quote" other than at the start of parentheses`)
+				}
+				p.quoting = len(p.markerStack)
+			} else if text == "define" {
+				return p.define()
+			} else if text == ":" && p.ColonDefinitions {
+				p.defining, p.definedExpansions = 1, p.expansions
+			} else if text == ";" && p.ColonDefinitions {
+				return fmt.Errorf(`found ";" outside a ":" definition`)
+			} else if b, ok := p.words[text]; ok {
+				return p.expand(text, b)
+			} else if f, ok := p.Functions[text]; ok {
+				return p.call(f, t)
+			} else {
+				p.pushString(text)
+			}
+		}
+	case QuotedString:
+		if p.silenced == 0 {
+			p.pushString(text)
+		}
+	case OpenParen:
+		p.markerStack = append(p.markerStack, len(p.operandStack))
+	case CloseParen:
+		return p.onCloseParen()
+	default:
+		panic("unexpected TokenType")
+	}
+	return nil
+}
+
+// call calls Function f, which t named, and the hooks.
+func (p *Parser) call(f Function, t Token) error {
+	op := p.getOperands()
+	if p.BeforeCall != nil {
+		p.BeforeCall(t.Text, op, t.Position)
+	}
+	err := f(t.Text, op, p.Context)
+	if err == SkipGroup {
+		err = p.silence(t.Text)
+	}
+	if p.AfterCall != nil {
+		p.AfterCall(t.Text, op, t.Position, err)
+	}
+	return err
+}
+
+// silence silences the rest of the innermost pair of parentheses for
+// the "silence" special function or for Function fn, which returned
+// SkipGroup.
+func (p *Parser) silence(fn string) error {
+	if len(p.markerStack) == 0 {
+		return fmt.Errorf(`found %q outside parentheses`, fn)
+	}
+	p.silenced = len(p.markerStack)
+	return nil
+}
+
+// quote records a token following a "quote".  The closing parenthesis
+// matching the "quote" pushes the recorded Block.
+func (p *Parser) quote(t Token) error {
+	switch t.Type {
+	case OpenParen:
+		p.markerStack = append(p.markerStack, len(p.operandStack))
+	case CloseParen:
+		if len(p.markerStack) == p.quoting {
+			block := p.quoted
+			p.quoting, p.quoted = 0, nil
+			if err := p.onCloseParen(); err != nil {
+				return err
+			}
+			p.operandStack = append(p.operandStack, block)
 			return nil
 		}
+		p.markerStack = p.markerStack[0 : len(p.markerStack)-1]
+	}
+	p.quoted = append(p.quoted, t)
+	return nil
+}
+
+// colon records a token of a ":" definition.  The first token names
+// the word, and ";" defines it.
+func (p *Parser) colon(t Token) error {
+	if p.defining == 1 {
+		if t.Type != String && t.Type != QuotedString {
+			return fmt.Errorf(`":" requires a name, not a parenthesis`)
+		}
+		p.defining, p.definedName = 2, t.Text
+		return nil
+	}
+	switch t.Type {
+	case OpenParen:
+		p.definedParens++
+	case CloseParen:
+		if p.definedParens == 0 {
+			return fmt.Errorf(`closing parenthesis in definition of %v does not have a matching open parenthesis`, p.definedName)
+		}
+		p.definedParens--
+	case String:
+		if t.Text == ":" {
+			return fmt.Errorf(`found ":" in definition of %v`, p.definedName)
+		} else if t.Text == ";" {
+			name, b, parens := p.definedName, p.defined, p.definedParens
+			p.endDefinition()
+			if parens != 0 {
+				return fmt.Errorf(`%v unclosed parentheses in definition of %v`, parens, name)
+			}
+			return p.defineWord(`";"`, name, b)
+		}
+	}
+	p.defined = append(p.defined, t)
+	return nil
+}
+
+// endDefinition abandons the ":" definition being read, if any.
+func (p *Parser) endDefinition() {
+	p.defining, p.definedName, p.defined, p.definedParens = 0, "", nil, 0
+}
+
+// define implements the "define" special function.
+//
+// Syntax: Block NAME define ->
+func (p *Parser) define() error {
+	op := p.getOperands()
+	if op.Length() < 2 {
+		return fmt.Errorf(`define: block and name operands required, but too few given`)
+	}
+	values := op.Pop(2)
+	block, ok := values[0].(Block)
+	if !ok {
+		return fmt.Errorf(`define: not a block: %v`, values[0])
+	}
+	name, ok := values[1].(string)
+	if !ok {
+		return fmt.Errorf(`define: non-string name: %v`, values[1])
+	}
+	return p.defineWord("define", name, block)
+}
+
+// defineWord defines a word for special function fn.
+func (p *Parser) defineWord(fn, name string, b Block) error {
+	if _, ok := p.Functions[name]; ok || p.isSpecial(name) {
+		return fmt.Errorf(`%v: %v is a function`, fn, name)
+	} else if _, ok = p.words[name]; ok && p.ForbidRedefinition {
+		return fmt.Errorf(`%v: %v is already defined`, fn, name)
+	}
+	p.words[name] = b
+	return nil
+}
+
+// expand evaluates a word's Block.  Errors are prefixed with the name
+// of the outermost word being expanded.
+func (p *Parser) expand(name string, b Block) error {
+	limit := p.MaxExpansionDepth
+	if limit == 0 {
+		limit = DefaultMaxExpansionDepth
+	}
+	if p.expansions >= limit {
+		return fmt.Errorf(`words nested too deeply (is a word recursive?)`)
+	}
+	steps := p.MaxExpansionSteps
+	if steps == 0 {
+		steps = DefaultMaxExpansionSteps
+	}
+	if p.expansions == 0 {
+		p.steps = 0
+	}
+	p.expansions++
+	defer func() { p.expansions-- }()
+	var err error
+	for _, t := range b {
+		if p.parsing != nil && p.parsing.Err() != nil {
+			return p.parsing.Err()
+		} else if p.steps++; p.steps > steps {
+			err = fmt.Errorf(`words evaluated more than %v tokens`, steps)
+			break
+		} else if err = p.evaluate(t); err != nil {
+			break
+		}
+	}
+	if err == nil && p.defining != 0 && p.definedExpansions == p.expansions {
+		err = fmt.Errorf(`unterminated ":" definition`)
 	}
+	if err != nil && p.expansions == 1 {
+		err = fmt.Errorf(`%v: %v`, name, err)
+	}
+	return err
 }
 
 // Finish runs final checks on the operand and marker stacks.
-// It returns nil if there are no problems.
+// It returns nil if there are no problems.  If DumpStackOnError is set,
+// Finish dumps the operand stack when it finds problems.
 func (p *Parser) Finish() error {
+	err := p.finish()
+	if err != nil && p.DumpStackOnError {
+		p.DumpStack(p.debugOutput())
+	}
+	return err
+}
+
+func (p *Parser) finish() error {
 	if len(p.operandStack) > 0 {
 		return fmt.Errorf("%v unconsumed tokens left on stack at EOF", len(p.operandStack))
 	} else if len(p.markerStack) > 0 {
 		return fmt.Errorf("%v unclosed parentheses at EOF", len(p.markerStack))
 	} else if p.silenced != 0 {
 		return fmt.Errorf("parser evaluation silenced at EOF")
+	} else if p.defining != 0 {
+		return fmt.Errorf(`unterminated ":" definition at EOF`)
 	}
 	return nil
 }
 
+// Stack returns a copy of the operand stack, bottom first.
+func (p *Parser) Stack() []interface{} {
+	return append([]interface{}(nil), p.operandStack...)
+}
+
+// Words returns a copy of the words defined by "define".
+func (p *Parser) Words() map[string]Block {
+	words := make(map[string]Block, len(p.words))
+	for name, b := range p.words {
+		words[name] = b
+	}
+	return words
+}
+
+// SetWord defines a word as though by "define", but without checking
+// its name.  It lets clients restore words returned by Words.
+func (p *Parser) SetWord(name string, b Block) {
+	p.words[name] = b
+}
+
+// Defining returns true if a ":" definition has begun but not ended.
+func (p *Parser) Defining() bool {
+	return p.defining != 0
+}
+
+// DumpStack writes the operand stack to w, bottom first, with
+// the Positions and types of the operands.  Operands pushed by Functions
+// and words have the Positions of the tokens that called them, and Blocks
+// have the Positions of the parentheses that closed them.  Each "("
+// marks an open parenthesis.
+func (p *Parser) DumpStack(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "operand stack (%v operands, %v open parentheses):\n", len(p.operandStack), len(p.markerStack))
+	m := 0
+	for n, v := range p.operandStack {
+		for ; m < len(p.markerStack) && p.markerStack[m] <= n; m++ {
+			b.WriteString("\t(\n")
+		}
+		var pos Position
+		if n < len(p.positions) {
+			pos = p.positions[n]
+		}
+		typ := fmt.Sprintf("%T", v)
+		if s, ok := v.(string); ok {
+			v = Quote(s)
+		}
+		fmt.Fprintf(&b, "\t%v\t%v\t%v\n", pos, typ, v)
+	}
+	for ; m < len(p.markerStack); m++ {
+		b.WriteString("\t(\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// debugOutput returns DebugOutput or, if it is nil, ioutil.Discard.
+func (p *Parser) debugOutput() io.Writer {
+	if p.DebugOutput == nil {
+		return ioutil.Discard
+	}
+	return p.DebugOutput
+}
+
+// Depth returns the number of open parentheses that have not been closed.
+func (p *Parser) Depth() int {
+	return len(p.markerStack)
+}
+
 // pushString is a convenience function for pushing a string onto
 // the operand stack.
 func (p *Parser) pushString(text string) {
@@ -160,7 +754,7 @@ func (p *Parser) getOperands() Operands {
 			panic("top of marker stack extends beyond length of operand stack")
 		}
 	}
-	return Operands{stack: &p.operandStack, stackIndex: index}
+	return Operands{stack: &p.operandStack, stackIndex: index, popped: &p.popped}
 }
 
 // onCloseParen implements the close parenthesis behavior.  It checks whether
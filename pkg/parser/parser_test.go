@@ -27,6 +27,8 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -66,6 +68,25 @@ func TestOperands_Push(t *testing.T) {
 	}
 }
 
+func TestOperands_Nested(t *testing.T) {
+	values := []interface{}{1, 2, 3}
+	op := Operands{stack: &values, stackIndex: 1}
+	nested := op.Nested()
+	if nested.Length() != 0 {
+		t.Errorf("Nested() failed: nested Operands have values: %v", nested.GetValues())
+	}
+	nested.Push(4, 5)
+	if !reflect.DeepEqual(nested.GetValues(), []interface{}{4, 5}) {
+		t.Errorf("Nested() failed: nested Operands don't see pushed values, got %v", nested.GetValues())
+	}
+	if popped := nested.Pop(5); !reflect.DeepEqual(popped, []interface{}{4, 5}) {
+		t.Errorf("Nested() failed: nested Operands popped %v", popped)
+	}
+	if !reflect.DeepEqual(op.GetValues(), []interface{}{2, 3}) {
+		t.Errorf("Nested() failed: outer Operands changed: %v", op.GetValues())
+	}
+}
+
 func TestOperands_Pop(t *testing.T) {
 	values := []interface{}{1, 2, 3, 4, 5}
 	op := Operands{stack: &values}
@@ -167,7 +188,7 @@ func TestParser_Parse_FunctionErrorPassesThrough(t *testing.T) {
 	p.Functions["error"] = func(fn string, op Operands, ctx interface{}) error {
 		return err
 	}
-	if e := p.Parse(lex); e.Error() != fmt.Sprintf(`1: %v`, err) {
+	if e := p.Parse(lex); e.Error() != fmt.Sprintf(`1:15: %v`, err) {
 		t.Errorf("Parse returned unexpected error: %v", e)
 	}
 }
@@ -211,6 +232,23 @@ func TestParser_Finish_UnconsumedOperands(t *testing.T) {
 	}
 }
 
+func TestParser_StackAndDepth(t *testing.T) {
+	p := NewParser(nil)
+	if e := p.Parse(NewLexer(strings.NewReader(`token1 ("token 2" (`))); e != nil {
+		t.Fatalf("Parse returned a non-nil error: %v", e)
+	}
+	if stack := p.Stack(); !reflect.DeepEqual(stack, []interface{}{"token1", "token 2"}) {
+		t.Errorf("Stack returned unexpected values: %v", stack)
+	} else if p.Depth() != 2 {
+		t.Errorf("Depth returned %v instead of 2", p.Depth())
+	}
+	if e := p.Parse(NewLexer(strings.NewReader(`)`))); e != nil {
+		t.Fatalf("Parse returned a non-nil error: %v", e)
+	} else if p.Depth() != 1 {
+		t.Errorf("Depth returned %v instead of 1 after closing a parenthesis", p.Depth())
+	}
+}
+
 func TestSilence(t *testing.T) {
 	lex := NewLexer(strings.NewReader(`(silence fail)`))
 	p := NewParser(nil)
@@ -222,6 +260,44 @@ func TestSilence(t *testing.T) {
 	}
 }
 
+func TestSkipGroup(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(inc skip inc (inc) inc) inc (inc skip)`))
+	p := NewParser(nil)
+	value := 0
+	p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error {
+		value++
+		return nil
+	}
+	p.Functions["skip"] = func(fn string, op Operands, ctx interface{}) error {
+		return SkipGroup
+	}
+	var errs []error
+	p.AfterCall = func(fn string, op Operands, pos Position, err error) {
+		errs = append(errs, err)
+	}
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if value != 3 {
+		t.Errorf("SkipGroup did not silence the rest of the parentheses")
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("AfterCall received an error: %v", err)
+		}
+	}
+}
+
+func TestSkipGroup_OutsideParens(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`skip`))
+	p := NewParser(nil)
+	p.Functions["skip"] = func(fn string, op Operands, ctx interface{}) error {
+		return SkipGroup
+	}
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
 func TestSilence_OutsideParens(t *testing.T) {
 	lex := NewLexer(strings.NewReader(`silence`))
 	p := NewParser(nil)
@@ -287,3 +363,450 @@ func TestSilence_AtTopLevelBetweenParens(t *testing.T) {
 		t.Errorf("Parse succeeded but should have failed")
 	}
 }
+
+func TestDefine(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(quote a (b "inc" inc) inc) w define w inc w`))
+	p := NewParser(nil)
+	var calls []string
+	p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error {
+		calls = append(calls, fmt.Sprint(op.Pop(op.Length())))
+		return nil
+	}
+	if err := p.Parse(lex); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	} else if s := strings.Join(calls, " "); s != "[b inc] [a] [] [b inc] [a]" {
+		t.Errorf("words expanded incorrectly: %v", s)
+	}
+	if err := p.Finish(); err != nil {
+		t.Errorf("Finish failed: %v", err)
+	}
+}
+
+func TestDefine_NestedWords(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(quote inc) one define (quote one one) two define two`))
+	p := NewParser(nil)
+	value := 0
+	p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error {
+		value++
+		return nil
+	}
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if value != 2 {
+		t.Errorf("nested words called inc %v times instead of 2", value)
+	}
+}
+
+func TestDefine_QuoteNotFirst(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(a quote b)`))
+	p := NewParser(nil)
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestDefine_QuoteOutsideParens(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`quote a`))
+	p := NewParser(nil)
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestDefine_NonBlock(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`a w define`))
+	p := NewParser(nil)
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestDefine_FunctionName(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(quote a) inc define`))
+	p := NewParser(nil)
+	p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error { return nil }
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestDefine_RecursiveWord(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(quote w) w define w`))
+	p := NewParser(nil)
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestDefine_SilencedQuote(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(silence (quote a) w define) w`))
+	p := NewParser(nil)
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if s := fmt.Sprint(p.Stack()); s != "[w]" {
+		t.Errorf("silence did not silence define: %v", s)
+	}
+}
+
+func TestColonDefinition(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`a : w (b "inc" inc) inc ; w inc w (: "v" c ;) v`))
+	p := NewParser(nil)
+	p.ColonDefinitions = true
+	var calls []string
+	p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error {
+		calls = append(calls, fmt.Sprint(op.Pop(op.Length())))
+		return nil
+	}
+	if err := p.Parse(lex); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	} else if s := strings.Join(calls, " "); s != "[b inc] [a] [] [b inc] []" {
+		t.Errorf("words expanded incorrectly: %v", s)
+	} else if s := fmt.Sprint(p.Stack()); s != "[c]" {
+		t.Errorf("word in parentheses expanded incorrectly: %v", s)
+	}
+}
+
+func TestColonDefinition_Recursion(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`: countdown (dec skip-if-zero countdown) ; countdown`))
+	p := NewParser(nil)
+	p.ColonDefinitions = true
+	value := 5
+	p.Functions["dec"] = func(fn string, op Operands, ctx interface{}) error {
+		value--
+		return nil
+	}
+	p.Functions["skip-if-zero"] = func(fn string, op Operands, ctx interface{}) error {
+		if value == 0 {
+			return SkipGroup
+		}
+		return nil
+	}
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if value != 0 {
+		t.Errorf("countdown stopped at %v", value)
+	}
+	value = 5
+	p.MaxExpansionDepth = 3
+	if p.Parse(NewLexer(strings.NewReader(`countdown`))) == nil {
+		t.Errorf("Parse succeeded beyond MaxExpansionDepth")
+	}
+}
+
+func TestColonDefinition_Redefinition(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`: w a ; : v w ; : w b ; v`))
+	p := NewParser(nil)
+	p.ColonDefinitions = true
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if s := fmt.Sprint(p.Stack()); s != "[b]" {
+		t.Errorf("redefinition did not replace the word: %v", s)
+	}
+	p.ForbidRedefinition = true
+	if p.Parse(NewLexer(strings.NewReader(`: w c ;`))) == nil {
+		t.Errorf("Parse redefined a word despite ForbidRedefinition")
+	} else if p.Parse(NewLexer(strings.NewReader(`(quote c) "w" define`))) == nil {
+		t.Errorf("define redefined a word despite ForbidRedefinition")
+	}
+}
+
+func TestColonDefinition_Failures(t *testing.T) {
+	for _, input := range []string{
+		`;`,
+		`: ( a ;`,
+		`: w ( a ;`,
+		`: w a ) ;`,
+		`: w : v ; ;`,
+		`: inc a ;`,
+		`: silence a ;`,
+		`(quote : w a) v define v`,
+	} {
+		p := NewParser(nil)
+		p.ColonDefinitions = true
+		p.Functions["inc"] = func(fn string, op Operands, ctx interface{}) error { return nil }
+		if p.Parse(NewLexer(strings.NewReader(input))) == nil {
+			t.Errorf("parsing %q succeeded", input)
+		}
+	}
+	p := NewParser(nil)
+	p.ColonDefinitions = true
+	if err := p.Parse(NewLexer(strings.NewReader(`: w a`))); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if !p.Defining() {
+		t.Errorf("Defining returned false within a definition")
+	} else if p.Finish() == nil {
+		t.Errorf("Finish succeeded within a definition")
+	} else if err = p.Parse(NewLexer(strings.NewReader(`;`))); err != nil {
+		t.Errorf("definition spanning two inputs failed: %v", err)
+	} else if p.Defining() {
+		t.Errorf("Defining returned true after a definition")
+	}
+}
+
+func TestColonDefinition_Disabled(t *testing.T) {
+	p := NewParser(nil)
+	var calls []string
+	p.Functions[";"] = func(fn string, op Operands, ctx interface{}) error {
+		calls = append(calls, fmt.Sprint(op.Pop(op.Length())))
+		return nil
+	}
+	if err := p.Parse(NewLexer(strings.NewReader(`(: w a ;) : v`))); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if s := strings.Join(calls, " "); s != "[: w a]" {
+		t.Errorf("; was not called as a Function: %v", s)
+	} else if s := fmt.Sprint(p.Stack()); s != "[: v]" {
+		t.Errorf(": was not pushed: %v", s)
+	} else if err = p.Parse(NewLexer(strings.NewReader(`(quote a) ":" define`))); err != nil {
+		t.Errorf("defining a word named : failed: %v", err)
+	}
+}
+
+func TestParser_MaxExpansionSteps(t *testing.T) {
+	p := NewParser(nil)
+	p.MaxExpansionSteps = 100
+	p.Functions["drop"] = func(fn string, op Operands, ctx interface{}) error {
+		op.Pop(op.Length())
+		return nil
+	}
+	// Each word doubles the work of the word before it.
+	input := `(quote (a drop)) w0 define
+		(quote w0 w0) w1 define
+		(quote w1 w1) w2 define
+		(quote w2 w2) w3 define
+		(quote w3 w3) w4 define
+		(quote w4 w4) w5 define`
+	if err := p.Parse(NewLexer(strings.NewReader(input + ` w4`))); err != nil {
+		t.Errorf("Parse failed within MaxExpansionSteps: %v", err)
+	} else if err = p.Parse(NewLexer(strings.NewReader(`w4 w4`))); err != nil {
+		t.Errorf("steps were not counted per word: %v", err)
+	} else if err = p.Parse(NewLexer(strings.NewReader(`w5`))); err == nil || !strings.Contains(err.Error(), "more than 100 tokens") {
+		t.Errorf("Parse returned %v beyond MaxExpansionSteps", err)
+	}
+}
+
+func TestDumpStack(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`a (b "c d" pair : w x ; w dump-stack`))
+	p := NewParser(nil)
+	p.ColonDefinitions = true
+	p.Functions["pair"] = func(fn string, op Operands, ctx interface{}) error {
+		values := op.Pop(2)
+		op.Push(fmt.Sprintf("%v %v", values[0], values[1]))
+		return nil
+	}
+	var b strings.Builder
+	p.Functions["dump-stack"] = func(fn string, op Operands, ctx interface{}) error {
+		return p.DumpStack(&b)
+	}
+	expected := "operand stack (3 operands, 1 open parentheses):\n" +
+		"\t1:1\tstring\t\"a\"\n" +
+		"\t(\n" +
+		"\t1:12\tstring\t\"b c d\"\n" +
+		"\t1:25\tstring\t\"x\"\n"
+	if err := p.Parse(lex); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	} else if b.String() != expected {
+		t.Errorf("dump-stack wrote %q instead of %q", b.String(), expected)
+	} else if s := fmt.Sprint(p.Stack()); s != "[a b c d x]" {
+		t.Errorf("dump-stack changed the stack: %v", s)
+	}
+}
+
+func TestDumpStack_OnError(t *testing.T) {
+	p := NewParser(nil)
+	var b strings.Builder
+	p.DebugOutput = &b
+	p.DumpStackOnError = true
+	if p.Parse(NewLexer(strings.NewReader(`(a)`))) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	} else if !strings.Contains(b.String(), "\t1:2\tstring\t\"a\"\n") {
+		t.Errorf("Parse dumped %q", b.String())
+	}
+	b.Reset()
+	p = NewParser(nil)
+	p.DebugOutput = &b
+	p.DumpStackOnError = true
+	if err := p.Parse(NewLexer(strings.NewReader(`a`))); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if b.Len() != 0 {
+		t.Errorf("Parse dumped the stack without an error: %q", b.String())
+	} else if p.Finish() == nil {
+		t.Errorf("Finish succeeded but should have failed")
+	} else if !strings.Contains(b.String(), "\t1:1\tstring\t\"a\"\n") {
+		t.Errorf("Finish dumped %q", b.String())
+	}
+}
+
+func TestPreprocessors(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`a $5 "$6" drop b`))
+	p := NewParser(nil)
+	p.Preprocessors = append(p.Preprocessors,
+		func(t Token) ([]Token, error) {
+			if t.Type == String && strings.HasPrefix(t.Text, "$") {
+				return []Token{{Type: String, Text: t.Text[1:]}, {Type: String, Text: "USD"}}, nil
+			}
+			return []Token{t}, nil
+		},
+		func(t Token) ([]Token, error) {
+			if t.Type == String && t.Text == "drop" {
+				return nil, nil
+			}
+			return []Token{t}, nil
+		})
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if s := fmt.Sprint(p.Stack()); s != "[a 5 USD $6 b]" {
+		t.Errorf("preprocessors produced unexpected stack: %v", s)
+	}
+}
+
+func TestPreprocessors_Error(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`a bad`))
+	p := NewParser(nil)
+	p.Preprocessors = append(p.Preprocessors, func(t Token) ([]Token, error) {
+		if t.Text == "bad" {
+			return nil, fmt.Errorf("bad token")
+		}
+		return []Token{t}, nil
+	})
+	if p.Parse(lex) == nil {
+		t.Errorf("Parse succeeded but should have failed")
+	}
+}
+
+func TestPreprocessors_Words(t *testing.T) {
+	calls := 0
+	lex := NewLexer(strings.NewReader(`(quote x) w define w w`))
+	p := NewParser(nil)
+	p.Preprocessors = append(p.Preprocessors, func(t Token) ([]Token, error) {
+		if t.Text == "x" {
+			calls++
+		}
+		return []Token{t}, nil
+	})
+	if err := p.Parse(lex); err != nil {
+		t.Errorf("Parse failed: %v", err)
+	} else if calls != 1 {
+		t.Errorf("preprocessed word tokens %v times instead of once", calls)
+	}
+}
+
+func TestParser_Parse_ParseError(t *testing.T) {
+	lex := NewLexer(strings.NewReader("token1\n\t\"token2\" fail"))
+	p := NewParser(t)
+	p.Functions["fail"] = func(fn string, op Operands, ctx interface{}) error {
+		return fmt.Errorf("failed")
+	}
+	err := p.Parse(lex)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("Parse returned unexpected error: %v", err)
+	} else if pe.Position != (Position{2, 11, 17}) || pe.Function != "fail" || pe.Source != "\t\"token2\" fail" || len(pe.Stack) != 2 {
+		t.Errorf("Parse returned a ParseError with unexpected fields: %+v", pe)
+	}
+	expected := "2:11: failed\n" +
+		"    2 | \t\"token2\" fail\n" +
+		"      | \t         ^\n" +
+		"function: fail\n" +
+		"stack: \"token1\" \"token2\""
+	if s := pe.Pretty(); s != expected {
+		t.Errorf("Pretty returned %#v instead of %#v", s, expected)
+	}
+	pe.File = "ledger.fbn"
+	if s := PrettyError(fmt.Errorf("2000-01-01: %w", pe)); !strings.HasPrefix(s, "2000-01-01: ledger.fbn:2:11: failed\n") {
+		t.Errorf("PrettyError returned %#v", s)
+	} else if s = PrettyError(fmt.Errorf("plain")); s != "plain" {
+		t.Errorf("PrettyError returned %#v for a plain error", s)
+	}
+}
+
+func TestParser_Parse_KeepGoing(t *testing.T) {
+	lex := NewLexer(strings.NewReader("a fail\n(b c fail d fail) e pop\n\"unfinished"))
+	p := NewParser(t)
+	p.KeepGoing = true
+	popped := []interface{}{}
+	p.Functions["fail"] = func(fn string, op Operands, ctx interface{}) error {
+		return fmt.Errorf("failed")
+	}
+	p.Functions["pop"] = func(fn string, op Operands, ctx interface{}) error {
+		popped = append(popped, op.Pop(op.Length())...)
+		return nil
+	}
+	errs, ok := p.Parse(lex).(ParseErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("Parse returned unexpected errors: %v", errs)
+	}
+	for n, expected := range []string{"1:3: failed", "2:6: failed", "3:12: syntax error: unfinished quoted string at end of file"} {
+		if errs[n].Error() != expected {
+			t.Errorf("error %v is %#v instead of %#v", n, errs[n].Error(), expected)
+		}
+	}
+	if !reflect.DeepEqual(popped, []interface{}{"e"}) {
+		t.Errorf("Parse did not discard the failed operands: popped %v", popped)
+	} else if err := p.Finish(); err != nil {
+		t.Errorf("Finish failed after recovering from errors: %v", err)
+	}
+}
+
+func TestParser_Parse_CallHooks(t *testing.T) {
+	lex := NewLexer(strings.NewReader("a ok\n(b fail)"))
+	p := NewParser(t)
+	p.Functions["ok"] = func(fn string, op Operands, ctx interface{}) error {
+		op.Pop(1)
+		return nil
+	}
+	p.Functions["fail"] = func(fn string, op Operands, ctx interface{}) error {
+		return fmt.Errorf("failed")
+	}
+	var calls []string
+	p.BeforeCall = func(fn string, op Operands, pos Position) {
+		calls = append(calls, fmt.Sprintf("before %v %v %v", fn, pos, op.GetValues()))
+	}
+	p.AfterCall = func(fn string, op Operands, pos Position, err error) {
+		calls = append(calls, fmt.Sprintf("after %v %v %v %v", fn, pos, op.GetValues(), err))
+	}
+	p.Parse(lex)
+	expected := []string{"before ok 1:3 [a]", "after ok 1:3 [] <nil>", "before fail 2:4 [b]", "after fail 2:4 [b] failed"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("hooks recorded %#v instead of %#v", calls, expected)
+	}
+}
+
+func TestParser_ParseContext(t *testing.T) {
+	for _, input := range []string{"a stop b c", "(quote a stop b) w define w c"} {
+		parsing, cancel := context.WithCancel(context.Background())
+		lex := NewLexer(strings.NewReader(input))
+		p := NewParser(t)
+		p.KeepGoing = true
+		p.Functions["stop"] = func(fn string, op Operands, ctx interface{}) error {
+			cancel()
+			return nil
+		}
+		if err := p.ParseContext(parsing, lex); err != context.Canceled {
+			t.Errorf("parsing %q returned %v instead of %v", input, err, context.Canceled)
+		} else if stack := p.Stack(); !reflect.DeepEqual(stack, []interface{}{"a"}) {
+			t.Errorf("parsing %q left %v on the stack instead of [a]", input, stack)
+		}
+		cancel()
+	}
+
+	// Errors collected before cancellation are not lost.
+	parsing, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewParser(t)
+	p.KeepGoing = true
+	p.Functions["fail"] = func(fn string, op Operands, ctx interface{}) error {
+		return fmt.Errorf("failed")
+	}
+	p.Functions["stop"] = func(fn string, op Operands, ctx interface{}) error {
+		cancel()
+		return nil
+	}
+	err := p.ParseContext(parsing, NewLexer(strings.NewReader("fail stop b")))
+	if errs, ok := err.(ParseErrors); !ok || len(errs) != 2 {
+		t.Errorf("parsing returned %v instead of two ParseErrors", err)
+	} else if !errors.Is(err, context.Canceled) {
+		t.Errorf("parsing returned %v, which does not wrap %v", err, context.Canceled)
+	} else if errs[0].Position != (Position{Line: 1, Column: 1}) {
+		t.Errorf("first error is at %v instead of 1:1", errs[0].Position)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package projection simulates future portfolio values.
+package projection
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// Returns describes the assumed annual return of an asset class as
+// a normally distributed fraction (e.g., 0.07 for 7%).
+type Returns struct {
+	Mean       float64
+	Volatility float64
+}
+
+// Flow is a yearly contribution (positive) or withdrawal (negative)
+// made at the end of each year from FirstYear through LastYear, inclusive.
+// Years are numbered from 1.
+type Flow struct {
+	Amount    float64
+	FirstYear int
+	LastYear  int
+}
+
+// Assumptions holds the inputs to a projection.  Returns are keyed by
+// asset class, which is usually a commodity tag.  Asset classes without
+// Returns have zero returns and zero volatility.
+type Assumptions struct {
+	Returns map[string]Returns
+	Flows   []Flow
+}
+
+// Function is a function that can appear in an assumptions file.
+type Function func(string, parser.Operands, *Assumptions) error
+
+func GetAssumptionsFunctions() map[string]Function {
+	return map[string]Function{
+		"contribute": contributeFunction,
+		"returns":    returnsFunction,
+		"withdraw":   withdrawFunction,
+	}
+}
+
+// ParseAssumptions parses an assumptions file written in the ledger language.
+func ParseAssumptions(r io.Reader) (*Assumptions, error) {
+	a := &Assumptions{Returns: map[string]Returns{}}
+	p := parser.NewParser(a)
+	for fn, f := range GetAssumptionsFunctions() {
+		f := f
+		p.Functions[fn] = func(fn string, op parser.Operands, _ interface{}) error {
+			return f(fn, op, a)
+		}
+	}
+	if err := p.Parse(parser.NewLexer(r)); err != nil {
+		return nil, err
+	} else if err = p.Finish(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// parseFloats converts string operands to float64s.  names names
+// the operands for error messages.
+func parseFloats(fn string, values []interface{}, names ...string) ([]float64, error) {
+	floats := make([]float64, len(values))
+	for n, v := range values {
+		if s, ok := v.(string); !ok {
+			return nil, fmt.Errorf("%v: non-string %v: %v", fn, names[n], v)
+		} else if f, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%v: illegal %v %v: %v", fn, names[n], s, err)
+		} else {
+			floats[n] = f
+		}
+	}
+	return floats, nil
+}
+
+func parseFlow(fn string, op parser.Operands, a *Assumptions, sign float64) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: amount, first year, and last year operands required, but too few given", fn)
+	}
+	values, err := parseFloats(fn, op.Pop(3), "amount", "first year", "last year")
+	if err != nil {
+		return err
+	}
+	first, last := int(values[1]), int(values[2])
+	if first < 1 || last < first {
+		return fmt.Errorf("%v: illegal year range %v to %v", fn, first, last)
+	}
+	a.Flows = append(a.Flows, Flow{Amount: sign * values[0], FirstYear: first, LastYear: last})
+	return nil
+}
+
+// contributeFunction adds a yearly contribution.
+//
+// Syntax: AMOUNT FIRST-YEAR LAST-YEAR contribute ->
+func contributeFunction(fn string, op parser.Operands, a *Assumptions) error {
+	return parseFlow(fn, op, a, 1)
+}
+
+// withdrawFunction adds a yearly withdrawal.
+//
+// Syntax: AMOUNT FIRST-YEAR LAST-YEAR withdraw ->
+func withdrawFunction(fn string, op parser.Operands, a *Assumptions) error {
+	return parseFlow(fn, op, a, -1)
+}
+
+// returnsFunction sets an asset class's mean annual return and volatility,
+// both in percent.
+//
+// Syntax: CLASS MEAN VOLATILITY returns ->
+func returnsFunction(fn string, op parser.Operands, a *Assumptions) error {
+	if op.Length() < 3 {
+		return fmt.Errorf("%v: class, mean, and volatility operands required, but too few given", fn)
+	}
+	values := op.Pop(3)
+	class, ok := values[0].(string)
+	if !ok {
+		return fmt.Errorf("%v: non-string class: %v", fn, values[0])
+	}
+	rates, err := parseFloats(fn, values[1:], "mean", "volatility")
+	if err != nil {
+		return err
+	} else if rates[1] < 0 {
+		return fmt.Errorf("%v: negative volatility: %v", fn, rates[1])
+	}
+	a.Returns[class] = Returns{Mean: rates[0] / 100, Volatility: rates[1] / 100}
+	return nil
+}
+
+// YearResult summarizes all trials' portfolio values at the end of a year.
+type YearResult struct {
+	Year int
+
+	// Percentiles maps requested percentiles to portfolio values.
+	Percentiles map[int]float64
+
+	// Depleted is the fraction of trials whose portfolios were exhausted
+	// at or before the end of the year.
+	Depleted float64
+}
+
+// Simulate runs trials simulations of the specified holdings (asset class
+// -> value) over the specified number of years and returns the requested
+// percentiles for each year.  Flows are allocated among asset classes
+// in proportion to their values at the end of each year; a portfolio
+// that drops to zero or below stays depleted.
+func Simulate(holdings map[string]float64, a *Assumptions, years, trials int, percentiles []int, rng *rand.Rand) []YearResult {
+	classes := make([]string, len(holdings))[:0]
+	for class := range holdings {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	totals := make([][]float64, years)
+	for y := range totals {
+		totals[y] = make([]float64, trials)
+	}
+	values := make([]float64, len(classes))
+	for t := 0; t < trials; t++ {
+		for n, class := range classes {
+			values[n] = holdings[class]
+		}
+		depleted := false
+		for y := 1; y <= years; y++ {
+			total := 0.0
+			if !depleted {
+				for n, class := range classes {
+					r := a.Returns[class]
+					values[n] *= 1 + r.Mean + r.Volatility*rng.NormFloat64()
+					total += values[n]
+				}
+				flow := 0.0
+				for _, f := range a.Flows {
+					if y >= f.FirstYear && y <= f.LastYear {
+						flow += f.Amount
+					}
+				}
+				if total > 0 {
+					for n := range values {
+						values[n] += flow * values[n] / total
+					}
+				} else if len(values) != 0 {
+					values[0] += flow
+				}
+				total += flow
+				depleted = total <= 0
+			}
+			if depleted {
+				total = 0
+			}
+			totals[y-1][t] = total
+		}
+	}
+
+	results := make([]YearResult, years)
+	for y, ts := range totals {
+		sort.Float64s(ts)
+		r := YearResult{Year: y + 1, Percentiles: make(map[int]float64, len(percentiles))}
+		for _, p := range percentiles {
+			r.Percentiles[p] = percentile(ts, p)
+		}
+		for _, v := range ts {
+			if v > 0 {
+				break
+			}
+			r.Depleted++
+		}
+		if trials != 0 {
+			r.Depleted /= float64(trials)
+		}
+		results[y] = r
+	}
+	return results
+}
+
+// percentile returns the pth percentile of the sorted values using
+// the nearest-rank method.
+func percentile(sorted []float64, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
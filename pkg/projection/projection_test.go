@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package projection
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseAssumptions(t *testing.T) {
+	a, err := ParseAssumptions(strings.NewReader(`
+		stocks 7 15 returns
+		bonds 3 5 returns
+		10000 1 20 contribute
+		40000 21 50 withdraw`))
+	if err != nil {
+		t.Fatalf("ParseAssumptions failed: %v", err)
+	}
+	if r := a.Returns["stocks"]; r.Mean != 0.07 || r.Volatility != 0.15 {
+		t.Errorf("unexpected stock returns: %+v", r)
+	} else if len(a.Flows) != 2 || a.Flows[0].Amount != 10000 || a.Flows[1].Amount != -40000 || a.Flows[1].FirstYear != 21 {
+		t.Errorf("unexpected flows: %+v", a.Flows)
+	}
+}
+
+func TestParseAssumptions_IllegalOperands(t *testing.T) {
+	for _, program := range []string{`stocks 7 returns`, `stocks x 15 returns`, `stocks 7 -1 returns`, `100 5 1 contribute`, `100 0 1 withdraw`} {
+		if _, err := ParseAssumptions(strings.NewReader(program)); err == nil {
+			t.Errorf("ParseAssumptions accepted %#v", program)
+		}
+	}
+}
+
+func TestSimulate_NoVolatility(t *testing.T) {
+	a := &Assumptions{
+		Returns: map[string]Returns{"stocks": {Mean: 0.1}},
+		Flows:   []Flow{{Amount: 100, FirstYear: 1, LastYear: 1}}}
+	results := Simulate(map[string]float64{"stocks": 1000, "cash": 1000}, a, 2, 10, []int{50}, rand.New(rand.NewSource(1)))
+	if len(results) != 2 {
+		t.Fatalf("Simulate returned %v years instead of 2", len(results))
+	}
+	// year 1: 1100 stocks + 1000 cash + 100 flow = 2200, with the flow split
+	// 1100:1000, so stocks hold 1100 + 52.38...
+	if v := results[0].Percentiles[50]; math.Abs(v-2200) > 1e-9 {
+		t.Errorf("year 1 median is %v instead of 2200", v)
+	}
+	expected := (1100+100*1100.0/2100)*1.1 + 1000 + 100*1000.0/2100
+	if v := results[1].Percentiles[50]; math.Abs(v-expected) > 1e-9 {
+		t.Errorf("year 2 median is %v instead of %v", v, expected)
+	}
+}
+
+func TestSimulate_Depletion(t *testing.T) {
+	a := &Assumptions{Flows: []Flow{{Amount: -600, FirstYear: 1, LastYear: 3}}}
+	results := Simulate(map[string]float64{"cash": 1000}, a, 3, 5, []int{10, 90}, rand.New(rand.NewSource(1)))
+	if results[0].Depleted != 0 || results[0].Percentiles[90] != 400 {
+		t.Errorf("unexpected year 1 result: %+v", results[0])
+	} else if results[1].Depleted != 1 || results[2].Depleted != 1 || results[2].Percentiles[90] != 0 {
+		t.Errorf("portfolio was not depleted in years 2 and 3: %+v", results)
+	}
+}
+
+func TestSimulate_Deterministic(t *testing.T) {
+	a := &Assumptions{Returns: map[string]Returns{"stocks": {Mean: 0.07, Volatility: 0.15}}}
+	h := map[string]float64{"stocks": 1000}
+	r1 := Simulate(h, a, 5, 100, []int{50}, rand.New(rand.NewSource(42)))
+	r2 := Simulate(h, a, 5, 100, []int{50}, rand.New(rand.NewSource(42)))
+	for n := range r1 {
+		if r1[n].Percentiles[50] != r2[n].Percentiles[50] {
+			t.Errorf("simulations with the same seed differ in year %v", n+1)
+		}
+	}
+}
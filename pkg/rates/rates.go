@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package rates implements core.RateProviders that fetch exchange rates
+// from web services.  It is separate from package core so that programs
+// that only use their ledgers' prices do not depend on the network.
+package rates
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// ECBHistoryURL is the URL of the European Central Bank's history of
+// euro foreign exchange reference rates.
+const ECBHistoryURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+
+// ExchangeRateHostURL is the URL of exchangerate.host's API.
+const ExchangeRateHostURL = "https://api.exchangerate.host"
+
+// ECB provides the European Central Bank's euro foreign exchange
+// reference rates, which are published on business days for about
+// thirty currencies.  Rates between two non-euro currencies are computed
+// from their euro rates.  Rates on days without publications are the most
+// recent rates published before them.  ECB downloads the whole history
+// once and keeps it, so it is cheap to query repeatedly.
+type ECB struct {
+	Client *http.Client // nil for http.DefaultClient
+	URL    string       // empty for ECBHistoryURL
+
+	once sync.Once
+	days []ecbDay // in chronological order
+	err  error
+}
+
+// ecbDay is a day's reference rates in units per euro.
+type ecbDay struct {
+	date  core.Date
+	rates map[string]decimal.Decimal
+}
+
+// ecbEnvelope is the ECB's XML document.  Its outer Cube element contains
+// a Cube per day, which contains a Cube per currency.
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+func (e *ECB) load() {
+	client, u := e.Client, e.URL
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(u) == 0 {
+		u = ECBHistoryURL
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		e.err = err
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e.err = fmt.Errorf("%v: %v", u, resp.Status)
+		return
+	}
+	var env ecbEnvelope
+	if err = xml.NewDecoder(resp.Body).Decode(&env); err != nil {
+		e.err = fmt.Errorf("%v: %v", u, err)
+		return
+	}
+	for _, d := range env.Days {
+		day := ecbDay{rates: map[string]decimal.Decimal{"EUR": decimal.New(1, 0)}}
+		if day.date, err = core.ParseDate(d.Time); err != nil {
+			e.err = fmt.Errorf("%v: illegal date: %v", u, d.Time)
+			return
+		}
+		for _, r := range d.Rates {
+			if day.rates[r.Currency], err = decimal.NewFromString(r.Rate); err != nil {
+				e.err = fmt.Errorf("%v: illegal rate for %v on %v: %v", u, r.Currency, d.Time, r.Rate)
+				return
+			}
+		}
+		e.days = append(e.days, day)
+	}
+	sort.Slice(e.days, func(i, j int) bool { return e.days[i].date.Before(e.days[j].date) })
+}
+
+func (e *ECB) GetRate(date core.Date, base, quote string) (decimal.Decimal, error) {
+	e.once.Do(e.load)
+	if e.err != nil {
+		return decimal.Zero, e.err
+	}
+	n := sort.Search(len(e.days), func(n int) bool { return e.days[n].date.After(date) })
+	for n--; n >= 0; n-- {
+		b, bok := e.days[n].rates[base]
+		q, qok := e.days[n].rates[quote]
+		if bok && qok && !b.IsZero() {
+			return q.Div(b), nil
+		}
+	}
+	return decimal.Zero, fmt.Errorf("no ECB rate of %v in %v on or before %v", base, quote, date)
+}
+
+// ExchangeRateHost provides rates from exchangerate.host's historical
+// rates API, which requires an access key.  It makes a request per
+// distinct date, base, and quote and keeps the rates.
+type ExchangeRateHost struct {
+	Client    *http.Client // nil for http.DefaultClient
+	URL       string       // empty for ExchangeRateHostURL
+	AccessKey string
+
+	mu    sync.Mutex
+	cache map[string]decimal.Decimal
+}
+
+// exchangeRateHostResponse is the historical rates API's response.
+type exchangeRateHostResponse struct {
+	Success bool
+	Quotes  map[string]json.Number
+	Error   struct {
+		Info string
+	}
+}
+
+func (h *ExchangeRateHost) GetRate(date core.Date, base, quote string) (decimal.Decimal, error) {
+	if base == quote {
+		return decimal.New(1, 0), nil
+	}
+	key := date.String() + " " + base + quote
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rate, ok := h.cache[key]; ok {
+		return rate, nil
+	}
+	client, u := h.Client, h.URL
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(u) == 0 {
+		u = ExchangeRateHostURL
+	}
+	query := url.Values{"access_key": {h.AccessKey}, "date": {date.String()}, "source": {base}, "currencies": {quote}}
+	resp, err := client.Get(u + "/historical?" + query.Encode())
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return decimal.Zero, fmt.Errorf("%v: %v", u, resp.Status)
+	}
+	var r exchangeRateHostResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return decimal.Zero, fmt.Errorf("%v: %v", u, err)
+	} else if !r.Success {
+		return decimal.Zero, fmt.Errorf("%v: %v", u, r.Error.Info)
+	}
+	n, ok := r.Quotes[base+quote]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%v: no rate of %v in %v on %v", u, base, quote, date)
+	}
+	rate, err := decimal.NewFromString(n.String())
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%v: illegal rate: %v", u, n)
+	}
+	if h.cache == nil {
+		h.cache = map[string]decimal.Decimal{}
+	}
+	h.cache[key] = rate
+	return rate, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package rates
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const ecbHistory = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2024-01-03">
+			<Cube currency="USD" rate="1.0919"/>
+			<Cube currency="JPY" rate="155.52"/>
+		</Cube>
+		<Cube time="2024-01-02">
+			<Cube currency="USD" rate="1.0956"/>
+			<Cube currency="JPY" rate="155.09"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func date(year, month, day int) core.Date {
+	return core.Date{Year: year, Month: month, Day: day}
+}
+
+func TestECB(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, ecbHistory)
+	}))
+	defer server.Close()
+	var p core.RateProvider = &ECB{URL: server.URL}
+	for _, test := range []struct {
+		date        core.Date
+		base, quote string
+		rate        string
+	}{
+		{date(2024, 1, 2), "EUR", "USD", "1.0956"},
+		{date(2024, 1, 3), "EUR", "JPY", "155.52"},
+		{date(2024, 1, 6), "EUR", "USD", "1.0919"},
+		{date(2024, 1, 2), "USD", "EUR", "0.9127418765972983"},
+		{date(2024, 1, 3), "USD", "JPY", "142.4306255151570657"},
+	} {
+		if rate, err := p.GetRate(test.date, test.base, test.quote); err != nil {
+			t.Errorf("GetRate(%v, %v, %v) failed: %v", test.date, test.base, test.quote, err)
+		} else if rate.String() != test.rate {
+			t.Errorf("GetRate(%v, %v, %v) returned %v instead of %v", test.date, test.base, test.quote, rate, test.rate)
+		}
+	}
+	if _, err := p.GetRate(date(2024, 1, 1), "EUR", "USD"); err == nil {
+		t.Errorf("GetRate succeeded before the first rate")
+	} else if _, err := p.GetRate(date(2024, 1, 3), "EUR", "GBP"); err == nil {
+		t.Errorf("GetRate succeeded for an unknown currency")
+	} else if requests != 1 {
+		t.Errorf("ECB downloaded the history %v times instead of once", requests)
+	}
+}
+
+func TestExchangeRateHost(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		q := r.URL.Query()
+		if r.URL.Path != "/historical" || q.Get("access_key") != "key" {
+			fmt.Fprint(w, `{"success": false, "error": {"info": "invalid access key"}}`)
+		} else if q.Get("date") == "2024-01-02" && q.Get("source") == "USD" && q.Get("currencies") == "EUR" {
+			fmt.Fprint(w, `{"success": true, "quotes": {"USDEUR": 0.912742}}`)
+		} else {
+			fmt.Fprint(w, `{"success": true, "quotes": {}}`)
+		}
+	}))
+	defer server.Close()
+	p := &ExchangeRateHost{URL: server.URL, AccessKey: "key"}
+	for n := 0; n < 2; n++ {
+		if rate, err := p.GetRate(date(2024, 1, 2), "USD", "EUR"); err != nil {
+			t.Fatalf("GetRate failed: %v", err)
+		} else if rate.String() != "0.912742" {
+			t.Errorf("GetRate returned %v instead of 0.912742", rate)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("ExchangeRateHost made %v requests instead of caching the rate", requests)
+	} else if _, err := p.GetRate(date(2024, 1, 2), "USD", "GBP"); err == nil {
+		t.Errorf("GetRate succeeded without a quote")
+	} else if _, err := (&ExchangeRateHost{URL: server.URL}).GetRate(date(2024, 1, 2), "USD", "EUR"); err == nil {
+		t.Errorf("GetRate succeeded without an access key")
+	}
+}
+
+func TestRateProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ecbHistory)
+	}))
+	defer server.Close()
+	usd := core.NewCommodity("USD", "Dollar", date(2024, 1, 1))
+	eur := core.NewCommodity("EUR", "Euro", date(2024, 1, 1))
+	jpy := core.NewCommodity("JPY", "Yen", date(2024, 1, 1))
+	db := core.NewPriceDB()
+	db.Add(core.Price{Date: date(2024, 1, 2), Commodity: eur, Price: core.Quantity{Commodity: usd, Amount: decimalOf(t, "1.1")}})
+	p := core.RateProviders{db, &ECB{URL: server.URL}}
+	if q, err := core.ConvertWith(p, core.Quantity{Commodity: eur, Amount: decimalOf(t, "10")}, usd, date(2024, 1, 3)); err != nil {
+		t.Errorf("ConvertWith failed: %v", err)
+	} else if q.String() != "11 USD" {
+		t.Errorf("ConvertWith did not prefer the ledger's price: %v", q)
+	}
+	if q, err := core.ConvertWith(p, core.Quantity{Commodity: eur, Amount: decimalOf(t, "10")}, jpy, date(2024, 1, 3)); err != nil {
+		t.Errorf("ConvertWith failed: %v", err)
+	} else if q.String() != "1555.2 JPY" {
+		t.Errorf("ConvertWith did not fall back to the ECB's rate: %v", q)
+	}
+	if _, err := core.ConvertWith(p, core.Quantity{Commodity: usd, Amount: decimalOf(t, "10")}, jpy, date(2023, 1, 1)); err == nil {
+		t.Errorf("ConvertWith succeeded without rates")
+	}
+}
+
+func decimalOf(t *testing.T, s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
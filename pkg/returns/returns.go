@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package returns computes investment returns.
+package returns
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"math"
+)
+
+// Period is an interval without cash flows, described by the portfolio's
+// values at its start and end.
+type Period struct {
+	Start float64
+	End   float64
+}
+
+// TimeWeighted returns the time-weighted return over consecutive Periods,
+// which is the product of the Periods' growth factors minus one.  Periods
+// that start with zero value are skipped because they have no return.
+func TimeWeighted(periods []Period) (float64, error) {
+	growth := 1.0
+	for _, p := range periods {
+		if p.Start == 0 {
+			continue
+		} else if p.Start < 0 {
+			return 0, fmt.Errorf("negative portfolio value %v", p.Start)
+		}
+		growth *= p.End / p.Start
+	}
+	return growth - 1, nil
+}
+
+// Flow is a cash flow into (positive) or out of (negative) a portfolio.
+type Flow struct {
+	Date   core.Date
+	Amount float64
+}
+
+// yearsBetween returns the number of 365-day years between two dates.
+func yearsBetween(from, to core.Date) float64 {
+	return float64(core.DaysBetween(from, to)) / 365
+}
+
+// MoneyWeighted returns the annualized money-weighted return (the internal
+// rate of return) of a portfolio worth startValue on start and endValue
+// on end with the specified Flows in between.
+func MoneyWeighted(start core.Date, startValue float64, flows []Flow, end core.Date, endValue float64) (float64, error) {
+	total := yearsBetween(start, end)
+	if total <= 0 {
+		return 0, fmt.Errorf("the end date %v is not after the start date %v", end, start)
+	}
+	// f is the portfolio's ending value at rate r minus its actual
+	// ending value.  It increases with r when contributions dominate.
+	f := func(r float64) float64 {
+		v := startValue*math.Pow(1+r, total) - endValue
+		for _, flow := range flows {
+			v += flow.Amount * math.Pow(1+r, yearsBetween(flow.Date, end))
+		}
+		return v
+	}
+	lo, hi := -0.9999, 1.0
+	for f(hi)*f(lo) > 0 {
+		if hi > 1e6 {
+			return 0, fmt.Errorf("the money-weighted return does not exist")
+		}
+		hi *= 2
+	}
+	for n := 0; n < 200 && hi-lo > 1e-12; n++ {
+		mid := (lo + hi) / 2
+		if f(mid)*f(lo) > 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package returns
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"math"
+	"testing"
+)
+
+func TestTimeWeighted(t *testing.T) {
+	r, err := TimeWeighted([]Period{{0, 100}, {100, 110}, {210, 189}})
+	if err != nil {
+		t.Fatalf("TimeWeighted failed: %v", err)
+	} else if math.Abs(r-(-0.01)) > 1e-9 {
+		t.Errorf("TimeWeighted returned %v instead of -0.01", r)
+	}
+}
+
+func TestMoneyWeighted(t *testing.T) {
+	start := core.Date{Year: 2020, Month: 1, Day: 1}
+	end := core.Date{Year: 2021, Month: 1, Day: 1}
+	r, err := MoneyWeighted(start, 1000, nil, end, 1100)
+	if err != nil {
+		t.Fatalf("MoneyWeighted failed: %v", err)
+	}
+	// 2020 has 366 days, so the annualized return is slightly below 10%.
+	if expected := math.Pow(1.1, 365.0/366) - 1; math.Abs(r-expected) > 1e-6 {
+		t.Errorf("MoneyWeighted returned %v instead of %v", r, expected)
+	}
+}
+
+func TestMoneyWeighted_Flows(t *testing.T) {
+	start := core.Date{Year: 2021, Month: 1, Day: 1}
+	mid := core.Date{Year: 2021, Month: 7, Day: 2}
+	end := core.Date{Year: 2022, Month: 1, Day: 1}
+	r, err := MoneyWeighted(start, 0, []Flow{{start, 1000}, {mid, -500}}, end, 600)
+	if err != nil {
+		t.Fatalf("MoneyWeighted failed: %v", err)
+	}
+	v := 1000*math.Pow(1+r, 1) - 500*math.Pow(1+r, yearsBetween(mid, end))
+	if math.Abs(v-600) > 1e-6 || r <= 0 {
+		t.Errorf("MoneyWeighted returned %v, which yields %v instead of 600", r, v)
+	}
+}
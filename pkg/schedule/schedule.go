@@ -0,0 +1,428 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package schedule generates recurring transactions from schedule files.
+package schedule
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Shift determines how occurrences that fall on non-business days move.
+type Shift int
+
+const (
+	// NoShift leaves occurrences on non-business days.
+	NoShift Shift = iota
+
+	// NextBusinessDay moves occurrences to the following business day.
+	NextBusinessDay
+
+	// PreviousBusinessDay moves occurrences to the preceding business day.
+	PreviousBusinessDay
+)
+
+// Unit is a unit of time between occurrences.
+type Unit int
+
+const (
+	Days Unit = iota
+	Weeks
+	Months
+	Years
+)
+
+// Recurrence is a transaction that occurs every Interval Units starting
+// on Start and ending on or before End, if End is not zero.  The Entry's
+// Date is ignored.
+type Recurrence struct {
+	importer.Entry
+	Start    core.Date
+	End      core.Date
+	Interval int
+	Unit     Unit
+	Shift    Shift
+}
+
+// Schedule is a set of Recurrences and the calendar of non-business days
+// on which they avoid occurring.
+type Schedule struct {
+	Recurrences []Recurrence
+
+	// Weekend holds the days of the week that are not business days.
+	Weekend map[time.Weekday]bool
+
+	// Holidays holds dates that are not business days.
+	Holidays map[core.Date]bool
+
+	// AnnualHolidays holds (month, day) pairs that are not business days
+	// in any year.
+	AnnualHolidays map[[2]int]bool
+}
+
+// NewSchedule creates an empty Schedule whose weekend is Saturday
+// and Sunday.
+func NewSchedule() *Schedule {
+	return &Schedule{
+		Weekend:        map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		Holidays:       map[core.Date]bool{},
+		AnnualHolidays: map[[2]int]bool{}}
+}
+
+// IsBusinessDay returns true if d is neither a weekend day nor a holiday.
+func (s *Schedule) IsBusinessDay(d core.Date) bool {
+	return !s.Weekend[d.Weekday()] && !s.Holidays[d] && !s.AnnualHolidays[[2]int{d.Month, d.Day}]
+}
+
+// ShiftDate moves d according to shift.  It returns an error if there
+// is no business day within a year of d.
+func (s *Schedule) ShiftDate(d core.Date, shift Shift) (core.Date, error) {
+	step := 1
+	switch shift {
+	case NoShift:
+		return d, nil
+	case PreviousBusinessDay:
+		step = -1
+	}
+	for n := 0; n < 366; n++ {
+		if date := d.AddDays(n * step); s.IsBusinessDay(date) {
+			return date, nil
+		}
+	}
+	return d, fmt.Errorf("no business day within a year of %v", d)
+}
+
+// nth returns the nth occurrence of r before shifting.  Monthly and yearly
+// occurrences that would fall on days beyond the ends of their months
+// fall on the last days instead.
+func (r Recurrence) nth(n int) core.Date {
+	switch r.Unit {
+	case Days:
+		return r.Start.AddDays(n * r.Interval)
+	case Weeks:
+		return r.Start.AddDays(7 * n * r.Interval)
+	case Years:
+		return r.Start.AddYears(n * r.Interval)
+	}
+	return r.Start.AddMonths(n * r.Interval)
+}
+
+// Entries returns the occurrences of the Schedule's Recurrences whose
+// shifted dates fall between from and to, inclusive.
+func (s *Schedule) Entries(from, to core.Date) ([]importer.Entry, error) {
+	var entries []importer.Entry
+	for _, r := range s.Recurrences {
+		for n := 0; ; n++ {
+			nominal := r.nth(n)
+			if !r.End.IsZero() && nominal.After(r.End) {
+				break
+			}
+			date, err := s.ShiftDate(nominal, r.Shift)
+			if err != nil {
+				return nil, err
+			} else if date.After(to) && nominal.After(to) {
+				break
+			} else if date.Before(from) || date.After(to) {
+				continue
+			}
+			e := r.Entry
+			e.Date = date
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Function is a function that can appear in a schedule file.
+type Function func(string, parser.Operands, *Schedule) error
+
+// GetFunctions returns the functions available in schedule files.
+// Recurrences look like transactions:
+//
+//	(ENTITY DESCRIPTION
+//		ACCOUNT AMOUNT COMMODITY posting
+//		ACCOUNT AMOUNT COMMODITY posting
+//		YEAR MONTH DAY starting
+//		N (days|weeks|months|years) every
+//		next-business-day shift
+//		NOTE-NAME NOTE-VALUE
+//		recur)
+//
+// "until" optionally sets the last date, "COMMENT set-comment" sets
+// the preceding posting's comment, and "shift" accepts next-business-day,
+// previous-business-day, and none.  As in ledgers, "STRING comment"
+// discards a string.
+func GetFunctions() map[string]Function {
+	return map[string]Function{
+		"annual-holiday": annualHolidayFunction,
+		"comment":        commentFunction,
+		"every":          everyFunction,
+		"holiday":        holidayFunction,
+		"posting":        postingFunction,
+		"recur":          recurFunction,
+		"set-comment":    setCommentFunction,
+		"shift":          shiftFunction,
+		"starting":       startingFunction,
+		"until":          untilFunction,
+		"weekend":        weekendFunction,
+	}
+}
+
+// Parse parses a schedule file into s.  Holiday files use the same syntax,
+// so a Schedule may be built from several files.
+func (s *Schedule) Parse(r io.Reader) error {
+	p := parser.NewParser(s)
+	for fn, f := range GetFunctions() {
+		f := f
+		p.Functions[fn] = func(fn string, op parser.Operands, _ interface{}) error {
+			return f(fn, op, s)
+		}
+	}
+	if err := p.Parse(parser.NewLexer(r)); err != nil {
+		return err
+	}
+	return p.Finish()
+}
+
+// option types are pushed onto the operand stack and consumed by recur.
+type (
+	startingOption core.Date
+	untilOption    core.Date
+	everyOption    struct {
+		Interval int
+		Unit     Unit
+	}
+	shiftOption Shift
+)
+
+func popInts(fn string, op parser.Operands, names ...string) ([]int, error) {
+	strs, err := functions.PopStrings(fn, op, names...)
+	if err != nil {
+		return nil, err
+	}
+	ints := make([]int, len(strs))
+	for n, s := range strs {
+		if ints[n], err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("%v: illegal %v %v", fn, names[n], s)
+		}
+	}
+	return ints, nil
+}
+
+func popDate(fn string, op parser.Operands) (core.Date, error) {
+	ints, err := popInts(fn, op, "year", "month", "day")
+	if err != nil {
+		return core.Date{}, err
+	}
+	d, err := core.NewDate(ints[0], ints[1], ints[2])
+	if err != nil {
+		return d, fmt.Errorf("%v: %v", fn, err)
+	}
+	return d, nil
+}
+
+// Syntax: MONTH DAY annual-holiday ->
+func annualHolidayFunction(fn string, op parser.Operands, s *Schedule) error {
+	ints, err := popInts(fn, op, "month", "day")
+	if err == nil {
+		s.AnnualHolidays[[2]int{ints[0], ints[1]}] = true
+	}
+	return err
+}
+
+// Syntax: STRING comment ->
+func commentFunction(fn string, op parser.Operands, s *Schedule) error {
+	_, err := functions.PopStrings(fn, op, "comment")
+	return err
+}
+
+// Syntax: Posting COMMENT set-comment -> Posting
+func setCommentFunction(fn string, op parser.Operands, s *Schedule) error {
+	if op.Length() < 2 {
+		return fmt.Errorf("%v: posting and comment operands are required, but too few given", fn)
+	}
+	values := op.Pop(2)
+	p, ok := values[0].(importer.Posting)
+	if !ok {
+		return fmt.Errorf("%v: non-posting operand: %v", fn, values[0])
+	} else if p.Comment, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string comment: %v", fn, values[1])
+	}
+	op.Push(p)
+	return nil
+}
+
+// Syntax: N UNIT every -> Option
+func everyFunction(fn string, op parser.Operands, s *Schedule) error {
+	values, err := functions.PopStrings(fn, op, "interval", "unit")
+	if err != nil {
+		return err
+	}
+	interval, err := strconv.Atoi(values[0])
+	if err != nil || interval < 1 {
+		return fmt.Errorf("%v: illegal interval %v", fn, values[0])
+	}
+	units := map[string]Unit{"day": Days, "days": Days, "week": Weeks, "weeks": Weeks, "month": Months, "months": Months, "year": Years, "years": Years}
+	unit, ok := units[values[1]]
+	if !ok {
+		return fmt.Errorf("%v: unknown unit %v", fn, values[1])
+	}
+	op.Push(everyOption{Interval: interval, Unit: unit})
+	return nil
+}
+
+// Syntax: YEAR MONTH DAY holiday ->
+func holidayFunction(fn string, op parser.Operands, s *Schedule) error {
+	d, err := popDate(fn, op)
+	if err == nil {
+		s.Holidays[d] = true
+	}
+	return err
+}
+
+// Syntax: ACCOUNT AMOUNT COMMODITY posting -> Posting
+func postingFunction(fn string, op parser.Operands, s *Schedule) error {
+	values, err := functions.PopStrings(fn, op, "account", "amount", "commodity")
+	if err != nil {
+		return err
+	}
+	amount, err := functions.ParseDecimal(values[1])
+	if err != nil {
+		return fmt.Errorf("%v: illegal amount %v: %v", fn, values[1], err)
+	}
+	op.Push(importer.Posting{Account: values[0], Amount: amount, Commodity: values[2]})
+	return nil
+}
+
+// Syntax: ENTITY DESCRIPTION Posting+ Option* (NOTE-NAME NOTE-VALUE)* recur ->
+func recurFunction(fn string, op parser.Operands, s *Schedule) error {
+	values := op.Pop(op.Length())
+	if len(values) < 2 {
+		return fmt.Errorf("%v: entity and description operands are required", fn)
+	}
+	r := Recurrence{Interval: 1, Unit: Months}
+	var ok bool
+	if r.Entity, ok = values[0].(string); !ok {
+		return fmt.Errorf("%v: non-string entity: %v", fn, values[0])
+	} else if r.Description, ok = values[1].(string); !ok {
+		return fmt.Errorf("%v: non-string description: %v", fn, values[1])
+	}
+	var notes []string
+	for _, v := range values[2:] {
+		switch o := v.(type) {
+		case importer.Posting:
+			r.Postings = append(r.Postings, o)
+		case startingOption:
+			r.Start = core.Date(o)
+		case untilOption:
+			r.End = core.Date(o)
+		case everyOption:
+			r.Interval, r.Unit = o.Interval, o.Unit
+		case shiftOption:
+			r.Shift = Shift(o)
+		case string:
+			notes = append(notes, o)
+		default:
+			return fmt.Errorf("%v: unexpected operand: %v", fn, v)
+		}
+	}
+	if len(r.Postings) < 2 {
+		return fmt.Errorf("%v: there must be at least two postings", fn)
+	} else if r.Start.IsZero() {
+		return fmt.Errorf("%v: no starting date", fn)
+	} else if len(notes)%2 != 0 {
+		return fmt.Errorf("%v: the number of notes must be a multiple of two, got %v", fn, len(notes))
+	}
+	for n := 0; n < len(notes); n += 2 {
+		r.Notes = append(r.Notes, [2]string{notes[n], notes[n+1]})
+	}
+	s.Recurrences = append(s.Recurrences, r)
+	return nil
+}
+
+// Syntax: (next-business-day|previous-business-day|none) shift -> Option
+func shiftFunction(fn string, op parser.Operands, s *Schedule) error {
+	values, err := functions.PopStrings(fn, op, "shift rule")
+	if err != nil {
+		return err
+	}
+	switch values[0] {
+	case "next-business-day":
+		op.Push(shiftOption(NextBusinessDay))
+	case "previous-business-day":
+		op.Push(shiftOption(PreviousBusinessDay))
+	case "none":
+		op.Push(shiftOption(NoShift))
+	default:
+		return fmt.Errorf("%v: unknown shift rule %v", fn, values[0])
+	}
+	return nil
+}
+
+// Syntax: YEAR MONTH DAY starting -> Option
+func startingFunction(fn string, op parser.Operands, s *Schedule) error {
+	d, err := popDate(fn, op)
+	if err == nil {
+		op.Push(startingOption(d))
+	}
+	return err
+}
+
+// Syntax: YEAR MONTH DAY until -> Option
+func untilFunction(fn string, op parser.Operands, s *Schedule) error {
+	d, err := popDate(fn, op)
+	if err == nil {
+		op.Push(untilOption(d))
+	}
+	return err
+}
+
+// Syntax: WEEKDAY* weekend ->
+//
+// weekend replaces the Schedule's weekend days.
+func weekendFunction(fn string, op parser.Operands, s *Schedule) error {
+	weekend := map[time.Weekday]bool{}
+	for _, v := range op.Pop(op.Length()) {
+		name, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v: non-string weekday: %v", fn, v)
+		}
+		wd, err := core.ParseWeekday(name)
+		if err != nil {
+			return fmt.Errorf("%v: %v", fn, err)
+		}
+		weekend[wd] = true
+	}
+	s.Weekend = weekend
+	return nil
+}
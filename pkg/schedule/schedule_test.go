@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package schedule
+
+import (
+	"bytes"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/importer"
+	"strings"
+	"testing"
+)
+
+const testSchedule = `
+	12 25 annual-holiday
+	2021 5 31 holiday
+	(Landlord Rent
+		Assets:Checking -900 USD posting
+		Expenses:Rent 900 USD posting "May" set-comment
+		2021 1 31 starting
+		1 month every
+		previous-business-day shift
+		period monthly
+		recur)
+	(Employer Paycheck
+		Assets:Checking 1,000 USD posting
+		Income:Salary -1,000 USD posting
+		2021 12 10 starting
+		2 weeks every
+		2022 1 7 until
+		next-business-day shift
+		recur)`
+
+func parseTestSchedule(t *testing.T) *Schedule {
+	s := NewSchedule()
+	if err := s.Parse(strings.NewReader(testSchedule)); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return s
+}
+
+func TestSchedule_Parse(t *testing.T) {
+	s := parseTestSchedule(t)
+	if len(s.Recurrences) != 2 {
+		t.Fatalf("Parse returned %v recurrences instead of 2", len(s.Recurrences))
+	}
+	r := s.Recurrences[0]
+	if r.Entity != "Landlord" || r.Unit != Months || r.Interval != 1 || r.Shift != PreviousBusinessDay || len(r.Notes) != 1 {
+		t.Errorf("unexpected recurrence: %+v", r)
+	} else if r.Postings[1].Comment != "May" {
+		t.Errorf("set-comment did not set the posting's comment: %+v", r.Postings[1])
+	}
+}
+
+func TestSchedule_Entries(t *testing.T) {
+	s := parseTestSchedule(t)
+	entries, err := s.Entries(core.Date{Year: 2021, Month: 4, Day: 1}, core.Date{Year: 2021, Month: 7, Day: 31})
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	// April 30 is a Friday; May 31 is a holiday; June 30 is a Wednesday;
+	// July 31 is a Saturday.
+	expected := []core.Date{{Year: 2021, Month: 4, Day: 30}, {Year: 2021, Month: 5, Day: 28}, {Year: 2021, Month: 6, Day: 30}, {Year: 2021, Month: 7, Day: 30}}
+	if len(entries) != len(expected) {
+		t.Fatalf("Entries returned %v entries instead of %v", len(entries), len(expected))
+	}
+	for n, e := range entries {
+		if !e.Date.Equal(expected[n]) {
+			t.Errorf("entry %v is on %v instead of %v", n, e.Date, expected[n])
+		}
+	}
+}
+
+func TestSchedule_Entries_NextBusinessDayAndUntil(t *testing.T) {
+	s := parseTestSchedule(t)
+	s.Recurrences = s.Recurrences[1:]
+	entries, err := s.Entries(core.Date{Year: 2021, Month: 12, Day: 1}, core.Date{Year: 2022, Month: 12, Day: 31})
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := importer.WriteEntries(&buf, entries); err != nil {
+		t.Fatalf("WriteEntries failed: %v", err)
+	}
+	// December 24 falls before the December 25 holiday, so it stays.
+	if out := buf.String(); !strings.Contains(out, "2021 12 10 date") || !strings.Contains(out, "2021 12 24 date") || !strings.Contains(out, "2022 1 7 date") || strings.Count(out, "xact") != 3 {
+		t.Errorf("unexpected entries:\n%v", out)
+	}
+}
+
+func TestSchedule_ShiftDate(t *testing.T) {
+	s := parseTestSchedule(t)
+	christmas := core.Date{Year: 2022, Month: 12, Day: 25} // a Sunday
+	if d, _ := s.ShiftDate(christmas, NextBusinessDay); !d.Equal(core.Date{Year: 2022, Month: 12, Day: 26}) {
+		t.Errorf("next business day after %v is %v", christmas, d)
+	} else if d, _ := s.ShiftDate(christmas, PreviousBusinessDay); !d.Equal(core.Date{Year: 2022, Month: 12, Day: 23}) {
+		t.Errorf("previous business day before %v is %v", christmas, d)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package search indexes the entries of Contexts' journals so that
+// long-running processes such as servers can search large histories
+// without scanning them.  See core.Options.KeepJournal.
+//
+// Indices are inverted indices: they map each term to the entries
+// containing it.  Terms are the lowercase runs of letters and digits
+// in entries' entities, descriptions, notes (names and values), and
+// postings' comments.
+package search
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Index is an inverted index of journal entries.  Indices are not safe
+// for concurrent use while entries are added.
+type Index struct {
+	entries []core.JournalEntry
+	terms   map[string][]int // term -> ascending indices into entries
+}
+
+// NewIndex returns an Index of the specified journal entries.
+func NewIndex(journal []core.JournalEntry) *Index {
+	ix := &Index{terms: make(map[string][]int)}
+	for _, e := range journal {
+		ix.Add(e)
+	}
+	return ix
+}
+
+// Terms returns the terms in s in order, including duplicates.
+func Terms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add adds an entry to the Index.
+func (ix *Index) Add(e core.JournalEntry) {
+	n := len(ix.entries)
+	ix.entries = append(ix.entries, e)
+	texts := []string{e.Entity, e.Description}
+	for nn, nv := range e.Notes {
+		texts = append(texts, nn, nv)
+	}
+	for _, p := range e.Postings {
+		texts = append(texts, p.Comment)
+	}
+	for _, text := range texts {
+		for _, term := range Terms(text) {
+			if indices := ix.terms[term]; len(indices) == 0 || indices[len(indices)-1] != n {
+				ix.terms[term] = append(indices, n)
+			}
+		}
+	}
+}
+
+// Len returns the number of entries in the Index.
+func (ix *Index) Len() int { return len(ix.entries) }
+
+// Search returns the entries containing all of the terms in query
+// in the order in which they were added.  It returns nothing if query
+// has no terms.
+func (ix *Index) Search(query string) []core.JournalEntry {
+	var lists [][]int
+	for _, term := range Terms(query) {
+		indices, ok := ix.terms[term]
+		if !ok {
+			return nil
+		}
+		lists = append(lists, indices)
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	// Intersecting the shortest lists first keeps intermediate results
+	// small.
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	matches := lists[0]
+	for _, indices := range lists[1:] {
+		matches = intersect(matches, indices)
+		if len(matches) == 0 {
+			return nil
+		}
+	}
+	entries := make([]core.JournalEntry, len(matches))
+	for n, index := range matches {
+		entries[n] = ix.entries[index]
+	}
+	return entries
+}
+
+// intersect returns the indices in both of the ascending slices a and b.
+func intersect(a, b []int) []int {
+	var c []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			c = append(c, a[i])
+			i++
+			j++
+		}
+	}
+	return c
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package search
+
+import (
+	"github.com/jtvaughan/freebean/pkg/testsupport"
+	"reflect"
+	"testing"
+)
+
+const ledger = `
+	2000 1 1 date
+	journal true pragma
+	USD Dollar commodity
+	Assets:Checking open
+	Expenses:Food open
+	Expenses:Rent open
+	(Grocer "Weekly groceries"
+		Assets:Checking -50 USD xfer
+		Expenses:Food 50 USD xfer "organic produce" set-comment
+		xact)
+	(Landlord "January rent" Assets:Checking -900 USD xfer Expenses:Rent 900 USD xfer xact)
+	2000 1 8 date
+	(Grocer "Weekly groceries"
+		Assets:Checking -40 USD xfer
+		Expenses:Food 40 USD xfer
+		receipt scan-0108.pdf
+		xact)`
+
+func TestIndex(t *testing.T) {
+	ix := NewIndex(testsupport.Parse(t, ledger).Journal)
+	if ix.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %v", ix.Len())
+	}
+	for _, test := range []struct {
+		query    string
+		expected []string
+	}{
+		{"grocer", []string{"Weekly groceries", "Weekly groceries"}},
+		{"WEEKLY Grocer", []string{"Weekly groceries", "Weekly groceries"}},
+		{"organic", []string{"Weekly groceries"}},
+		{"receipt 0108", []string{"Weekly groceries"}},
+		{"rent", []string{"January rent"}},
+		{"rent grocer", nil},
+		{"missing", nil},
+		{" ,; ", nil},
+	} {
+		var descriptions []string
+		for _, e := range ix.Search(test.query) {
+			descriptions = append(descriptions, e.Description)
+		}
+		if !reflect.DeepEqual(descriptions, test.expected) {
+			t.Errorf("search for %#v returned %v instead of %v", test.query, descriptions, test.expected)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	if terms := Terms("Scan-0108.PDF, café"); !reflect.DeepEqual(terms, []string{"scan", "0108", "pdf", "café"}) {
+		t.Errorf("unexpected terms: %v", terms)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package settle computes who owes whom for transfers shared among people.
+package settle
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// Balances maps people's names to commodity names to net amounts.
+// Positive amounts are owed to people and negative amounts are owed
+// by them.  All people's amounts in a commodity sum to zero.
+type Balances map[string]map[string]decimal.Decimal
+
+func (b Balances) add(person, commodityName string, amount decimal.Decimal) {
+	ctoa, ok := b[person]
+	if !ok {
+		ctoa = map[string]decimal.Decimal{}
+		b[person] = ctoa
+	}
+	ctoa[commodityName] = ctoa[commodityName].Add(amount)
+}
+
+// AddTransfer adds a Transfer's shares to b.  The Transfer's payer is
+// owed the Transfer's quantity and each person sharing the Transfer owes
+// their share.  Transfers without shares do not affect b.
+func (b Balances) AddTransfer(t *functions.Transfer) error {
+	if len(t.Shares) == 0 {
+		return nil
+	} else if len(t.Payer) == 0 {
+		return fmt.Errorf("shared transfer to %v has no payer", t.Account.Name)
+	}
+	cn := t.Quantity.Commodity.Name
+	amounts := Split(t.Quantity.Amount, t.Shares)
+	b.add(t.Payer, cn, t.Quantity.Amount)
+	for n, s := range t.Shares {
+		b.add(s.Person, cn, amounts[n].Neg())
+	}
+	return nil
+}
+
+// AddTransaction adds the shares of a Transaction's transfers to b.
+func (b Balances) AddTransaction(xact *functions.Transaction) error {
+	for _, t := range xact.Transfers {
+		if err := b.AddTransfer(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split splits an amount among shares in proportion to their weights.
+// Amounts are rounded to the amount's precision (but at least cents)
+// and the last share absorbs the rounding error, so the amounts always
+// sum to the original amount.
+func Split(amount decimal.Decimal, shares []functions.Share) []decimal.Decimal {
+	total := decimal.Zero
+	for _, s := range shares {
+		total = total.Add(s.Weight)
+	}
+	places := -amount.Exponent()
+	if places < 2 {
+		places = 2
+	}
+	amounts := make([]decimal.Decimal, len(shares))
+	remaining := amount
+	for n, s := range shares {
+		if n == len(shares)-1 {
+			amounts[n] = remaining
+		} else {
+			amounts[n] = amount.Mul(s.Weight).DivRound(total, places)
+			remaining = remaining.Sub(amounts[n])
+		}
+	}
+	return amounts
+}
+
+// Payment is a payment that settles part of a debt.
+type Payment struct {
+	From      string
+	To        string
+	Amount    decimal.Decimal
+	Commodity string
+}
+
+// People returns the names of the people in b, sorted.
+func (b Balances) People() []string {
+	people := make([]string, len(b))[:0]
+	for person := range b {
+		people = append(people, person)
+	}
+	sort.Strings(people)
+	return people
+}
+
+// Payments returns payments that settle all of b's debts.  Within each
+// commodity, the largest debtor repeatedly pays the largest creditor,
+// so there are fewer payments than people with nonzero balances.
+// Payments are sorted by commodity name and then by the order in which
+// they were computed.
+func (b Balances) Payments() []Payment {
+	type party struct {
+		person string
+		amount decimal.Decimal
+	}
+	commodities := map[string]bool{}
+	for _, ctoa := range b {
+		for cn := range ctoa {
+			commodities[cn] = true
+		}
+	}
+	names := make([]string, len(commodities))[:0]
+	for cn := range commodities {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+
+	var payments []Payment
+	for _, cn := range names {
+		var debtors, creditors []*party
+		for _, person := range b.People() {
+			a := b[person][cn]
+			if a.IsNegative() {
+				debtors = append(debtors, &party{person, a.Neg()})
+			} else if a.IsPositive() {
+				creditors = append(creditors, &party{person, a})
+			}
+		}
+		for len(debtors) != 0 && len(creditors) != 0 {
+			for _, parties := range [][]*party{debtors, creditors} {
+				sort.SliceStable(parties, func(i, j int) bool { return parties[i].amount.GreaterThan(parties[j].amount) })
+			}
+			d, c := debtors[0], creditors[0]
+			amount := decimal.Min(d.amount, c.amount)
+			payments = append(payments, Payment{From: d.person, To: c.person, Amount: amount, Commodity: cn})
+			if d.amount = d.amount.Sub(amount); d.amount.IsZero() {
+				debtors = debtors[1:]
+			}
+			if c.amount = c.amount.Sub(amount); c.amount.IsZero() {
+				creditors = creditors[1:]
+			}
+		}
+	}
+	return payments
+}
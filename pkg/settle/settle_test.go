@@ -0,0 +1,124 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package settle
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+const testLedger = `
+	2021 1 1 date
+	USD Dollar commodity
+	Assets:Checking open
+	Expenses:Rent open
+	Expenses:Food open
+	(Landlord Rent
+		Expenses:Rent 900 USD xfer alice paid-by alice 1 bob 1 carol 1 share
+		Assets:Checking -900 USD xfer
+		xact)
+	(Grocer Food
+		Expenses:Food 100 USD xfer bob paid-by alice 1 bob 3 share
+		Assets:Checking -100 USD xfer
+		xact)`
+
+func parseBalances(t *testing.T, ledger string) Balances {
+	p := functions.NewParser(strings.NewReader(ledger))
+	p.AddCoreFunctions()
+	b := Balances{}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = b.AddTransaction(&xact)
+		}
+		return err
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return b
+}
+
+func TestBalances_AddTransaction(t *testing.T) {
+	b := parseBalances(t, testLedger)
+	expected := map[string]string{"alice": "575", "bob": "-275", "carol": "-300"}
+	for person, amount := range expected {
+		if a := b[person]["USD"].String(); a != amount {
+			t.Errorf("%v's balance is %v instead of %v", person, a, amount)
+		}
+	}
+}
+
+func TestBalances_AddTransaction_NoPayer(t *testing.T) {
+	p := functions.NewParser(strings.NewReader(`
+		USD Dollar commodity
+		Expenses:Rent open
+		Assets:Checking open
+		(Landlord Rent
+			Expenses:Rent 900 USD xfer alice 1 bob 1 share
+			Assets:Checking -900 USD xfer
+			xact)`))
+	p.AddCoreFunctions()
+	b := Balances{}
+	p.Functions["xact"] = func(fn string, op parser.Operands, ctx *core.Context) error {
+		xact, err := functions.ParseTransaction(op, ctx)
+		if err == nil {
+			err = b.AddTransaction(&xact)
+		}
+		return err
+	}
+	if p.Parse() == nil {
+		t.Errorf("AddTransaction accepted a shared transfer without a payer")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	shares := []functions.Share{
+		{Person: "a", Weight: decimal.NewFromInt(1)},
+		{Person: "b", Weight: decimal.NewFromInt(1)},
+		{Person: "c", Weight: decimal.NewFromInt(1)}}
+	amounts := Split(decimal.NewFromInt(100), shares)
+	if s := fmt.Sprint(amounts); s != "[33.33 33.33 33.34]" {
+		t.Errorf("Split returned %v", s)
+	}
+}
+
+func TestBalances_Payments(t *testing.T) {
+	b := parseBalances(t, testLedger)
+	var s []string
+	for _, p := range b.Payments() {
+		s = append(s, fmt.Sprintf("%v %v %v %v", p.From, p.To, p.Amount, p.Commodity))
+	}
+	if r := strings.Join(s, "; "); r != "carol alice 300 USD; bob alice 275 USD" {
+		t.Errorf("Payments returned %v", r)
+	}
+}
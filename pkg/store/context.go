@@ -0,0 +1,593 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+// Keys and key prefixes of saved Contexts
+const (
+	contextKey        = "context"
+	commoditiesPrefix = "commodities/"
+	accountsPrefix    = "accounts/"
+	closedPrefix      = "closed-accounts/"
+	pricesPrefix      = "prices/"
+	budgetsPrefix     = "budgets/"
+	journalPrefix     = "journal/"
+	checkpointsPrefix = "balance-checkpoints/"
+)
+
+type quantityRecord struct {
+	Amount    decimal.Decimal
+	Commodity string
+}
+
+type contextRecord struct {
+	Date           core.Date
+	Book           string `json:",omitempty"`
+	Variables      map[string]string
+	AccountAliases map[string]string
+	Entities       map[string]string `json:",omitempty"`
+	Conversions    map[string]decimal.Decimal
+	Alerts         []alertRecord
+	Events         []core.Event
+	Gains          []gainRecord
+	BillingRates   []billingRateRecord
+	NoteTypes      map[string]string
+	Warnings       []string
+	Options        core.Options
+}
+
+type alertRecord struct {
+	Account    string
+	Comparison string
+	Threshold  quantityRecord
+}
+
+type gainRecord struct {
+	Date      core.Date
+	Account   string
+	LotName   string
+	Quantity  quantityRecord
+	Proceeds  quantityRecord
+	CostBasis quantityRecord
+}
+
+type billingRateRecord struct {
+	Date    core.Date
+	Client  string
+	Project string
+	Rate    quantityRecord
+}
+
+type commodityRecord struct {
+	Name              string
+	Description       string
+	CreationDate      core.Date
+	Tags              []string
+	Notes             map[string]string
+	DecimalPlaces     int32
+	Rounding          string
+	RoundingTolerance decimal.Decimal
+	SmallestUnit      decimal.Decimal
+	Display           core.DisplayFormat
+}
+
+type lotRecord struct {
+	Name         string
+	CreationDate core.Date
+	Balance      quantityRecord
+	UnitPrice    *quantityRecord   `json:",omitempty"`
+	TotalPrice   *quantityRecord   `json:",omitempty"`
+	Notes        map[string]string `json:",omitempty"`
+}
+
+type accountRecord struct {
+	Name         string
+	CreationDate core.Date
+	ClosingDate  core.Date
+	Commodities  []string
+	Lots         []lotRecord
+	Tags         []string
+	Notes        map[string]string
+	Book         string `json:",omitempty"`
+}
+
+type priceRecord struct {
+	Date  core.Date
+	Price quantityRecord
+}
+
+type budgetResultRecord struct {
+	First, Last core.Date
+	Amount      quantityRecord
+	Actual      quantityRecord
+}
+
+type budgetRecord struct {
+	Account     string
+	Period      string
+	Amount      quantityRecord
+	First, Last core.Date
+	Start       decimal.Decimal
+	Results     []budgetResultRecord
+}
+
+type postingRecord struct {
+	Account    string
+	LotName    string
+	Quantity   quantityRecord
+	UnitPrice  *quantityRecord `json:",omitempty"`
+	TotalPrice *quantityRecord `json:",omitempty"`
+	Comment    string
+	Document   string `json:",omitempty"`
+}
+
+type balanceCheckpointRecord struct {
+	Date     core.Date
+	Balances map[string][]quantityRecord
+}
+
+type journalRecord struct {
+	Date        core.Date
+	Entity      string
+	Description string
+	Postings    []postingRecord
+	Notes       map[string]string
+	Tags        map[string]string `json:",omitempty"`
+}
+
+func toQuantityRecord(q core.Quantity) quantityRecord {
+	return quantityRecord{Amount: q.Amount, Commodity: q.Commodity.Name}
+}
+
+func sortedTags(tt core.TagTarget) []string {
+	tags := tt.GetTags()
+	sort.Strings(tags)
+	return tags
+}
+
+func toAccountRecord(a *core.Account) accountRecord {
+	r := accountRecord{Name: a.Name, CreationDate: a.CreationDate, ClosingDate: a.ClosingDate, Tags: sortedTags(a), Notes: a.Notes, Book: a.Book}
+	for cn := range a.Commodities {
+		r.Commodities = append(r.Commodities, cn)
+	}
+	sort.Strings(r.Commodities)
+	for ln, ctol := range a.Lots {
+		if len(ctol) == 0 {
+			r.Lots = append(r.Lots, lotRecord{Name: ln})
+		}
+		for _, l := range ctol {
+			lr := lotRecord{Name: ln, CreationDate: l.CreationDate, Balance: toQuantityRecord(l.Balance), Notes: l.Notes}
+			if l.ExchangeRate != nil {
+				up, tp := toQuantityRecord(l.ExchangeRate.UnitPrice), toQuantityRecord(l.ExchangeRate.TotalPrice)
+				lr.UnitPrice, lr.TotalPrice = &up, &tp
+			}
+			r.Lots = append(r.Lots, lr)
+		}
+	}
+	sort.Slice(r.Lots, func(i, j int) bool {
+		if r.Lots[i].Name != r.Lots[j].Name {
+			return r.Lots[i].Name < r.Lots[j].Name
+		}
+		return r.Lots[i].Balance.Commodity < r.Lots[j].Balance.Commodity
+	})
+	return r
+}
+
+func put(s Store, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	} else if err = s.Put(key, value); err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	}
+	return nil
+}
+
+func get(s Store, key string, v interface{}) error {
+	value, ok, err := s.Get(key)
+	if err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	} else if !ok {
+		return fmt.Errorf("%v: missing", key)
+	} else if err = json.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	}
+	return nil
+}
+
+// Save stores a snapshot of ctx in s, replacing any snapshot that s
+// holds.  Later changes to ctx do not affect the snapshot.  Keys that
+// are not part of snapshots are left alone.
+func Save(s Store, ctx *core.Context) error {
+	keys := map[string]bool{}
+	for _, prefix := range []string{commoditiesPrefix, accountsPrefix, closedPrefix, pricesPrefix, budgetsPrefix, journalPrefix, checkpointsPrefix} {
+		existing, err := s.Keys(prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range existing {
+			keys[key] = true
+		}
+	}
+	saved := func(key string, v interface{}) error {
+		delete(keys, key)
+		return put(s, key, v)
+	}
+
+	for cn, c := range ctx.Commodities {
+		r := commodityRecord{Name: c.Name, Description: c.Description, CreationDate: c.CreationDate, Tags: sortedTags(c), Notes: c.Notes, DecimalPlaces: c.DecimalPlaces, Rounding: c.Rounding, RoundingTolerance: c.RoundingTolerance, SmallestUnit: c.SmallestUnit, Display: c.Display}
+		if err := saved(commoditiesPrefix+cn, r); err != nil {
+			return err
+		}
+	}
+	for an, a := range ctx.Accounts {
+		if err := saved(accountsPrefix+an, toAccountRecord(a)); err != nil {
+			return err
+		}
+	}
+	for n, a := range ctx.ClosedAccounts {
+		if err := saved(fmt.Sprintf("%v%08d", closedPrefix, n), toAccountRecord(a)); err != nil {
+			return err
+		}
+	}
+	for _, cn := range ctx.Prices.Commodities() {
+		var prices []priceRecord
+		for _, p := range ctx.Prices.History(cn) {
+			prices = append(prices, priceRecord{Date: p.Date, Price: toQuantityRecord(p.Price)})
+		}
+		if err := saved(pricesPrefix+cn, prices); err != nil {
+			return err
+		}
+	}
+	for n, b := range ctx.Budgets {
+		r := budgetRecord{Account: b.Account, Period: b.Period, Amount: toQuantityRecord(b.Amount), First: b.First, Last: b.Last, Start: b.Start}
+		for _, res := range b.Results {
+			r.Results = append(r.Results, budgetResultRecord{First: res.First, Last: res.Last, Amount: toQuantityRecord(res.Amount), Actual: toQuantityRecord(res.Actual)})
+		}
+		if err := saved(fmt.Sprintf("%v%08d", budgetsPrefix, n), r); err != nil {
+			return err
+		}
+	}
+	for n, e := range ctx.Journal {
+		r := journalRecord{Date: e.Date, Entity: e.Entity, Description: e.Description, Notes: e.Notes, Tags: e.Tags}
+		for _, p := range e.Postings {
+			pr := postingRecord{Account: p.Account, LotName: p.LotName, Quantity: toQuantityRecord(p.Quantity), Comment: p.Comment, Document: p.Document}
+			if p.ExchangeRate != nil {
+				up, tp := toQuantityRecord(p.ExchangeRate.UnitPrice), toQuantityRecord(p.ExchangeRate.TotalPrice)
+				pr.UnitPrice, pr.TotalPrice = &up, &tp
+			}
+			r.Postings = append(r.Postings, pr)
+		}
+		if err := saved(fmt.Sprintf("%v%08d", journalPrefix, n), r); err != nil {
+			return err
+		}
+	}
+	for n, bc := range ctx.BalanceCheckpoints {
+		r := balanceCheckpointRecord{Date: bc.Date, Balances: make(map[string][]quantityRecord, len(bc.Balances))}
+		for an, qs := range bc.Balances {
+			cns := make([]string, len(qs))[:0]
+			for cn := range qs {
+				cns = append(cns, cn)
+			}
+			sort.Strings(cns)
+			r.Balances[an] = []quantityRecord{}
+			for _, cn := range cns {
+				r.Balances[an] = append(r.Balances[an], toQuantityRecord(qs[cn]))
+			}
+		}
+		if err := saved(fmt.Sprintf("%v%08d", checkpointsPrefix, n), r); err != nil {
+			return err
+		}
+	}
+	for key := range keys {
+		if err := s.Delete(key); err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+	}
+
+	// Save the context record last so that Load fails on Contexts that
+	// were not completely saved for the first time.
+	r := contextRecord{Date: ctx.Date, Book: ctx.Book, Variables: ctx.Variables, AccountAliases: ctx.AccountAliases, Entities: ctx.Entities, Conversions: ctx.Conversions, Events: ctx.Events, NoteTypes: ctx.NoteTypes, Warnings: ctx.Warnings, Options: ctx.Options}
+	for _, a := range ctx.Alerts {
+		r.Alerts = append(r.Alerts, alertRecord{Account: a.Account, Comparison: a.Comparison, Threshold: toQuantityRecord(a.Threshold)})
+	}
+	for _, g := range ctx.RealizedGains {
+		r.Gains = append(r.Gains, gainRecord{Date: g.Date, Account: g.Account, LotName: g.LotName, Quantity: toQuantityRecord(g.Quantity), Proceeds: toQuantityRecord(g.Proceeds), CostBasis: toQuantityRecord(g.CostBasis)})
+	}
+	for _, br := range ctx.BillingRates {
+		r.BillingRates = append(r.BillingRates, billingRateRecord{Date: br.Date, Client: br.Client, Project: br.Project, Rate: toQuantityRecord(br.Rate)})
+	}
+	return put(s, contextKey, r)
+}
+
+// Load reads the whole snapshot that s holds into a new Context, which
+// does not use s afterwards.  Tags list their accounts and commodities
+// sorted by name, with closed accounts first, rather than in the order
+// in which they were tagged.
+func Load(s Store) (*core.Context, error) {
+	var cr contextRecord
+	if err := get(s, contextKey, &cr); err != nil {
+		return nil, err
+	}
+	ctx := core.NewContext()
+	ctx.Date = cr.Date
+	ctx.Book = cr.Book
+	ctx.Events = cr.Events
+	ctx.Warnings = cr.Warnings
+	for nn, typ := range cr.NoteTypes {
+		ctx.NoteTypes[nn] = typ
+	}
+	ctx.Options = cr.Options
+	for name, value := range cr.Variables {
+		ctx.Variables[name] = value
+	}
+	for old, name := range cr.AccountAliases {
+		ctx.AccountAliases[old] = name
+	}
+	for alias, name := range cr.Entities {
+		ctx.Entities[alias] = name
+	}
+	for cn, q := range cr.Conversions {
+		ctx.Conversions[cn] = q
+	}
+
+	keys, err := s.Keys(commoditiesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var r commodityRecord
+		if err := get(s, key, &r); err != nil {
+			return nil, err
+		}
+		c := core.NewCommodity(r.Name, r.Description, r.CreationDate)
+		c.DecimalPlaces = r.DecimalPlaces
+		if len(r.Rounding) != 0 {
+			c.Rounding = r.Rounding
+		}
+		c.RoundingTolerance = r.RoundingTolerance
+		c.SmallestUnit = r.SmallestUnit
+		c.Display = r.Display
+		for nn, nv := range r.Notes {
+			c.Notes[nn] = nv
+		}
+		ctx.Commodities[c.Name] = c
+		for _, tag := range r.Tags {
+			c.AddTag(tag)
+			key, _, _ := core.SplitTag(tag)
+			ctx.Tags[key] = append(ctx.Tags[key], c)
+		}
+	}
+	quantity := func(key string, r quantityRecord) (core.Quantity, error) {
+		c, ok := ctx.Commodities[r.Commodity]
+		if !ok {
+			return core.Quantity{}, fmt.Errorf("%v: nonexistent commodity: %v", key, r.Commodity)
+		}
+		return core.Quantity{Commodity: c, Amount: r.Amount}, nil
+	}
+
+	loadAccount := func(key string) (*core.Account, error) {
+		var r accountRecord
+		if err := get(s, key, &r); err != nil {
+			return nil, err
+		}
+		a := core.NewAccount(r.Name, r.CreationDate)
+		a.Book = r.Book
+		a.ClosingDate = r.ClosingDate
+		for _, cn := range r.Commodities {
+			c, ok := ctx.Commodities[cn]
+			if !ok {
+				return nil, fmt.Errorf("%v: nonexistent commodity: %v", key, cn)
+			}
+			a.Commodities[cn] = c
+		}
+		for _, lr := range r.Lots {
+			ctol, ok := a.Lots[lr.Name]
+			if !ok {
+				ctol = map[string]*core.Lot{}
+				a.Lots[lr.Name] = ctol
+			}
+			if len(lr.Balance.Commodity) == 0 {
+				continue
+			}
+			balance, err := quantity(key, lr.Balance)
+			if err != nil {
+				return nil, err
+			}
+			l := &core.Lot{Name: lr.Name, CreationDate: lr.CreationDate, Balance: balance, Notes: lr.Notes}
+			if lr.UnitPrice != nil && lr.TotalPrice != nil {
+				var er core.ExchangeRate
+				if er.UnitPrice, err = quantity(key, *lr.UnitPrice); err != nil {
+					return nil, err
+				} else if er.TotalPrice, err = quantity(key, *lr.TotalPrice); err != nil {
+					return nil, err
+				}
+				l.ExchangeRate = &er
+			}
+			ctol[balance.Commodity.Name] = l
+		}
+		for nn, nv := range r.Notes {
+			a.Notes[nn] = nv
+		}
+		for _, tag := range r.Tags {
+			a.AddTag(tag)
+			key, _, _ := core.SplitTag(tag)
+			ctx.Tags[key] = append(ctx.Tags[key], a)
+		}
+		return a, nil
+	}
+	if keys, err = s.Keys(closedPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		a, err := loadAccount(key)
+		if err != nil {
+			return nil, err
+		}
+		ctx.ClosedAccounts = append(ctx.ClosedAccounts, a)
+	}
+	if keys, err = s.Keys(accountsPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		a, err := loadAccount(key)
+		if err != nil {
+			return nil, err
+		}
+		ctx.Accounts[a.Name] = a
+	}
+
+	if keys, err = s.Keys(pricesPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var prices []priceRecord
+		if err := get(s, key, &prices); err != nil {
+			return nil, err
+		}
+		c, ok := ctx.Commodities[strings.TrimPrefix(key, pricesPrefix)]
+		if !ok {
+			return nil, fmt.Errorf("%v: nonexistent commodity", key)
+		}
+		for _, pr := range prices {
+			price, err := quantity(key, pr.Price)
+			if err != nil {
+				return nil, err
+			}
+			ctx.Prices.Add(core.Price{Date: pr.Date, Commodity: c, Price: price})
+		}
+	}
+
+	if keys, err = s.Keys(budgetsPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var r budgetRecord
+		if err := get(s, key, &r); err != nil {
+			return nil, err
+		}
+		b := core.Budget{Account: r.Account, Period: r.Period, First: r.First, Last: r.Last, Start: r.Start}
+		if b.Amount, err = quantity(key, r.Amount); err != nil {
+			return nil, err
+		}
+		for _, rr := range r.Results {
+			res := core.BudgetResult{First: rr.First, Last: rr.Last}
+			if res.Amount, err = quantity(key, rr.Amount); err != nil {
+				return nil, err
+			} else if res.Actual, err = quantity(key, rr.Actual); err != nil {
+				return nil, err
+			}
+			b.Results = append(b.Results, res)
+		}
+		ctx.Budgets = append(ctx.Budgets, b)
+	}
+
+	if keys, err = s.Keys(journalPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var r journalRecord
+		if err := get(s, key, &r); err != nil {
+			return nil, err
+		}
+		e := core.JournalEntry{Date: r.Date, Entity: r.Entity, Description: r.Description, Notes: r.Notes, Tags: r.Tags}
+		for _, pr := range r.Postings {
+			p := core.Posting{Account: pr.Account, LotName: pr.LotName, Comment: pr.Comment, Document: pr.Document}
+			if p.Quantity, err = quantity(key, pr.Quantity); err != nil {
+				return nil, err
+			}
+			if pr.UnitPrice != nil && pr.TotalPrice != nil {
+				var er core.ExchangeRate
+				if er.UnitPrice, err = quantity(key, *pr.UnitPrice); err != nil {
+					return nil, err
+				} else if er.TotalPrice, err = quantity(key, *pr.TotalPrice); err != nil {
+					return nil, err
+				}
+				p.ExchangeRate = &er
+			}
+			e.Postings = append(e.Postings, p)
+		}
+		ctx.Journal = append(ctx.Journal, e)
+	}
+
+	if keys, err = s.Keys(checkpointsPrefix); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		var r balanceCheckpointRecord
+		if err := get(s, key, &r); err != nil {
+			return nil, err
+		}
+		bc := core.BalanceCheckpoint{Date: r.Date, Balances: make(map[string]map[string]core.Quantity, len(r.Balances))}
+		for an, qrs := range r.Balances {
+			bc.Balances[an] = make(map[string]core.Quantity, len(qrs))
+			for _, qr := range qrs {
+				q, err := quantity(key, qr)
+				if err != nil {
+					return nil, err
+				}
+				bc.Balances[an][qr.Commodity] = q
+			}
+		}
+		ctx.BalanceCheckpoints = append(ctx.BalanceCheckpoints, bc)
+	}
+
+	for _, a := range cr.Alerts {
+		threshold, err := quantity(contextKey, a.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		ctx.Alerts = append(ctx.Alerts, core.Alert{Account: a.Account, Comparison: a.Comparison, Threshold: threshold})
+	}
+	for _, gr := range cr.Gains {
+		g := core.RealizedGain{Date: gr.Date, Account: gr.Account, LotName: gr.LotName}
+		if g.Quantity, err = quantity(contextKey, gr.Quantity); err != nil {
+			return nil, err
+		} else if g.Proceeds, err = quantity(contextKey, gr.Proceeds); err != nil {
+			return nil, err
+		} else if g.CostBasis, err = quantity(contextKey, gr.CostBasis); err != nil {
+			return nil, err
+		}
+		ctx.RealizedGains = append(ctx.RealizedGains, g)
+	}
+	for _, rr := range cr.BillingRates {
+		r := core.BillingRate{Date: rr.Date, Client: rr.Client, Project: rr.Project}
+		if r.Rate, err = quantity(contextKey, rr.Rate); err != nil {
+			return nil, err
+		}
+		ctx.BillingRates = append(ctx.BillingRates, r)
+	}
+	return ctx, nil
+}
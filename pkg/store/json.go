@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"github.com/jtvaughan/freebean/pkg/core"
+)
+
+// MarshalJSON returns a JSON object describing ctx, so that ctx can be
+// persisted in a single file and other programs can consume it without
+// parsing ledgers.  The object maps the keys under which Save would store
+// ctx's parts, such as "context" and "accounts/Assets:Checking", to their
+// values.  Its keys are sorted, so marshaling equal Contexts produces
+// equal JSON.
+func MarshalJSON(ctx *core.Context) ([]byte, error) {
+	s := NewMemoryStore()
+	if err := Save(s, ctx); err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(s.values))
+	for key, value := range s.values {
+		values[key] = value
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON returns the Context that data, which MarshalJSON
+// returned, describes.  See Load.
+func UnmarshalJSON(data []byte) (*core.Context, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	s := NewMemoryStore()
+	for key, value := range values {
+		s.values[key] = value
+	}
+	return Load(s)
+}
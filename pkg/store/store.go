@@ -0,0 +1,226 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package store saves snapshots of Contexts and loads them again, so
+// that processes can keep ledger states across restarts and other
+// programs can read states without parsing ledgers.
+//
+// Snapshots are not storage backends.  Save copies a whole Context into a
+// Store, and Load reads a whole snapshot into a new Context in memory:
+// Contexts are made of maps that the functions and subcommands use
+// directly, and they never read from or write to Stores as they change.
+//
+// Save stores each account, closed account, commodity, commodity's price
+// history, budget, and journal entry under its own key, so Stores can be
+// simple key-value stores, such as directories of files.  MarshalJSON and
+// UnmarshalJSON save snapshots as single JSON documents instead.
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is a key-value store that holds Context snapshots.  Keys are
+// slash-separated paths, such as "accounts/Assets:Checking".
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored under key and whether there is one.
+	Get(key string) ([]byte, bool, error)
+
+	// Put stores value under key, replacing any existing value.
+	Put(key string, value []byte) error
+
+	// Delete deletes the value stored under key, if any.
+	Delete(key string) error
+
+	// Keys returns the keys that start with prefix, sorted.
+	Keys(prefix string) ([]string, error)
+}
+
+// MemoryStore is a Store that keeps its values in memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: map[string][]byte{}}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return append([]byte(nil), value...), ok, nil
+}
+
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *MemoryStore) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DirStore is a Store that keeps each value in a file within a directory.
+// Each key's path segments are escaped and become directories and a file
+// name, so the values of keys with the same prefixes share directories.
+type DirStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewDirStore returns a DirStore that keeps its values in dir, creating
+// dir if it does not exist.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// path returns the path of the file that holds key's value.
+func (s *DirStore) path(key string) (string, error) {
+	segments := strings.Split(key, "/")
+	for n, segment := range segments {
+		if len(segment) == 0 {
+			return "", fmt.Errorf("invalid key %#v: empty path segment", key)
+		}
+		// PathEscape leaves "." and ".." alone.
+		segments[n] = strings.Replace(url.PathEscape(segment), ".", "%2E", -1)
+	}
+	return filepath.Join(s.dir, filepath.Join(segments...)), nil
+}
+
+func (s *DirStore) Get(key string) ([]byte, bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Put writes value to a temporary file and then renames it, so readers
+// never see partially written values.
+func (s *DirStore) Put(key string, value []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *DirStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *DirStore) Keys(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for n, segment := range segments {
+			if segments[n], err = url.PathUnescape(segment); err != nil {
+				return fmt.Errorf("%v: %v", path, err)
+			}
+		}
+		if key := strings.Join(segments, "/"); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package store
+
+import (
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/testsupport"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ledger = `
+	2000 1 1 date
+	journal true pragma
+	balance-checkpoints true pragma
+	location Tokyo event
+	USD Dollar commodity
+	ACME "Acme Corporation" commodity
+	ACME stock tag-commodity
+	ACME exchange NYSE add-commodity-notes
+	USD 2 decimal-places
+	USD 0.01 smallest-unit
+	Acme "" 100 USD billing-rate
+	miles decimal note-type
+	Store "Corner Store" entity
+	Assets:Checking open
+	Assets:Checking bank trip=japan2024 tag
+	Assets:Broker open
+	Expenses:Food open
+	Equity:Opening open
+	Assets:Old open
+	Assets:Old bank tag
+	(Me Opening Assets:Checking 1000 USD xfer Equity:Opening -1000 USD xfer xact)
+	(Broker Buy
+		Assets:Broker 10 ACME 30 USD 300 USD xfer-exch lot1 create-lot
+		Assets:Checking -300 USD xfer
+		xact)
+	Assets:Broker lot1 confirmation C-123 add-lot-notes
+	ACME 35 USD price
+	Expenses:Food monthly 100 USD budget
+	Assets:Checking < 100 USD alert
+	Assets:Broker Assets:Brokerage alias rename-account
+	100 limit store
+	2000 2 1 date
+	(Store Food Assets:Checking -50 USD xfer Expenses:Food 50 USD xfer receipt.pdf set-document trip=japan2024 tag-xact xact)
+	(Broker Sell Assets:Brokerage -2 ACME 35 USD -70 USD xfer-exch lot1 lot Assets:Checking 70 USD xfer xact)
+	Assets:Old close
+	2000 2 2 date
+	Assets:Old open
+	business book
+	Expenses:Supplies open`
+
+func testSaveAndLoad(t *testing.T, s Store) {
+	ctx := testsupport.Parse(t, ledger)
+	if err := Save(s, ctx); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(s)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	testsupport.AssertSnapshot(t, loaded, testsupport.Snapshot(ctx))
+	if !reflect.DeepEqual(loaded.Options, ctx.Options) {
+		t.Errorf("Load returned the wrong options: %v", loaded.Options)
+	} else if len(loaded.ClosedAccounts) != 1 || len(loaded.Tags["bank"]) != 2 {
+		t.Errorf("Load did not restore closed accounts' tags: %v", loaded.Tags["bank"])
+	} else if loaded.Accounts["Assets:Brokerage"].Lots["lot1"]["ACME"].Notes["confirmation"] != "C-123" {
+		t.Errorf("Load did not restore lot notes")
+	} else if tagged := loaded.Tagged("trip=japan2024"); len(tagged) != 1 {
+		t.Errorf("Load did not restore key=value tags: %v", loaded.Tags["trip"])
+	} else if loaded.Book != "business" || loaded.Accounts["Expenses:Supplies"].Book != "business" || loaded.Accounts["Assets:Old"].Book != "" {
+		t.Errorf("Load did not restore books")
+	} else if loaded.Journal[2].Postings[1].Document != "receipt.pdf" {
+		t.Errorf("Load did not restore documents: %v", loaded.Journal[2].Postings)
+	} else if loaded.Entities["Corner Store"] != "Store" {
+		t.Errorf("Load did not restore entities: %v", loaded.Entities)
+	} else if len(loaded.BalanceCheckpoints) != 1 || loaded.BalanceCheckpoints[0].Balances["Assets:Checking"]["USD"].String() != "700.00 USD" {
+		t.Errorf("Load did not restore balance checkpoints: %v", loaded.BalanceCheckpoints)
+	} else if len(loaded.Budgets) != 1 || len(loaded.Budgets[0].Results) != 1 {
+		t.Errorf("Load did not restore budgets: %v", loaded.Budgets)
+	} else if price, _ := loaded.Prices.Latest("ACME"); price.Price.String() != "35.00 USD" {
+		t.Errorf("Load restored the wrong price: %v", price.Price)
+	} else if loaded.Commodities["USD"].DecimalPlaces != 2 || loaded.Commodities["USD"].SmallestUnit.String() != "0.01" || loaded.Commodities["ACME"].Notes["exchange"] != "NYSE" {
+		t.Errorf("Load did not restore commodities")
+	} else if len(loaded.Events) != 1 || loaded.Events[0].Value != "Tokyo" {
+		t.Errorf("Load did not restore events: %v", loaded.Events)
+	} else if len(loaded.RealizedGains) != 1 || loaded.RealizedGains[0].Gain().String() != "10.00 USD" {
+		t.Errorf("Load did not restore realized gains: %v", loaded.RealizedGains)
+	} else if rate, ok := loaded.BillingRate("Acme", "Website", loaded.Date); !ok || rate.String() != "100.00 USD" {
+		t.Errorf("Load did not restore billing rates: %v", loaded.BillingRates)
+	} else if loaded.NoteTypes["miles"] != "decimal" {
+		t.Errorf("Load did not restore note types: %v", loaded.NoteTypes)
+	} else if len(loaded.Journal) != 4 || loaded.Journal[1].Postings[0].ExchangeRate == nil || loaded.Journal[2].Postings[1].Account != "Expenses:Food" || loaded.Journal[2].Tags["trip"] != "japan2024" {
+		t.Errorf("Load did not restore the journal: %v", loaded.Journal)
+	}
+
+	// The loaded Context must support further parsing, including
+	// the old account name's alias and the exchange rate of lot1.
+	p := functions.NewParserWithContext(strings.NewReader(`
+		Assets:Broker lot1 8 ACME assert-lot
+		Assets:Checking 720 USD assert
+		(Broker Sell
+			Assets:Broker -8 ACME 35 USD -280 USD xfer-exch lot1 lot
+			Assets:Checking 280 USD xfer
+			xact)
+		Assets:Brokerage lot1 close-lot
+		Assets:Checking < limit recall USD alert`), loaded)
+	p.AddCoreFunctions()
+	if err := p.Parse(); err != nil {
+		t.Errorf("parsing with the loaded Context failed: %v", err)
+	}
+
+	// Saving a smaller Context deletes the extra keys.
+	if err := Save(s, testsupport.Parse(t, "2000 1 1 date USD Dollar commodity")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	} else if keys, _ := s.Keys(""); len(keys) != 2 {
+		t.Errorf("Save did not delete old keys: %v", keys)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSaveAndLoad(t, NewMemoryStore())
+}
+
+func TestMarshalJSON(t *testing.T) {
+	ctx := testsupport.Parse(t, ledger)
+	data, err := MarshalJSON(ctx)
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+	loaded, err := UnmarshalJSON(data)
+	if err != nil {
+		t.Fatalf("UnmarshalJSON failed: %v", err)
+	}
+	testsupport.AssertSnapshot(t, loaded, testsupport.Snapshot(ctx))
+	if again, err := MarshalJSON(loaded); err != nil {
+		t.Errorf("MarshalJSON failed on the unmarshaled Context: %v", err)
+	} else if string(again) != string(data) {
+		t.Errorf("MarshalJSON is not stable:\n%s\n%s", data, again)
+	}
+	if _, err = UnmarshalJSON([]byte(`{"accounts/x": 1}`)); err == nil {
+		t.Errorf("UnmarshalJSON succeeded without a context key")
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freebean-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSaveAndLoad(t, s)
+	if err := s.Put("a/../b", []byte("x")); err != nil {
+		t.Fatalf("DirStore.Put failed: %v", err)
+	} else if keys, _ := s.Keys("a/"); len(keys) != 1 || keys[0] != "a/../b" {
+		t.Errorf("DirStore did not escape dots: %v", keys)
+	} else if err := s.Put("a//b", nil); err == nil {
+		t.Errorf("DirStore accepted a key with an empty segment")
+	}
+}
+
+func TestLoad_Empty(t *testing.T) {
+	if _, err := Load(NewMemoryStore()); err == nil {
+		t.Errorf("Load succeeded on an empty Store")
+	}
+}
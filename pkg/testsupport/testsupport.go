@@ -0,0 +1,205 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+// Package testsupport helps tests parse ledgers and check the resulting
+// Contexts.  It suits tests of Freebean itself as well as tests of custom
+// functions.
+//
+// Snapshots describe the balances of all lots in open accounts as assert
+// and assert-lot calls, one per line, sorted.  For example:
+//
+//	Assets:Brokerage lot1 10 ACME assert-lot
+//	Assets:Checking 700 USD assert
+//
+// Snapshots are themselves ledgers, so they can be appended to the ledgers
+// they describe.
+package testsupport
+
+import (
+	"fmt"
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ParseWithFunctions parses a ledger with the core functions and
+// the specified functions, which replace core functions with the same
+// names, and returns the resulting Context.  It fails the test
+// if parsing fails.
+func ParseWithFunctions(t testing.TB, ledger string, fns map[string]functions.Function) *core.Context {
+	t.Helper()
+	p := functions.NewParser(strings.NewReader(ledger))
+	p.AddCoreFunctions()
+	for fn, f := range fns {
+		p.Functions[fn] = f
+	}
+	if err := p.Parse(); err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	return p.Context()
+}
+
+// Parse parses a ledger with the core functions and returns the resulting
+// Context.  It fails the test if parsing fails.
+func Parse(t testing.TB, ledger string) *core.Context {
+	t.Helper()
+	return ParseWithFunctions(t, ledger, nil)
+}
+
+// ParseFails parses a ledger with the core functions and returns
+// the parsing error.  It fails the test if parsing succeeds.
+func ParseFails(t testing.TB, ledger string) error {
+	t.Helper()
+	p := functions.NewParser(strings.NewReader(ledger))
+	p.AddCoreFunctions()
+	err := p.Parse()
+	if err == nil {
+		t.Errorf("parsing succeeded but should have failed")
+	}
+	return err
+}
+
+// parseQuantity parses an "AMOUNT COMMODITY" string.
+func parseQuantity(t testing.TB, q string) (decimal.Decimal, string) {
+	t.Helper()
+	fields := strings.Fields(q)
+	if len(fields) != 2 {
+		t.Fatalf(`illegal quantity (expected "AMOUNT COMMODITY"): %v`, q)
+	}
+	amount, err := functions.ParseDecimal(fields[0])
+	if err != nil {
+		t.Fatalf("illegal amount %v: %v", fields[0], err)
+	}
+	return amount, fields[1]
+}
+
+// AssertBalance checks that the sum of an account's lots of a commodity
+// is expected, which is formatted as "AMOUNT COMMODITY".
+func AssertBalance(t testing.TB, ctx *core.Context, account, expected string) {
+	t.Helper()
+	amount, cn := parseQuantity(t, expected)
+	a, ok := ctx.Account(account)
+	if !ok {
+		t.Errorf("nonexistent account: %v", account)
+	} else if q := a.Balances()[cn]; !q.Amount.Equal(amount) {
+		t.Errorf("account %v's balance is %v %v instead of %v", account, q.Amount, cn, expected)
+	}
+}
+
+// AssertLot checks that an account's lot of a commodity has
+// the expected balance, which is formatted as "AMOUNT COMMODITY".
+func AssertLot(t testing.TB, ctx *core.Context, account, lot, expected string) {
+	t.Helper()
+	amount, cn := parseQuantity(t, expected)
+	a, ok := ctx.Account(account)
+	if !ok {
+		t.Errorf("nonexistent account: %v", account)
+	} else if l, ok := a.Lots[lot][cn]; !ok {
+		t.Errorf(`account %v's lot "%v" does not have %v`, account, lot, cn)
+	} else if !l.Balance.Amount.Equal(amount) {
+		t.Errorf(`account %v's lot "%v" has %v %v instead of %v`, account, lot, l.Balance.Amount, cn, expected)
+	}
+}
+
+// token quotes s if the Lexer would not lex it as a single unquoted string.
+func token(s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\r\n\"()\\") {
+		return parser.Quote(s)
+	}
+	return s
+}
+
+// Snapshot returns a snapshot of the nonzero balances of the lots
+// in a Context's open accounts.
+func Snapshot(ctx *core.Context) string {
+	var lines []string
+	for an, a := range ctx.Accounts {
+		if a.IsClosed(ctx.Date) {
+			continue
+		}
+		for ln, ctol := range a.Lots {
+			for cn, l := range ctol {
+				if l.Balance.Amount.IsZero() {
+					continue
+				} else if ln == core.DefaultLotName {
+					lines = append(lines, fmt.Sprintf("%v %v %v assert", token(an), l.Balance.Amount, token(cn)))
+				} else {
+					lines = append(lines, fmt.Sprintf("%v %v %v %v assert-lot", token(an), token(ln), l.Balance.Amount, token(cn)))
+				}
+			}
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// normalizeSnapshot removes blank lines and surrounding whitespace from
+// a snapshot's lines.
+func normalizeSnapshot(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); len(l) != 0 {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// AssertSnapshot checks that a Context's snapshot is expected.  Blank lines
+// and indentation in expected are ignored.  It reports missing and
+// unexpected lines.
+func AssertSnapshot(t testing.TB, ctx *core.Context, expected string) {
+	t.Helper()
+	actual := normalizeSnapshot(Snapshot(ctx))
+	wanted := normalizeSnapshot(expected)
+	count := map[string]int{}
+	for _, l := range actual {
+		count[l]++
+	}
+	for _, l := range wanted {
+		count[l]--
+	}
+	var diffs []string
+	for _, l := range wanted {
+		if count[l] < 0 {
+			diffs = append(diffs, "- "+l)
+			count[l]++
+		}
+	}
+	for _, l := range actual {
+		if count[l] > 0 {
+			diffs = append(diffs, "+ "+l)
+			count[l]--
+		}
+	}
+	if len(diffs) != 0 {
+		t.Errorf("snapshot differs (- expected, + actual):\n%v", strings.Join(diffs, "\n"))
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2021, Jordan Vaughan
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package testsupport
+
+import (
+	"github.com/jtvaughan/freebean/pkg/core"
+	"github.com/jtvaughan/freebean/pkg/functions"
+	"github.com/jtvaughan/freebean/pkg/parser"
+	"strings"
+	"testing"
+)
+
+const testLedger = `
+	2000 1 1 date
+	USD Dollar commodity
+	ACME Stock commodity
+	Assets:Checking open
+	Assets:Brokerage open
+	Equity:Opening open
+	(Me Opening Assets:Checking 1000 USD xfer Equity:Opening -1000 USD xfer xact)
+	(Broker Buy
+		Assets:Brokerage 10 ACME 30 USD 300 USD xfer-exch "lot 1" create-lot
+		Assets:Checking -300 USD xfer
+		xact)`
+
+func TestParseAndAssertions(t *testing.T) {
+	ctx := Parse(t, testLedger)
+	AssertBalance(t, ctx, "Assets:Checking", "700 USD")
+	AssertBalance(t, ctx, "Assets:Brokerage", "10 ACME")
+	AssertLot(t, ctx, "Assets:Brokerage", "lot 1", "10.0 ACME")
+	AssertSnapshot(t, ctx, `
+		Assets:Brokerage "lot 1" 10 ACME assert-lot
+		Assets:Checking 700 USD assert
+		Equity:Opening -1000 USD assert`)
+}
+
+func TestSnapshot_IsLedger(t *testing.T) {
+	ctx := Parse(t, testLedger)
+	Parse(t, testLedger+"\n"+Snapshot(ctx))
+}
+
+func TestParseWithFunctions(t *testing.T) {
+	called := false
+	ParseWithFunctions(t, testLedger+" custom", map[string]functions.Function{
+		"custom": func(fn string, op parser.Operands, ctx *core.Context) error {
+			called = true
+			return nil
+		}})
+	if !called {
+		t.Errorf("ParseWithFunctions did not add the custom function")
+	}
+}
+
+func TestParseFails(t *testing.T) {
+	if err := ParseFails(t, testLedger+" Assets:Checking 1 USD assert"); err == nil || !strings.Contains(err.Error(), "assert") {
+		t.Errorf("ParseFails returned an unexpected error: %v", err)
+	}
+}
+
+// recorder records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, format)
+}
+
+func TestAssertions_Failures(t *testing.T) {
+	ctx := Parse(t, testLedger)
+	r := &recorder{TB: t}
+	AssertBalance(r, ctx, "Assets:Checking", "701 USD")
+	AssertBalance(r, ctx, "Assets:Nope", "0 USD")
+	AssertLot(r, ctx, "Assets:Brokerage", "lot 2", "10 ACME")
+	AssertLot(r, ctx, "Assets:Brokerage", "lot 1", "11 ACME")
+	AssertSnapshot(r, ctx, `Assets:Checking 700 USD assert`)
+	if len(r.failures) != 5 {
+		t.Errorf("expected 5 failures, got %v: %v", len(r.failures), r.failures)
+	}
+}